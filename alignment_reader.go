@@ -0,0 +1,668 @@
+package gophy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// datatypes for alignments
+const (
+	DNAData      = "DNA"
+	ProteinData  = "PROTEIN"
+	StandardData = "STANDARD"
+)
+
+// charMatrix is a phylip or nexus alignment before it becomes Seq or MSeq
+// each cell is one character or a {..} or (..) set of characters
+type charMatrix struct {
+	datatype string
+	symbols  string // for STANDARD. empty means the states are 0-9
+	missing  byte
+	gap      byte
+	names    []string
+	cells    [][]string
+}
+
+// seqFileFormat looks at the first line of the file for nexus, phylip, or fasta
+func seqFileFormat(filen string) string {
+	if IsNexusFile(filen) {
+		return "nexus"
+	}
+	f, err := os.Open(filen)
+	if err != nil {
+		return "fasta"
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	buf := make([]byte, 0, 1024*1024)
+	scanner.Buffer(buf, 1024*1024)
+	for scanner.Scan() {
+		flds := strings.Fields(scanner.Text())
+		if len(flds) == 0 {
+			continue
+		}
+		if len(flds) >= 2 {
+			_, err1 := strconv.Atoi(flds[0])
+			_, err2 := strconv.Atoi(flds[1])
+			if err1 == nil && err2 == nil {
+				return "phylip"
+			}
+		}
+		return "fasta"
+	}
+	return "fasta"
+}
+
+// ReadAlignmentFromFile reads a fasta, phylip, or nexus alignment and works out the
+// datatype (DNAData, ProteinData, or StandardData). DNA and protein come back as
+// seqs and standard (morphological) data as mseqs
+func ReadAlignmentFromFile(filen string) (seqs []Seq, mseqs []MSeq, numstates int, datatype string) {
+	var m *charMatrix
+	var err error
+	switch seqFileFormat(filen) {
+	case "nexus":
+		m, err = parseNexusCharacters(readAlignmentFile(filen))
+	case "phylip":
+		m, err = parsePhylip(readAlignmentFile(filen))
+	default:
+		m = seqsToCharMatrix(ReadSeqsFromFile(filen))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, filen+":", err)
+		return
+	}
+	datatype = m.datatype
+	if datatype == StandardData {
+		mseqs, numstates = m.mseqs()
+		return
+	}
+	seqs = m.seqs()
+	if datatype == DNAData {
+		numstates = 4
+	} else {
+		numstates = 20
+	}
+	return
+}
+
+// ReadPhylipSeqsFromFile reads sequential or interleaved phylip with strict
+// (10 character) or relaxed (up to the first space) names
+func ReadPhylipSeqsFromFile(filen string) (seqs []Seq) {
+	m, err := parsePhylip(readAlignmentFile(filen))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, filen+":", err)
+		return
+	}
+	return m.seqs()
+}
+
+// ReadPhylipMSeqsFromFile reads a phylip file of multistate characters
+// (one character per state, 0-9, ? or N for missing, and - for gaps)
+func ReadPhylipMSeqsFromFile(filen string) (seqs []MSeq, numstates int) {
+	m, err := parsePhylip(readAlignmentFile(filen))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, filen+":", err)
+		return
+	}
+	return m.mseqs()
+}
+
+// ReadNexusSeqsFromFile reads the DATA or CHARACTERS block of a nexus file
+func ReadNexusSeqsFromFile(filen string) (seqs []Seq) {
+	m, err := parseNexusCharacters(readAlignmentFile(filen))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, filen+":", err)
+		return
+	}
+	return m.seqs()
+}
+
+// ReadNexusMSeqsFromFile reads the DATA or CHARACTERS block of a nexus file as
+// multistate characters. the states are numbered by the order of the SYMBOLS.
+// {01} and (01) cells are written as 0/1 in SQs
+func ReadNexusMSeqsFromFile(filen string) (seqs []MSeq, numstates int) {
+	m, err := parseNexusCharacters(readAlignmentFile(filen))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, filen+":", err)
+		return
+	}
+	return m.mseqs()
+}
+
+// DetectSeqType guesses whether the seqs are DNA, protein, or standard (0-9) data
+func DetectSeqType(seqs []Seq) string {
+	nuc, digits, other := 0, 0, 0
+	for _, s := range seqs {
+		for _, c := range strings.ToUpper(s.SQ) {
+			switch {
+			case strings.ContainsRune("ACGTU", c):
+				nuc++
+			case c >= '0' && c <= '9':
+				digits++
+			case strings.ContainsRune("-?N.{}()/ \t\r\n", c):
+			default:
+				other++
+			}
+		}
+	}
+	if digits > 0 && nuc+other == 0 {
+		return StandardData
+	}
+	if nuc > 0 && float64(nuc) >= 0.9*float64(nuc+other) {
+		return DNAData
+	}
+	return ProteinData
+}
+
+func readAlignmentFile(filen string) string {
+	b, err := ioutil.ReadFile(filen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(b)
+}
+
+// seqsToCharMatrix is for fasta. multistate fasta has the states separated by spaces
+func seqsToCharMatrix(seqs []Seq) *charMatrix {
+	m := &charMatrix{datatype: DetectSeqType(seqs), missing: '?', gap: '-'}
+	for _, s := range seqs {
+		m.names = append(m.names, s.NM)
+		if m.datatype == StandardData && strings.Contains(s.SQ, " ") {
+			m.cells = append(m.cells, strings.Fields(s.SQ))
+		} else {
+			m.cells = append(m.cells, splitCells(s.SQ))
+		}
+	}
+	return m
+}
+
+// splitCells splits a sequence into cells keeping {..} and (..) together
+func splitCells(sq string) (cells []string) {
+	for i := 0; i < len(sq); i++ {
+		c := sq[i]
+		if isNewickSpace(c) {
+			continue
+		}
+		if c == '{' || c == '(' {
+			cl := byte('}')
+			if c == '(' {
+				cl = ')'
+			}
+			end := strings.IndexByte(sq[i:], cl)
+			if end == -1 {
+				end = len(sq) - i - 1
+			}
+			cells = append(cells, strings.Join(strings.Fields(sq[i:i+end+1]), ""))
+			i += end
+			continue
+		}
+		cells = append(cells, sq[i:i+1])
+	}
+	return
+}
+
+func removeSpaces(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// cellStates the state indices for a cell. code is - for a gap and N for missing
+func (m *charMatrix) cellStates(cell string) (states []int, code string) {
+	if len(cell) > 2 && (cell[0] == '{' || cell[0] == '(') {
+		seen := map[int]bool{}
+		for _, c := range splitCells(cell[1 : len(cell)-1]) {
+			st, cd := m.cellStates(c)
+			if cd != "" {
+				continue
+			}
+			for _, s := range st {
+				if seen[s] == false {
+					seen[s] = true
+					states = append(states, s)
+				}
+			}
+		}
+		sort.Ints(states)
+		if len(states) == 0 {
+			return nil, "N"
+		}
+		return states, ""
+	}
+	if cell == string(m.gap) {
+		return nil, "-"
+	}
+	if cell == string(m.missing) || cell == "?" {
+		return nil, "N"
+	}
+	switch m.datatype {
+	case DNAData:
+		cell = strings.ToUpper(cell)
+		if cell == "U" {
+			cell = "T"
+		}
+		if cell == "N" || cell == "X" {
+			return nil, "N"
+		}
+		if st, ok := GetNucMap()[cell]; ok {
+			return st, ""
+		}
+	case ProteinData:
+		cell = strings.ToUpper(cell)
+		if cell == "X" {
+			return nil, "N"
+		}
+		if st, ok := GetProtMap()[cell]; ok {
+			return st, ""
+		}
+	default:
+		if len(m.symbols) > 0 {
+			if i := strings.Index(m.symbols, cell); i != -1 && len(cell) == 1 {
+				return []int{i}, ""
+			}
+		} else if cell == "N" {
+			return nil, "N"
+		} else if i, err := strconv.Atoi(cell); err == nil && i >= 0 {
+			return []int{i}, ""
+		}
+	}
+	fmt.Fprintln(os.Stderr, "unknown character", cell, "treated as missing")
+	return nil, "N"
+}
+
+func (m *charMatrix) numStates() int {
+	switch m.datatype {
+	case DNAData:
+		return 4
+	case ProteinData:
+		return 20
+	}
+	if len(m.symbols) > 0 {
+		return len(m.symbols)
+	}
+	numstates := 0
+	for _, row := range m.cells {
+		for _, cell := range row {
+			st, _ := m.cellStates(cell)
+			for _, s := range st {
+				if s+1 > numstates {
+					numstates = s + 1
+				}
+			}
+		}
+	}
+	return numstates
+}
+
+// mseqs the matrix as MSeq with the states numbered from 0
+func (m *charMatrix) mseqs() (seqs []MSeq, numstates int) {
+	numstates = m.numStates()
+	for i, nm := range m.names {
+		sqs := make([]string, len(m.cells[i]))
+		for j, cell := range m.cells[i] {
+			st, code := m.cellStates(cell)
+			if code != "" {
+				sqs[j] = code
+			} else if len(st) == numstates && numstates > 1 {
+				sqs[j] = "N"
+			} else {
+				strs := make([]string, len(st))
+				for k, s := range st {
+					strs[k] = strconv.Itoa(s)
+				}
+				sqs[j] = strings.Join(strs, "/")
+			}
+		}
+		seqs = append(seqs, MSeq{nm, strings.Join(sqs, " "), sqs})
+	}
+	return
+}
+
+// seqs the matrix as Seq. sets of states become IUPAC codes for DNA and X
+// (or B and Z) for protein. standard data is kept as it was written
+func (m *charMatrix) seqs() (seqs []Seq) {
+	var revmap map[string]string
+	if m.datatype == DNAData {
+		revmap = stateSetMap(GetNucMap())
+	} else if m.datatype == ProteinData {
+		revmap = stateSetMap(GetProtMap())
+	}
+	for i, nm := range m.names {
+		var buffer bytes.Buffer
+		for _, cell := range m.cells[i] {
+			if m.datatype == StandardData {
+				buffer.WriteString(cell)
+				continue
+			}
+			st, code := m.cellStates(cell)
+			if code == "-" {
+				buffer.WriteString("-")
+			} else if cd, ok := revmap[fmt.Sprint(st)]; ok && code == "" {
+				buffer.WriteString(cd)
+			} else if m.datatype == DNAData {
+				buffer.WriteString("N")
+			} else {
+				buffer.WriteString("X")
+			}
+		}
+		seqs = append(seqs, Seq{nm, buffer.String()})
+	}
+	return
+}
+
+// stateSetMap reverses a char map so a set of states gives the character
+func stateSetMap(charMap map[string][]int) map[string]string {
+	ret := make(map[string]string)
+	for k, v := range charMap {
+		if k == "-" {
+			continue
+		}
+		st := append([]int{}, v...)
+		sort.Ints(st)
+		ret[fmt.Sprint(st)] = k
+	}
+	return ret
+}
+
+// parsePhylip tries relaxed then strict names and sequential then interleaved
+// and keeps the first one where all the sequences have the right length
+func parsePhylip(ph string) (*charMatrix, error) {
+	lines := make([]string, 0)
+	for _, ln := range strings.Split(ph, "\n") {
+		ln = strings.TrimRight(ln, "\r")
+		if len(strings.TrimSpace(ln)) > 0 {
+			lines = append(lines, ln)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("empty phylip file")
+	}
+	flds := strings.Fields(lines[0])
+	if len(flds) < 2 {
+		return nil, errors.New("phylip header should have the number of taxa and characters")
+	}
+	ntax, err1 := strconv.Atoi(flds[0])
+	nchar, err2 := strconv.Atoi(flds[1])
+	if err1 != nil || err2 != nil {
+		return nil, errors.New("bad phylip header: " + lines[0])
+	}
+	for _, strict := range []bool{false, true} {
+		for _, interleaved := range []bool{false, true} {
+			names, sqs, ok := readPhylipLines(lines[1:], ntax, nchar, strict, interleaved)
+			if ok == false {
+				continue
+			}
+			tseqs := make([]Seq, ntax)
+			for i := range names {
+				tseqs[i] = Seq{names[i], sqs[i]}
+			}
+			return seqsToCharMatrix(tseqs), nil
+		}
+	}
+	return nil, fmt.Errorf("could not read %d sequences of length %d as phylip", ntax, nchar)
+}
+
+func phylipName(ln string, strict bool) (name string, rest string) {
+	if strict {
+		if len(ln) <= 10 {
+			return strings.TrimSpace(ln), ""
+		}
+		return strings.TrimSpace(ln[:10]), ln[10:]
+	}
+	ln = strings.TrimLeft(ln, " \t")
+	i := strings.IndexAny(ln, " \t")
+	if i == -1 {
+		return ln, ""
+	}
+	return ln[:i], ln[i:]
+}
+
+func readPhylipLines(lines []string, ntax int, nchar int, strict bool, interleaved bool) (names []string, sqs []string, ok bool) {
+	names = make([]string, ntax)
+	sqs = make([]string, ntax)
+	if interleaved {
+		if len(lines) < ntax {
+			return nil, nil, false
+		}
+		for i := 0; i < ntax; i++ {
+			nm, rest := phylipName(lines[i], strict)
+			names[i] = nm
+			sqs[i] = removeSpaces(rest)
+		}
+		for j, ln := range lines[ntax:] {
+			sqs[j%ntax] += removeSpaces(ln)
+		}
+	} else {
+		li := 0
+		for i := 0; i < ntax; i++ {
+			if li >= len(lines) {
+				return nil, nil, false
+			}
+			nm, rest := phylipName(lines[li], strict)
+			names[i] = nm
+			sqs[i] = removeSpaces(rest)
+			li++
+			for len(splitCells(sqs[i])) < nchar && li < len(lines) {
+				sqs[i] += removeSpaces(lines[li])
+				li++
+			}
+		}
+		if li != len(lines) {
+			return nil, nil, false
+		}
+	}
+	for i := range sqs {
+		if len(names[i]) == 0 || len(splitCells(sqs[i])) != nchar {
+			return nil, nil, false
+		}
+	}
+	return names, sqs, true
+}
+
+// parseNexusCharacters reads the first DATA or CHARACTERS block
+func parseNexusCharacters(nex string) (*charMatrix, error) {
+	m := &charMatrix{missing: '?', gap: '-'}
+	indata := false
+	nchar := 0
+	interleaved := false
+	match := byte(0)
+	nex = strings.TrimSpace(nex)
+	if strings.HasPrefix(strings.ToUpper(nex), "#NEXUS") {
+		nex = nex[len("#NEXUS"):]
+	}
+	for _, st := range splitNexusStatements(nex) {
+		st = strings.TrimSpace(stripNexusComments(st, true))
+		if len(st) == 0 {
+			continue
+		}
+		cmd := strings.ToUpper(strings.Fields(st)[0])
+		switch {
+		case cmd == "BEGIN":
+			blk := strings.ToUpper(strings.TrimSpace(st[len(cmd):]))
+			indata = blk == "DATA" || blk == "CHARACTERS"
+		case cmd == "END" || cmd == "ENDBLOCK":
+			indata = false
+		case indata && cmd == "DIMENSIONS":
+			if v, ok := nexusOptions(st[len(cmd):])["NCHAR"]; ok {
+				nchar, _ = strconv.Atoi(v)
+			}
+		case indata && cmd == "FORMAT":
+			for k, v := range nexusOptions(st[len(cmd):]) {
+				switch k {
+				case "DATATYPE":
+					switch strings.ToUpper(v) {
+					case "DNA", "RNA", "NUCLEOTIDE":
+						m.datatype = DNAData
+					case "PROTEIN":
+						m.datatype = ProteinData
+					case "STANDARD":
+						m.datatype = StandardData
+					default:
+						return nil, errors.New("unsupported DATATYPE " + v)
+					}
+				case "SYMBOLS":
+					m.symbols = removeSpaces(v)
+				case "MISSING":
+					if len(v) > 0 {
+						m.missing = v[0]
+					}
+				case "GAP":
+					if len(v) > 0 {
+						m.gap = v[0]
+					}
+				case "MATCHCHAR":
+					if len(v) > 0 {
+						match = v[0]
+					}
+				case "INTERLEAVE":
+					interleaved = v == "" || strings.ToUpper(v) == "YES"
+				}
+			}
+		case indata && cmd == "MATRIX":
+			readNexusMatrix(m, st[len(cmd):], nchar, interleaved)
+			if match != 0 && len(m.cells) > 0 {
+				for _, row := range m.cells[1:] {
+					for j := range row {
+						if row[j] == string(match) && j < len(m.cells[0]) {
+							row[j] = m.cells[0][j]
+						}
+					}
+				}
+			}
+			if m.datatype == "" {
+				tseqs := make([]Seq, len(m.names))
+				for i := range m.names {
+					tseqs[i] = Seq{m.names[i], strings.Join(m.cells[i], "")}
+				}
+				m.datatype = DetectSeqType(tseqs)
+			}
+			if m.datatype != StandardData {
+				m.symbols = ""
+			}
+			return m, nil
+		}
+	}
+	return nil, errors.New("no MATRIX in a DATA or CHARACTERS block")
+}
+
+// readNexusMatrix fills in the names and cells. interleaved matrices are read
+// line by line, otherwise each taxon takes cells until it has nchar
+func readNexusMatrix(m *charMatrix, mat string, nchar int, interleaved bool) {
+	index := make(map[string]int)
+	if interleaved || nchar == 0 {
+		for _, ln := range strings.Split(mat, "\n") {
+			toks := nexusMatrixTokens(ln)
+			if len(toks) == 0 {
+				continue
+			}
+			nm := unquoteNewickLabel(toks[0])
+			i, ok := index[nm]
+			if !ok {
+				i = len(m.names)
+				index[nm] = i
+				m.names = append(m.names, nm)
+				m.cells = append(m.cells, nil)
+			}
+			m.cells[i] = append(m.cells[i], splitCells(strings.Join(toks[1:], ""))...)
+		}
+		return
+	}
+	toks := nexusMatrixTokens(mat)
+	for i := 0; i < len(toks); {
+		m.names = append(m.names, unquoteNewickLabel(toks[i]))
+		i++
+		cells := make([]string, 0, nchar)
+		for len(cells) < nchar && i < len(toks) {
+			cells = append(cells, splitCells(toks[i])...)
+			i++
+		}
+		m.cells = append(m.cells, cells)
+	}
+}
+
+// nexusMatrixTokens splits on whitespace that is not in a 'quote', {..}, or (..)
+func nexusMatrixTokens(s string) (toks []string) {
+	var buffer bytes.Buffer
+	inquote := false
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inquote:
+			buffer.WriteByte(c)
+			if c == '\'' {
+				inquote = false
+			}
+		case c == '\'' && depth == 0:
+			inquote = true
+			buffer.WriteByte(c)
+		case c == '{' || c == '(':
+			depth++
+			buffer.WriteByte(c)
+		case (c == '}' || c == ')') && depth > 0:
+			depth--
+			buffer.WriteByte(c)
+		case isNewickSpace(c):
+			if depth == 0 && buffer.Len() > 0 {
+				toks = append(toks, buffer.String())
+				buffer.Reset()
+			}
+		default:
+			buffer.WriteByte(c)
+		}
+	}
+	if buffer.Len() > 0 {
+		toks = append(toks, buffer.String())
+	}
+	return
+}
+
+// nexusOptions reads KEY=VALUE pairs (and flags like INTERLEAVE). the keys are upper case
+func nexusOptions(s string) map[string]string {
+	opts := make(map[string]string)
+	i := 0
+	skip := func() {
+		for i < len(s) && isNewickSpace(s[i]) {
+			i++
+		}
+	}
+	for {
+		skip()
+		if i >= len(s) {
+			return opts
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && !isNewickSpace(s[i]) {
+			i++
+		}
+		key := strings.ToUpper(s[start:i])
+		skip()
+		if i >= len(s) || s[i] != '=' {
+			opts[key] = ""
+			continue
+		}
+		i++
+		skip()
+		if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+			q := s[i]
+			end := strings.IndexByte(s[i+1:], q)
+			if end == -1 {
+				end = len(s) - i - 1
+			}
+			opts[key] = s[i+1 : i+1+end]
+			i += end + 2
+			continue
+		}
+		start = i
+		for i < len(s) && !isNewickSpace(s[i]) {
+			i++
+		}
+		opts[key] = s[start:i]
+	}
+}
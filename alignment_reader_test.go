@@ -0,0 +1,92 @@
+package gophy_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func writeTestFile(t *testing.T, nm string, s string) string {
+	fn := filepath.Join(t.TempDir(), nm)
+	if err := ioutil.WriteFile(fn, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestReadPhylip(t *testing.T) {
+	files := map[string]string{
+		"strict sequential":   "3 12\nHomo_sapieACGTACGTAC\nGT\nPan       ACGTACGTAC GA\nGorilla   ACGTACGTACTT\n",
+		"relaxed interleaved": "3 12\nHomo_sapiens ACGTAC\nPan ACGTAC\nGorilla ACGTAC\n\nGTACGT\nGTACGA\nGTACTT\n",
+	}
+	for k, s := range files {
+		seqs := gophy.ReadSeqsFromFile(writeTestFile(t, "aln.phy", s))
+		if len(seqs) != 3 {
+			t.Fatal(k, seqs)
+		}
+		if seqs[0].SQ != "ACGTACGTACGT" || seqs[1].NM != "Pan" || seqs[2].SQ != "ACGTACGTACTT" {
+			t.Error(k, seqs)
+		}
+	}
+}
+
+func TestReadNexusDNA(t *testing.T) {
+	nex := `#NEXUS
+BEGIN DATA;
+	DIMENSIONS NTAX=3 NCHAR=8;
+	FORMAT DATATYPE=DNA MISSING=? GAP=- MATCHCHAR=. INTERLEAVE;
+	MATRIX
+	[first block]
+	'taxon one' ACGT
+	b           ..-?
+	c           {AG}CGU
+	'taxon one' ACGT
+	b           ....
+	c           ACG(CT)
+	;
+END;
+`
+	seqs, _, numstates, dt := gophy.ReadAlignmentFromFile(writeTestFile(t, "aln.nex", nex))
+	if dt != gophy.DNAData || numstates != 4 || len(seqs) != 3 {
+		t.Fatal(dt, numstates, seqs)
+	}
+	if seqs[0].NM != "taxon one" || seqs[1].SQ != "AC-NACGT" || seqs[2].SQ != "RCGTACGY" {
+		t.Error(seqs)
+	}
+}
+
+func TestReadNexusStandard(t *testing.T) {
+	nex := `#NEXUS
+BEGIN CHARACTERS;
+	DIMENSIONS NCHAR=5;
+	FORMAT DATATYPE=STANDARD SYMBOLS="0 1 2" MISSING=? GAP=-;
+	MATRIX
+	a 01{01}2?
+	b 1-(12)
+	  00
+	;
+END;
+`
+	fn := writeTestFile(t, "morph.nex", nex)
+	mseqs, numstates := gophy.ReadMSeqsFromFile(fn)
+	if numstates != 3 || len(mseqs) != 2 {
+		t.Fatal(numstates, mseqs)
+	}
+	if mseqs[0].SQ != "0 1 0/1 2 N" || mseqs[1].SQ != "1 - 1/2 0 0" {
+		t.Error(mseqs)
+	}
+	_, mseqs2, _, dt := gophy.ReadAlignmentFromFile(fn)
+	if dt != gophy.StandardData || len(mseqs2) != 2 {
+		t.Error(dt, mseqs2)
+	}
+	bf := gophy.GetEmpiricalBaseFreqsMS(mseqs, numstates)
+	if bf[0] != 3.0/6.0 {
+		t.Error(bf)
+	}
+	_, patternsint, _, _, _, _ := gophy.GetSitePatternsMS(mseqs, gophy.GetMap(numstates), numstates)
+	if len(patternsint) != 5 {
+		t.Error(patternsint)
+	}
+}
@@ -0,0 +1,237 @@
+package gophy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/floats"
+)
+
+// ascertainment bias corrections for data that only has variable (or parsimony
+// informative) characters, like morphology and SNPs
+const (
+	AscLewis       = "lewis"       // Mkv, condition on the site being variable (Lewis 2001)
+	AscLewisInf    = "lewisinf"    // condition on the site being parsimony informative
+	AscFelsenstein = "felsenstein" // add the known number of invariant sites that were left out
+	AscStamatakis  = "stamatakis"  // add the known number of invariant sites of each state that were left out
+)
+
+// SetAscBias set the ascertainment bias correction used by PCalcLikePatterns,
+// PCalcLogLikePatterns, the gamma, Marked, and Mul versions, and OptimizeBLNR.
+// inv is the number of invariant sites that were left out, one number for
+// felsenstein or one for each state for stamatakis (nil for the lewis ones).
+// lewisinf needs more tips (ntips) than states and gets slow with many states.
+// An empty asc turns the correction off
+func (d *DiscreteModel) SetAscBias(asc string, inv []float64, ntips int) error {
+	asc = strings.ToLower(asc)
+	switch asc {
+	case "", AscLewis:
+	case AscLewisInf:
+		if d.NumStates > 12 {
+			return errors.New("lewisinf is only for up to 12 states")
+		}
+		// otherwise a pattern can be uninformative in more than one way and
+		// is counted more than once
+		if ntips <= d.NumStates {
+			return fmt.Errorf("lewisinf needs more tips than the %d states", d.NumStates)
+		}
+	case AscFelsenstein:
+		if len(inv) == 0 {
+			return errors.New("felsenstein needs the number of invariant sites")
+		}
+	case AscStamatakis:
+		if len(inv) != d.NumStates {
+			return fmt.Errorf("stamatakis needs the number of invariant sites for each of the %d states", d.NumStates)
+		}
+	default:
+		return errors.New("unknown ascertainment bias correction " + asc)
+	}
+	d.Asc = asc
+	d.AscInv = inv
+	return nil
+}
+
+// ParseAscInv the comma separated numbers of invariant sites for SetAscBias (from
+// the command line). An empty string is nil
+func ParseAscInv(s string) ([]float64, error) {
+	if len(strings.TrimSpace(s)) == 0 {
+		return nil, nil
+	}
+	var inv []float64
+	for _, v := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, errors.New("problem parsing " + v + " as the number of invariant sites")
+		}
+		inv = append(inv, f)
+	}
+	return inv, nil
+}
+
+// ascCorrection the log likelihood to add to the sum over the patterns for the
+// ascertainment bias correction in x.Asc
+func ascCorrection(t *Tree, x *DiscreteModel, patternval []float64) float64 {
+	return ascCorrectionMul(t, []*DiscreteModel{x}, nil, patternval)
+}
+
+// ascCorrectionMul is ascCorrection with the model of each node in nodemodels.
+// The correction, the rate categories, and PInv are those of models[0] (like the
+// Mul likelihoods)
+func ascCorrectionMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, patternval []float64) float64 {
+	x := models[0]
+	switch x.Asc {
+	case AscLewis:
+		cl, _ := ascLikes(t, models, nodemodels, 0)
+		return -floats.Sum(patternval) * math.Log(1-floats.Sum(cl))
+	case AscLewisInf:
+		_, ul := ascLikes(t, models, nodemodels, x.NumStates-1)
+		return -floats.Sum(patternval) * math.Log(1-ul)
+	case AscFelsenstein:
+		cl, _ := ascLikes(t, models, nodemodels, 0)
+		return floats.Sum(x.AscInv) * math.Log(floats.Sum(cl))
+	case AscStamatakis:
+		cl, _ := ascLikes(t, models, nodemodels, 0)
+		s := 0.
+		for i, w := range x.AscInv {
+			if w > 0 {
+				s += w * math.Log(cl[i])
+			}
+		}
+		return s
+	}
+	return 0
+}
+
+// ascLikes the likelihood of the constant site for each state (cl) and the total
+// likelihood of the parsimony uninformative sites (ul, constant ones included),
+// over the rate categories and invariable sites. ul is only calculated if maxs > 0
+func ascLikes(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, maxs int) (cl []float64, ul float64) {
+	x := models[0]
+	cl = make([]float64, x.NumStates)
+	if x.GammaNCats == 0 {
+		cl, ul = ascLikesRate(t, models, nodemodels, 1.0, maxs)
+	} else {
+		bf := models[nodemodels[t.Rt]].BF
+		rs := invRateScale(x)
+		for ci, g := range x.GammaCats {
+			w := x.catWeight(ci) * (1 - x.PInv)
+			c, u := ascLikesRate(t, models, nodemodels, g*rs, maxs)
+			for i := range cl {
+				cl[i] += w * c[i]
+			}
+			ul += w * u
+		}
+		for i := range cl {
+			cl[i] += x.PInv * bf[i]
+		}
+		ul += x.PInv
+	}
+	return
+}
+
+// ascLikesRate is ascLikes for one rate. the uninformative sites are the ones
+// where every tip has the dominant state d except at most one tip for each of the
+// other states (a set S). The likelihood of all the tips below each node for
+// every S is calculated with the subsets of S split over the children
+func ascLikesRate(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, rate float64, maxs int) (cl []float64, ul float64) {
+	ns := models[0].NumStates
+	bf := models[nodemodels[t.Rt]].BF
+	nsub := 1
+	if maxs > 0 {
+		nsub = 1 << uint(ns)
+	}
+	cl = make([]float64, ns)
+	for d := 0; d < ns; d++ {
+		ls := map[*Node][][]float64{}
+		for _, n := range t.Post {
+			l := make([][]float64, nsub)
+			if len(n.Chs) == 0 {
+				l[0] = make([]float64, ns)
+				l[0][d] = 1.
+				if maxs > 0 {
+					for e := 0; e < ns; e++ {
+						if e != d {
+							l[1<<uint(e)] = make([]float64, ns)
+							l[1<<uint(e)][e] = 1.
+						}
+					}
+				}
+				ls[n] = l
+				continue
+			}
+			l[0] = make([]float64, ns)
+			for i := range l[0] {
+				l[0][i] = 1.
+			}
+			for _, c := range n.Chs {
+				P := models[nodemodels[c]].GetPMapRate(c.Len, rate)
+				v := make([][]float64, nsub)
+				for s, cs := range ls[c] {
+					if cs == nil {
+						continue
+					}
+					v[s] = make([]float64, ns)
+					for i := 0; i < ns; i++ {
+						for j := 0; j < ns; j++ {
+							v[s][i] += P.At(i, j) * cs[j]
+						}
+					}
+				}
+				nl := make([][]float64, nsub)
+				for s := 0; s < nsub; s++ {
+					if s&(1<<uint(d)) != 0 || bits.OnesCount(uint(s)) > maxs {
+						continue
+					}
+					// every split of s into the ones below the node so far and c
+					for s1 := s; ; s1 = (s1 - 1) & s {
+						if l[s1] != nil && v[s^s1] != nil {
+							if nl[s] == nil {
+								nl[s] = make([]float64, ns)
+							}
+							for i := 0; i < ns; i++ {
+								nl[s][i] += l[s1][i] * v[s^s1][i]
+							}
+						}
+						if s1 == 0 {
+							break
+						}
+					}
+				}
+				l = nl
+			}
+			ls[n] = l
+		}
+		for s, rl := range ls[t.Rt] {
+			if rl == nil {
+				continue
+			}
+			sl := floats.Dot(rl, bf)
+			if s == 0 {
+				cl[d] = sl
+			}
+			ul += sl
+		}
+	}
+	return
+}
+
+// ascCorrectionDerivs the first and second derivatives of ascCorrection with the
+// length of n (from a parabola through three lengths) for OptimizeBLNR
+func ascCorrectionDerivs(t *Tree, n *Node, x *DiscreteModel, patternval []float64) (d1, d2 float64) {
+	l := n.Len
+	h := math.Max(l*1e-3, 1e-6)
+	lo := math.Max(l-h, 0)
+	c := make([]float64, 3)
+	for i := range c {
+		n.Len = lo + float64(i)*h
+		c[i] = ascCorrection(t, x, patternval)
+	}
+	n.Len = l
+	d2 = (c[0] - 2*c[1] + c[2]) / (h * h)
+	d1 = (c[2]-c[0])/(2*h) + d2*(l-lo-h)
+	return
+}
@@ -0,0 +1,141 @@
+package gophy_test
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+// every pattern of 3 states for 5 tips, checked against the sums over the
+// constant and uninformative ones
+func TestAscBias(t *testing.T) {
+	tr := gophy.NewTree()
+	tr.Instantiate(gophy.ReadNewickString("((a:0.1,b:0.2):0.05,c:0.3,(d:0.15,e:0.25):0.1);"))
+	names := []string{"a", "b", "c", "d", "e"}
+	ns := 3
+	seqs := map[string][]string{}
+	patternsint := map[int]float64{}
+	npat := int(math.Pow(float64(ns), float64(len(names))))
+	for p := 0; p < npat; p++ {
+		v := p
+		for _, nm := range names {
+			seqs[nm] = append(seqs[nm], strconv.Itoa(v%ns))
+			v /= ns
+		}
+		patternsint[p] = 1
+	}
+	x := gophy.NewMultStateModel(ns)
+	x.M.SetBaseFreqs([]float64{0.5, 0.3, 0.2})
+	x.M.SetupQMk([]float64{0.8, 0.3, 0.5, 1.2, 0.4, 0.9}, false)
+	x.M.GammaNCats = 4
+	x.M.GammaCats = gophy.GetGammaCats(0.5, 4, false)
+	x.M.PInv = 0.1
+	patternval, patternvec := gophy.PreparePatternVecsMS(tr, patternsint, seqs, x.M.CharMap, ns)
+	constl := make([]float64, ns)
+	uninf, tot := 0., 0.
+	for i, p := range patternvec {
+		l := gophy.CalcLikeOneSiteGamma(tr, &x.M, i)
+		tot += l
+		counts := make([]int, ns)
+		for _, nm := range names {
+			s, _ := strconv.Atoi(seqs[nm][p])
+			counts[s]++
+		}
+		twos := 0
+		for s, c := range counts {
+			if c == len(names) {
+				constl[s] += l
+			}
+			if c >= 2 {
+				twos++
+			}
+		}
+		if twos < 2 {
+			uninf += l
+		}
+	}
+	if math.Abs(tot-1) > 1e-9 {
+		t.Fatal("the patterns should sum to 1", tot)
+	}
+	pv := patternval[:1]
+	lnl := gophy.PCalcLogLikePatternsGamma(tr, &x.M, pv, 1)
+	tests := []struct {
+		asc  string
+		inv  []float64
+		corr float64
+	}{
+		{gophy.AscLewis, nil, -math.Log(1 - constl[0] - constl[1] - constl[2])},
+		{gophy.AscLewisInf, nil, -math.Log(1 - uninf)},
+		{gophy.AscFelsenstein, []float64{10}, 10 * math.Log(constl[0]+constl[1]+constl[2])},
+		{gophy.AscStamatakis, []float64{5, 0, 2}, 5*math.Log(constl[0]) + 2*math.Log(constl[2])},
+	}
+	for _, tc := range tests {
+		if err := x.M.SetAscBias(tc.asc, tc.inv, 5); err != nil {
+			t.Fatal(err)
+		}
+		alnl := gophy.PCalcLogLikePatternsGamma(tr, &x.M, pv, 1)
+		if math.Abs(alnl-lnl-tc.corr) > 1e-9 {
+			t.Error(tc.asc, alnl-lnl, tc.corr)
+		}
+	}
+	// and without rate variation with the other likelihood functions
+	x.M.GammaNCats = 0
+	x.M.PInv = 0
+	x.M.SetAscBias("", nil, 5)
+	lnl = gophy.PCalcLikePatterns(tr, &x.M, pv, 1)
+	x.M.SetAscBias(gophy.AscLewis, nil, 5)
+	c := 0.
+	for s := 0; s < ns; s++ {
+		for i, p := range patternvec {
+			if seqs["a"][p] == strconv.Itoa(s) && seqs["b"][p] == seqs["a"][p] && seqs["c"][p] == seqs["a"][p] &&
+				seqs["d"][p] == seqs["a"][p] && seqs["e"][p] == seqs["a"][p] {
+				c += gophy.CalcLikeOneSite(tr, &x.M, i)
+			}
+		}
+	}
+	if alnl := gophy.PCalcLogLikePatterns(tr, &x.M, pv, 1); math.Abs(alnl-lnl+math.Log(1-c)) > 1e-9 {
+		t.Error("Mkv", alnl-lnl, -math.Log(1-c))
+	}
+	// the Marked and Mul versions have the correction too
+	alnl := gophy.PCalcLikePatterns(tr, &x.M, patternval, 1)
+	if mlnl := gophy.PCalcLikePatternsMarked(tr, &x.M, patternval, 1); math.Abs(mlnl-alnl) > 1e-9 {
+		t.Error("Marked", alnl, mlnl)
+	}
+	models := []*gophy.DiscreteModel{&x.M}
+	if mlnl := gophy.PCalcLikePatternsMul(tr, models, map[*gophy.Node]int{}, patternval, 1); math.Abs(mlnl-alnl) > 1e-9 {
+		t.Error("Mul", alnl, mlnl)
+	}
+	// NR on the expected counts of the variable patterns with the correction gets
+	// to where no tip branch length change is better (without the correction NR
+	// doesn't get the internal ones here either)
+	for i, p := range patternvec {
+		patternval[i] = 1000 * gophy.CalcLikeOneSite(tr, &x.M, i)
+		if seqs["a"][p] == seqs["b"][p] && seqs["a"][p] == seqs["c"][p] && seqs["a"][p] == seqs["d"][p] &&
+			seqs["a"][p] == seqs["e"][p] {
+			patternval[i] = 0
+		}
+	}
+	for _, n := range tr.Post {
+		n.Len = 0.1
+	}
+	gophy.OptimizeBLNR(tr, &x.M, patternval, 1)
+	blnl := gophy.PCalcLikePatterns(tr, &x.M, patternval, 1)
+	for _, n := range tr.Tips {
+		l := n.Len
+		for _, f := range []float64{0.95, 1.05} {
+			n.Len = l * f
+			if plnl := gophy.PCalcLikePatterns(tr, &x.M, patternval, 1); plnl > blnl+1e-6 {
+				t.Error("NR with the correction isn't at the optimum", n.Nam, l, blnl, plnl)
+			}
+		}
+		n.Len = l
+	}
+	if x.M.SetAscBias(gophy.AscStamatakis, []float64{1}, 5) == nil || x.M.SetAscBias("nope", nil, 5) == nil {
+		t.Error("bad corrections should be errors")
+	}
+	if x.M.SetAscBias(gophy.AscLewisInf, nil, 3) == nil {
+		t.Error("lewisinf needs more tips than states")
+	}
+}
@@ -130,7 +130,7 @@ func main() {
 		os.Exit(1)
 	}
 	defer f.Close()
-	scanner := bufio.NewReader(f)
+	scanner := gophy.NewTreeStream(f)
 	bps := make([]gophy.Bipart, 0) // list of all biparts
 	bpts := make(map[int][]int)    // key tree index, value bipart index list
 	ntrees := 0
@@ -144,50 +144,50 @@ func main() {
 	//start a timer
 	start := time.Now()
 
-	// reading the trees
+	// reading the trees (newick or nexus)
 	fmt.Fprint(os.Stderr, "reading trees\n")
 	for {
-		ln, err := scanner.ReadString('\n')
-		if len(ln) > 0 {
-			if rngcheck {
-				if ntrees < rngstart {
-					ntrees++
-					continue
-				}
-				if ntrees >= rngstop {
-					continue
-				}
+		rtr, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*gophy.NewickError); ok {
+			fmt.Fprintln(os.Stderr, *fn+":", err)
+			continue
+		} else if err != nil {
+			log.Printf("read error: %v", err)
+			break
+		}
+		if rngcheck {
+			if ntrees < rngstart {
+				ntrees++
+				continue
 			}
-			rt := gophy.ReadNewickString(ln)
-			var t gophy.Tree
-			t.Index = ntrees
-			t.Instantiate(rt)
-			trees = append(trees, t)
-			for _, n := range t.Tips {
-				if gophy.StringSliceContains(ignore, n.Nam) {
-					continue
-				}
-				if _, ok := maptips[n.Nam]; !ok {
-					maptips[n.Nam] = numtips
-					mapints[numtips] = n.Nam
-					numtips++
-				}
+			if ntrees >= rngstop {
+				break
 			}
-			ntrees++
-			readtrees++
-			if ntrees%10 == 0 {
-				fmt.Fprint(os.Stderr, ".")
+		}
+		var t gophy.Tree
+		t.Index = ntrees
+		t.Instantiate(rtr.Rt)
+		trees = append(trees, t)
+		for _, n := range t.Tips {
+			if gophy.StringSliceContains(ignore, n.Nam) {
+				continue
 			}
-			if ntrees%100 == 0 {
-				fmt.Fprint(os.Stderr, "\n")
+			if _, ok := maptips[n.Nam]; !ok {
+				maptips[n.Nam] = numtips
+				mapints[numtips] = n.Nam
+				numtips++
 			}
 		}
-		if err == io.EOF {
-			break
+		ntrees++
+		readtrees++
+		if ntrees%10 == 0 {
+			fmt.Fprint(os.Stderr, ".")
 		}
-		if err != nil {
-			log.Printf("read %s bytes: %v", ln, err)
-			break
+		if ntrees%100 == 0 {
+			fmt.Fprint(os.Stderr, "\n")
 		}
 	}
 	fmt.Fprint(os.Stderr, "\n")
@@ -238,7 +238,7 @@ func main() {
 	//pairwise comparisons
 	if *pca {
 		fmt.Println("--biparts compared to those in the pool of biparts")
-		gophy.CompareTreeToBiparts(bps, bps, *wks, mapints, *v, *tv)
+		gophy.CompareTreeToBiparts(bps, bps, *wks, mapints, *v, *tv, false)
 		/* This is the old comparison to a pool. I don't think we wnat this
 		for j, k := range bpts { // j is tree index, k is list of biparts in bps
 			comptreebps := make([]gophy.Bipart, 0)
@@ -271,7 +271,7 @@ func main() {
 					}
 					fmt.Println("comparing", j, i)
 					start := time.Now()
-					gophy.CompareTreeToBiparts(comptreebps2, comptreebps1, *wks, mapints, *v, *tv)
+					gophy.CompareTreeToBiparts(comptreebps2, comptreebps1, *wks, mapints, *v, *tv, false)
 					end := time.Now()
 					fmt.Fprintln(os.Stderr, "comp done:", end.Sub(start))
 				}
@@ -498,24 +498,12 @@ func runConflict(outfile string, workers int, bps []gophy.Bipart, mapints map[in
 func runCompare(rp RunParams, ignore []string, compfile string, workers int, mapints map[int]string,
 	maptips map[string]int, bps []gophy.Bipart, numtrees int, verbose bool, treeverbose bool) {
 	fmt.Fprintln(os.Stderr, "--biparts compared to those in", compfile, "--")
-	fc, err := os.Open(compfile)
-	if err != nil {
-		fmt.Println(err)
-	}
-	defer fc.Close()
-	csc := bufio.NewScanner(fc)
 	comptreebps := make([]gophy.Bipart, 0)
 	/*
 	   read tree and get biparts
 	*/
-	var t gophy.Tree
-	for csc.Scan() {
-		ln := csc.Text()
-		if len(ln) < 2 {
-			continue
-		}
-		rt := gophy.ReadNewickString(ln)
-		t.Instantiate(rt)
+	var t *gophy.Tree
+	for _, t = range gophy.ReadTreesFromFile(compfile) {
 		for _, n := range t.Tips {
 			if _, ok := maptips[n.Nam]; !ok {
 				fmt.Fprintln(os.Stderr, "need to figure out what to do when these tips don't map on the compare tree", n.Nam)
@@ -559,7 +547,7 @@ func runCompare(rp RunParams, ignore []string, compfile string, workers int, map
 	// this is just going to run it on each edge independently
 	for i := range comptreebps {
 		tc := []gophy.Bipart{comptreebps[i]}
-		gophy.CompareTreeToBiparts(bps, tc, workers, mapints, verbose, treeverbose)
+		gophy.CompareTreeToBiparts(bps, tc, workers, mapints, verbose, treeverbose, false)
 	}
 	//
 	end := time.Now()
@@ -19,6 +19,9 @@ func main() {
 	tfn := flag.String("t", "", "tree filename")
 	afn := flag.String("s", "", "seq filename")
 	wks := flag.Int("w", 4, "number of threads")
+	asc := flag.String("asc", "", "ascertainment bias correction [lewis/lewisinf/felsenstein/stamatakis] for data without the constant characters")
+	ascw := flag.String("ascw", "", "comma separated number of invariant sites left out (one for felsenstein, one per state for stamatakis)")
+	qmask := flag.String("qmask", "", "file with the allowed changes (rows of 0s and 1s) or ordered")
 	flag.Parse()
 	if len(*tfn) == 0 {
 		fmt.Fprintln(os.Stderr, "need a tree filename (-t)")
@@ -58,15 +61,14 @@ func main() {
 		seqnames = append(seqnames, i.NM)
 		nsites = len(i.SQ)
 	}
-	x := gophy.NewMultStateModel()
-	x.NumStates = numstates
-	x.SetMap()
+	y := gophy.NewMultStateModel(numstates)
+	x := &y.M
 	bf := gophy.GetEmpiricalBaseFreqsMS(mseqs, x.NumStates)
 	x.SetBaseFreqs(bf)
 	x.EBF = x.BF
 	// get the site patternas
-	patterns, patternsint, gapsites, constant, uninformative, _ := gophy.GetSitePatternsMS(mseqs, x)
-	patternval, _ := gophy.PreparePatternVecsMS(t, patternsint, seqs, x)
+	patterns, patternsint, gapsites, constant, uninformative, _ := gophy.GetSitePatternsMS(mseqs, x.CharMap, x.NumStates)
+	patternval, _ := gophy.PreparePatternVecsMS(t, patternsint, seqs, x.CharMap, x.NumStates)
 	//list of sites
 	fmt.Fprintln(os.Stderr, "nsites:", nsites)
 	fmt.Fprintln(os.Stderr, "patterns:", len(patterns), len(patternsint))
@@ -80,14 +82,34 @@ func main() {
 	if nsites < w {
 		w = nsites
 	}
-	x.SetupQJC()
-	l := gophy.PCalcLikePatternsMS(t, x, patternval, *wks)
+	if len(*qmask) > 0 {
+		mask, err := gophy.ReadQMaskFile(*qmask, x.NumStates)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		x.SetupQJCMask(mask)
+	} else {
+		x.SetupQJC()
+	}
+	inv, err := gophy.ParseAscInv(*ascw)
+	if err == nil {
+		err = x.SetAscBias(*asc, inv, len(t.Tips))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(*asc) > 0 && len(constant) > 0 {
+		fmt.Fprintln(os.Stderr, "warning: there are constant characters and an ascertainment bias correction")
+	}
+	l := gophy.PCalcLikePatterns(t, x, patternval, *wks)
 	fmt.Println("starting lnL:", l)
 
 	//optimize branch lengths
 	fmt.Println("start:\n" + t.Rt.Newick(true) + ";")
-	gophy.OptimizeBLNRMS(t, x, patternval, 10)
-	l = gophy.PCalcLikePatternsMS(t, x, patternval, *wks)
+	gophy.OptimizeBLNR(t, x, patternval, 10)
+	l = gophy.PCalcLikePatterns(t, x, patternval, *wks)
 	fmt.Println("ln:", l)
 	fmt.Println("end:\n" + t.Rt.Newick(true) + ";")
 }
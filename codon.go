@@ -0,0 +1,285 @@
+package gophy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// GeneticCode a translation table (the NCBI ones). The sense codons, in ACGT
+// order, are the states of the codon models
+type GeneticCode struct {
+	ID     int
+	Name   string
+	AA     map[string]byte // codon to amino acid, '*' for stops
+	Codons []string        // the sense codons
+	index  map[string]int
+}
+
+// the NCBI translation tables, the amino acids for codons in TCAG order
+// (TTT, TTC, TTA, TTG, TCT, ...)
+var geneticCodeTables = map[int][2]string{
+	1:  {"standard", "FFLLSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG"},
+	2:  {"vertebrate mitochondrial", "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSS**VVVVAAAADDEEGGGG"},
+	3:  {"yeast mitochondrial", "FFLLSSSSYY**CCWWTTTTPPPPHHQQRRRRIIMMTTTTNNKKSSRRVVVVAAAADDEEGGGG"},
+	4:  {"mold, protozoan, and coelenterate mitochondrial", "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG"},
+	5:  {"invertebrate mitochondrial", "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSSSSVVVVAAAADDEEGGGG"},
+	6:  {"ciliate, dasycladacean and hexamita nuclear", "FFLLSSSSYYQQCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG"},
+	9:  {"echinoderm and flatworm mitochondrial", "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNNKSSSSVVVVAAAADDEEGGGG"},
+	10: {"euplotid nuclear", "FFLLSSSSYY**CCCWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG"},
+	11: {"bacterial, archaeal and plant plastid", "FFLLSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG"},
+	12: {"alternative yeast nuclear", "FFLLSSSSYY**CC*WLLLSPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG"},
+	13: {"ascidian mitochondrial", "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSSGGVVVVAAAADDEEGGGG"},
+}
+
+// GetGeneticCode get one of the NCBI translation tables by number (1 is the
+// standard code)
+func GetGeneticCode(id int) (*GeneticCode, error) {
+	tb, ok := geneticCodeTables[id]
+	if !ok {
+		ids := []string{}
+		for i := range geneticCodeTables {
+			ids = append(ids, fmt.Sprint(i))
+		}
+		sort.Strings(ids)
+		return nil, fmt.Errorf("no genetic code %d (there are %s)", id, strings.Join(ids, ", "))
+	}
+	gc := &GeneticCode{ID: id, Name: tb[0], AA: map[string]byte{}, index: map[string]int{}}
+	tcag := "TCAG"
+	for i := 0; i < 64; i++ {
+		cd := string([]byte{tcag[i/16], tcag[(i/4)%4], tcag[i%4]})
+		gc.AA[cd] = tb[1][i]
+	}
+	for _, a := range "ACGT" {
+		for _, b := range "ACGT" {
+			for _, c := range "ACGT" {
+				cd := string([]rune{a, b, c})
+				if gc.AA[cd] != '*' {
+					gc.index[cd] = len(gc.Codons)
+					gc.Codons = append(gc.Codons, cd)
+				}
+			}
+		}
+	}
+	return gc, nil
+}
+
+// NumStates the number of sense codons (61 for the standard code)
+func (g *GeneticCode) NumStates() int {
+	return len(g.Codons)
+}
+
+// IsStop is the codon a stop codon
+func (g *GeneticCode) IsStop(codon string) bool {
+	return g.AA[strings.ToUpper(codon)] == '*'
+}
+
+// Synonymous do the two codons code for the same amino acid
+func (g *GeneticCode) Synonymous(c1, c2 string) bool {
+	return g.AA[c1] == g.AA[c2]
+}
+
+// CodonStates the states a codon could be. Ambiguity codes (and gaps) at each
+// position are expanded, stop codons are left out. A codon that is all gaps,
+// is a stop, or has only stops in its possibilities is missing (all the states)
+func (g *GeneticCode) CodonStates(codon string) []int {
+	nm := GetNucMap()
+	codon = strings.ToUpper(strings.Replace(codon, "U", "T", -1))
+	var states []int
+	if len(codon) == 3 {
+		p1, p2, p3 := nm[codon[0:1]], nm[codon[1:2]], nm[codon[2:3]]
+		acgt := "ACGT"
+		for _, a := range p1 {
+			for _, b := range p2 {
+				for _, c := range p3 {
+					if i, ok := g.index[string([]byte{acgt[a], acgt[b], acgt[c]})]; ok {
+						states = append(states, i)
+					}
+				}
+			}
+		}
+	}
+	if len(states) == 0 {
+		states = make([]int, len(g.Codons))
+		for i := range states {
+			states[i] = i
+		}
+		return states
+	}
+	sort.Ints(states)
+	return states
+}
+
+// GetCodonMap get the int map for the sense codons and the gap/missing codons
+func GetCodonMap(gc *GeneticCode) (charMap map[string][]int) {
+	charMap = make(map[string][]int)
+	for i, c := range gc.Codons {
+		charMap[c] = []int{i}
+	}
+	charMap["---"] = gc.CodonStates("---")
+	charMap["NNN"] = gc.CodonStates("NNN")
+	return
+}
+
+// GetSitePatternsCodon return the codon site patterns. The sites are the codons
+// (so nucleotide site / 3) and the sequences need to be a multiple of 3 long
+func GetSitePatternsCodon(seqs map[string]string, seqnames []string, gc *GeneticCode) (patterns map[string][]int,
+	patternsint map[int]float64, gapsites []int, err error) {
+	ncodons := -1
+	for _, nm := range seqnames {
+		if len(seqs[nm])%3 != 0 {
+			return nil, nil, nil, errors.New(nm + " is not a multiple of 3 long")
+		}
+		if ncodons >= 0 && len(seqs[nm])/3 != ncodons {
+			return nil, nil, nil, errors.New(nm + " is not the same length as the other sequences")
+		}
+		ncodons = len(seqs[nm]) / 3
+	}
+	patterns = make(map[string][]int)
+	for k := 0; k < ncodons; k++ {
+		var tp strings.Builder
+		gapcount := 0
+		for _, nm := range seqnames {
+			cd := strings.ToUpper(seqs[nm][k*3 : k*3+3])
+			if len(gc.CodonStates(cd)) == gc.NumStates() {
+				gapcount++
+			}
+			tp.WriteString(cd)
+		}
+		if gapcount == len(seqnames) {
+			gapsites = append(gapsites, k)
+			continue
+		}
+		patterns[tp.String()] = append(patterns[tp.String()], k)
+	}
+	patternsint = make(map[int]float64) // key is first site, value is the number of that one
+	for _, j := range patterns {
+		patternsint[j[0]] = float64(len(j))
+	}
+	return
+}
+
+// PreparePatternVecsCodon for tree calculations with codons (from
+// GetSitePatternsCodon). Stop codons in the data are treated as missing
+func PreparePatternVecsCodon(t *Tree, patternsint map[int]float64, seqs map[string]string,
+	gc *GeneticCode) (patternval []float64, patternvec []int) {
+	numstates := gc.NumStates()
+	patternvec = make([]int, len(patternsint))     //which site
+	patternval = make([]float64, len(patternsint)) //log of number of sites
+	count := 0
+	for i := range patternsint {
+		patternvec[count] = i
+		patternval[count] = patternsint[i]
+		count++
+	}
+	for _, n := range t.Post {
+		n.Data = make([][]float64, len(patternsint))
+		n.TpConds = make([][]float64, len(patternval))
+		for i := 0; i < len(patternsint); i++ {
+			n.Data[i] = make([]float64, numstates)
+			n.TpConds[i] = make([]float64, numstates)
+		}
+		if len(n.Chs) == 0 {
+			if _, ok := seqs[n.Nam]; !ok {
+				fmt.Fprintln(os.Stderr, n.Nam, "is not in the sequences")
+				os.Exit(1)
+			}
+			for k, i := range patternvec {
+				for _, j := range gc.CodonStates(seqs[n.Nam][i*3 : i*3+3]) {
+					n.Data[k][j] = 1.0
+					n.TpConds[k][j] = 1.0
+				}
+			}
+		}
+	}
+	return
+}
+
+// GetCodonFreqs the equilibrium codon frequencies for F1x4 (nucleotide
+// frequencies pooled over positions), F3x4 (by position), or F61 (the codon
+// counts). nf are the nucleotide frequencies (A,C,G,T) at each position (the
+// pooled ones for F1x4) which are needed for MG94
+func GetCodonFreqs(seqs map[string]string, gc *GeneticCode, freqs string) (cf []float64, nf [][]float64, err error) {
+	nm := GetNucMap()
+	nf = make([][]float64, 3)
+	for i := range nf {
+		nf[i] = make([]float64, 4)
+	}
+	cf = make([]float64, gc.NumStates())
+	for _, s := range seqs {
+		s = strings.ToUpper(s)
+		for k := 0; k+3 <= len(s); k += 3 {
+			cd := s[k : k+3]
+			if gc.IsStop(cd) {
+				continue
+			}
+			if sts := gc.CodonStates(cd); len(sts) < gc.NumStates() {
+				for _, j := range sts {
+					cf[j] += 1. / float64(len(sts))
+				}
+			}
+			for p := 0; p < 3; p++ {
+				sts := nm[cd[p:p+1]]
+				if len(sts) == 4 {
+					continue
+				}
+				for _, j := range sts {
+					nf[p][j] += 1. / float64(len(sts))
+				}
+			}
+		}
+	}
+	switch strings.ToUpper(freqs) {
+	case "F1X4":
+		for j := 0; j < 4; j++ {
+			pooled := nf[0][j] + nf[1][j] + nf[2][j]
+			for p := 0; p < 3; p++ {
+				nf[p][j] = pooled
+			}
+		}
+		fallthrough
+	case "F3X4":
+		for p := 0; p < 3; p++ {
+			normalizeFreqs(nf[p])
+		}
+		cf = codonFreqsFromNucs(gc, nf)
+	case "F61":
+		for p := 0; p < 3; p++ {
+			normalizeFreqs(nf[p])
+		}
+		for i := range cf {
+			// codons that aren't seen would never be reached
+			cf[i] += 0.5
+		}
+		normalizeFreqs(cf)
+	default:
+		return nil, nil, errors.New("codon frequencies should be F1x4, F3x4, or F61 not " + freqs)
+	}
+	return
+}
+
+// codonFreqsFromNucs the codon frequencies as the product of the position
+// nucleotide frequencies (normalised over the sense codons)
+func codonFreqsFromNucs(gc *GeneticCode, nf [][]float64) []float64 {
+	cf := make([]float64, gc.NumStates())
+	for i, cd := range gc.Codons {
+		cf[i] = 1.
+		for p := 0; p < 3; p++ {
+			cf[i] *= nf[p][strings.IndexByte("ACGT", cd[p])]
+		}
+	}
+	normalizeFreqs(cf)
+	return cf
+}
+
+func normalizeFreqs(f []float64) {
+	sum := 0.
+	for _, i := range f {
+		sum += i
+	}
+	for i := range f {
+		f[i] /= sum
+	}
+}
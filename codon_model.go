@@ -0,0 +1,422 @@
+package gophy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// codon model names
+const (
+	GY94 = "GY94"
+	MG94 = "MG94"
+)
+
+// CodonModel a GY94 or MG94 codon model with kappa (transition/transversion) and
+// omega (dN/dS). The rates are in M so it works with the likelihood functions
+// and DecomposeQ like the other models
+type CodonModel struct {
+	M        DiscreteModel
+	Code     *GeneticCode
+	Sub      string // GY94 or MG94
+	Freqs    string // F1x4, F3x4, or F61
+	Kappa    float64
+	Omega    float64
+	NucFreqs [][]float64 // nucleotide frequencies (A,C,G,T) at each codon position
+}
+
+// NewCodonModel get a GY94 or MG94 codon model with the codon frequencies (F1x4,
+// F3x4, or F61, see GetCodonFreqs) from seqs. MG94 needs F1x4 or F3x4 as the
+// rates are proportional to the nucleotide frequencies
+func NewCodonModel(sub string, gc *GeneticCode, freqs string, seqs map[string]string) (*CodonModel, error) {
+	c := &CodonModel{Code: gc, Sub: strings.ToUpper(sub), Freqs: strings.ToUpper(freqs), Kappa: 2.0, Omega: 0.4}
+	if c.Sub != GY94 && c.Sub != MG94 {
+		return nil, errors.New("codon model should be GY94 or MG94 not " + sub)
+	}
+	if c.Sub == MG94 && c.Freqs == "F61" {
+		return nil, errors.New("MG94 needs F1x4 or F3x4 codon frequencies")
+	}
+	cf, nf, err := GetCodonFreqs(seqs, gc, freqs)
+	if err != nil {
+		return nil, err
+	}
+	c.NucFreqs = nf
+	c.M.Alph = Codon
+	c.M.NumStates = gc.NumStates()
+	c.M.CharMap = GetCodonMap(gc)
+	c.M.SetBaseFreqs(cf)
+	c.M.EBF = cf
+	c.SetKappaOmega(c.Kappa, c.Omega)
+	return c, nil
+}
+
+// SetKappaOmega set kappa and omega and setup Q
+func (c *CodonModel) SetKappaOmega(kappa, omega float64) {
+	c.Kappa = kappa
+	c.Omega = omega
+	c.M.R = c.codonR(kappa, omega)
+	c.M.SetupQGTR()
+}
+
+// NumParams kappa, omega, and the frequencies (3 for F1x4, 9 for F3x4, or the
+// number of codons - 1 for F61)
+func (c *CodonModel) NumParams() int {
+	return 2 + c.numFreqParams()
+}
+
+func (c *CodonModel) numFreqParams() int {
+	switch c.Freqs {
+	case "F1X4":
+		return 3
+	case "F3X4":
+		return 9
+	}
+	return c.M.NumStates - 1
+}
+
+func isTransition(a, b byte) bool {
+	return (a == 'A' && b == 'G') || (a == 'G' && b == 'A') || (a == 'C' && b == 'T') || (a == 'T' && b == 'C')
+}
+
+// codonR the exchangeabilities for kappa and omega so that Q = R diag(BF). Codons
+// that differ at more than one position have 0. For MG94 the rate to a codon is
+// proportional to the frequency of the new nucleotide (at that position) instead of
+// the codon, which is R / the frequencies of the other two positions
+func (c *CodonModel) codonR(kappa, omega float64) *mat.Dense {
+	n := c.M.NumStates
+	R := mat.NewDense(n, n, nil)
+	for i, ci := range c.Code.Codons {
+		for j := i + 1; j < n; j++ {
+			cj := c.Code.Codons[j]
+			diff, p := 0, 0
+			for k := 0; k < 3; k++ {
+				if ci[k] != cj[k] {
+					diff++
+					p = k
+				}
+			}
+			if diff != 1 {
+				continue
+			}
+			r := 1.
+			if isTransition(ci[p], cj[p]) {
+				r *= kappa
+			}
+			if c.Code.Synonymous(ci, cj) == false {
+				r *= omega
+			}
+			if c.Sub == MG94 {
+				for k := 0; k < 3; k++ {
+					if k != p {
+						r /= c.NucFreqs[k][strings.IndexByte("ACGT", ci[k])]
+					}
+				}
+			}
+			R.Set(i, j, r)
+			R.Set(j, i, r)
+		}
+	}
+	return R
+}
+
+// meanRate the expected number of changes per unit time for R and the frequencies
+func meanRate(R *mat.Dense, bf []float64) float64 {
+	mr := 0.
+	for i := range bf {
+		for j := range bf {
+			if i != j {
+				mr += bf[i] * R.At(i, j) * bf[j]
+			}
+		}
+	}
+	return mr
+}
+
+// OptimizeCodonModel optimize kappa and omega (M0) with the branch lengths fixed
+func OptimizeCodonModel(t *Tree, c *CodonModel, patternvals []float64, wks int) []float64 {
+	fcn := func(mds []float64) float64 {
+		for _, i := range mds {
+			if i <= 0 || i > 999 {
+				return 1000000000000
+			}
+		}
+		c.SetKappaOmega(mds[0], mds[1])
+		lnl := PCalcLogLikePatterns(t, &c.M, patternvals, wks)
+		return -lnl
+	}
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, []float64{c.Kappa, c.Omega}, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	c.SetKappaOmega(res.X[0], res.X[1])
+	return res.X
+}
+
+// codon site model names
+const (
+	M0  = "M0"
+	M1a = "M1a"
+	M2a = "M2a"
+	M7  = "M7"
+	M8  = "M8"
+)
+
+// the site model parameters and their starting values
+var codonSiteParams = map[string][]float64{
+	M0:  {0.4},                // omega
+	M1a: {0.7, 0.1},           // p0, omega0 < 1 (omega1 = 1)
+	M2a: {0.6, 0.3, 0.1, 2.0}, // p0, p1, omega0 < 1, omega2 > 1 (omega1 = 1)
+	M7:  {0.5, 0.5},           // p and q of the beta for omega
+	M8:  {0.9, 0.5, 0.5, 2.0}, // p0 (of the beta), p, q, omegas > 1
+}
+
+// CodonSiteModel one of the site models (M0, M1a, M2a, M7, M8) where each site is
+// from a mixture of omega classes. The classes share kappa, the codon
+// frequencies, and the branch lengths, and Q is scaled so that the mean rate over
+// the classes is 1
+type CodonSiteModel struct {
+	Name    string
+	C       *CodonModel
+	Params  []float64 // see codonSiteParams
+	NCats   int       // number of beta categories for M7 and M8
+	Props   []float64 // proportion of each class
+	Omegas  []float64 // omega of each class
+	Classes []*DiscreteModel
+}
+
+// NewCodonSiteModel get a site model with the starting parameters using kappa and
+// the frequencies from c
+func NewCodonSiteModel(name string, c *CodonModel) (*CodonSiteModel, error) {
+	p, ok := codonSiteParams[name]
+	if !ok {
+		return nil, errors.New("codon site model should be M0, M1a, M2a, M7, or M8 not " + name)
+	}
+	m := &CodonSiteModel{Name: name, C: c, NCats: 10}
+	if err := m.SetParams(c.Kappa, p); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NumParams kappa, the site model parameters, and the codon frequencies
+func (m *CodonSiteModel) NumParams() int {
+	return 1 + len(m.Params) + m.C.numFreqParams()
+}
+
+// SetParams set kappa and the site model parameters and setup the Q of each class
+func (m *CodonSiteModel) SetParams(kappa float64, params []float64) error {
+	if len(params) != len(codonSiteParams[m.Name]) {
+		return fmt.Errorf("%s has %d parameters not %d", m.Name, len(codonSiteParams[m.Name]), len(params))
+	}
+	if kappa <= 0 {
+		return errors.New("kappa should be > 0")
+	}
+	p := params
+	switch m.Name {
+	case M0:
+		if p[0] <= 0 {
+			return errors.New("omega should be > 0")
+		}
+		m.Props, m.Omegas = []float64{1.0}, []float64{p[0]}
+	case M1a:
+		if p[0] < 0 || p[0] > 1 || p[1] <= 0 || p[1] >= 1 {
+			return errors.New("M1a needs 0 <= p0 <= 1 and 0 < omega0 < 1")
+		}
+		m.Props, m.Omegas = []float64{p[0], 1 - p[0]}, []float64{p[1], 1.0}
+	case M2a:
+		if p[0] < 0 || p[1] < 0 || p[0]+p[1] > 1 || p[2] <= 0 || p[2] >= 1 || p[3] < 1 {
+			return errors.New("M2a needs p0 + p1 <= 1, 0 < omega0 < 1, and omega2 >= 1")
+		}
+		m.Props, m.Omegas = []float64{p[0], p[1], 1 - p[0] - p[1]}, []float64{p[2], 1.0, p[3]}
+	case M7, M8:
+		bp := p
+		p0 := 1.0
+		if m.Name == M8 {
+			if p[0] < 0 || p[0] > 1 || p[3] < 1 {
+				return errors.New("M8 needs 0 <= p0 <= 1 and omegas >= 1")
+			}
+			p0 = p[0]
+			bp = p[1:3]
+		}
+		if bp[0] <= 0.005 || bp[1] <= 0.005 || bp[0] > 99 || bp[1] > 99 {
+			return errors.New("the beta p and q should be between 0.005 and 99")
+		}
+		m.Props, m.Omegas = make([]float64, m.NCats), make([]float64, m.NCats)
+		b := distuv.Beta{Alpha: bp[0], Beta: bp[1]}
+		for i := 0; i < m.NCats; i++ {
+			// the median of each category
+			m.Omegas[i] = b.Quantile((float64(i) + 0.5) / float64(m.NCats))
+			m.Props[i] = p0 / float64(m.NCats)
+		}
+		if m.Name == M8 {
+			m.Props = append(m.Props, 1-p0)
+			m.Omegas = append(m.Omegas, p[3])
+		}
+	}
+	m.Params = append([]float64{}, params...)
+	m.C.Kappa = kappa
+	bf := m.C.M.BF
+	Rs := make([]*mat.Dense, len(m.Omegas))
+	scale := 0.
+	for i, w := range m.Omegas {
+		Rs[i] = m.C.codonR(kappa, w)
+		scale += m.Props[i] * meanRate(Rs[i], bf)
+	}
+	m.Classes = make([]*DiscreteModel, len(m.Omegas))
+	for i, R := range Rs {
+		x := &DiscreteModel{Alph: Codon, NumStates: m.C.M.NumStates, CharMap: m.C.M.CharMap, R: R}
+		x.SetBaseFreqs(bf)
+		x.SetupQGTR()
+		// SetupQGTR scales to a mean rate of 1 for the class, this puts them on a common scale
+		x.Q.Scale(meanRate(R, bf)/scale, x.Q)
+		m.Classes[i] = x
+	}
+	return nil
+}
+
+// PCalcLogLikePatternsCodonSites log likelihood of a codon site model where the
+// likelihood of each site is the mixture over the omega classes
+func PCalcLogLikePatternsCodonSites(t *Tree, m *CodonSiteModel, patternval []float64, wks int) (fl float64) {
+	fl = 0.0
+	nsites := len(patternval)
+	jobs := make(chan int, nsites)
+	results := make(chan LikeResult, nsites)
+	// populate the P matrix dictionaries without problems of race conditions
+	// just the first site
+	for _, x := range m.Classes {
+		x.EmptyPDict()
+		x.EmptyPLDict()
+		x.FillPCache(t, false)
+	}
+	setupScale(t, nsites)
+	fl += floats.LogSumExp(calcLogLikeOneSiteCodonClasses(t, m, 0)) * patternval[0]
+	for i := 0; i < wks; i++ {
+		go calcLogLikeWorkCodonClasses(t, m, jobs, results)
+	}
+	for i := 1; i < nsites; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	rr := LikeResult{}
+	for i := 1; i < nsites; i++ {
+		rr = <-results
+		fl += (rr.value * patternval[rr.site])
+	}
+	return
+}
+
+// calcLogLikeOneSiteCodonClasses the log likelihood of the site for each class
+// (times the proportion of the class). This uses the scaled likelihood as the log
+// space one is slow with 61 states
+func calcLogLikeOneSiteCodonClasses(t *Tree, m *CodonSiteModel, site int) []float64 {
+	ls := make([]float64, len(m.Classes))
+	for c, x := range m.Classes {
+		sl, sc := calcLikeOneSite(t, x, site)
+		ls[c] = math.Log(sl) + sc + math.Log(m.Props[c])
+	}
+	return ls
+}
+
+func calcLogLikeWorkCodonClasses(t *Tree, m *CodonSiteModel, jobs <-chan int, results chan<- LikeResult) {
+	for j := range jobs {
+		results <- LikeResult{value: floats.LogSumExp(calcLogLikeOneSiteCodonClasses(t, m, j)), site: j}
+	}
+}
+
+// CalcCodonSitePosteriors the posterior probability of each omega class for each
+// pattern (naive empirical Bayes). The sum over the classes with omega > 1 is the
+// probability that the site is under positive selection
+func CalcCodonSitePosteriors(t *Tree, m *CodonSiteModel, patternval []float64) (post [][]float64) {
+	for _, x := range m.Classes {
+		x.EmptyPDict()
+		x.EmptyPLDict()
+	}
+	setupScale(t, len(patternval))
+	post = make([][]float64, len(patternval))
+	for s := range patternval {
+		ls := calcLogLikeOneSiteCodonClasses(t, m, s)
+		tot := floats.LogSumExp(ls)
+		post[s] = make([]float64, len(ls))
+		for c := range ls {
+			post[s][c] = math.Exp(ls[c] - tot)
+		}
+	}
+	return
+}
+
+// OptimizeCodonSiteModel optimize kappa and the parameters of the site model with
+// the branch lengths fixed (e.g., from M0). Returns the log likelihood
+func OptimizeCodonSiteModel(t *Tree, m *CodonSiteModel, patternvals []float64, wks int) float64 {
+	fcn := func(mds []float64) float64 {
+		if m.SetParams(mds[0], mds[1:]) != nil {
+			return 1000000000000
+		}
+		lnl := PCalcLogLikePatternsCodonSites(t, m, patternvals, wks)
+		return -lnl
+	}
+	p0 := append([]float64{m.C.Kappa}, m.Params...)
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, p0, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	m.SetParams(res.X[0], res.X[1:])
+	return -res.F
+}
+
+// codonSiteAlt the alternative (with positive selection) of each null site model
+var codonSiteAlt = map[string]string{M1a: M2a, M7: M8}
+
+// CodonSiteLRT the fits of a null site model and its alternative with positive
+// selection and the likelihood ratio test between them
+type CodonSiteLRT struct {
+	Null, Alt  *CodonSiteModel
+	LnL0, LnL1 float64
+	DF         float64 // difference in the number of parameters
+	Stat, P    float64
+}
+
+// FitCodonSiteLRT fit null (M1a or M7) and its alternative (M2a or M8, with the
+// same NCats) with the branch lengths fixed (e.g., from M0) and test for positive
+// selection. The alternative starts from the null estimates so it fits at least
+// as well
+func FitCodonSiteLRT(t *Tree, null *CodonSiteModel, patternvals []float64, wks int) (*CodonSiteLRT, error) {
+	alt, ok := codonSiteAlt[null.Name]
+	if !ok {
+		return nil, errors.New("the null site model should be M1a or M7 not " + null.Name)
+	}
+	r := &CodonSiteLRT{Null: null}
+	r.LnL0 = OptimizeCodonSiteModel(t, null, patternvals, wks)
+	r.Alt = &CodonSiteModel{Name: alt, C: null.C, NCats: null.NCats}
+	p := null.Params
+	// the alternative with no positively selected class is the null
+	start := []float64{p[0], 1 - p[0], p[1], 1.0}
+	if alt == M8 {
+		start = []float64{1.0, p[0], p[1], 1.0}
+	}
+	if err := r.Alt.SetParams(null.C.Kappa, start); err != nil {
+		return nil, err
+	}
+	r.LnL1 = OptimizeCodonSiteModel(t, r.Alt, patternvals, wks)
+	r.DF = float64(r.Alt.NumParams() - null.NumParams())
+	r.Stat, r.P = LikelihoodRatioTest(r.LnL0, r.LnL1, r.DF)
+	return r, nil
+}
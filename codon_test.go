@@ -0,0 +1,137 @@
+package gophy_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+	"gonum.org/v1/gonum/floats"
+)
+
+func TestGeneticCode(t *testing.T) {
+	gc, err := gophy.GetGeneticCode(1)
+	if err != nil || gc.NumStates() != 61 || gc.IsStop("TGA") == false || gc.Synonymous("CTT", "TTA") == false {
+		t.Error("standard code", err)
+	}
+	mt, _ := gophy.GetGeneticCode(2)
+	if mt.NumStates() != 60 || mt.IsStop("TGA") || mt.IsStop("AGA") == false {
+		t.Error("vertebrate mitochondrial code", mt.NumStates())
+	}
+	if _, err := gophy.GetGeneticCode(7); err == nil {
+		t.Error("there is no table 7")
+	}
+	// R is A or G, a stop is missing, a gap is any base (TCA or TTA here)
+	if len(gc.CodonStates("AAR")) != 2 || len(gc.CodonStates("TAA")) != 61 || len(gc.CodonStates("T-A")) != 2 {
+		t.Error(gc.CodonStates("AAR"), len(gc.CodonStates("TAA")), len(gc.CodonStates("T-A")))
+	}
+}
+
+func TestCodonModels(t *testing.T) {
+	// four of the tips and 30 codons keep this fast
+	tr := gophy.NewTree()
+	tr.Instantiate(gophy.ReadNewickString("(taxon_1:0.215,(taxon_2:0.030,taxon_3:0.050):0.130,taxon_4:0.727);"))
+	var seqnames []string
+	seqs := map[string]string{}
+	for _, s := range gophy.ReadSeqsFromFile("test_files/10tips.nuc.fa")[:4] {
+		seqs[s.NM] = s.SQ[:90]
+		seqnames = append(seqnames, s.NM)
+	}
+	gc, _ := gophy.GetGeneticCode(1)
+	_, patternsint, _, err := gophy.GetSitePatternsCodon(seqs, seqnames, gc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patternval, _ := gophy.PreparePatternVecsCodon(tr, patternsint, seqs, gc)
+	if floats.Sum(patternval) > 30 {
+		t.Error("there are only 30 codons", floats.Sum(patternval))
+	}
+	if _, err := gophy.NewCodonModel(gophy.MG94, gc, "F61", seqs); err == nil {
+		t.Error("MG94 needs F1x4 or F3x4")
+	}
+	for _, f := range []string{"F1x4", "F3x4", "F61"} {
+		c, err := gophy.NewCodonModel(gophy.GY94, gc, f, seqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(floats.Sum(c.M.BF)-1) > 1e-9 {
+			t.Error(f, "frequencies", floats.Sum(c.M.BF))
+		}
+	}
+	c, _ := gophy.NewCodonModel(gophy.MG94, gc, "F3x4", seqs)
+	if c.NumParams() != 11 {
+		t.Error("MG94 F3x4 params", c.NumParams())
+	}
+	lnl := gophy.PCalcLogLikePatterns(tr, &c.M, patternval, 2)
+	slnl := gophy.PCalcLikePatterns(tr, &c.M, patternval, 2)
+	if math.IsNaN(lnl) || lnl >= 0 || math.Abs(lnl-slnl) > 1e-4 {
+		t.Error("MG94 lnL", lnl, slnl)
+	}
+	gophy.OptimizeCodonModel(tr, c, patternval, 2)
+	olnl := gophy.PCalcLogLikePatterns(tr, &c.M, patternval, 2)
+	if olnl < lnl {
+		t.Error("optimizing kappa and omega made it worse", lnl, olnl)
+	}
+
+	// M0 as a site model is the same as the codon model
+	m0, _ := gophy.NewCodonSiteModel(gophy.M0, c)
+	m0.SetParams(c.Kappa, []float64{c.Omega})
+	m0lnl := gophy.PCalcLogLikePatternsCodonSites(tr, m0, patternval, 2)
+	if math.Abs(m0lnl-olnl) > 1e-6 {
+		t.Error("M0", olnl, m0lnl)
+	}
+	// M8 with p0 = 1 is M7
+	m7, _ := gophy.NewCodonSiteModel(gophy.M7, c)
+	m8, _ := gophy.NewCodonSiteModel(gophy.M8, c)
+	if m8.SetParams(c.Kappa, []float64{1.0, 0.5, 0.5, 2.0}) != nil || m7.SetParams(c.Kappa, []float64{0.5, 0.5}) != nil {
+		t.Fatal("M7/M8 params")
+	}
+	m7lnl := gophy.PCalcLogLikePatternsCodonSites(tr, m7, patternval, 2)
+	m8lnl := gophy.PCalcLogLikePatternsCodonSites(tr, m8, patternval, 2)
+	if math.Abs(m7lnl-m8lnl) > 1e-6 || m8.NumParams() != m7.NumParams()+2 {
+		t.Error("M7 and M8", m7lnl, m8lnl)
+	}
+	if m8.SetParams(c.Kappa, []float64{1.0, 0.5, 0.5, 0.5}) == nil {
+		t.Error("M8 needs omegas >= 1")
+	}
+	m1a, _ := gophy.NewCodonSiteModel(gophy.M1a, c)
+	m1alnl := gophy.PCalcLogLikePatternsCodonSites(tr, m1a, patternval, 2)
+	r, err := gophy.FitCodonSiteLRT(tr, m1a, patternval, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.LnL0 < m1alnl || math.Abs(r.LnL0-gophy.PCalcLogLikePatternsCodonSites(tr, m1a, patternval, 2)) > 1e-6 {
+		t.Error("M1a optimization", m1alnl, r.LnL0)
+	}
+	post := gophy.CalcCodonSitePosteriors(tr, m1a, patternval)
+	if len(post) != len(patternval) || math.Abs(floats.Sum(post[0])-1) > 1e-9 {
+		t.Error("posteriors", post[0])
+	}
+	// fewer beta categories keep M7 and M8 fast
+	m7.NCats = 3
+	r7, err := gophy.FitCodonSiteLRT(tr, m7, patternval, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*gophy.CodonSiteLRT{r, r7} {
+		if r.DF != 2 || r.LnL1 < r.LnL0-1e-6 || r.P < 0 || r.P > 1 || len(r.Alt.Omegas) != len(r.Null.Omegas)+1 {
+			t.Error(r.Null.Name, "LRT", r.DF, r.LnL0, r.LnL1, r.P)
+		}
+		if stat, p := gophy.LikelihoodRatioTest(r.LnL0, r.LnL1, 2); stat != r.Stat || p != r.P {
+			t.Error(r.Null.Name, "LRT stat", r.Stat, r.P)
+		}
+	}
+	if _, err := gophy.FitCodonSiteLRT(tr, m0, patternval, 2); err == nil {
+		t.Error("M0 isn't the null of a positive selection test")
+	}
+}
+
+func TestLikelihoodRatioTest(t *testing.T) {
+	// 3.841 is the 0.05 critical value with 1 df
+	stat, p := gophy.LikelihoodRatioTest(-100, -100+3.841459/2, 1)
+	if math.Abs(stat-3.841459) > 1e-6 || math.Abs(p-0.05) > 1e-6 {
+		t.Error(stat, p)
+	}
+	if _, p := gophy.LikelihoodRatioTest(-100, -101, 2); p != 1 {
+		t.Error("a worse alternative", p)
+	}
+}
@@ -33,6 +33,7 @@ func PCalcLogLike(t *Tree, x *DiscreteModel, nsites int, wks int) (fl float64) {
 	// populate the P matrix dictionary without problems of race conditions
 	// just the first site
 	x.EmptyPDict()
+	x.FillPCache(t, true)
 	fl += CalcLogLikeOneSite(t, x, 0)
 	for i := 0; i < wks; i++ {
 		go CalcLogLikeWork(t, x, jobs, results)
@@ -59,7 +60,10 @@ func PCalcLike(t *Tree, x *DiscreteModel, nsites int, wks int) (fl float64) {
 	// populate the P matrix dictionary without problems of race conditions
 	// just the first site
 	x.EmptyPDict()
-	fl += math.Log(CalcLikeOneSite(t, x, 0))
+	x.FillPCache(t, false)
+	setupScale(t, nsites)
+	sl, sc := calcLikeOneSite(t, x, 0)
+	fl += math.Log(sl) + sc
 	for i := 0; i < wks; i++ {
 		go CalcLikeWork(t, x, jobs, results)
 	}
@@ -70,35 +74,23 @@ func PCalcLike(t *Tree, x *DiscreteModel, nsites int, wks int) (fl float64) {
 	rr := LikeResult{}
 	for i := 1; i < nsites; i++ {
 		rr = <-results
-		fl += math.Log(rr.value)
+		fl += math.Log(rr.value) + rr.scale
 		//fl += <-results
 	}
 	return
 }
 
-// PCalcLikePatterns parallel caclulation of likelihood with patterns
+// PCalcLikePatterns parallel caclulation of likelihood with patterns (with the
+// LikeBuffer of t)
 func PCalcLikePatterns(t *Tree, x *DiscreteModel, patternval []float64, wks int) (fl float64) {
 	fl = 0.0
-	nsites := len(patternval)
-	jobs := make(chan int, nsites)
-	//results := make(chan float64, nsites)
-	results := make(chan LikeResult, nsites)
-	// populate the P matrix dictionary without problems of race conditions
-	// just the first site
 	x.EmptyPDict()
-	fl += math.Log(CalcLikeOneSite(t, x, 0)) * patternval[0]
-	for i := 0; i < wks; i++ {
-		go CalcLikeWork(t, x, jobs, results)
+	sl := calcLogLikePatternsFlat(t, x, len(patternval), false, false, wks)
+	for i, v := range sl {
+		fl += v * patternval[i]
 	}
-	for i := 1; i < nsites; i++ {
-		jobs <- i
-	}
-	close(jobs)
-	rr := LikeResult{}
-	for i := 1; i < nsites; i++ {
-		rr = <-results
-		fl += math.Log(rr.value) * patternval[rr.site]
-		//fl += <-results
+	if x.Asc != "" {
+		fl += ascCorrection(t, x, patternval)
 	}
 	return
 }
@@ -131,53 +123,27 @@ func PCalcSupLikePatterns(t *Tree, x *DiscreteModel, patternval []float64, wks i
 // PCalcLikePatternsGamma parallel caclulation of likelihood with patterns with gamma
 func PCalcLikePatternsGamma(t *Tree, x *DiscreteModel, patternval []float64, wks int) (fl float64) {
 	fl = 0.0
-	nsites := len(patternval)
-	jobs := make(chan int, nsites)
-	//results := make(chan float64, nsites)
-	results := make(chan LikeResult, nsites)
-	// populate the P matrix dictionary without problems of race conditions
-	// just the first site
 	x.EmptyPDict()
-	fl += math.Log(CalcLikeOneSiteGamma(t, x, 0)) * patternval[0]
-	for i := 0; i < wks; i++ {
-		go CalcLikeWorkGamma(t, x, jobs, results)
+	sl := calcLogLikePatternsFlat(t, x, len(patternval), true, false, wks)
+	for i, v := range sl {
+		fl += v * patternval[i]
 	}
-	for i := 1; i < nsites; i++ {
-		jobs <- i
-	}
-	close(jobs)
-	rr := LikeResult{}
-	for i := 1; i < nsites; i++ {
-		rr = <-results
-		fl += math.Log(rr.value) * patternval[rr.site]
-		//fl += <-results
+	if x.Asc != "" {
+		fl += ascCorrection(t, x, patternval)
 	}
 	return
 }
 
 // PCalcLikePatternsMarked parallel likelihood caclulation with patterns and just update the values
+// of the marked nodes in the LikeBuffer of t
 func PCalcLikePatternsMarked(t *Tree, x *DiscreteModel, patternval []float64, wks int) (fl float64) {
 	fl = 0.0
-	nsites := len(patternval)
-	jobs := make(chan int, nsites)
-	//results := make(chan float64, nsites)
-	results := make(chan LikeResult, nsites)
-	// populate the P matrix dictionary without problems of race conditions
-	// just the first site
-	//x.EmptyPDict()
-	fl += math.Log(CalcLikeOneSiteMarked(t, x, 0)) * patternval[0]
-	for i := 0; i < wks; i++ {
-		go CalcLikeWorkMarked(t, x, jobs, results)
-	}
-	for i := 1; i < nsites; i++ {
-		jobs <- i
+	sl := calcLogLikePatternsFlat(t, x, len(patternval), false, true, wks)
+	for i, v := range sl {
+		fl += v * patternval[i]
 	}
-	close(jobs)
-	rr := LikeResult{}
-	for i := 1; i < nsites; i++ {
-		rr = <-results
-		fl += math.Log(rr.value) * patternval[rr.site]
-		//fl += <-results
+	if x.Asc != "" {
+		fl += ascCorrection(t, x, patternval)
 	}
 	return
 }
@@ -185,53 +151,28 @@ func PCalcLikePatternsMarked(t *Tree, x *DiscreteModel, patternval []float64, wk
 // PCalcLikePatternsMarkedGamma parallel likelihood caclulation with patterns and just update the values
 func PCalcLikePatternsMarkedGamma(t *Tree, x *DiscreteModel, patternval []float64, wks int) (fl float64) {
 	fl = 0.0
-	nsites := len(patternval)
-	jobs := make(chan int, nsites)
-	//results := make(chan float64, nsites)
-	results := make(chan LikeResult, nsites)
-	// populate the P matrix dictionary without problems of race conditions
-	// just the first site
-	//x.EmptyPDict()
-	fl += math.Log(CalcLikeOneSiteMarkedGamma(t, x, 0)) * patternval[0]
-	for i := 0; i < wks; i++ {
-		go CalcLikeWorkMarkedGamma(t, x, jobs, results)
-	}
-	for i := 1; i < nsites; i++ {
-		jobs <- i
+	sl := calcLogLikePatternsFlat(t, x, len(patternval), true, true, wks)
+	for i, v := range sl {
+		fl += v * patternval[i]
 	}
-	close(jobs)
-	rr := LikeResult{}
-	for i := 1; i < nsites; i++ {
-		rr = <-results
-		fl += math.Log(rr.value) * patternval[rr.site]
-		//fl += <-results
+	if x.Asc != "" {
+		fl += ascCorrection(t, x, patternval)
 	}
 	return
 }
 
 // PCalcLogLikePatterns parallel log likeliohood calculation including patterns
+// (with the LikeBuffer of t, scaled instead of in logs)
 func PCalcLogLikePatterns(t *Tree, x *DiscreteModel, patternval []float64, wks int) (fl float64) {
 	fl = 0.0
-	nsites := len(patternval)
-	jobs := make(chan int, nsites)
-	//results := make(chan float64, nsites)
-	results := make(chan LikeResult, nsites)
-	// populate the P matrix dictionary without problems of race conditions
-	// just the first site
 	x.EmptyPDict()
 	x.EmptyPLDict()
-	fl += CalcLogLikeOneSite(t, x, 0) * patternval[0]
-	for i := 0; i < wks; i++ {
-		go CalcLogLikeWork(t, x, jobs, results)
+	sl := calcLogLikePatternsFlat(t, x, len(patternval), false, false, wks)
+	for i, v := range sl {
+		fl += v * patternval[i]
 	}
-	for i := 1; i < nsites; i++ {
-		jobs <- i
-	}
-	close(jobs)
-	rr := LikeResult{}
-	for i := 1; i < nsites; i++ {
-		rr = <-results
-		fl += (rr.value * patternval[rr.site])
+	if x.Asc != "" {
+		fl += ascCorrection(t, x, patternval)
 	}
 	return
 }
@@ -239,26 +180,14 @@ func PCalcLogLikePatterns(t *Tree, x *DiscreteModel, patternval []float64, wks i
 // PCalcLogLikePatternsGamma parallel log likeliohood calculation including patterns
 func PCalcLogLikePatternsGamma(t *Tree, x *DiscreteModel, patternval []float64, wks int) (fl float64) {
 	fl = 0.0
-	nsites := len(patternval)
-	jobs := make(chan int, nsites)
-	//results := make(chan float64, nsites)
-	results := make(chan LikeResult, nsites)
-	// populate the P matrix dictionary without problems of race conditions
-	// just the first site
 	x.EmptyPDict()
 	x.EmptyPLDict()
-	fl += CalcLogLikeOneSiteGamma(t, x, 0) * patternval[0]
-	for i := 0; i < wks; i++ {
-		go CalcLogLikeWorkGamma(t, x, jobs, results)
+	sl := calcLogLikePatternsFlat(t, x, len(patternval), true, false, wks)
+	for i, v := range sl {
+		fl += v * patternval[i]
 	}
-	for i := 1; i < nsites; i++ {
-		jobs <- i
-	}
-	close(jobs)
-	rr := LikeResult{}
-	for i := 1; i < nsites; i++ {
-		rr = <-results
-		fl += (rr.value * patternval[rr.site])
+	if x.Asc != "" {
+		fl += ascCorrection(t, x, patternval)
 	}
 	return
 }
@@ -330,24 +259,50 @@ func CalcLogLikeOneSite(t *Tree, x *DiscreteModel, site int) float64 {
 func CalcLogLikeOneSiteGamma(t *Tree, x *DiscreteModel, site int) float64 {
 	numstates := x.NumStates
 	tsl := make([]float64, x.GammaNCats)
+	rs := invRateScale(x)
 	for p, g := range x.GammaCats {
 		for _, n := range t.Post {
 			if len(n.Chs) > 0 {
-				CalcLogLikeNodeGamma(n, x, site, g)
+				CalcLogLikeNodeGamma(n, x, site, g*rs)
 			}
 			if t.Rt == n {
 				for i := 0; i < numstates; i++ {
 					t.Rt.Data[site][i] += math.Log(x.BF[i])
 				}
-				tsl[p] = (floats.LogSumExp(t.Rt.Data[site]) + (math.Log(1) - math.Log(float64(x.GammaNCats))))
+				tsl[p] = (floats.LogSumExp(t.Rt.Data[site]) + math.Log(x.catWeight(p)))
 			}
 		}
 	}
-	return floats.LogSumExp(tsl)
+	return mixLogInv(t, x, site, floats.LogSumExp(tsl))
 }
 
-// CalcLikeOneSite just one site
+// mixLogInv adds the invariable class to the log likelihood of a site
+func mixLogInv(t *Tree, x *DiscreteModel, site int, lnl float64) float64 {
+	if x.PInv <= 0 {
+		return lnl
+	}
+	return mixLogInvLike(x.PInv, calcInvLikeOneSite(t, x, site), lnl)
+}
+
+// mixLogInvLike mixes the log likelihood of the variable class (lnl) with the
+// likelihood of the invariable class (il)
+func mixLogInvLike(pinv float64, il float64, lnl float64) float64 {
+	if il == 0 {
+		return math.Log(1.-pinv) + lnl
+	}
+	return floats.LogSumExp([]float64{math.Log(1.-pinv) + lnl, math.Log(pinv * il)})
+}
+
+// CalcLikeOneSite just one site. This can underflow, the workers use the scaled
+// calcLikeOneSite
 func CalcLikeOneSite(t *Tree, x *DiscreteModel, site int) float64 {
+	sl, sc := calcLikeOneSite(t, x, site)
+	return sl * math.Exp(sc)
+}
+
+// calcLikeOneSite the likelihood of one site and its log scaler (see
+// CalcLikeNode)
+func calcLikeOneSite(t *Tree, x *DiscreteModel, site int) (float64, float64) {
 	numstates := x.NumStates
 	sl := 0.0
 	for _, n := range t.Post {
@@ -361,7 +316,7 @@ func CalcLikeOneSite(t *Tree, x *DiscreteModel, site int) float64 {
 			sl = floats.Sum(t.Rt.Data[site])
 		}
 	}
-	return sl
+	return sl, nodeScale(t.Rt, site)
 }
 
 func CalcSupLikeOneSite(t *Tree, x *DiscreteModel, site int) *SupFlo {
@@ -383,24 +338,84 @@ func CalcSupLikeOneSite(t *Tree, x *DiscreteModel, site int) *SupFlo {
 
 // CalcLikeOneSiteGamma just one site
 func CalcLikeOneSiteGamma(t *Tree, x *DiscreteModel, site int) float64 {
+	sl, sc := calcLikeOneSiteGamma(t, x, site)
+	return sl * math.Exp(sc)
+}
+
+// calcLikeOneSiteGamma the likelihood of one site and its log scaler. The
+// categories are scaled to the one with the largest scaler
+func calcLikeOneSiteGamma(t *Tree, x *DiscreteModel, site int) (float64, float64) {
 	numstates := x.NumStates
-	sl := 0.0
-	for _, g := range x.GammaCats {
+	sl, sc := 0.0, 0.0
+	rs := invRateScale(x)
+	for ci, g := range x.GammaCats {
 		for _, n := range t.Post {
 			if len(n.Chs) > 0 {
-				CalcLikeNodeGamma(n, x, site, g)
+				CalcLikeNodeGamma(n, x, site, g*rs)
 			}
 			if t.Rt == n {
 				for i := 0; i < numstates; i++ {
 					t.Rt.Data[site][i] *= x.BF[i]
 				}
-				sl += floats.Sum(t.Rt.Data[site]) * (1. / float64(x.GammaNCats))
+				sl, sc = addScaled(sl, sc, floats.Sum(t.Rt.Data[site])*x.catWeight(ci), nodeScale(t.Rt, site))
 			}
 		}
 	}
+	if x.PInv > 0 {
+		sl = math.Exp(mixLogInv(t, x, site, math.Log(sl)+sc) - sc)
+	}
+	return sl, sc
+}
+
+// invRateScale is the multiplier for the rates of the variable sites so that
+// the mean rate stays 1 when there are invariable sites
+func invRateScale(x *DiscreteModel) float64 {
+	if x.PInv > 0 {
+		return 1. / (1. - x.PInv)
+	}
+	return 1.
+}
+
+// calcInvLikeOneSite the likelihood of a site under the invariable class, that is
+// the base frequency of each state that every tip could have
+func calcInvLikeOneSite(t *Tree, x *DiscreteModel, site int) float64 {
+	return calcInvLikeTips(t.Tips, x.BF, site)
+}
+
+// calcInvLikeTips the invariable class likelihood of the tips with each state
+// weighted by w
+func calcInvLikeTips(tips []*Node, w []float64, site int) float64 {
+	sl := 0.0
+	for i := range w {
+		l := w[i]
+		for _, n := range tips {
+			l *= n.Data[site][i]
+		}
+		sl += l
+	}
 	return sl
 }
 
+// calcInvLikeSubClade the invariable class likelihood of the tips in arr. The
+// states are weighted by the base frequencies when the subclade likelihood is at
+// the root (tn) and by 1 (like the conditionals that are summed) when it isn't
+func calcInvLikeSubClade(t *Tree, arr []*Node, tn *Node, x *DiscreteModel, site int) float64 {
+	var tips []*Node
+	for _, n := range arr {
+		if len(n.Chs) == 0 {
+			tips = append(tips, n)
+		}
+	}
+	w := x.BF
+	if tn != t.Rt {
+		w = make([]float64, x.NumStates)
+		for i := range w {
+			w[i] = 1.
+		}
+	}
+	return calcInvLikeTips(tips, w, site)
+}
+
 // CalcLogLikeOneSiteBack like the one above but from nb to the root only
 func CalcLogLikeOneSiteBack(t *Tree, nb *Node, x *DiscreteModel, site int) float64 {
 	numstates := x.NumStates
@@ -451,8 +466,12 @@ func CalcLogLikeOneSiteMarked(t *Tree, x *DiscreteModel, site int) float64 {
 
 // CalcLikeOneSiteMarked this uses the marked machinery to recalculate
 func CalcLikeOneSiteMarked(t *Tree, x *DiscreteModel, site int) float64 {
+	sl, sc := calcLikeOneSiteMarked(t, x, site)
+	return sl * math.Exp(sc)
+}
+
+func calcLikeOneSiteMarked(t *Tree, x *DiscreteModel, site int) (float64, float64) {
 	numstates := x.NumStates
-	sl := 0.0
 	for _, n := range t.Post {
 		if len(n.Chs) > 0 {
 			if n.Marked == true {
@@ -462,43 +481,20 @@ func CalcLikeOneSiteMarked(t *Tree, x *DiscreteModel, site int) float64 {
 				}
 			}
 		}
-		if t.Rt == n && n.Marked == true {
-			for i := 0; i < numstates; i++ {
-				t.Rt.Data[site][i] *= x.BF[i]
-			}
-			sl = floats.Sum(t.Rt.Data[site])
-		} else {
-			sl = floats.Sum(t.Rt.Data[site])
+	}
+	if t.Rt.Marked == true {
+		for i := 0; i < numstates; i++ {
+			t.Rt.Data[site][i] *= x.BF[i]
 		}
 	}
-	return sl
+	return floats.Sum(t.Rt.Data[site]), nodeScale(t.Rt, site)
 }
 
-// CalcLikeOneSiteMarkedGamma this uses the marked machinery to recalculate
+// CalcLikeOneSiteMarkedGamma the Data of a node only has one category so this
+// calculates everything again (PCalcLikePatternsMarkedGamma can just do the
+// marked ones)
 func CalcLikeOneSiteMarkedGamma(t *Tree, x *DiscreteModel, site int) float64 {
-	numstates := x.NumStates
-	sl := 0.0
-	for _, g := range x.GammaCats {
-		for _, n := range t.Post {
-			if len(n.Chs) > 0 {
-				if n.Marked == true {
-					CalcLikeNodeGamma(n, x, site, g)
-					if n != t.Rt {
-						n.Par.Marked = true
-					}
-				}
-			}
-			if t.Rt == n && n.Marked == true {
-				for i := 0; i < numstates; i++ {
-					t.Rt.Data[site][i] *= x.BF[i]
-				}
-				sl += floats.Sum(t.Rt.Data[site]) * (1. / float64(x.GammaNCats))
-			} else {
-				sl += floats.Sum(t.Rt.Data[site]) * (1. / float64(x.GammaNCats))
-			}
-		}
-	}
-	return sl
+	return CalcLikeOneSiteGamma(t, x, site)
 }
 
 // CalcLogLikeWork this is intended for a worker that will be executing this per site
@@ -524,42 +520,31 @@ func CalcLogLikeWork(t *Tree, x *DiscreteModel, jobs <-chan int, results chan<-
 // CalcLogLikeWorkGamma this is intended for a worker that will be executing this per site
 func CalcLogLikeWorkGamma(t *Tree, x *DiscreteModel, jobs <-chan int, results chan<- LikeResult) { //results chan<- float64) {
 	numstates := x.NumStates
+	rs := invRateScale(x)
 	for j := range jobs {
 		tsl := make([]float64, x.GammaNCats)
 		for p, g := range x.GammaCats {
 			for _, n := range t.Post {
 				if len(n.Chs) > 0 {
-					CalcLogLikeNodeGamma(n, x, j, g)
+					CalcLogLikeNodeGamma(n, x, j, g*rs)
 				}
 				if t.Rt == n {
 					for i := 0; i < numstates; i++ {
 						t.Rt.Data[j][i] += math.Log(x.BF[i])
 					}
-					tsl[p] = (floats.LogSumExp(t.Rt.Data[j]) + (math.Log(1) - math.Log(float64(x.GammaNCats))))
+					tsl[p] = (floats.LogSumExp(t.Rt.Data[j]) + math.Log(x.catWeight(p)))
 				}
 			}
 		}
-		results <- LikeResult{value: floats.LogSumExp(tsl), site: j}
+		results <- LikeResult{value: mixLogInv(t, x, j, floats.LogSumExp(tsl)), site: j}
 	}
 }
 
 // CalcLikeWork this is the worker
 func CalcLikeWork(t *Tree, x *DiscreteModel, jobs <-chan int, results chan<- LikeResult) { //results chan<- float64) {
-	numstates := x.NumStates
 	for j := range jobs {
-		sl := 0.0
-		for _, n := range t.Post {
-			if len(n.Chs) > 0 {
-				CalcLikeNode(n, x, j)
-			}
-			if t.Rt == n {
-				for i := 0; i < numstates; i++ {
-					t.Rt.Data[j][i] *= x.BF[i]
-				}
-				sl = floats.Sum(t.Rt.Data[j])
-			}
-		}
-		results <- LikeResult{value: sl, site: j}
+		sl, sc := calcLikeOneSite(t, x, j)
+		results <- LikeResult{value: sl, scale: sc, site: j}
 	}
 }
 
@@ -585,23 +570,9 @@ func CalcSupLikeWork(t *Tree, x *DiscreteModel, jobs <-chan int, results chan<-
 
 // CalcLikeWorkGamma ...
 func CalcLikeWorkGamma(t *Tree, x *DiscreteModel, jobs <-chan int, results chan<- LikeResult) { //results chan<- float64) {
-	numstates := x.NumStates
 	for j := range jobs {
-		sl := 0.0
-		for _, g := range x.GammaCats {
-			for _, n := range t.Post {
-				if len(n.Chs) > 0 {
-					CalcLikeNodeGamma(n, x, j, g)
-				}
-				if t.Rt == n {
-					for i := 0; i < numstates; i++ {
-						t.Rt.Data[j][i] *= x.BF[i]
-					}
-					sl += floats.Sum(t.Rt.Data[j]) * (1. / float64(x.GammaNCats))
-				}
-			}
-		}
-		results <- LikeResult{value: sl, site: j}
+		sl, sc := calcLikeOneSiteGamma(t, x, j)
+		results <- LikeResult{value: sl, scale: sc, site: j}
 	}
 }
 
@@ -632,52 +603,15 @@ func CalcLogLikeWorkBack(t *Tree, nb *Node, x *DiscreteModel, jobs <-chan int, r
 
 // CalcLikeWorkMarked this is intended to calculate only on the marked nodes back to teh root
 func CalcLikeWorkMarked(t *Tree, x *DiscreteModel, jobs <-chan int, results chan<- LikeResult) {
-	numstates := x.NumStates
 	for j := range jobs {
-		sl := 0.0
-		for _, n := range t.Post {
-			if len(n.Chs) > 0 {
-				if n.Marked == true {
-					CalcLikeNode(n, x, j)
-				}
-			}
-			if t.Rt == n && n.Marked == true {
-				for i := 0; i < numstates; i++ {
-					t.Rt.Data[j][i] *= x.BF[i]
-				}
-				sl = floats.Sum(t.Rt.Data[j])
-			} else {
-				sl = floats.Sum(t.Rt.Data[j])
-			}
-		}
-		results <- LikeResult{value: sl, site: j}
+		sl, sc := calcLikeOneSiteMarked(t, x, j)
+		results <- LikeResult{value: sl, scale: sc, site: j}
 	}
 }
 
-// CalcLikeWorkMarkedGamma this is intended to calculate only on the marked nodes back to teh root
+// CalcLikeWorkMarkedGamma see CalcLikeOneSiteMarkedGamma
 func CalcLikeWorkMarkedGamma(t *Tree, x *DiscreteModel, jobs <-chan int, results chan<- LikeResult) {
-	numstates := x.NumStates
-	for j := range jobs {
-		sl := 0.0
-		for _, g := range x.GammaCats {
-			for _, n := range t.Post {
-				if len(n.Chs) > 0 {
-					if n.Marked == true {
-						CalcLikeNodeGamma(n, x, j, g)
-					}
-				}
-				if t.Rt == n && n.Marked == true {
-					for i := 0; i < numstates; i++ {
-						t.Rt.Data[j][i] *= x.BF[i]
-					}
-					sl += floats.Sum(t.Rt.Data[j]) * (1. / float64(x.GammaNCats))
-				} else {
-					sl += floats.Sum(t.Rt.Data[j]) * (1. / float64(x.GammaNCats))
-				}
-			}
-		}
-		results <- LikeResult{value: sl, site: j}
-	}
+	CalcLikeWorkGamma(t, x, jobs, results)
 }
 
 // CalcLogLikeWorkMarked this is intended to calculate only on the marked nodes back to teh root
@@ -751,7 +685,7 @@ func CalcLogLikeNodeGamma(nd *Node, model *DiscreteModel, site int, gammav float
 			c.Len = 0.0
 		}
 		if len(c.Chs) == 0 {
-			P := model.GetPMap(c.Len * gammav)
+			P := model.GetPMapRate(c.Len, gammav)
 			for i := 0; i < numstates; i++ {
 				x1 = 0.0
 				for j := 0; j < numstates; j++ {
@@ -760,7 +694,7 @@ func CalcLogLikeNodeGamma(nd *Node, model *DiscreteModel, site int, gammav float
 				nd.Data[site][i] += math.Log(x1)
 			}
 		} else {
-			PL := model.GetPMapLogged(c.Len * gammav)
+			PL := model.GetPMapLoggedRate(c.Len, gammav)
 			for i := 0; i < numstates; i++ {
 				for j := 0; j < numstates; j++ {
 					//x2[j] = math.Log(P.At(i, j)) + c.Data[site][j]
@@ -772,7 +706,9 @@ func CalcLogLikeNodeGamma(nd *Node, model *DiscreteModel, site int, gammav float
 	}
 }
 
-// CalcLikeNode calculate the likelihood of a node
+// CalcLikeNode calculate the likelihood of a node. The Data are rescaled when
+// they get small and the log scaler (with the ones of the children) is kept in
+// Scale (if it was setup, see setupScale)
 func CalcLikeNode(nd *Node, model *DiscreteModel, site int) {
 	numstates := model.NumStates
 	for i := 0; i < numstates; i++ {
@@ -780,8 +716,10 @@ func CalcLikeNode(nd *Node, model *DiscreteModel, site int) {
 	}
 	x1 := 0.0
 	x2 := 0.0
+	sc := 0.0
 	for _, c := range nd.Chs {
 		P := model.GetPMap(c.Len)
+		sc += nodeScale(c, site)
 		if len(c.Chs) == 0 {
 			for i := 0; i < numstates; i++ {
 				x1 = 0.0
@@ -800,6 +738,7 @@ func CalcLikeNode(nd *Node, model *DiscreteModel, site int) {
 			}
 		}
 	}
+	scaleNode(nd, site, sc)
 }
 
 func CalcSupLikeNode(nd *Node, model *DiscreteModel, site int) {
@@ -834,7 +773,7 @@ func CalcSupLikeNode(nd *Node, model *DiscreteModel, site int) {
 	}
 }
 
-// CalcLikeNodeGamma calculate the likelihood of a node
+// CalcLikeNodeGamma calculate the likelihood of a node (scaled like CalcLikeNode)
 func CalcLikeNodeGamma(nd *Node, model *DiscreteModel, site int, gammav float64) {
 	numstates := model.NumStates
 	for i := 0; i < numstates; i++ {
@@ -842,8 +781,10 @@ func CalcLikeNodeGamma(nd *Node, model *DiscreteModel, site int, gammav float64)
 	}
 	x1 := 0.0
 	x2 := 0.0
+	sc := 0.0
 	for _, c := range nd.Chs {
-		P := model.GetPMap(c.Len * gammav) //the only gamma bit, arg
+		P := model.GetPMapRate(c.Len, gammav) //the only gamma bit, arg
+		sc += nodeScale(c, site)
 		if len(c.Chs) == 0 {
 			for i := 0; i < numstates; i++ {
 				x1 = 0.0
@@ -862,6 +803,61 @@ func CalcLikeNodeGamma(nd *Node, model *DiscreteModel, site int, gammav float64)
 			}
 		}
 	}
+	scaleNode(nd, site, sc)
+}
+
+// setupScale makes the Scale of the nodes of t for nsites (keeping them if they
+// are already there). This has to be done before the workers start
+func setupScale(t *Tree, nsites int) {
+	for _, n := range t.Post {
+		if len(n.Scale) != nsites {
+			n.Scale = make([]float64, nsites)
+		}
+	}
+}
+
+// nodeScale the log scaler of the Data of n at site (0 if there isn't one)
+func nodeScale(n *Node, site int) float64 {
+	if site < len(n.Scale) {
+		return n.Scale[site]
+	}
+	return 0.
+}
+
+// scaleNode rescales the Data of nd at site so the largest is 1 if they are all
+// below likeScaleMin. sc is the log scaler of the children
+func scaleNode(nd *Node, site int, sc float64) {
+	if site >= len(nd.Scale) {
+		return
+	}
+	mx := 0.0
+	for _, v := range nd.Data[site] {
+		if v > mx {
+			mx = v
+		}
+	}
+	if mx > 0 && mx < likeScaleMin {
+		for i := range nd.Data[site] {
+			nd.Data[site][i] /= mx
+		}
+		sc += math.Log(mx)
+	}
+	nd.Scale[site] = sc
+}
+
+// addScaled adds b (with the log scaler bs) to a (with as) and returns the sum
+// with the larger scaler
+func addScaled(a, as, b, bs float64) (float64, float64) {
+	if a == 0 {
+		return b, bs
+	}
+	if b == 0 {
+		return a, as
+	}
+	if as >= bs {
+		return a + b*math.Exp(bs-as), as
+	}
+	return a*math.Exp(as-bs) + b, bs
 }
 
 /*
@@ -1009,31 +1005,7 @@ func RVTPconditionalsLog(x *DiscreteModel, node *Node, patternval []float64) {
 
 // CalcLikeFrontBack ...
 func CalcLikeFrontBack(x *DiscreteModel, tree *Tree, patternval []float64) {
-	numstates := x.NumStates
-	for _, n := range tree.Post {
-		if len(n.Chs) != 0 {
-			n.TpConds = make([][]float64, len(patternval))
-		}
-		n.RvTpConds = make([][]float64, len(patternval))
-		n.RvConds = make([][]float64, len(patternval))
-		n.RtConds = make([][]float64, len(patternval))
-		for i := 0; i < len(patternval); i++ {
-			if len(n.Chs) != 0 {
-				n.TpConds[i] = make([]float64, numstates)
-				for j := 0; j < numstates; j++ {
-					n.TpConds[i][j] = 1.0
-				}
-			}
-			n.RvTpConds[i] = make([]float64, numstates)
-			n.RvConds[i] = make([]float64, numstates)
-			n.RtConds[i] = make([]float64, numstates)
-			for j := 0; j < numstates; j++ {
-				n.RvTpConds[i][j] = 1.0
-				n.RvConds[i][j] = 1.0
-				n.RtConds[i][j] = 1.0
-			}
-		}
-	}
+	tree.setupConds(len(patternval), x.NumStates, 1.0)
 	//loglike := 0.
 	for _, c := range tree.Post {
 		//calculate the tip conditionals
@@ -1062,28 +1034,7 @@ func CalcLikeFrontBack(x *DiscreteModel, tree *Tree, patternval []float64) {
 }
 
 func CalcLikeFrontBackLog(x *DiscreteModel, tree *Tree, patternval []float64) {
-	numstates := x.NumStates
-	for _, n := range tree.Post {
-		if len(n.Chs) != 0 {
-			n.TpConds = make([][]float64, len(patternval))
-		}
-		n.RvTpConds = make([][]float64, len(patternval))
-		n.RvConds = make([][]float64, len(patternval))
-		n.RtConds = make([][]float64, len(patternval))
-		for i := 0; i < len(patternval); i++ {
-			if len(n.Chs) != 0 {
-				n.TpConds[i] = make([]float64, numstates)
-			}
-			n.RvTpConds[i] = make([]float64, numstates)
-			n.RvConds[i] = make([]float64, numstates)
-			n.RtConds[i] = make([]float64, numstates)
-			for j := 0; j < numstates; j++ {
-				n.RvTpConds[i][j] = 0.0
-				n.RvConds[i][j] = 0.0
-				n.RtConds[i][j] = 0.0
-			}
-		}
-	}
+	tree.setupConds(len(patternval), x.NumStates, 0.0)
 	for _, c := range tree.Post {
 		TPconditionalsLog(x, c, patternval)
 		RTconditionalsLog(x, c, patternval)
@@ -1303,10 +1254,10 @@ func CalcJointLogLikelihoodValueGamma(x *DiscreteModel, tree *Tree, patternval [
 			for j := range x.BF {
 				for _, i := range c.Chs {
 					templike := []float64{0.0, 0.0, 0.0, 0.0}
-					for _, g := range x.GammaCats {
+					for ci, g := range x.GammaCats {
 						p := x.GetPCalc(i.Len * g)
 						for k := range x.BF {
-							templike[k] += p.At(j, k) * i.ContData[k] * 1 / float64(x.GammaNCats)
+							templike[k] += p.At(j, k) * i.ContData[k] * x.catWeight(ci)
 						}
 					}
 					c.ContData[j] *= Max(templike)
@@ -1368,10 +1319,10 @@ func CalcJointBestConfigGamma(x *DiscreteModel, tree *Tree, value float64) Joint
 		if len(c.Chs) > 0 {
 			if c != tree.Rt {
 				cc := make([]float64, x.NumStates)
-				for _, g := range x.GammaCats { //TODO: test
+				for ci, g := range x.GammaCats { //TODO: test
 					p := x.GetPCalc(c.Len * g)
 					for j := range x.BF {
-						cc[j] += c.ContData[j] * p.At(jconfig.Config[c.Par], j) * 1 / float64(x.GammaNCats)
+						cc[j] += c.ContData[j] * p.At(jconfig.Config[c.Par], j) * x.catWeight(ci)
 					}
 				}
 				_, i := MaxIndex(cc)
@@ -1421,9 +1372,9 @@ func CalcJointConfigScoreGamma(x *DiscreteModel, tree *Tree, patternval []float6
 			v := 1.0
 			for _, i := range c.Chs {
 				templike := 0.0
-				for _, g := range x.GammaCats { //TODO: test
+				for ci, g := range x.GammaCats { //TODO: test
 					p := x.GetPCalc(i.Len * g)
-					templike += p.At(jc.Config[c], jc.Config[i]) * vals[i] * 1 / float64(x.GammaNCats)
+					templike += p.At(jc.Config[c], jc.Config[i]) * vals[i] * x.catWeight(ci)
 				}
 				v *= templike
 			}
@@ -1638,9 +1589,9 @@ func CalcJointAncStatesGamma(x *DiscreteModel, tree *Tree, patternval []float64)
 				for c1, cc := range c.Chs {
 					for _, l := range jconfigs[cc] {
 						jc := JointConfig{Score: 0.0, Config: make(map[*Node]int)}
-						for _, g := range x.GammaCats { //TODO: test
+						for ci, g := range x.GammaCats { //TODO: test
 							p := x.GetPCalc(cc.Len * g)
-							jc.Score += l.Score * p.At(k, l.Config[cc]) * 1 / float64(x.GammaNCats)
+							jc.Score += l.Score * p.At(k, l.Config[cc]) * x.catWeight(ci)
 						}
 						for x, y := range l.Config {
 							jc.Config[x] = y
@@ -1825,26 +1776,27 @@ func calcLogLikeOneSiteSubCladeGamma(t *Tree, inn *Node, excl bool, x *DiscreteM
 	} else {
 		arr = inn.PostorderArray()
 	}
+	var tn *Node
+	if excl == true { // calc at rt
+		tn = t.Rt
+	} else {
+		tn = inn
+	}
 	tsl := make([]float64, x.GammaNCats)
+	rs := invRateScale(x)
 	for p, g := range x.GammaCats {
 		for _, n := range arr {
 			if len(n.Chs) > 0 {
-				CalcLogLikeNodeGamma(n, x, site, g)
-			}
-			var tn *Node
-			if excl == true { // calc at rt
-				tn = t.Rt
-			} else {
-				tn = inn
+				CalcLogLikeNodeGamma(n, x, site, g*rs)
 			}
 			if tn == n {
 				if tn == t.Rt { //only happens at the root
 					for i := 0; i < numstates; i++ {
 						n.Data[site][i] += math.Log(x.BF[i])
 					}
-					tsl[p] = (floats.LogSumExp(n.Data[site]) + (math.Log(1) - math.Log(float64(x.GammaNCats))))
+					tsl[p] = (floats.LogSumExp(n.Data[site]) + math.Log(x.catWeight(p)))
 				} else {
-					pc := x.GetPMapLogged(n.Len * g)
+					pc := x.GetPMapLoggedRate(n.Len, g*rs)
 					rtconds := make([]float64, x.GetNumStates())
 					x2 := make([]float64, x.GetNumStates())
 					for m := 0; m < numstates; m++ {
@@ -1853,16 +1805,20 @@ func calcLogLikeOneSiteSubCladeGamma(t *Tree, inn *Node, excl bool, x *DiscreteM
 						}
 						rtconds[m] = floats.LogSumExp(x2)
 					}
-					tsl[p] = (floats.LogSumExp(rtconds) + (math.Log(1) - math.Log(float64(x.GammaNCats))))
+					tsl[p] = (floats.LogSumExp(rtconds) + math.Log(x.catWeight(p)))
 				}
 			}
 		}
 	}
+	if x.PInv > 0 {
+		return mixLogInvLike(x.PInv, calcInvLikeSubClade(t, arr, tn, x, site), floats.LogSumExp(tsl))
+	}
 	return floats.LogSumExp(tsl)
 }
 
-// calcLogLikeOneSiteSubClade calc for just a clade, starting at a node
-func calcLikeOneSiteSubClade(t *Tree, inn *Node, excl bool, x *DiscreteModel, site int) float64 {
+// calcLikeOneSiteSubClade calc for just a clade, starting at a node. Returns
+// the likelihood and its log scaler
+func calcLikeOneSiteSubClade(t *Tree, inn *Node, excl bool, x *DiscreteModel, site int) (float64, float64) {
 	numstates := x.NumStates
 	sl := 0.0
 	arr := []*Node{}
@@ -1871,16 +1827,16 @@ func calcLikeOneSiteSubClade(t *Tree, inn *Node, excl bool, x *DiscreteModel, si
 	} else {
 		arr = inn.PostorderArray()
 	}
+	var tn *Node
+	if excl == true { // calc at rt
+		tn = t.Rt
+	} else {
+		tn = inn
+	}
 	for _, n := range arr {
 		if len(n.Chs) > 0 {
 			CalcLikeNode(n, x, site)
 		}
-		var tn *Node
-		if excl == true { // calc at rt
-			tn = t.Rt
-		} else {
-			tn = inn
-		}
 		if tn == n {
 			if tn == t.Rt { //only happens at the root
 				for i := 0; i < numstates; i++ {
@@ -1902,39 +1858,39 @@ func calcLikeOneSiteSubClade(t *Tree, inn *Node, excl bool, x *DiscreteModel, si
 			}
 		}
 	}
-	return sl
+	return sl, nodeScale(tn, site)
 }
 
-func calcLikeOneSiteSubCladeGamma(t *Tree, inn *Node, excl bool, x *DiscreteModel, site int) float64 {
+func calcLikeOneSiteSubCladeGamma(t *Tree, inn *Node, excl bool, x *DiscreteModel, site int) (float64, float64) {
 	numstates := x.NumStates
-	sl := 0.0
+	sl, sc := 0.0, 0.0
 	arr := []*Node{}
 	if excl == true {
 		arr = t.Rt.PostorderArrayExcl(inn)
 	} else {
 		arr = inn.PostorderArray()
 	}
-	for _, g := range x.GammaCats {
+	var tn *Node
+	if excl == true { // calc at rt
+		tn = t.Rt
+	} else {
+		tn = inn
+	}
+	rs := invRateScale(x)
+	for ci, g := range x.GammaCats {
 		for _, n := range arr {
 			if len(n.Chs) > 0 {
-				CalcLikeNodeGamma(n, x, site, g)
-			}
-			var tn *Node
-			if excl == true { // calc at rt
-				tn = t.Rt
-			} else {
-				tn = inn
+				CalcLikeNodeGamma(n, x, site, g*rs)
 			}
 			if tn == n {
 				if tn == t.Rt { //only happens at the root
 					for i := 0; i < numstates; i++ {
 						n.Data[site][i] *= x.BF[i]
 					}
-					//sl = floats.Sum(n.Data[site])
-					sl += floats.Sum(n.Data[site]) * (1. / float64(x.GammaNCats))
+					sl, sc = addScaled(sl, sc, floats.Sum(n.Data[site])*x.catWeight(ci), nodeScale(n, site))
 				} else {
 					//needs to get the branch length incorporated
-					p := x.GetPCalc(n.Len * g)
+					p := x.GetPCalc(n.Len * g * rs)
 					rtconds := make([]float64, x.GetNumStates())
 					for j := 0; j < numstates; j++ {
 						templike := 0.0
@@ -1943,12 +1899,15 @@ func calcLikeOneSiteSubCladeGamma(t *Tree, inn *Node, excl bool, x *DiscreteMode
 						}
 						rtconds[j] = templike
 					}
-					sl += floats.Sum(rtconds) * (1. / float64(x.GammaNCats))
+					sl, sc = addScaled(sl, sc, floats.Sum(rtconds)*x.catWeight(ci), nodeScale(n, site))
 				}
 			}
 		}
 	}
-	return sl
+	if x.PInv > 0 {
+		sl = math.Exp(mixLogInvLike(x.PInv, calcInvLikeSubClade(t, arr, tn, x, site), math.Log(sl)+sc) - sc)
+	}
+	return sl, sc
 }
 
 // PCalcLogLikePatternsSubClade parallel log likeliohood calculation including patterns
@@ -1962,6 +1921,7 @@ func PCalcLogLikePatternsSubClade(t *Tree, n *Node, excl bool, x *DiscreteModel,
 	// just the first site
 	x.EmptyPDict()
 	x.EmptyPLDict()
+	x.FillPCache(t, true)
 	var lkfun1 func(t *Tree, inn *Node, excl bool, x *DiscreteModel, site int) float64
 	var lkfun2 func(t *Tree, inn *Node, excl bool, x *DiscreteModel, jobs <-chan int, results chan<- LikeResult)
 	if x.GammaNCats != 0 { // gamma
@@ -1997,7 +1957,9 @@ func PCalcLikePatternsSubClade(t *Tree, n *Node, excl bool, x *DiscreteModel, pa
 	// populate the P matrix dictionary without problems of race conditions
 	// just the first site
 	x.EmptyPDict()
-	var lkfun1 func(t *Tree, inn *Node, excl bool, x *DiscreteModel, site int) float64
+	x.FillPCache(t, false)
+	setupScale(t, nsites)
+	var lkfun1 func(t *Tree, inn *Node, excl bool, x *DiscreteModel, site int) (float64, float64)
 	var lkfun2 func(t *Tree, inn *Node, excl bool, x *DiscreteModel, jobs <-chan int, results chan<- LikeResult)
 	if x.GammaNCats != 0 { // gamma
 		lkfun1 = calcLikeOneSiteSubCladeGamma
@@ -2006,7 +1968,8 @@ func PCalcLikePatternsSubClade(t *Tree, n *Node, excl bool, x *DiscreteModel, pa
 		lkfun1 = calcLikeOneSiteSubClade
 		lkfun2 = calcLikeSubCladeWork
 	}
-	fl += math.Log(lkfun1(t, n, excl, x, 0)) * patternval[0]
+	sl, sc := lkfun1(t, n, excl, x, 0)
+	fl += (math.Log(sl) + sc) * patternval[0]
 	for i := 0; i < wks; i++ {
 		go lkfun2(t, n, excl, x, jobs, results)
 	}
@@ -2017,101 +1980,24 @@ func PCalcLikePatternsSubClade(t *Tree, n *Node, excl bool, x *DiscreteModel, pa
 	rr := LikeResult{}
 	for i := 1; i < nsites; i++ {
 		rr = <-results
-		fl += (math.Log(rr.value) * patternval[rr.site])
+		fl += (math.Log(rr.value) + rr.scale) * patternval[rr.site]
 	}
 	return
 }
 
 // calcLikeSubCladeWork this is intended for a worker that will be executing this per site
 func calcLikeSubCladeWork(t *Tree, inn *Node, excl bool, x *DiscreteModel, jobs <-chan int, results chan<- LikeResult) { //results chan<- float64) {
-	numstates := x.NumStates
-	arr := []*Node{}
-	if excl == true {
-		arr = t.Rt.PostorderArrayExcl(inn)
-	} else {
-		arr = inn.PostorderArray()
-	}
 	for j := range jobs {
-		sl := 0.0
-		for _, n := range arr {
-			if len(n.Chs) > 0 {
-				CalcLikeNode(n, x, j)
-			}
-			var tn *Node
-			if excl == true { // calc at rt
-				tn = t.Rt
-			} else {
-				tn = inn
-			}
-			if tn == n {
-				if tn == t.Rt { //only happens at the root
-					for i := 0; i < numstates; i++ {
-						n.Data[j][i] *= x.BF[i]
-					}
-					sl = floats.Sum(n.Data[j])
-				} else {
-					p := x.GetPCalc(n.Len)
-					rtconds := make([]float64, x.GetNumStates())
-					for m := 0; m < numstates; m++ {
-						templike := 0.0
-						for k := 0; k < numstates; k++ {
-							templike += p.At(m, k) * n.Data[j][k]
-						}
-						rtconds[m] = templike
-					}
-					sl = floats.Sum(rtconds)
-				}
-			}
-		}
-		results <- LikeResult{value: sl, site: j}
+		sl, sc := calcLikeOneSiteSubClade(t, inn, excl, x, j)
+		results <- LikeResult{value: sl, scale: sc, site: j}
 	}
 }
 
-// calcLikeSubCladeWork this is intended for a worker that will be executing this per site
+// calcLikeSubCladeWorkGamma this is intended for a worker that will be executing this per site
 func calcLikeSubCladeWorkGamma(t *Tree, inn *Node, excl bool, x *DiscreteModel, jobs <-chan int, results chan<- LikeResult) { //results chan<- float64) {
-	numstates := x.NumStates
-	arr := []*Node{}
-	if excl == true {
-		arr = t.Rt.PostorderArrayExcl(inn)
-	} else {
-		arr = inn.PostorderArray()
-	}
 	for j := range jobs {
-		sl := 0.0
-		for _, g := range x.GammaCats {
-			for _, n := range arr {
-				if len(n.Chs) > 0 {
-					CalcLikeNodeGamma(n, x, j, g)
-				}
-				var tn *Node
-				if excl == true { // calc at rt
-					tn = t.Rt
-				} else {
-					tn = inn
-				}
-				if tn == n {
-					if tn == t.Rt { //only happens at the root
-						for i := 0; i < numstates; i++ {
-							n.Data[j][i] *= x.BF[i]
-						}
-						//sl = floats.Sum(n.Data[j])
-						sl += floats.Sum(n.Data[j]) * (1. / float64(x.GammaNCats))
-					} else {
-						p := x.GetPCalc(n.Len * g)
-						rtconds := make([]float64, x.GetNumStates())
-						for m := 0; m < numstates; m++ {
-							templike := 0.0
-							for k := 0; k < numstates; k++ {
-								templike += p.At(m, k) * n.Data[j][k]
-							}
-							rtconds[m] = templike
-						}
-						sl += floats.Sum(rtconds) * (1. / float64(x.GammaNCats))
-					}
-				}
-			}
-		}
-		results <- LikeResult{value: sl, site: j}
+		sl, sc := calcLikeOneSiteSubCladeGamma(t, inn, excl, x, j)
+		results <- LikeResult{value: sl, scale: sc, site: j}
 	}
 }
 
@@ -2168,28 +2054,29 @@ func calcLogLikeSubCladeWorkGamma(t *Tree, inn *Node, excl bool, x *DiscreteMode
 	} else {
 		arr = inn.PostorderArray()
 	}
+	var tn *Node
+	if excl == true { // calc at rt
+		tn = t.Rt
+	} else {
+		tn = inn
+	}
+	rs := invRateScale(x)
 	for j := range jobs {
 		tsl := make([]float64, x.GammaNCats)
 		for p, g := range x.GammaCats {
 
 			for _, n := range arr {
 				if len(n.Chs) > 0 {
-					CalcLogLikeNodeGamma(n, x, j, g)
-				}
-				var tn *Node
-				if excl == true { // calc at rt
-					tn = t.Rt
-				} else {
-					tn = inn
+					CalcLogLikeNodeGamma(n, x, j, g*rs)
 				}
 				if tn == n {
 					if tn == t.Rt { //only happens at the root
 						for i := 0; i < numstates; i++ {
 							n.Data[j][i] += math.Log(x.BF[i])
 						}
-						tsl[p] = (floats.LogSumExp(n.Data[j]) + (math.Log(1) - math.Log(float64(x.GammaNCats))))
+						tsl[p] = (floats.LogSumExp(n.Data[j]) + math.Log(x.catWeight(p)))
 					} else {
-						pc := x.GetPMapLogged(n.Len * g)
+						pc := x.GetPMapLoggedRate(n.Len, g*rs)
 						rtconds := make([]float64, x.GetNumStates())
 						x2 := make([]float64, x.GetNumStates())
 						for m := 0; m < numstates; m++ {
@@ -2198,11 +2085,15 @@ func calcLogLikeSubCladeWorkGamma(t *Tree, inn *Node, excl bool, x *DiscreteMode
 							}
 							rtconds[m] += floats.LogSumExp(x2)
 						}
-						tsl[p] = (floats.LogSumExp(rtconds) + (math.Log(1) - math.Log(float64(x.GammaNCats))))
+						tsl[p] = (floats.LogSumExp(rtconds) + math.Log(x.catWeight(p)))
 					}
 				}
 			}
 		}
-		results <- LikeResult{value: floats.LogSumExp(tsl), site: j}
+		lnl := floats.LogSumExp(tsl)
+		if x.PInv > 0 {
+			lnl = mixLogInvLike(x.PInv, calcInvLikeSubClade(t, arr, tn, x, j), lnl)
+		}
+		results <- LikeResult{value: lnl, site: j}
 	}
 }
@@ -22,17 +22,20 @@ func PCalcLikePatternsMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node
 	// just the first site
 	for _, x := range models {
 		x.EmptyPDict()
+		x.FillPCache(t, false)
 	}
-	var lkfun1 func(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int) float64
+	setupScale(t, nsites)
+	var lkfun1 func(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int) (float64, float64)
 	var lkfun2 func(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, jobs <-chan int, results chan<- LikeResult)
 	if models[0].GammaNCats != 0 {
-		lkfun1 = CalcLikeOneSiteGammaMul
+		lkfun1 = calcLikeOneSiteGammaMul
 		lkfun2 = CalcLikeWorkGammaMul
 	} else {
 		lkfun1 = calcLikeOneSiteMul
 		lkfun2 = calcLikeWorkMul
 	}
-	fl += math.Log(lkfun1(t, models, nodemodels, 0)) * patternval[0]
+	sl, sc := lkfun1(t, models, nodemodels, 0)
+	fl += (math.Log(sl) + sc) * patternval[0]
 	for i := 0; i < wks; i++ {
 		go lkfun2(t, models, nodemodels, jobs, results)
 	}
@@ -43,7 +46,10 @@ func PCalcLikePatternsMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node
 	rr := LikeResult{}
 	for i := 1; i < nsites; i++ {
 		rr = <-results
-		fl += math.Log(rr.value) * patternval[rr.site]
+		fl += (math.Log(rr.value) + rr.scale) * patternval[rr.site]
+	}
+	if models[0].Asc != "" {
+		fl += ascCorrectionMul(t, models, nodemodels, patternval)
 	}
 	return
 }
@@ -60,8 +66,11 @@ func PCalcLikePatternsMulSubClade(t *Tree, n *Node, excl bool, models []*Discret
 	// just the first site
 	for _, x := range models {
 		x.EmptyPDict()
+		x.FillPCache(t, false)
 	}
-	fl += calcLikeOneSiteMulSubClade(t, n, excl, models, nodemodels, 0) * patternval[0]
+	setupScale(t, nsites)
+	sl, sc := calcLikeOneSiteMulSubClade(t, n, excl, models, nodemodels, 0)
+	fl += (math.Log(sl) + sc) * patternval[0]
 	for i := 0; i < wks; i++ {
 		go calcLikeMulSubCladeWork(t, n, excl, models, nodemodels, jobs, results)
 	}
@@ -72,7 +81,7 @@ func PCalcLikePatternsMulSubClade(t *Tree, n *Node, excl bool, models []*Discret
 	rr := LikeResult{}
 	for i := 1; i < nsites; i++ {
 		rr = <-results
-		fl += (math.Log(rr.value) * patternval[rr.site])
+		fl += (math.Log(rr.value) + rr.scale) * patternval[rr.site]
 	}
 	return
 }
@@ -80,53 +89,15 @@ func PCalcLikePatternsMulSubClade(t *Tree, n *Node, excl bool, models []*Discret
 // calcLikeSubCladeWork this is intended for a worker that will be executing this per site
 func calcLikeMulSubCladeWork(t *Tree, inn *Node, excl bool, models []*DiscreteModel,
 	nodemodels map[*Node]int, jobs <-chan int, results chan<- LikeResult) { //results chan<- float64) {
-	arr := []*Node{}
-	if excl == true {
-		arr = t.Rt.PostorderArrayExcl(inn)
-	} else {
-		arr = inn.PostorderArray()
-	}
 	for j := range jobs {
-		sl := 0.0
-		for _, n := range arr {
-			x := models[nodemodels[n]]
-			numstates := x.NumStates
-			if len(n.Chs) > 0 {
-				CalcLikeNode(n, x, j)
-			}
-			var tn *Node
-			if excl == true { // calc at rt
-				tn = t.Rt
-			} else {
-				tn = inn
-			}
-			if tn == n {
-				if tn == t.Rt { //only happens at the root
-					for i := 0; i < numstates; i++ {
-						n.Data[j][i] *= x.BF[i]
-					}
-					sl = floats.Sum(n.Data[j])
-				} else {
-					p := x.GetPCalc(n.Len)
-					rtconds := make([]float64, x.GetNumStates())
-					for m := 0; m < numstates; m++ {
-						templike := 0.0
-						for k := 0; k < numstates; k++ {
-							templike += p.At(m, k) * n.Data[j][k]
-						}
-						rtconds[m] = templike
-					}
-					sl = floats.Sum(rtconds)
-				}
-			}
-		}
-		results <- LikeResult{value: sl, site: j}
+		sl, sc := calcLikeOneSiteMulSubClade(t, inn, excl, models, nodemodels, j)
+		results <- LikeResult{value: sl, scale: sc, site: j}
 	}
 }
 
 // calcLogLikeOneSiteSubClade calc for just a clade, starting at a node
 func calcLikeOneSiteMulSubClade(t *Tree, inn *Node, excl bool, models []*DiscreteModel,
-	nodemodels map[*Node]int, site int) float64 {
+	nodemodels map[*Node]int, site int) (float64, float64) {
 	sl := 0.0
 	arr := []*Node{}
 	if excl == true {
@@ -134,18 +105,18 @@ func calcLikeOneSiteMulSubClade(t *Tree, inn *Node, excl bool, models []*Discret
 	} else {
 		arr = inn.PostorderArray()
 	}
+	var tn *Node
+	if excl == true { // calc at rt
+		tn = t.Rt
+	} else {
+		tn = inn
+	}
 	for _, n := range arr {
 		x := models[nodemodels[n]]
 		numstates := x.NumStates
 		if len(n.Chs) > 0 {
 			CalcLikeNode(n, x, site)
 		}
-		var tn *Node
-		if excl == true { // calc at rt
-			tn = t.Rt
-		} else {
-			tn = inn
-		}
 		if tn == n {
 			if tn == t.Rt { //only happens at the root
 				for i := 0; i < numstates; i++ {
@@ -167,7 +138,7 @@ func calcLikeOneSiteMulSubClade(t *Tree, inn *Node, excl bool, models []*Discret
 			}
 		}
 	}
-	return sl
+	return sl, nodeScale(tn, site)
 }
 
 //PCalcLogLikePatternsMul ...
@@ -181,6 +152,7 @@ func PCalcLogLikePatternsMul(t *Tree, models []*DiscreteModel, nodemodels map[*N
 	for _, x := range models {
 		x.EmptyPDict()
 		x.EmptyPLDict()
+		x.FillPCache(t, true)
 	}
 	var lkfun1 func(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int) float64
 	var lkfun2 func(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, jobs <-chan int, results chan<- LikeResult)
@@ -204,20 +176,33 @@ func PCalcLogLikePatternsMul(t *Tree, models []*DiscreteModel, nodemodels map[*N
 		rr = <-results
 		fl += (rr.value * patternval[rr.site])
 	}
+	if models[0].Asc != "" {
+		fl += ascCorrectionMul(t, models, nodemodels, patternval)
+	}
 	return
 }
 
+// mixLogInvMul adds the invariable class (with the PInv of the first model, like
+// the gamma categories, and the base frequencies of the root model)
+func mixLogInvMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int, lnl float64) float64 {
+	if models[0].PInv <= 0 {
+		return lnl
+	}
+	return mixLogInvLike(models[0].PInv, calcInvLikeTips(t.Tips, models[nodemodels[t.Rt]].BF, site), lnl)
+}
+
 //CalcLogLikeOneSiteGammaMul just one site
 func CalcLogLikeOneSiteGammaMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int) float64 {
 	numstates := models[0].NumStates
 	gammacats := models[0].GammaCats
 	ncats := models[0].GammaNCats
+	rs := invRateScale(models[0])
 	tsl := make([]float64, ncats)
 	for p, g := range gammacats {
 		for _, n := range t.Post {
 			x := models[nodemodels[n]]
 			if len(n.Chs) > 0 {
-				CalcLogLikeNodeGamma(n, x, site, g)
+				CalcLogLikeNodeGamma(n, x, site, g*rs)
 			}
 			if t.Rt == n {
 				for i := 0; i < numstates; i++ {
@@ -227,20 +212,21 @@ func CalcLogLikeOneSiteGammaMul(t *Tree, models []*DiscreteModel, nodemodels map
 			}
 		}
 	}
-	return floats.LogSumExp(tsl)
+	return mixLogInvMul(t, models, nodemodels, site, floats.LogSumExp(tsl))
 }
 
 func CalcLogLikeWorkGammaMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, jobs <-chan int, results chan<- LikeResult) { //results chan<- float64) {
 	numstates := models[0].NumStates
 	gammacats := models[0].GammaCats
 	ncats := models[0].GammaNCats
+	rs := invRateScale(models[0])
 	for j := range jobs {
 		tsl := make([]float64, ncats)
 		for p, g := range gammacats {
 			for _, n := range t.Post {
 				x := models[nodemodels[n]]
 				if len(n.Chs) > 0 {
-					CalcLogLikeNodeGamma(n, x, j, g)
+					CalcLogLikeNodeGamma(n, x, j, g*rs)
 				}
 				if t.Rt == n {
 					for i := 0; i < numstates; i++ {
@@ -250,52 +236,45 @@ func CalcLogLikeWorkGammaMul(t *Tree, models []*DiscreteModel, nodemodels map[*N
 				}
 			}
 		}
-		results <- LikeResult{value: floats.LogSumExp(tsl), site: j}
+		results <- LikeResult{value: mixLogInvMul(t, models, nodemodels, j, floats.LogSumExp(tsl)), site: j}
 	}
 }
 
-//CalcLikeOneSiteGamma just one site
+//CalcLikeOneSiteGammaMul just one site
 func CalcLikeOneSiteGammaMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int) float64 {
-	sl := 0.0
+	sl, sc := calcLikeOneSiteGammaMul(t, models, nodemodels, site)
+	return sl * math.Exp(sc)
+}
+
+func calcLikeOneSiteGammaMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int) (float64, float64) {
+	sl, sc := 0.0, 0.0
 	numstates := models[0].NumStates
 	gammacats := models[0].GammaCats
+	rs := invRateScale(models[0])
 	for _, g := range gammacats {
 		for _, n := range t.Post {
 			x := models[nodemodels[n]]
 			if len(n.Chs) > 0 {
-				CalcLikeNodeGamma(n, x, site, g)
+				CalcLikeNodeGamma(n, x, site, g*rs)
 			}
 			if t.Rt == n {
 				for i := 0; i < numstates; i++ {
 					t.Rt.Data[site][i] *= x.BF[i]
 				}
-				sl += floats.Sum(t.Rt.Data[site]) * (1. / float64(x.GammaNCats))
+				sl, sc = addScaled(sl, sc, floats.Sum(t.Rt.Data[site])*(1./float64(x.GammaNCats)), nodeScale(t.Rt, site))
 			}
 		}
 	}
-	return sl
+	if models[0].PInv > 0 {
+		sl = math.Exp(mixLogInvMul(t, models, nodemodels, site, math.Log(sl)+sc) - sc)
+	}
+	return sl, sc
 }
 
 func CalcLikeWorkGammaMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, jobs <-chan int, results chan<- LikeResult) { //results chan<- float64) {
-	numstates := models[0].NumStates
-	gammacats := models[0].GammaCats
 	for j := range jobs {
-		sl := 0.0
-		for _, g := range gammacats {
-			for _, n := range t.Post {
-				x := models[nodemodels[n]]
-				if len(n.Chs) > 0 {
-					CalcLikeNodeGamma(n, x, j, g)
-				}
-				if t.Rt == n {
-					for i := 0; i < numstates; i++ {
-						t.Rt.Data[j][i] *= x.BF[i]
-					}
-					sl += floats.Sum(t.Rt.Data[j]) * (1. / float64(x.GammaNCats))
-				}
-			}
-		}
-		results <- LikeResult{value: sl, site: j}
+		sl, sc := calcLikeOneSiteGammaMul(t, models, nodemodels, j)
+		results <- LikeResult{value: sl, scale: sc, site: j}
 	}
 }
 
@@ -311,6 +290,7 @@ func PCalcLogLikePatternsGammaMul(t *Tree, models []*DiscreteModel, nodemodels m
 	for _, x := range models {
 		x.EmptyPDict()
 		x.EmptyPLDict()
+		x.FillPCache(t, true)
 	}
 	fl += CalcLogLikeOneSiteGammaMul(t, models, nodemodels, 0) * patternval[0]
 	for i := 0; i < wks; i++ {
@@ -326,6 +306,9 @@ func PCalcLogLikePatternsGammaMul(t *Tree, models []*DiscreteModel, nodemodels m
 		fl += (rr.value * patternval[rr.site])
 		//fl += <-results
 	}
+	if models[0].Asc != "" {
+		fl += ascCorrectionMul(t, models, nodemodels, patternval)
+	}
 	return
 }
 
@@ -342,8 +325,11 @@ func PCalcLikePatternsGammaMul(t *Tree, models []*DiscreteModel, nodemodels map[
 	for _, x := range models {
 		x.EmptyPDict()
 		x.EmptyPLDict()
+		x.FillPCache(t, false)
 	}
-	fl += math.Log(CalcLikeOneSiteGammaMul(t, models, nodemodels, 0)) * patternval[0]
+	setupScale(t, nsites)
+	sl, sc := calcLikeOneSiteGammaMul(t, models, nodemodels, 0)
+	fl += (math.Log(sl) + sc) * patternval[0]
 	for i := 0; i < wks; i++ {
 		go CalcLikeWorkGammaMul(t, models, nodemodels, jobs, results)
 	}
@@ -354,14 +340,17 @@ func PCalcLikePatternsGammaMul(t *Tree, models []*DiscreteModel, nodemodels map[
 	rr := LikeResult{}
 	for i := 1; i < nsites; i++ {
 		rr = <-results
-		fl += math.Log(rr.value) * patternval[rr.site]
+		fl += (math.Log(rr.value) + rr.scale) * patternval[rr.site]
 		//fl += <-results
 	}
+	if models[0].Asc != "" {
+		fl += ascCorrectionMul(t, models, nodemodels, patternval)
+	}
 	return
 }
 
 //CalcLikeOneSiteMul just one site
-func calcLikeOneSiteMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int) float64 {
+func calcLikeOneSiteMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int) (float64, float64) {
 	sl := 0.0
 	for _, n := range t.Post {
 		x := models[nodemodels[n]]
@@ -376,7 +365,7 @@ func calcLikeOneSiteMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]i
 			sl = floats.Sum(t.Rt.Data[site])
 		}
 	}
-	return sl
+	return sl, nodeScale(t.Rt, site)
 }
 
 //CalcLogLikeOneSiteMul just one site
@@ -401,21 +390,8 @@ func CalcLogLikeOneSiteMul(t *Tree, models []*DiscreteModel, nodemodels map[*Nod
 //calcLikeWorkMul this is the worker
 func calcLikeWorkMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, jobs <-chan int, results chan<- LikeResult) { //results chan<- float64) {
 	for j := range jobs {
-		sl := 0.0
-		for _, n := range t.Post {
-			x := models[nodemodels[n]]
-			numstates := x.NumStates
-			if len(n.Chs) > 0 {
-				CalcLikeNode(n, x, j)
-			}
-			if t.Rt == n {
-				for i := 0; i < numstates; i++ {
-					t.Rt.Data[j][i] *= x.BF[i]
-				}
-				sl = floats.Sum(t.Rt.Data[j])
-			}
-		}
-		results <- LikeResult{value: sl, site: j}
+		sl, sc := calcLikeOneSiteMul(t, models, nodemodels, j)
+		results <- LikeResult{value: sl, scale: sc, site: j}
 	}
 }
 
@@ -450,7 +426,9 @@ func PCalcLikePatternsMarkedMul(t *Tree, models []*DiscreteModel, nodemodels map
 	// populate the P matrix dictionary without problems of race conditions
 	// just the first site
 	//x.EmptyPDict()
-	fl += math.Log(CalcLikeOneSiteMarkedMul(t, models, nodemodels, 0)) * patternval[0]
+	setupScale(t, nsites)
+	sl, sc := calcLikeOneSiteMarkedMul(t, models, nodemodels, 0)
+	fl += (math.Log(sl) + sc) * patternval[0]
 	for i := 0; i < wks; i++ {
 		go CalcLikeWorkMarkedMul(t, models, nodemodels, jobs, results)
 	}
@@ -461,18 +439,24 @@ func PCalcLikePatternsMarkedMul(t *Tree, models []*DiscreteModel, nodemodels map
 	rr := LikeResult{}
 	for i := 1; i < nsites; i++ {
 		rr = <-results
-		fl += math.Log(rr.value) * patternval[rr.site]
+		fl += (math.Log(rr.value) + rr.scale) * patternval[rr.site]
 		//fl += <-results
 	}
+	if models[0].Asc != "" {
+		fl += ascCorrectionMul(t, models, nodemodels, patternval)
+	}
 	return
 }
 
 // CalcLikeOneSiteMarkedMul this uses the marked machinery to recalculate
 func CalcLikeOneSiteMarkedMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int) float64 {
-	sl := 0.0
+	sl, sc := calcLikeOneSiteMarkedMul(t, models, nodemodels, site)
+	return sl * math.Exp(sc)
+}
+
+func calcLikeOneSiteMarkedMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, site int) (float64, float64) {
 	for _, n := range t.Post {
 		x := models[nodemodels[n]]
-		numstates := x.NumStates
 		if len(n.Chs) > 0 {
 			if n.Marked == true {
 				CalcLikeNode(n, x, site)
@@ -481,40 +465,21 @@ func CalcLikeOneSiteMarkedMul(t *Tree, models []*DiscreteModel, nodemodels map[*
 				}
 			}
 		}
-		if t.Rt == n && n.Marked == true {
-			for i := 0; i < numstates; i++ {
-				t.Rt.Data[site][i] *= x.BF[i]
-			}
-			sl = floats.Sum(t.Rt.Data[site])
-		} else {
-			sl = floats.Sum(t.Rt.Data[site])
+	}
+	if t.Rt.Marked == true {
+		x := models[nodemodels[t.Rt]]
+		for i := 0; i < x.NumStates; i++ {
+			t.Rt.Data[site][i] *= x.BF[i]
 		}
 	}
-	return sl
+	return floats.Sum(t.Rt.Data[site]), nodeScale(t.Rt, site)
 }
 
 // CalcLikeWorkMarkedMul this is intended to calculate only on the marked nodes back to teh root
 func CalcLikeWorkMarkedMul(t *Tree, models []*DiscreteModel, nodemodels map[*Node]int, jobs <-chan int, results chan<- LikeResult) {
 	for j := range jobs {
-		sl := 0.0
-		for _, n := range t.Post {
-			x := models[nodemodels[n]]
-			numstates := x.NumStates
-			if len(n.Chs) > 0 {
-				if n.Marked == true {
-					CalcLikeNode(n, x, j)
-				}
-			}
-			if t.Rt == n && n.Marked == true {
-				for i := 0; i < numstates; i++ {
-					t.Rt.Data[j][i] *= x.BF[i]
-				}
-				sl = floats.Sum(t.Rt.Data[j])
-			} else {
-				sl = floats.Sum(t.Rt.Data[j])
-			}
-		}
-		results <- LikeResult{value: sl, site: j}
+		sl, sc := calcLikeOneSiteMarkedMul(t, models, nodemodels, j)
+		results <- LikeResult{value: sl, scale: sc, site: j}
 	}
 }
 
@@ -553,22 +518,7 @@ func RVMultconditionals(models []*DiscreteModel, nodemodels map[*Node]int, node
 
 //CalcLikeFrontBackMult ...
 func CalcLikeFrontBackMult(models []*DiscreteModel, nodemodels map[*Node]int, tree *Tree, patternval []float64) {
-	for _, n := range tree.Post {
-		if len(n.Chs) != 0 {
-			n.TpConds = make([][]float64, len(patternval))
-		}
-		n.RvTpConds = make([][]float64, len(patternval))
-		n.RvConds = make([][]float64, len(patternval))
-		n.RtConds = make([][]float64, len(patternval))
-		for i := 0; i < len(patternval); i++ {
-			if len(n.Chs) != 0 {
-				n.TpConds[i] = []float64{1.0, 1.0, 1.0, 1.0}
-			}
-			n.RvTpConds[i] = []float64{1.0, 1.0, 1.0, 1.0}
-			n.RvConds[i] = []float64{1.0, 1.0, 1.0, 1.0}
-			n.RtConds[i] = []float64{1.0, 1.0, 1.0, 1.0}
-		}
-	}
+	tree.setupConds(len(patternval), models[0].NumStates, 1.0)
 	//loglike := 0.
 	for _, c := range tree.Post {
 		//calculate the tip conditionals
@@ -125,7 +125,7 @@ func OptimizeGammaBLS(t *Tree, x *DiscreteModel, patternvals []float64, wks int)
 	if err != nil {
 		fmt.Println(err)
 	}
-	fmt.Println(res.F)
+	fmt.Fprintln(os.Stderr, res.F)
 	for x, n := range t.Post {
 		n.Len = res.X[x]
 	}
@@ -203,6 +203,11 @@ func AdjustBLNR(node *Node, x *DiscreteModel, patternvals []float64, t *Tree, wk
 	//need subtree 2
 	s2probs := node.RvConds
 	for z := 0; z < 10; z++ {
+		// the correction doesn't split over the sites
+		ad1, ad2 := 0., 0.
+		if x.Asc != "" {
+			ad1, ad2 = ascCorrectionDerivs(t, node, x, patternvals)
+		}
 		t := node.Len
 		p := x.GetPCalc(t)
 		x.DecomposeQ()
@@ -226,6 +231,8 @@ func AdjustBLNR(node *Node, x *DiscreteModel, patternvals []float64, t *Tree, wk
 			d2 += (((tempd2 / templike) - (math.Pow(tempd1, 2) / math.Pow(templike, 2))) * patternvals[s])
 			like += math.Log(templike)
 		}
+		d1 += ad1
+		d2 += ad2
 		if (t - (d1 / d2)) < 0 {
 			node.Len = 10e-12
 			break
@@ -679,6 +686,50 @@ func OptimizeGTRDNA(t *Tree, x *DiscreteModel, patternvals []float64,
 	return res
 }
 
+// OptimizeNucModel optimize the free exchangeabilities of a named nucleotide model
+// (x.Sub) keeping the constraints of that model. The base frequencies are not changed
+func OptimizeNucModel(t *Tree, x *DNAModel, patternvals []float64, wks int) []float64 {
+	nr := nucModelFreeRates[x.Sub]
+	if nr == 0 {
+		return nil
+	}
+	var lkfun func(*Tree, *DiscreteModel, []float64, int) float64
+	if x.M.GammaNCats != 0 {
+		lkfun = PCalcLikePatternsGamma
+	} else {
+		lkfun = PCalcLikePatterns
+	}
+	fcn := func(mds []float64) float64 {
+		for _, i := range mds {
+			if i <= 0 || i > 100 {
+				return 1000000000000
+			}
+		}
+		x.SetNucModelParams(mds)
+		lnl := lkfun(t, &x.M, patternvals, wks)
+		return -lnl
+	}
+	p0 := make([]float64, nr)
+	for i := range p0 {
+		p0[i] = 1.0
+		if len(x.Params) == nr && x.Params[i] > 0 {
+			p0[i] = x.Params[i]
+		}
+	}
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, p0, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	x.SetNucModelParams(res.X)
+	return res.X
+}
+
 // OptimizeBF optimizing the basefreq model but for a clade
 func OptimizeBF(t *Tree, x *DiscreteModel, patternvals []float64, log bool, wks int) {
 	numstates := x.NumStates
@@ -1037,6 +1088,60 @@ func OptimizeGamma(t *Tree, x *DiscreteModel, patternvals []float64, log bool, w
 	fmt.Fprintln(os.Stderr, "gamma:", minf, res[0])
 }
 
+// OptimizeGammaInv jointly optimize the gamma alpha and the proportion of
+// invariable sites (PInv). If x.GammaNCats is less than 2 there is no gamma and
+// just PInv is optimized with a single rate category
+func OptimizeGammaInv(t *Tree, x *DiscreteModel, patternvals []float64, log bool, wks int) {
+	var lkfun func(*Tree, *DiscreteModel, []float64, int) float64
+	if log {
+		lkfun = PCalcLogLikePatternsGamma
+	} else {
+		lkfun = PCalcLikePatternsGamma
+	}
+	gamma := x.GammaNCats > 1
+	if gamma == false {
+		x.GammaNCats = 1
+		x.GammaCats = []float64{1.0}
+	}
+	fcn := func(mds []float64) float64 {
+		if mds[0] < 0 || mds[0] > 0.99 {
+			return 1000000000000
+		}
+		x.PInv = mds[0]
+		if gamma {
+			if mds[1] < 10e-4 || mds[1] > 100 {
+				return 1000000000000
+			}
+			x.GammaAlpha = mds[1]
+			x.GammaCats = GetGammaCats(x.GammaAlpha, x.GammaNCats, false)
+		}
+		lnl := lkfun(t, x, patternvals, wks)
+		return -lnl
+	}
+	p0 := []float64{0.1}
+	if x.PInv > 0 {
+		p0[0] = x.PInv
+	}
+	if gamma {
+		p0 = append(p0, 1.0)
+		if x.GammaAlpha > 0 {
+			p0[1] = x.GammaAlpha
+		}
+	}
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, p0, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	fcn(res.X)
+	fmt.Fprintln(os.Stderr, "gamma+inv:", res.F, res.X)
+}
+
 // OptimizeGammaAndBL ...
 func OptimizeGammaAndBL(t *Tree, x *DiscreteModel, patternvals []float64, log bool, wks int) {
 	OptimizeGamma(t, x, patternvals, log, wks)
@@ -3,6 +3,7 @@ package gophy_test
 import (
 	"fmt"
 	"math"
+	"math/rand"
 	"testing"
 
 	"github.com/FePhyFoFum/gophy"
@@ -287,3 +288,98 @@ func TestBLDecomp(t *testing.T) {
 	fmt.Println(lnl)
 	t.Fail()
 }
+
+func TestPCalcLikePatternsGammaInv(t *testing.T) {
+	tr := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	seqs, patternsint, _, bf := gophy.ReadPatternsSeqsFromFile("test_files/10tips.nuc.fa", true)
+	patternval, _ := gophy.PreparePatternVecs(tr, patternsint, seqs)
+	x := gophy.NewF81Model(bf)
+	// one rate category without invariable sites is the plain likelihood
+	x.M.GammaNCats = 1
+	x.M.GammaCats = []float64{1.0}
+	lnl := gophy.PCalcLikePatternsGamma(tr, &x.M, patternval, 2)
+	if math.Round(lnl*1000)/1000 != -4570.796 {
+		t.Error(lnl)
+	}
+	x.M.GammaNCats = 4
+	x.M.GammaCats = gophy.GetGammaCats(0.5, 4, false)
+	x.M.PInv = 0.2
+	lnl = gophy.PCalcLikePatternsGamma(tr, &x.M, patternval, 2)
+	llnl := gophy.PCalcLogLikePatternsGamma(tr, &x.M, patternval, 2)
+	if math.Abs(lnl-llnl) > 1e-6 {
+		t.Error(lnl, llnl)
+	}
+	// the subclade at the root and a single model for every node are the whole tree
+	models := []*gophy.DiscreteModel{&x.M}
+	nodemodels := map[*gophy.Node]int{}
+	for _, n := range tr.Post {
+		nodemodels[n] = 0
+	}
+	for k, l := range map[string]float64{
+		"PCalcLikePatternsMarkedGamma": gophy.PCalcLikePatternsMarkedGamma(tr, &x.M, patternval, 2),
+		"PCalcLikePatternsSubClade":    gophy.PCalcLikePatternsSubClade(tr, tr.Rt, false, &x.M, patternval, 2),
+		"PCalcLogLikePatternsSubClade": gophy.PCalcLogLikePatternsSubClade(tr, tr.Rt, false, &x.M, patternval, 2),
+		"PCalcLikePatternsGammaMul":    gophy.PCalcLikePatternsGammaMul(tr, models, nodemodels, patternval, 2),
+		"PCalcLogLikePatternsGammaMul": gophy.PCalcLogLikePatternsGammaMul(tr, models, nodemodels, patternval, 2),
+		"PCalcLikePatternsMul":         gophy.PCalcLikePatternsMul(tr, models, nodemodels, patternval, 2),
+		"PCalcLogLikePatternsMul":      gophy.PCalcLogLikePatternsMul(tr, models, nodemodels, patternval, 2),
+	} {
+		if math.Abs(l-llnl) > 1e-6 {
+			t.Error(k, l, llnl)
+		}
+	}
+	gophy.OptimizeGammaInv(tr, &x.M, patternval, false, 2)
+	if olnl := gophy.PCalcLikePatternsGamma(tr, &x.M, patternval, 2); olnl < lnl || x.M.PInv == 0.2 {
+		t.Error(lnl, olnl, x.M.PInv, x.M.GammaAlpha)
+	}
+	if x.NumParams() != 5 {
+		t.Error(x.NumParams())
+	}
+}
+
+func TestLikeScaling(t *testing.T) {
+	rand.Seed(2)
+	tr, pv := randomPatterns(2000, 10, 0.5)
+	x, _ := gophy.NewNucModel(gophy.JC69, nil, []float64{0.25, 0.25, 0.25, 0.25})
+	x.M.EmptyPDict()
+	x.M.EmptyPLDict()
+	lg := 0.0
+	for s := range pv {
+		lg += gophy.CalcLogLikeOneSite(tr, &x.M, s)
+	}
+	// the unscaled likelihood of a site is 0
+	if gophy.CalcLikeOneSite(tr, &x.M, 0) != 0 {
+		t.Fatal("this tree should underflow")
+	}
+	models := []*gophy.DiscreteModel{&x.M}
+	nodemodels := map[*gophy.Node]int{}
+	for _, n := range tr.Post {
+		nodemodels[n] = 0
+	}
+	ls := map[string]float64{
+		"PCalcLike":                 gophy.PCalcLike(tr, &x.M, len(pv), 2),
+		"PCalcLikePatternsSubClade": gophy.PCalcLikePatternsSubClade(tr, tr.Rt, false, &x.M, pv, 2),
+		"PCalcLikePatternsMul":      gophy.PCalcLikePatternsMul(tr, models, nodemodels, pv, 2),
+	}
+	for k, l := range ls {
+		if math.Abs(l-lg) > 1e-6*math.Abs(lg) {
+			t.Error(k, l, lg)
+		}
+	}
+
+	// the categories have different scalers
+	x.M.GammaNCats = 4
+	x.M.GammaCats = gophy.GetGammaCats(0.5, 4, false)
+	lg = 0.0
+	for s := range pv {
+		lg += gophy.CalcLogLikeOneSiteGamma(tr, &x.M, s)
+	}
+	for k, l := range map[string]float64{
+		"PCalcLikePatternsGamma":    gophy.PCalcLikePatternsGamma(tr, &x.M, pv, 2),
+		"PCalcLikePatternsGammaMul": gophy.PCalcLikePatternsGammaMul(tr, models, nodemodels, pv, 2),
+	} {
+		if math.Abs(l-lg) > 1e-6*math.Abs(lg) {
+			t.Error(k, l, lg)
+		}
+	}
+}
@@ -16,6 +16,7 @@ const (
 	Nucleotide DataType = "nuc"
 	AminoAcid           = "aa"
 	MultiState          = "mult"
+	Codon               = "codon"
 )
 
 // DiscreteModel overall model struct
@@ -29,21 +30,33 @@ type DiscreteModel struct {
 	Ex        string     // PAML-formatted exchangeabilities for Prot models
 	CharMap   map[string][]int
 	NumStates int
-	//sync.RWMutex
-	Ps  map[float64]*mat.Dense
-	PsL map[float64]*mat.Dense
+	Ps  *PCache // the P matrices for the branch lengths (see GetPMap)
+	PsL *PCache // the logged ones (see GetPMapLogged)
 	X   *mat.Dense
 	P   mat.Dense
 	//for decomposing
-	QS         *mat.Dense
-	EigenVals  []float64  // to be exponentiated
-	EigenVecs  *mat.Dense //
-	EigenVecsI *mat.Dense
-	X1         *mat.Dense
-	X2         *mat.Dense
-	GammaAlpha float64
-	GammaNCats int
-	GammaCats  []float64
+	QS          *mat.Dense
+	EigenVals   []float64  // to be exponentiated
+	EigenVecs   *mat.Dense //
+	EigenVecsI  *mat.Dense
+	X1          *mat.Dense
+	X2          *mat.Dense
+	GammaAlpha  float64
+	GammaNCats  int
+	GammaCats   []float64
+	PInv        float64   // proportion of invariable sites (used with the gamma likelihoods)
+	RateWeights []float64 // weights of the GammaCats for FreeRate (+R), equal if nil
+	RootBF      []float64 // root state frequencies for the rooted likelihoods, BF if nil
+	Asc         string    // ascertainment bias correction (see SetAscBias)
+	AscInv      []float64 // invariant sites left out for the felsenstein and stamatakis corrections
+}
+
+// catWeight the weight of the rate category i (1/GammaNCats unless FreeRate)
+func (d *DiscreteModel) catWeight(i int) float64 {
+	if d.RateWeights != nil {
+		return d.RateWeights[i]
+	}
+	return 1. / float64(d.GammaNCats)
 }
 
 // NewDiscreteModel get new model pointer
@@ -82,7 +95,7 @@ func (d *DiscreteModel) SetupQJC() {
 		bf[i] = 1. / float64(d.NumStates)
 	}
 	d.BF = bf
-	d.Ps = make(map[float64]*mat.Dense)
+	d.EmptyPDict()
 	d.Q = mat.NewDense(d.NumStates, d.NumStates, nil)
 
 	for i := 0; i < d.NumStates; i++ {
@@ -101,7 +114,7 @@ func (d *DiscreteModel) SetupQJC() {
 //     and not relative to these rates
 //     Will take BF from something else
 func (d *DiscreteModel) SetupQJC1Rate(rt float64) {
-	d.Ps = make(map[float64]*mat.Dense)
+	d.EmptyPDict()
 	d.Q = mat.NewDense(d.NumStates, d.NumStates, nil)
 
 	for i := 0; i < d.NumStates; i++ {
@@ -152,7 +165,7 @@ func (d *DiscreteModel) SetupQGTR() {
 //    and not to these branch lengths)
 //    Will take the BF from something else
 func (d *DiscreteModel) SetupQMk(rt []float64, sym bool) {
-	d.Ps = make(map[float64]*mat.Dense)
+	d.EmptyPDict()
 	d.Q = mat.NewDense(d.NumStates, d.NumStates, nil)
 	cc := 0
 	for i := 0; i < d.NumStates; i++ {
@@ -342,67 +355,73 @@ func (d *DiscreteModel) SetP(blen float64) {
 	d.ExpValue(d.EigenVals, blen)
 	P.Mul(d.EigenVecs, d.X)
 	P.Mul(P, d.EigenVecsI)
-	// easier
-	//d.Lock()
-	d.Ps[blen] = P
-	//d.Unlock()
+	d.Ps.Put(blen, 1.0, P)
 }
 
 // SetPSimple use the gonum matrixexp (seems faster)
 func (d *DiscreteModel) SetPSimple(blen float64) {
+	d.setPRate(blen, 1.0)
+}
+
+// setPRate P for blen in the rate category with rate
+func (d *DiscreteModel) setPRate(blen float64, rate float64) *mat.Dense {
 	P := mat.NewDense(d.NumStates, d.NumStates, nil)
-	P.Scale(blen, d.Q)
+	P.Scale(blen*rate, d.Q)
 	P.Exp(P)
-	//d.Lock()
-	d.Ps[blen] = P
-	//d.Unlock()
+	d.Ps.Put(blen, rate, P)
+	return P
 }
 
-// EmptyPDict save memory perhaps?
+// EmptyPDict empty the P matrices if Q has changed since they were calculated
+// (call it whenever Q may have changed). The ones for the same Q are kept for
+// the calls that only change the branch lengths. It keeps the size of the cache
 func (d *DiscreteModel) EmptyPDict() {
-	d.Ps = nil
-	d.Ps = make(map[float64]*mat.Dense)
+	if d.Ps == nil {
+		d.Ps = NewPCache(DefaultPCacheSize)
+	}
+	d.Ps.SetQ(d.Q)
 }
 
 // EmptyPLDict the logged one
 func (d *DiscreteModel) EmptyPLDict() {
-	d.PsL = nil
-	d.PsL = make(map[float64]*mat.Dense)
+	if d.PsL == nil {
+		d.PsL = NewPCache(DefaultPCacheSize)
+	}
+	d.PsL.SetQ(d.Q)
 }
 
-// GetPMap get the Ps from the dictionary
+// GetPMap get the P for blen from the cache (calculating it if it isn't there)
 func (d *DiscreteModel) GetPMap(blen float64) *mat.Dense {
-	//d.RLock()
-	if _, ok := d.Ps[blen]; ok {
-		//d.RLock()
-		return d.Ps[blen]
-		//X := d.Ps[blen]
-		//	d.RUnlock()
-		//return X
+	return d.GetPMapRate(blen, 1.0)
+}
+
+// GetPMapRate get the P for blen in the rate category with rate (e.g., a gamma
+// category) from the cache
+func (d *DiscreteModel) GetPMapRate(blen float64, rate float64) *mat.Dense {
+	if P, ok := d.Ps.Get(blen, rate); ok {
+		return P
 	}
-	//d.RUnlock()
-	d.SetPSimple(blen)
-	//d.RLock()
-	//X := d.Ps[blen]
-	//d.RUnlock()
-	return d.Ps[blen]
+	return d.setPRate(blen, rate)
 }
 
-// GetPMapLogged get the Ps from the dictionary
+// GetPMapLogged get the logged P for blen from the cache
 func (d *DiscreteModel) GetPMapLogged(blen float64) *mat.Dense {
-	if _, ok := d.PsL[blen]; ok {
-		return d.PsL[blen]
+	return d.GetPMapLoggedRate(blen, 1.0)
+}
+
+// GetPMapLoggedRate get the logged P for blen in the rate category with rate
+func (d *DiscreteModel) GetPMapLoggedRate(blen float64, rate float64) *mat.Dense {
+	if P, ok := d.PsL.Get(blen, rate); ok {
+		return P
 	}
-	P := mat.NewDense(d.NumStates, d.NumStates, nil)
-	P.Scale(blen, d.Q)
-	P.Exp(P)
+	P := mat.DenseCopyOf(d.GetPMapRate(blen, rate))
 	for i := 0; i < d.NumStates; i++ {
 		for j := 0; j < d.NumStates; j++ {
 			P.Set(i, j, math.Log(P.At(i, j)))
 		}
 	}
-	d.PsL[blen] = P
-	return d.PsL[blen]
+	d.PsL.Put(blen, rate, P)
+	return P
 }
 
 // GetPCalc calculate P matrix
@@ -0,0 +1,213 @@
+package gophy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/optimize"
+)
+
+// SetFreeRates sets up a FreeRate (+R) model with the rates and weights of the
+// categories. The weights are normalised to sum to 1 and the rates so that the
+// mean rate is 1. The likelihood functions for gamma (e.g., PCalcLikePatternsGamma)
+// are then used with these categories
+func (d *DiscreteModel) SetFreeRates(rates []float64, weights []float64) {
+	ws := floats.Sum(weights)
+	d.RateWeights = make([]float64, len(weights))
+	for i, w := range weights {
+		d.RateWeights[i] = w / ws
+	}
+	mr := floats.Dot(rates, d.RateWeights)
+	d.GammaCats = make([]float64, len(rates))
+	for i, r := range rates {
+		d.GammaCats[i] = r / mr
+	}
+	d.GammaNCats = len(rates)
+	d.GammaAlpha = 0.
+}
+
+// SetupFreeRates starts a FreeRate model with k categories at the discrete gamma
+// rates for alpha = 1 with equal weights
+func (d *DiscreteModel) SetupFreeRates(k int) {
+	ws := make([]float64, k)
+	for i := range ws {
+		ws[i] = 1.
+	}
+	d.SetFreeRates(GetGammaCats(1.0, k, false), ws)
+}
+
+// OptimizeFreeRates optimize the rates and weights of a FreeRate model (see
+// SetupFreeRates). There are 2k-2 free parameters as the weights sum to 1 and the
+// mean rate is 1. The categories are sorted by rate after
+func OptimizeFreeRates(t *Tree, x *DiscreteModel, patternvals []float64, log bool, wks int) {
+	var lkfun func(*Tree, *DiscreteModel, []float64, int) float64
+	if log {
+		lkfun = PCalcLogLikePatternsGamma
+	} else {
+		lkfun = PCalcLikePatternsGamma
+	}
+	k := x.GammaNCats
+	// the params are the logs of the rates and the weights relative to the last category
+	setp := func(mds []float64) {
+		rs := make([]float64, k)
+		ws := make([]float64, k)
+		for i := 0; i < k-1; i++ {
+			rs[i] = math.Exp(mds[i])
+			ws[i] = math.Exp(mds[k-1+i])
+		}
+		rs[k-1] = 1.
+		ws[k-1] = 1.
+		x.SetFreeRates(rs, ws)
+	}
+	fcn := func(mds []float64) float64 {
+		for _, i := range mds {
+			if i < -10 || i > 10 {
+				return 1000000000000
+			}
+		}
+		setp(mds)
+		lnl := lkfun(t, x, patternvals, wks)
+		return -lnl
+	}
+	p0 := make([]float64, 2*k-2)
+	for i := 0; i < k-1; i++ {
+		p0[i] = math.Log(x.GammaCats[i] / x.GammaCats[k-1])
+		p0[k-1+i] = math.Log(x.RateWeights[i] / x.RateWeights[k-1])
+	}
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, p0, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	setp(res.X)
+	idx := make([]int, k)
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return x.GammaCats[idx[i]] < x.GammaCats[idx[j]] })
+	rs := make([]float64, k)
+	ws := make([]float64, k)
+	for i, j := range idx {
+		rs[i] = x.GammaCats[j]
+		ws[i] = x.RateWeights[j]
+	}
+	x.SetFreeRates(rs, ws)
+	fmt.Fprintln(os.Stderr, "freerate:", res.F, x.GammaCats, x.RateWeights)
+}
+
+// OptimizeFreeRatesAndBL alternates between the FreeRate parameters and the branch
+// lengths until the lnL improves by less than 0.01 (or 10 rounds). Returns the lnL
+func OptimizeFreeRatesAndBL(t *Tree, x *DiscreteModel, patternvals []float64, log bool, wks int) float64 {
+	lnl := PCalcLikePatternsGamma(t, x, patternvals, wks)
+	for i := 0; i < 10; i++ {
+		OptimizeFreeRates(t, x, patternvals, log, wks)
+		OptimizeGammaBLS(t, x, patternvals, wks)
+		nlnl := PCalcLikePatternsGamma(t, x, patternvals, wks)
+		if nlnl-lnl < 0.01 {
+			return math.Max(lnl, nlnl)
+		}
+		lnl = nlnl
+	}
+	return lnl
+}
+
+// CalcSiteRatePosteriors the posterior probability of each rate category (with the
+// invariable class as the last one if PInv > 0) for each pattern, in the order of
+// patternvals. This works for gamma and FreeRate
+func CalcSiteRatePosteriors(t *Tree, x *DiscreteModel, patternvals []float64) (post [][]float64) {
+	numstates := x.NumStates
+	rs := invRateScale(x)
+	nc := x.GammaNCats
+	if x.PInv > 0 {
+		nc++
+	}
+	x.EmptyPDict()
+	x.EmptyPLDict()
+	post = make([][]float64, len(patternvals))
+	for s := range patternvals {
+		lp := make([]float64, nc)
+		for c, g := range x.GammaCats {
+			for _, n := range t.Post {
+				if len(n.Chs) > 0 {
+					CalcLogLikeNodeGamma(n, x, s, g*rs)
+				}
+			}
+			for i := 0; i < numstates; i++ {
+				t.Rt.Data[s][i] += math.Log(x.BF[i])
+			}
+			lp[c] = floats.LogSumExp(t.Rt.Data[s]) + math.Log(x.catWeight(c))
+			if x.PInv > 0 {
+				lp[c] += math.Log(1. - x.PInv)
+			}
+		}
+		if x.PInv > 0 {
+			lp[nc-1] = math.Log(x.PInv * calcInvLikeOneSite(t, x, s))
+		}
+		tot := floats.LogSumExp(lp)
+		post[s] = make([]float64, nc)
+		for c := range lp {
+			post[s][c] = math.Exp(lp[c] - tot)
+		}
+	}
+	return
+}
+
+// WriteSiteRatePosteriors writes a tab separated table with the posterior
+// probabilities of the rate categories (from CalcSiteRatePosteriors) and the
+// posterior mean rate for each site (starting at 1). patternvec is from
+// PreparePatternVecs and patterns from GetSitePatterns. Sites that are all gaps
+// are not included
+func WriteSiteRatePosteriors(w io.Writer, x *DiscreteModel, post [][]float64, patternvec []int,
+	patterns map[string][]int) error {
+	first := map[int]int{}
+	for i, s := range patternvec {
+		first[s] = i
+	}
+	bysite := map[int]int{}
+	var sites []int
+	for _, ss := range patterns {
+		pi, ok := first[ss[0]]
+		if !ok {
+			continue
+		}
+		for _, s := range ss {
+			bysite[s] = pi
+			sites = append(sites, s)
+		}
+	}
+	sort.Ints(sites)
+	rs := invRateScale(x)
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "site")
+	for c := 0; c < x.GammaNCats; c++ {
+		fmt.Fprint(bw, "\tcat"+strconv.Itoa(c+1))
+	}
+	if x.PInv > 0 {
+		fmt.Fprint(bw, "\tinv")
+	}
+	fmt.Fprintln(bw, "\tmean_rate")
+	for _, s := range sites {
+		pp := post[bysite[s]]
+		fmt.Fprint(bw, s+1)
+		mr := 0.
+		for c, v := range pp {
+			fmt.Fprint(bw, "\t"+strconv.FormatFloat(v, 'f', 6, 64))
+			if c < x.GammaNCats {
+				mr += v * x.GammaCats[c] * rs
+			}
+		}
+		fmt.Fprintln(bw, "\t"+strconv.FormatFloat(mr, 'f', 6, 64))
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,60 @@
+package gophy_test
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+	"gonum.org/v1/gonum/floats"
+)
+
+func TestFreeRates(t *testing.T) {
+	tr := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	var seqnames []string
+	seqs := map[string]string{}
+	nsites := 0
+	for _, s := range gophy.ReadSeqsFromFile("test_files/10tips.nuc.fa") {
+		seqs[s.NM] = s.SQ
+		seqnames = append(seqnames, s.NM)
+		nsites = len(s.SQ)
+	}
+	patterns, patternsint, gaps, _, _, _ := gophy.GetSitePatterns(seqs, nsites, seqnames)
+	patternval, patternvec := gophy.PreparePatternVecs(tr, patternsint, seqs)
+	x := gophy.NewF81Model(gophy.GetEmpiricalBaseFreqs(seqs))
+	x.M.GammaNCats = 4
+	x.M.GammaCats = gophy.GetGammaCats(1.0, 4, false)
+	glnl := gophy.PCalcLikePatternsGamma(tr, &x.M, patternval, 2)
+	// starts at gamma with alpha 1
+	x.M.SetupFreeRates(4)
+	lnl := gophy.PCalcLikePatternsGamma(tr, &x.M, patternval, 2)
+	if math.Abs(lnl-glnl) > 1e-6 || x.NumParams() != 9 {
+		t.Error(glnl, lnl, x.NumParams())
+	}
+	x.M.SetFreeRates([]float64{1, 3}, []float64{3, 1})
+	if x.M.GammaCats[0] != 2./3. || x.M.RateWeights[1] != 0.25 {
+		t.Error(x.M.GammaCats, x.M.RateWeights)
+	}
+	x.M.SetupFreeRates(3)
+	lnl = gophy.PCalcLikePatternsGamma(tr, &x.M, patternval, 2)
+	olnl := gophy.OptimizeFreeRatesAndBL(tr, &x.M, patternval, false, 2)
+	if olnl < lnl || math.Abs(floats.Dot(x.M.GammaCats, x.M.RateWeights)-1) > 1e-9 ||
+		x.M.GammaCats[0] > x.M.GammaCats[1] {
+		t.Error(lnl, olnl, x.M.GammaCats, x.M.RateWeights)
+	}
+	post := gophy.CalcSiteRatePosteriors(tr, &x.M, patternval)
+	for _, p := range post {
+		if len(p) != 3 || math.Abs(floats.Sum(p)-1) > 1e-9 {
+			t.Fatal(p)
+		}
+	}
+	var b bytes.Buffer
+	if err := gophy.WriteSiteRatePosteriors(&b, &x.M, post, patternvec, patterns); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != nsites-len(gaps)+1 || strings.HasPrefix(lines[1], "1\t") == false {
+		t.Error(len(lines), nsites, lines[:2])
+	}
+}
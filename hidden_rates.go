@@ -0,0 +1,217 @@
+package gophy
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize"
+)
+
+// the hidden rates parameterizations
+const (
+	Covarion = "covarion" // Tuffley and Steel (1998), an on and off class with switching between them
+	CorHMM   = "corhmm"   // Beaulieu et al. (2013), each rate class has its own rates
+)
+
+// HiddenRatesModel a model where each observed state is split into hidden rate
+// classes. The hidden states are ordered by class so that hidden state
+// c*NumObs+i is observed state i in class c. Changes between observed states
+// are within a class and switches between classes keep the observed state.
+// These are unscaled like SetupQMk
+type HiddenRatesModel struct {
+	M       DiscreteModel
+	Param   string // Covarion or CorHMM
+	NumObs  int    // observed states
+	NumCats int    // hidden rate classes
+	// the all rates different changes between the observed states by row for
+	// each class. Covarion has one for the on class (the off class is 0)
+	ObsRates    [][]float64
+	SwitchRates []float64 // all rates different switches between the classes by row
+}
+
+// GetHiddenMap the character map (like GetMultMap) where each observed state is
+// all of its hidden states
+func GetHiddenMap(numobs int, numcats int) (charMap map[string][]int) {
+	charMap = GetMultMap(numobs * numcats)
+	for i := 0; i < numobs; i++ {
+		charMap[strconv.Itoa(i)] = make([]int, numcats)
+		for c := 0; c < numcats; c++ {
+			charMap[strconv.Itoa(i)][c] = c*numobs + i
+		}
+	}
+	for i := numobs; i < numobs*numcats; i++ {
+		delete(charMap, strconv.Itoa(i))
+	}
+	return
+}
+
+// NewCovarionModel get a Tuffley and Steel covarion model for numobs states with
+// the changes at rt in the on class and the switches at sw
+func NewCovarionModel(numobs int, rt float64, sw float64) *HiddenRatesModel {
+	h := newHiddenRatesModel(Covarion, numobs, 2)
+	h.ObsRates = [][]float64{filledRates(numobs*(numobs-1), rt)}
+	h.SwitchRates = filledRates(2, sw)
+	h.SetupQ()
+	return h
+}
+
+// NewCorHMMModel get a corHMM style model for numobs states and numcats rate
+// classes. Class c starts with the changes at rt*(c+1) (so they can be told
+// apart) and the switches at sw
+func NewCorHMMModel(numobs int, numcats int, rt float64, sw float64) *HiddenRatesModel {
+	h := newHiddenRatesModel(CorHMM, numobs, numcats)
+	h.ObsRates = make([][]float64, numcats)
+	for c := range h.ObsRates {
+		h.ObsRates[c] = filledRates(numobs*(numobs-1), rt*float64(c+1))
+	}
+	h.SwitchRates = filledRates(numcats*(numcats-1), sw)
+	h.SetupQ()
+	return h
+}
+
+func newHiddenRatesModel(param string, numobs int, numcats int) *HiddenRatesModel {
+	h := &HiddenRatesModel{Param: param, NumObs: numobs, NumCats: numcats}
+	h.M.Alph = MultiState
+	h.M.NumStates = numobs * numcats
+	h.M.CharMap = GetHiddenMap(numobs, numcats)
+	return h
+}
+
+func filledRates(n int, rt float64) []float64 {
+	r := make([]float64, n)
+	for i := range r {
+		r[i] = rt
+	}
+	return r
+}
+
+// SetupQ builds the hidden state Q from ObsRates and SwitchRates. The
+// frequencies (at the root) are the stationary ones for Covarion and equal for
+// CorHMM (like corHMM)
+func (h *HiddenRatesModel) SetupQ() {
+	n := h.M.NumStates
+	h.M.EmptyPDict()
+	h.M.EmptyPLDict()
+	h.M.Q = mat.NewDense(n, n, nil)
+	for c := 0; c < h.NumCats; c++ {
+		var obs []float64
+		if h.Param == CorHMM {
+			obs = h.ObsRates[c]
+		} else if c == 0 {
+			obs = h.ObsRates[0]
+		}
+		cc := 0
+		for i := 0; i < h.NumObs; i++ {
+			for j := 0; j < h.NumObs; j++ {
+				if i == j {
+					continue
+				}
+				if obs != nil {
+					h.M.Q.Set(c*h.NumObs+i, c*h.NumObs+j, obs[cc])
+				}
+				cc++
+			}
+		}
+	}
+	cc := 0
+	for c := 0; c < h.NumCats; c++ {
+		for d := 0; d < h.NumCats; d++ {
+			if c == d {
+				continue
+			}
+			for i := 0; i < h.NumObs; i++ {
+				h.M.Q.Set(c*h.NumObs+i, d*h.NumObs+i, h.SwitchRates[cc])
+			}
+			cc++
+		}
+	}
+	for i := 0; i < n; i++ {
+		h.M.Q.Set(i, i, -sumRow(h.M.Q, i))
+	}
+	if h.Param == Covarion {
+		h.M.BF = StationaryFreqs(h.M.Q)
+	} else {
+		h.M.BF = filledRates(n, 1./float64(n))
+	}
+}
+
+// NumParams the number of free rates
+func (h *HiddenRatesModel) NumParams() int {
+	return len(h.Params())
+}
+
+// Params the free rates, ObsRates by class and then SwitchRates
+func (h *HiddenRatesModel) Params() []float64 {
+	var p []float64
+	for _, r := range h.ObsRates {
+		p = append(p, r...)
+	}
+	return append(p, h.SwitchRates...)
+}
+
+// SetParams set the free rates (in the order of Params) and setup Q
+func (h *HiddenRatesModel) SetParams(params []float64) {
+	cc := 0
+	for _, r := range h.ObsRates {
+		copy(r, params[cc:cc+len(r)])
+		cc += len(r)
+	}
+	copy(h.SwitchRates, params[cc:])
+	h.SetupQ()
+}
+
+// CollapseHiddenStates sums the hidden states of each observed state in the
+// ancestral states (from CalcAncStates)
+func CollapseHiddenStates(states map[*Node][][]float64, numobs int) map[*Node][][]float64 {
+	ret := make(map[*Node][][]float64)
+	for n, sites := range states {
+		ret[n] = make([][]float64, len(sites))
+		for s, v := range sites {
+			ret[n][s] = make([]float64, numobs)
+			for j, p := range v {
+				ret[n][s][j%numobs] += p
+			}
+		}
+	}
+	return ret
+}
+
+// CalcAncStates the marginal ancestral states with CalcAncStates collapsed to
+// the observed states
+func (h *HiddenRatesModel) CalcAncStates(tree *Tree, patternval []float64) map[*Node][][]float64 {
+	return CollapseHiddenStates(CalcAncStates(&h.M, tree, patternval), h.NumObs)
+}
+
+// OptimizeHiddenRatesModel optimize the rates of h (the data should be setup
+// with h.M.CharMap and h.M.NumStates). Returns the lnL
+func OptimizeHiddenRatesModel(t *Tree, h *HiddenRatesModel, patternvals []float64, wks int) float64 {
+	fcn := func(mds []float64) float64 {
+		for _, v := range mds {
+			if v < 0 || v > 1000 {
+				return 1000000000000
+			}
+		}
+		h.SetParams(mds)
+		lnl := PCalcLogLikePatterns(t, &h.M, patternvals, wks)
+		if math.IsNaN(lnl) {
+			return 1000000000000
+		}
+		return -lnl
+	}
+	p0 := h.Params()
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, p0, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	h.SetParams(res.X)
+	return -res.F
+}
@@ -0,0 +1,81 @@
+package gophy_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+// purines 0 and pyrimidines 1 from the 10 tip alignment
+func binaryTestData() ([]gophy.MSeq, map[string][]string) {
+	var mseqs []gophy.MSeq
+	seqs := map[string][]string{}
+	for _, s := range gophy.ReadSeqsFromFile("test_files/10tips.nuc.fa") {
+		m := gophy.MSeq{NM: s.NM}
+		for _, c := range s.SQ {
+			if c == 'A' || c == 'G' {
+				m.SQs = append(m.SQs, "0")
+			} else {
+				m.SQs = append(m.SQs, "1")
+			}
+		}
+		mseqs = append(mseqs, m)
+		seqs[m.NM] = m.SQs
+	}
+	return mseqs, seqs
+}
+
+func TestHiddenRates(t *testing.T) {
+	tr := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	mseqs, seqs := binaryTestData()
+	mk := gophy.NewMultStateModel(2)
+	mk.M.SetBaseFreqs([]float64{0.5, 0.5})
+	mk.M.SetupQMk([]float64{0.6, 1.4}, false)
+	_, patternsint, _, _, _, _ := gophy.GetSitePatternsMS(mseqs, mk.M.CharMap, 2)
+	pv, _ := gophy.PreparePatternVecsMS(tr, patternsint, seqs, mk.M.CharMap, 2)
+	mklnl := gophy.PCalcLogLikePatterns(tr, &mk.M, pv, 2)
+
+	// two classes with the same rates are Mk
+	h := gophy.NewCorHMMModel(2, 2, 1.0, 0.3)
+	h.SetParams([]float64{0.6, 1.4, 0.6, 1.4, 0.3, 0.3})
+	if h.NumParams() != 6 || h.M.NumStates != 4 || len(h.M.CharMap["1"]) != 2 || h.M.CharMap["1"][1] != 3 {
+		t.Fatal("corHMM setup", h.NumParams(), h.M.CharMap)
+	}
+	hpv, _ := gophy.PreparePatternVecsMS(tr, patternsint, seqs, h.M.CharMap, h.M.NumStates)
+	if hlnl := gophy.PCalcLogLikePatterns(tr, &h.M, hpv, 2); math.Abs(hlnl-mklnl) > 1e-6 {
+		t.Error("corHMM with equal classes", hlnl, mklnl)
+	}
+	// covarion that never switches off is Mk
+	c := gophy.NewCovarionModel(2, 1.0, 0.5)
+	c.SetParams([]float64{0.6, 1.4, 0.0, 0.5})
+	if c.M.BF[2]+c.M.BF[3] > 1e-9 {
+		t.Error("the off class should be empty", c.M.BF)
+	}
+	c.M.BF = []float64{0.5, 0.5, 0, 0}
+	if clnl := gophy.PCalcLogLikePatterns(tr, &c.M, hpv, 2); math.Abs(clnl-mklnl) > 1e-6 {
+		t.Error("covarion always on", clnl, mklnl)
+	}
+	c.SetParams([]float64{0.6, 1.4, 0.2, 0.5})
+	if math.Abs(c.M.BF[0]+c.M.BF[1]-0.5/0.7) > 1e-9 || c.M.Q.At(2, 3) != 0 || c.M.Q.At(3, 1) != 0.5 {
+		t.Error("covarion Q", c.M.BF, c.M.Q)
+	}
+	start := gophy.PCalcLogLikePatterns(tr, &c.M, hpv, 2)
+	if olnl := gophy.OptimizeHiddenRatesModel(tr, c, hpv, 2); olnl < start {
+		t.Error("optimizing made it worse", start, olnl)
+	}
+
+	// the ancestral states are for the observed states
+	anc := h.CalcAncStates(tr, hpv)
+	for _, sites := range anc {
+		for _, s := range sites {
+			if len(s) != 2 || math.Abs(s[0]+s[1]-1) > 1e-9 {
+				t.Fatal("ancestral states", s)
+			}
+		}
+	}
+	mkanc := gophy.CalcAncStates(&mk.M, tr, pv)
+	if math.Abs(anc[tr.Rt][0][0]-mkanc[tr.Rt][0][0]) > 1e-6 {
+		t.Error("root ancestral states", anc[tr.Rt][0], mkanc[tr.Rt][0])
+	}
+}
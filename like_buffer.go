@@ -0,0 +1,363 @@
+package gophy
+
+import (
+	"math"
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// likeScaleMin the conditionals of a pattern at a node are rescaled (so the
+// largest is 1) when they are all below this
+const likeScaleMin = 1e-100
+
+// LikeBuffer the conditional likelihoods of all the nodes of a tree in one
+// slice indexed [node][pattern][category][state] instead of the Data of each
+// node. Each node and pattern has a log scaler (that includes the ones of the
+// descendants) so the values don't underflow. The tips are filled from their
+// Data
+type LikeBuffer struct {
+	NumNodes    int
+	NumPatterns int
+	NumCats     int
+	NumStates   int
+	CLV         []float64 // [node][pattern][category][state]
+	Scale       []float64 // [node][pattern] log scalers
+	index       map[*Node]int
+	tips        map[*Node]*float64 // the Data the tips were filled from
+	chs         [][]int            // [node] the children it was calculated with
+	lens        [][]float64        // [node] and their branch lengths
+}
+
+// NewLikeBuffer get a LikeBuffer for the nodes of t (in postorder) with the
+// Data of the tips
+func NewLikeBuffer(t *Tree, npatterns int, ncats int, nstates int) *LikeBuffer {
+	return newLikeBuffer(postorder(t.Rt), npatterns, ncats, nstates)
+}
+
+func newLikeBuffer(post []*Node, npatterns int, ncats int, nstates int) *LikeBuffer {
+	b := &LikeBuffer{NumNodes: len(post), NumPatterns: npatterns, NumCats: ncats, NumStates: nstates}
+	b.CLV = make([]float64, len(post)*npatterns*ncats*nstates)
+	b.Scale = make([]float64, len(post)*npatterns)
+	b.index = make(map[*Node]int, len(post))
+	b.tips = make(map[*Node]*float64)
+	b.chs = make([][]int, len(post))
+	b.lens = make([][]float64, len(post))
+	for i, n := range post {
+		b.index[n] = i
+		if len(n.Chs) == 0 {
+			b.setTip(n, i)
+		}
+	}
+	return b
+}
+
+func (b *LikeBuffer) setTip(n *Node, i int) {
+	for p := 0; p < b.NumPatterns; p++ {
+		for c := 0; c < b.NumCats; c++ {
+			copy(b.CLV[b.Offset(i, p, c):b.Offset(i, p, c)+b.NumStates], n.Data[p])
+		}
+	}
+	b.tips[n] = &n.Data[0][0]
+}
+
+// Index the index of n in the buffer (-1 if it isn't there)
+func (b *LikeBuffer) Index(n *Node) int {
+	if i, ok := b.index[n]; ok {
+		return i
+	}
+	return -1
+}
+
+// Offset the start of the states of node i, pattern p and category c in CLV
+func (b *LikeBuffer) Offset(i int, p int, c int) int {
+	return ((i*b.NumPatterns+p)*b.NumCats + c) * b.NumStates
+}
+
+// fits the buffer has the nodes and the same tip Data
+func (b *LikeBuffer) fits(post []*Node, npatterns int, ncats int, nstates int) bool {
+	if b.NumPatterns != npatterns || b.NumCats != ncats || b.NumStates != nstates {
+		return false
+	}
+	for _, n := range post {
+		if _, ok := b.index[n]; !ok {
+			return false
+		}
+		if len(n.Chs) == 0 {
+			if len(n.Data) != npatterns || b.tips[n] != &n.Data[0][0] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// GetLikeBuffer the LikeBuffer of t (LB) for npatterns, ncats and nstates. A new
+// one is made (and fresh is true) if there wasn't one or it doesn't fit the tree
+// or the tip Data anymore
+func (t *Tree) GetLikeBuffer(npatterns int, ncats int, nstates int) (b *LikeBuffer, fresh bool) {
+	return t.likeBuffer(postorder(t.Rt), npatterns, ncats, nstates)
+}
+
+func (t *Tree) likeBuffer(post []*Node, npatterns int, ncats int, nstates int) (*LikeBuffer, bool) {
+	if t.LB != nil && t.LB.fits(post, npatterns, ncats, nstates) {
+		return t.LB, false
+	}
+	t.LB = newLikeBuffer(post, npatterns, ncats, nstates)
+	return t.LB, true
+}
+
+// postorder the nodes from rt in the same order as Instantiate. This is used
+// instead of t.Post as that isn't updated when the tree is edited (e.g., with
+// SwapBranch)
+func postorder(rt *Node) []*Node {
+	var post []*Node
+	stk := NewNodeStack()
+	stk.Push(rt)
+	for stk.Empty() == false {
+		cur, _ := stk.Pop()
+		post = append(post, cur)
+		for _, n := range cur.Chs {
+			stk.Push(n)
+		}
+	}
+	for i, j := 0, len(post)-1; i < j; i, j = i+1, j-1 {
+		post[i], post[j] = post[j], post[i]
+	}
+	return post
+}
+
+// changed the children of n (at i) or their branch lengths aren't the ones it
+// was last calculated with or a child was calculated again (dirty)
+func (b *LikeBuffer) changed(n *Node, i int, dirty []bool) bool {
+	if len(b.chs[i]) != len(n.Chs) {
+		return true
+	}
+	for k, c := range n.Chs {
+		ci := b.index[c]
+		if b.chs[i][k] != ci || b.lens[i][k] != c.Len || dirty[ci] {
+			return true
+		}
+	}
+	return false
+}
+
+// likeNode an internal node to calculate with the P matrices (row major) of
+// each child (and category)
+type likeNode struct {
+	i   int
+	chs []int
+	ps  [][]float64 // [child*ncats+category]
+}
+
+// calcNode the conditionals of nd for the patterns p0 to p1 from the children
+func (b *LikeBuffer) calcNode(nd *likeNode, p0 int, p1 int) {
+	ns, nc, np := b.NumStates, b.NumCats, b.NumPatterns
+	for p := p0; p < p1; p++ {
+		st := b.Offset(nd.i, p, 0)
+		dst := b.CLV[st : st+nc*ns]
+		for k := range dst {
+			dst[k] = 1.
+		}
+		scale := 0.0
+		for k, ch := range nd.chs {
+			scale += b.Scale[ch*np+p]
+			cst := b.Offset(ch, p, 0)
+			src := b.CLV[cst : cst+nc*ns]
+			for c := 0; c < nc; c++ {
+				P := nd.ps[k*nc+c]
+				cs := src[c*ns : (c+1)*ns]
+				for i := 0; i < ns; i++ {
+					row := P[i*ns : (i+1)*ns]
+					x := 0.0
+					for j, v := range cs {
+						x += row[j] * v
+					}
+					dst[c*ns+i] *= x
+				}
+			}
+		}
+		mx := 0.0
+		for _, v := range dst {
+			if v > mx {
+				mx = v
+			}
+		}
+		if mx > 0 && mx < likeScaleMin {
+			for k := range dst {
+				dst[k] /= mx
+			}
+			scale += math.Log(mx)
+		}
+		b.Scale[nd.i*np+p] = scale
+	}
+}
+
+// rawP the row major values of P
+func rawP(P *mat.Dense) []float64 {
+	r := P.RawMatrix()
+	if r.Stride == r.Cols {
+		return r.Data[:r.Rows*r.Cols]
+	}
+	return mat.DenseCopyOf(P).RawMatrix().Data
+}
+
+// calcLogLikePatternsFlat the log likelihood of each pattern with the LikeBuffer
+// of t, with the rate categories (and +I) of x if gamma. If marked only the dirty
+// nodes (Marked ones, ones with other children or branch lengths than the last
+// time, e.g., after SwapBranch) and the ones above them are calculated again
+// (unless the buffer is new), and the marks are cleared. The P matrices are all
+// gotten before the workers start, which each take a block of patterns through
+// the whole tree
+func calcLogLikePatternsFlat(t *Tree, x *DiscreteModel, npatterns int, gamma bool, marked bool, wks int) []float64 {
+	rates, weights := []float64{1.0}, []float64{1.0}
+	if gamma && x.GammaNCats > 0 {
+		rates = x.pRates()
+		weights = make([]float64, len(rates))
+		for i := range weights {
+			weights[i] = x.catWeight(i)
+		}
+	}
+	post := postorder(t.Rt)
+	b, fresh := t.likeBuffer(post, npatterns, len(rates), x.NumStates)
+	if x.Ps == nil {
+		x.EmptyPDict()
+	}
+	var nodes []likeNode
+	dirty := make([]bool, b.NumNodes)
+	for _, n := range post {
+		if len(n.Chs) == 0 {
+			continue
+		}
+		for _, c := range n.Chs {
+			if math.IsNaN(c.Len) {
+				c.Len = 0.0
+			}
+		}
+		i := b.index[n]
+		if marked {
+			if !fresh && !n.Marked && !b.changed(n, i, dirty) {
+				continue
+			}
+			n.Marked = false
+		}
+		dirty[i] = true
+		nd := likeNode{i: i}
+		b.chs[i] = b.chs[i][:0]
+		b.lens[i] = b.lens[i][:0]
+		for _, c := range n.Chs {
+			nd.chs = append(nd.chs, b.index[c])
+			b.chs[i] = append(b.chs[i], b.index[c])
+			b.lens[i] = append(b.lens[i], c.Len)
+			for _, r := range rates {
+				nd.ps = append(nd.ps, rawP(x.GetPMapRate(c.Len, r)))
+			}
+		}
+		nodes = append(nodes, nd)
+	}
+	ns, nc := b.NumStates, b.NumCats
+	rt := b.index[t.Rt]
+	sl := make([]float64, npatterns)
+	if wks < 1 {
+		wks = 1
+	}
+	bsize := npatterns/(wks*4) + 1
+	jobs := make(chan int, npatterns/bsize+1)
+	var wg sync.WaitGroup
+	for w := 0; w < wks; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p0 := range jobs {
+				p1 := p0 + bsize
+				if p1 > npatterns {
+					p1 = npatterns
+				}
+				for k := range nodes {
+					b.calcNode(&nodes[k], p0, p1)
+				}
+				for p := p0; p < p1; p++ {
+					st := b.Offset(rt, p, 0)
+					l := 0.0
+					for c := 0; c < nc; c++ {
+						cl := 0.0
+						for i, v := range b.CLV[st+c*ns : st+(c+1)*ns] {
+							cl += v * x.BF[i]
+						}
+						l += cl * weights[c]
+					}
+					sl[p] = math.Log(l) + b.Scale[rt*npatterns+p]
+					if gamma && x.GammaNCats > 0 {
+						sl[p] = mixLogInv(t, x, p, sl[p])
+					}
+				}
+			}
+		}()
+	}
+	for p0 := 0; p0 < npatterns; p0 += bsize {
+		jobs <- p0
+	}
+	close(jobs)
+	wg.Wait()
+	return sl
+}
+
+// condBuffer the TpConds, RvTpConds, RvConds, and RtConds of every node for
+// CalcLikeFrontBack (and the Log and Mult versions) in one slice that is kept
+// with the tree so they aren't allocated again on each call
+type condBuffer struct {
+	npatterns int
+	nstates   int
+	data      []float64
+	rows      map[*Node]*[4][][]float64 // the four [pattern][state] of each node
+}
+
+func newCondBuffer(nodes []*Node, npatterns int, nstates int) *condBuffer {
+	b := &condBuffer{npatterns: npatterns, nstates: nstates}
+	b.data = make([]float64, len(nodes)*4*npatterns*nstates)
+	b.rows = make(map[*Node]*[4][][]float64, len(nodes))
+	off := 0
+	for _, n := range nodes {
+		r := &[4][][]float64{}
+		for k := range r {
+			r[k] = make([][]float64, npatterns)
+			for p := range r[k] {
+				r[k][p] = b.data[off : off+nstates : off+nstates]
+				off += nstates
+			}
+		}
+		b.rows[n] = r
+	}
+	return b
+}
+
+// fits the buffer has the nodes and the sizes
+func (b *condBuffer) fits(nodes []*Node, npatterns int, nstates int) bool {
+	if b.npatterns != npatterns || b.nstates != nstates || len(b.rows) != len(nodes) {
+		return false
+	}
+	for _, n := range nodes {
+		if _, ok := b.rows[n]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// setupConds point the TpConds (of the internal nodes), RvTpConds, RvConds, and
+// RtConds of the nodes of t at the condBuffer of t with every value set to v
+func (t *Tree) setupConds(npatterns int, nstates int, v float64) {
+	if t.cb == nil || !t.cb.fits(t.Post, npatterns, nstates) {
+		t.cb = newCondBuffer(t.Post, npatterns, nstates)
+	}
+	for i := range t.cb.data {
+		t.cb.data[i] = v
+	}
+	for _, n := range t.Post {
+		r := t.cb.rows[n]
+		if len(n.Chs) != 0 {
+			n.TpConds = r[0]
+		}
+		n.RvTpConds, n.RvConds, n.RtConds = r[1], r[2], r[3]
+	}
+}
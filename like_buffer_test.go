@@ -0,0 +1,228 @@
+package gophy_test
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+// randomNewick a random tree with ntips tips (t0, t1, ...) and branch lengths
+// of blen
+func randomNewick(ntips int, blen float64) string {
+	bl := ":" + strconv.FormatFloat(blen, 'f', -1, 64)
+	sub := make([]string, ntips)
+	for i := range sub {
+		sub[i] = "t" + strconv.Itoa(i) + bl
+	}
+	for len(sub) > 2 {
+		i := rand.Intn(len(sub) - 1)
+		sub[i] = "(" + sub[i] + "," + sub[i+1] + ")" + bl
+		sub = append(sub[:i+1], sub[i+2:]...)
+	}
+	return "(" + strings.Join(sub, ",") + ");"
+}
+
+// randomPatterns a tree and random (so all different) nucleotide patterns
+func randomPatterns(ntips int, npatterns int, blen float64) (*gophy.Tree, []float64) {
+	tr := gophy.NewTree()
+	tr.Instantiate(gophy.ReadNewickString(randomNewick(ntips, blen)))
+	seqs := map[string]string{}
+	for _, n := range tr.Tips {
+		b := make([]byte, npatterns)
+		for i := range b {
+			b[i] = "ACGT"[rand.Intn(4)]
+		}
+		seqs[n.Nam] = string(b)
+	}
+	patternsint := map[int]float64{}
+	for i := 0; i < npatterns; i++ {
+		patternsint[i] = 1
+	}
+	patternval, _ := gophy.PreparePatternVecs(tr, patternsint, seqs)
+	return tr, patternval
+}
+
+func TestLikeBuffer(t *testing.T) {
+	tr, x, pv := benchSetup()
+	// the per site (node Data) likelihoods
+	x.EmptyPDict()
+	x.EmptyPLDict()
+	old := 0.0
+	for s := range pv {
+		old += math.Log(gophy.CalcLikeOneSite(tr, x, s)) * pv[s]
+	}
+	if l := gophy.PCalcLikePatterns(tr, x, pv, 3); math.Abs(l-old) > 1e-8 {
+		t.Error("PCalcLikePatterns", l, old)
+	}
+	if l := gophy.PCalcLogLikePatterns(tr, x, pv, 3); math.Abs(l-old) > 1e-8 {
+		t.Error("PCalcLogLikePatterns", l, old)
+	}
+	x.GammaNCats = 4
+	x.GammaCats = gophy.GetGammaCats(0.5, 4, false)
+	x.PInv = 0.1
+	old = 0.0
+	for s := range pv {
+		old += gophy.CalcLogLikeOneSiteGamma(tr, x, s) * pv[s]
+	}
+	if l := gophy.PCalcLikePatternsGamma(tr, x, pv, 3); math.Abs(l-old) > 1e-8 {
+		t.Error("PCalcLikePatternsGamma", l, old)
+	}
+	b, fresh := tr.GetLikeBuffer(len(pv), 4, 4)
+	if fresh || len(b.CLV) != len(tr.Post)*len(pv)*4*4 || b.Index(tr.Rt) != len(tr.Post)-1 {
+		t.Error("the buffer of the tree wasn't kept", fresh, len(b.CLV))
+	}
+
+	// just the marked nodes are calculated again
+	nd := tr.Tips[3]
+	nd.Len *= 2
+	nd.Par.Marked = true
+	ml := gophy.PCalcLikePatternsMarkedGamma(tr, x, pv, 3)
+	for _, n := range tr.Post {
+		n.Marked = false
+	}
+	if l := gophy.PCalcLikePatternsGamma(tr, x, pv, 3); math.Abs(l-ml) > 1e-8 {
+		t.Error("marked", ml, l)
+	}
+
+	// a deep tree underflows without scaling
+	rand.Seed(1)
+	dtr, dpv := randomPatterns(2000, 20, 0.5)
+	dx, _ := gophy.NewNucModel(gophy.JC69, nil, []float64{0.25, 0.25, 0.25, 0.25})
+	l := gophy.PCalcLikePatterns(dtr, &dx.M, dpv, 2)
+	dx.M.EmptyPLDict()
+	lg := 0.0
+	for s := range dpv {
+		lg += gophy.CalcLogLikeOneSite(dtr, &dx.M, s)
+	}
+	if math.IsInf(l, 0) || math.IsNaN(l) || math.Abs(l-lg) > 1e-6*math.Abs(lg) {
+		t.Error("scaling", l, lg)
+	}
+}
+
+func TestIncrementalLike(t *testing.T) {
+	tr, x, pv := benchSetup()
+	x.GammaNCats = 4
+	x.GammaCats = gophy.GetGammaCats(0.5, 4, false)
+	start := gophy.PCalcLikePatternsGamma(tr, x, pv, 2)
+	check := func(msg string) {
+		ml := gophy.PCalcLikePatternsMarkedGamma(tr, x, pv, 2)
+		for _, n := range tr.Post {
+			if n.Marked {
+				t.Error(msg, "the marks weren't cleared")
+				break
+			}
+		}
+		if l := gophy.PCalcLikePatternsGamma(tr, x, pv, 2); math.Abs(l-ml) > 1e-8 {
+			t.Error(msg, ml, l)
+		}
+	}
+	// an NNI
+	var nd *gophy.Node
+	for _, n := range tr.Post {
+		if len(n.Chs) == 2 && n.Par != tr.Rt {
+			nd = n
+			break
+		}
+	}
+	if !gophy.SwapBranch(nd.Chs[0], nd.GetSib()) {
+		t.Fatal("SwapBranch")
+	}
+	check("SwapBranch")
+	// a branch length changed without marking
+	tr.Tips[0].Len *= 3
+	check("branch length")
+	// an SPR
+	mid := gophy.PruneSubtree(tr.Tips[1])
+	if mid == nil {
+		t.Fatal("PruneSubtree")
+	}
+	target := tr.Tips[5]
+	if target == tr.Tips[1] || target.Par == nil {
+		target = tr.Tips[6]
+	}
+	if !gophy.RegraftSubtree(mid, target) {
+		t.Fatal("RegraftSubtree")
+	}
+	if gophy.RegraftSubtree(mid, tr.Tips[1]) {
+		t.Error("regrafted in the pruned subtree")
+	}
+	check("SPR")
+	if len(tr.Rt.PostorderArray()) != len(tr.Post) {
+		t.Error("the SPR changed the number of nodes")
+	}
+	// the root doesn't change the lnL
+	l := gophy.PCalcLikePatternsGamma(tr, x, pv, 2)
+	gophy.Reroot(tr.Tips[4], tr)
+	check("Reroot")
+	if r := gophy.PCalcLikePatternsGamma(tr, x, pv, 2); math.Abs(r-l) > 1e-6 {
+		t.Error("rerooted", r, l)
+	}
+	if math.Abs(l-start) < 1e-6 {
+		t.Error("the moves didn't change the lnL")
+	}
+}
+
+func benchLargeSetup(b *testing.B, ncats int) (*gophy.Tree, *gophy.DiscreteModel, []float64) {
+	rand.Seed(1)
+	tr, pv := randomPatterns(100, 10000, 0.05)
+	x, _ := gophy.NewNucModel(gophy.GTR, []float64{1.2, 3.0, 0.8, 1.1, 2.9}, []float64{0.3, 0.2, 0.2, 0.3})
+	if ncats > 0 {
+		x.M.GammaNCats = ncats
+		x.M.GammaCats = gophy.GetGammaCats(0.5, ncats, false)
+	}
+	b.ResetTimer()
+	return tr, &x.M, pv
+}
+
+func BenchmarkPCalcLikePatterns100x10k(b *testing.B) {
+	tr, x, pv := benchLargeSetup(b, 0)
+	for i := 0; i < b.N; i++ {
+		gophy.PCalcLikePatterns(tr, x, pv, 4)
+	}
+}
+
+func BenchmarkPCalcLogLikePatternsGamma100x10k(b *testing.B) {
+	tr, x, pv := benchLargeSetup(b, 4)
+	for i := 0; i < b.N; i++ {
+		gophy.PCalcLogLikePatternsGamma(tr, x, pv, 4)
+	}
+}
+
+// the per node Data layout (for comparison)
+func BenchmarkPCalcLike100x10k(b *testing.B) {
+	tr, x, pv := benchLargeSetup(b, 0)
+	for i := 0; i < b.N; i++ {
+		gophy.PCalcLike(tr, x, len(pv), 4)
+	}
+}
+
+func TestFrontBackConds(t *testing.T) {
+	tr, x, pv := benchSetup()
+	x.EmptyPDict()
+	x.DecomposeQ()
+	gophy.CalcLikeFrontBack(x, tr, pv)
+	nd := tr.Pre[3]
+	p := &nd.RvConds[0][0]
+	rt := append([]float64{}, nd.RtConds[len(pv)-1]...)
+	// the log ones are the logs of the same values
+	gophy.CalcLikeFrontBackLog(x, tr, pv)
+	for i, v := range nd.RtConds[len(pv)-1] {
+		if math.Abs(math.Exp(v)-rt[i]) > 1e-8*rt[i] {
+			t.Error("log", i, math.Exp(v), rt[i])
+		}
+	}
+	// the conditionals are kept with the tree and start again from 1
+	gophy.CalcLikeFrontBack(x, tr, pv)
+	if &nd.RvConds[0][0] != p {
+		t.Error("the conditionals weren't kept")
+	}
+	for i, v := range nd.RtConds[len(pv)-1] {
+		if v != rt[i] {
+			t.Error("again", i, v, rt[i])
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package gophy
+
+import (
+	"gonum.org/v1/gonum/optimize"
+)
+
+// searchEps the lnL has to be better by this for a move to be kept
+const searchEps = 1e-4
+
+// searchLike the likelihood (with gamma if x has categories). If marked just the
+// dirty nodes are calculated again (see PCalcLikePatternsMarked)
+func searchLike(t *Tree, x *DiscreteModel, patternval []float64, marked bool, wks int) float64 {
+	if x.GammaNCats > 0 {
+		if marked {
+			return PCalcLikePatternsMarkedGamma(t, x, patternval, wks)
+		}
+		return PCalcLikePatternsGamma(t, x, patternval, wks)
+	}
+	if marked {
+		return PCalcLikePatternsMarked(t, x, patternval, wks)
+	}
+	return PCalcLikePatterns(t, x, patternval, wks)
+}
+
+// optimizeLen fits the length of the branch subtending n (with just the dirty
+// nodes calculated again) and returns the lnL
+func optimizeLen(n *Node, t *Tree, x *DiscreteModel, patternval []float64, wks int) float64 {
+	fcn := func(p []float64) float64 {
+		if p[0] < 0 || p[0] > 10 {
+			return 1000000000000
+		}
+		n.Len = p[0]
+		return -searchLike(t, x, patternval, true, wks)
+	}
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, []float64{n.Len}, &settings, &optimize.NelderMead{})
+	if err != nil {
+		return -fcn([]float64{n.Len})
+	}
+	return -fcn(res.X)
+}
+
+// NNIRound tries the two NNIs of each internal branch of t (fitting the length
+// of that branch) and keeps the ones that make the lnL better. The lnL and
+// the number of moves kept are returned. t.Post and t.Pre aren't updated
+func NNIRound(t *Tree, x *DiscreteModel, patternval []float64, wks int) (lnl float64, moves int) {
+	lnl = searchLike(t, x, patternval, false, wks)
+	for _, n := range postorder(t.Rt) {
+		if n == t.Rt || len(n.Chs) < 2 {
+			continue
+		}
+		var sibs []*Node
+		for _, c := range n.Par.Chs {
+			if c != n {
+				sibs = append(sibs, c)
+			}
+		}
+		if len(sibs) == 0 {
+			continue
+		}
+		swaps := [][]*Node{{n.Chs[0], sibs[0]}, {n.Chs[1], sibs[0]}}
+		if len(sibs) > 1 {
+			swaps[1] = []*Node{n.Chs[0], sibs[1]}
+		}
+		nlen := n.Len
+		for _, s := range swaps {
+			SwapBranch(s[0], s[1])
+			if l := optimizeLen(n, t, x, patternval, wks); l > lnl+searchEps {
+				lnl = l
+				moves++
+				break
+			}
+			SwapBranch(s[0], s[1])
+			n.Len = nlen
+		}
+	}
+	return
+}
+
+// sprTargets the branches (by the node they subtend) within radius branches of
+// n, not including n
+func sprTargets(n *Node, radius int) (targets []*Node) {
+	dist := map[*Node]int{n: 0}
+	queue := []*Node{n}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if dist[c] == radius {
+			continue
+		}
+		next := append([]*Node{}, c.Chs...)
+		next = append(next, c.Par.Chs...)
+		if c.Par.Par != nil {
+			next = append(next, c.Par)
+		}
+		for _, e := range next {
+			if _, ok := dist[e]; !ok {
+				dist[e] = dist[c] + 1
+				targets = append(targets, e)
+				queue = append(queue, e)
+			}
+		}
+	}
+	return
+}
+
+// SPRRound prunes each subtree of t (that has a bifurcating parent other than
+// the root) and regrafts it on the branch within radius branches of where it was
+// with the best lnL (the regrafted branch is cut in half and the length of the
+// branch of the subtree is fitted), if that is better. The lnL and the number of moves kept are
+// returned. t.Post and t.Pre aren't updated
+func SPRRound(t *Tree, x *DiscreteModel, patternval []float64, radius int, wks int) (lnl float64, moves int) {
+	lnl = searchLike(t, x, patternval, false, wks)
+	for _, n := range postorder(t.Rt) {
+		mid := n.Par
+		if mid == nil || mid == t.Rt || len(mid.Chs) != 2 {
+			continue
+		}
+		sib := mid.Chs[0]
+		if sib == n {
+			sib = mid.Chs[1]
+		}
+		midlen, siblen := mid.Len, sib.Len
+		PruneSubtree(n)
+		nlen := n.Len
+		var best *Node
+		bestl, bestlen := lnl, nlen
+		for _, e := range sprTargets(sib, radius) {
+			elen := e.Len
+			RegraftSubtree(mid, e)
+			if l := optimizeLen(n, t, x, patternval, wks); l > bestl+searchEps {
+				best = e
+				bestl, bestlen = l, n.Len
+			}
+			PruneSubtree(n)
+			e.Len = elen
+		}
+		if best == nil {
+			RegraftSubtree(mid, sib)
+			mid.Len, sib.Len, n.Len = midlen, siblen, nlen
+			continue
+		}
+		RegraftSubtree(mid, best)
+		n.Len = bestlen
+		lnl = bestl
+		moves++
+	}
+	return
+}
+
+// MLTreeSearch hill climbs from t with rounds of NNIs and SPRs (see NNIRound and
+// SPRRound) each followed by optimizing the branch lengths (each on its own and
+// then with OptimizeBLNR or OptimizeGammaBLS with gamma) and the model with
+// optmodel (which can be nil) until the lnL stops getting better. t is changed
+// and the lnL is returned
+func MLTreeSearch(t *Tree, x *DiscreteModel, patternval []float64, radius int,
+	optmodel func(*Tree), wks int) (lnl float64) {
+	optimize := func() float64 {
+		t.Instantiate(t.Rt)
+		// each on its own first as NR doesn't get far from where it starts
+		searchLike(t, x, patternval, false, wks)
+		for _, n := range t.Post {
+			if n != t.Rt {
+				optimizeLen(n, t, x, patternval, wks)
+			}
+		}
+		if x.GammaNCats > 0 {
+			OptimizeGammaBLS(t, x, patternval, wks)
+		} else {
+			OptimizeBLNR(t, x, patternval, wks)
+		}
+		if optmodel != nil {
+			optmodel(t)
+		}
+		return searchLike(t, x, patternval, false, wks)
+	}
+	lnl = optimize()
+	for {
+		NNIRound(t, x, patternval, wks)
+		SPRRound(t, x, patternval, radius, wks)
+		nlnl := optimize()
+		improved := nlnl-lnl >= 0.01
+		lnl = nlnl
+		if !improved {
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,44 @@
+package gophy_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func TestMLTreeSearch(t *testing.T) {
+	rand.Seed(3)
+	var names []string
+	for _, s := range gophy.ReadSeqsFromFile("test_files/10tips.nuc.fa") {
+		names = append(names, s.NM)
+	}
+	seqs, patternsint, _, bf := gophy.ReadPatternsSeqsFromFile("test_files/10tips.nuc.fa", true)
+	x, _ := gophy.NewNucModel(gophy.GTR, []float64{1.2, 3.0, 0.8, 1.1, 2.9}, bf)
+
+	ml := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	pv, _ := gophy.PreparePatternVecs(ml, patternsint, seqs)
+	gophy.OptimizeBLNR(ml, &x.M, pv, 2)
+	mll := gophy.PCalcLikePatterns(ml, &x.M, pv, 2)
+
+	tr := gophy.RandomTree(names, 0.1)
+	if len(tr.Tips) != len(names) || len(tr.Rt.Chs) != 3 || len(tr.Post) != 2*len(names)-2 {
+		t.Fatal("random tree", tr.Rt.Newick(false))
+	}
+	pv, _ = gophy.PreparePatternVecs(tr, patternsint, seqs)
+	start := gophy.PCalcLikePatterns(tr, &x.M, pv, 2)
+	lnl := gophy.MLTreeSearch(tr, &x.M, pv, 5, nil, 2)
+	if lnl < start {
+		t.Error("the search made the lnL worse", lnl, start)
+	}
+	if l := gophy.PCalcLikePatterns(tr, &x.M, pv, 2); math.Abs(l-lnl) > 1e-6 {
+		t.Error("the lnL isn't the one of the tree", lnl, l)
+	}
+	if len(tr.Post) != 2*len(names)-2 || len(tr.Tips) != len(names) {
+		t.Error("the tree lost nodes", tr.Rt.Newick(false))
+	}
+	if lnl < mll-0.1 {
+		t.Error("didn't find the ML tree", lnl, mll, tr.Rt.Newick(true))
+	}
+}
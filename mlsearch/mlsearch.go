@@ -0,0 +1,171 @@
+// mlsearch searches for the maximum likelihood tree with NNI and SPR hill
+// climbing from parsimony or random starting trees
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+// newModel the model to search with and the function to optimize its
+// parameters (the exchangeabilities of the nucleotide models and the gamma
+// alpha) on a tree
+func newModel(nuc bool, name string, bf []float64, ncats int,
+	patternval *[]float64, wks int) (*gophy.DiscreteModel, func(*gophy.Tree), error) {
+	var x *gophy.DiscreteModel
+	var d *gophy.DNAModel
+	if nuc {
+		var err error
+		if d, err = gophy.NewNucModel(name, nil, bf); err != nil {
+			return nil, nil, err
+		}
+		x = &d.M
+	} else {
+		y := gophy.NewProteinModel()
+		if err := y.SetRateMatrixByName(name); err != nil {
+			return nil, nil, err
+		}
+		y.M.SetModelBF()
+		y.M.SetupQGTR()
+		x = &y.M
+	}
+	if ncats > 0 {
+		x.GammaNCats = ncats
+		x.GammaAlpha = 1.0
+		x.GammaCats = gophy.GetGammaCats(x.GammaAlpha, x.GammaNCats, false)
+	}
+	optmodel := func(t *gophy.Tree) {
+		if d != nil {
+			gophy.OptimizeNucModel(t, d, *patternval, wks)
+		}
+		if ncats > 0 {
+			gophy.OptimizeGamma(t, x, *patternval, false, wks)
+		}
+	}
+	return x, optmodel, nil
+}
+
+func main() {
+	afn := flag.String("s", "", "seq filename")
+	st := flag.String("st", "", "sequence type [nuc/aa] (detected if not given)")
+	mdl := flag.String("m", "", "model (a nucleotide model like GTR or HKY85, or an amino acid model name or PAML file, default GTR/LG)")
+	ncats := flag.Int("g", 0, "number of gamma categories (0 for none)")
+	tfn := flag.String("t", "", "starting tree filename (used for the first start)")
+	start := flag.String("start", "pars", "starting trees [pars/rand] (parsimony from a random addition sequence and TBR or random trees)")
+	nstarts := flag.Int("n", 1, "number of starts")
+	radius := flag.Int("rad", 5, "SPR radius (in branches)")
+	seed := flag.Int64("seed", 0, "random seed (0 for the time)")
+	wks := flag.Int("w", 4, "number of threads")
+	flag.Parse()
+	if len(*afn) == 0 {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *start != "pars" && *start != "rand" {
+		fmt.Fprintln(os.Stderr, "starting tree type is not recognised, please use [pars/rand]")
+		os.Exit(1)
+	}
+	if *nstarts < 1 || *radius < 1 {
+		fmt.Fprintln(os.Stderr, "there needs to be at least 1 start and an SPR radius of at least 1")
+		os.Exit(1)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rand.Seed(*seed)
+	rseqs := gophy.ReadSeqsFromFile(*afn)
+	if len(rseqs) < 4 {
+		fmt.Fprintln(os.Stderr, "there need to be at least 4 sequences")
+		os.Exit(1)
+	}
+	if len(*st) == 0 {
+		if gophy.DetectSeqType(rseqs) == gophy.DNAData {
+			*st = "nuc"
+		} else {
+			*st = "aa"
+		}
+		fmt.Fprintln(os.Stderr, "sequence type:", *st)
+	}
+	if *st != "nuc" && *st != "aa" {
+		fmt.Fprintln(os.Stderr, "sequence type string is not a recognised datatype, please use [nuc/aa]")
+		os.Exit(1)
+	}
+	nuc := *st == "nuc"
+	if len(*mdl) == 0 {
+		*mdl = gophy.GTR
+		if nuc == false {
+			*mdl = "LG"
+		}
+	}
+	seqs, patternsint, nsites, bf := gophy.ReadPatternsSeqsFromFile(*afn, nuc)
+	numstates := 4
+	if nuc == false {
+		numstates = 20
+	}
+	prep := func(t *gophy.Tree) []float64 {
+		var patternval []float64
+		if nuc {
+			patternval, _ = gophy.PreparePatternVecs(t, patternsint, seqs)
+		} else {
+			patternval, _ = gophy.PreparePatternVecsProt(t, patternsint, seqs)
+		}
+		return patternval
+	}
+	var names []string
+	for _, s := range rseqs {
+		names = append(names, s.NM)
+	}
+
+	bestl := math.Inf(-1)
+	var best string
+	for i := 0; i < *nstarts; i++ {
+		var t *gophy.Tree
+		if i > 0 {
+			rand.Shuffle(len(names), func(a, b int) { names[a], names[b] = names[b], names[a] })
+		}
+		if i == 0 && len(*tfn) > 0 {
+			t = gophy.ReadTreeFromFile(*tfn)
+			if t == nil {
+				os.Exit(1)
+			}
+			for _, n := range t.Tips {
+				if _, ok := seqs[n.Nam]; !ok {
+					fmt.Fprintln(os.Stderr, n.Nam, "is in the tree but not the alignment")
+					os.Exit(1)
+				}
+			}
+			if len(t.Rt.Chs) == 2 {
+				gophy.TritomyRoot(t)
+				t.Instantiate(t.Rt)
+			}
+		} else if *start == "pars" {
+			var err error
+			if t, err = gophy.ParsStartTree(names, numstates, nsites, prep, 1, *wks); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		} else {
+			t = gophy.RandomTree(names, 0.1)
+		}
+		patternval := prep(t)
+		x, optmodel, err := newModel(nuc, *mdl, bf, *ncats, &patternval, *wks)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		lnl := gophy.MLTreeSearch(t, x, patternval, *radius, optmodel, *wks)
+		fmt.Fprintln(os.Stderr, "start", i+1, "lnL:", lnl)
+		if lnl > bestl {
+			bestl = lnl
+			best = t.Rt.Newick(true) + ";"
+		}
+	}
+	fmt.Println(best)
+	fmt.Println("lnL:", bestl)
+}
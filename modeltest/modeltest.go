@@ -0,0 +1,324 @@
+// modeltest fits a set of nucleotide or amino acid models (with and without +I
+// and +G) on a tree and reports the lnL, AIC, AICc and BIC of each
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/FePhyFoFum/gophy"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize"
+)
+
+// candidate is one model to fit
+type candidate struct {
+	name  string
+	x     *gophy.DiscreteModel
+	nuc   *gophy.DNAModel // nil for amino acids
+	k     int             // free parameters of the model (not branch lengths)
+	gamma bool
+	inv   bool
+}
+
+// modelFit the results for a candidate
+type modelFit struct {
+	name string
+	lnl  float64
+	k    int
+	ic   map[string]float64
+	w    map[string]float64
+}
+
+var criteria = []string{"AIC", "AICc", "BIC"}
+
+func calcLike(t *gophy.Tree, x *gophy.DiscreteModel, patternval []float64, wks int) float64 {
+	if x.GammaNCats != 0 {
+		return gophy.PCalcLikePatternsGamma(t, x, patternval, wks)
+	}
+	return gophy.PCalcLikePatterns(t, x, patternval, wks)
+}
+
+// optimizeAlpha fits the gamma alpha (see OptimizeGammaInv for +I)
+func optimizeAlpha(t *gophy.Tree, x *gophy.DiscreteModel, patternval []float64, wks int) {
+	fcn := func(p []float64) float64 {
+		if p[0] < 0.001 || p[0] > 100 {
+			return 1000000000000
+		}
+		x.GammaAlpha = p[0]
+		x.GammaCats = gophy.GetGammaCats(x.GammaAlpha, x.GammaNCats, false)
+		return -gophy.PCalcLikePatternsGamma(t, x, patternval, wks)
+	}
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, []float64{x.GammaAlpha}, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	fcn(res.X)
+}
+
+// setupRateHet the starting gamma categories and invariable sites of the model
+func (c *candidate) setupRateHet(ncats int) {
+	x := c.x
+	if c.gamma {
+		x.GammaNCats = ncats
+		x.GammaAlpha = 1.0
+		x.GammaCats = gophy.GetGammaCats(x.GammaAlpha, x.GammaNCats, false)
+	} else if c.inv {
+		// +I alone is a single rate category
+		x.GammaNCats = 1
+		x.GammaCats = []float64{1.0}
+	}
+	if c.inv {
+		x.PInv = 0.1
+	}
+}
+
+// fit optimizes the branch lengths and the model parameters in turn until the
+// lnL stops improving. The tree that is passed is modified
+func fit(t *gophy.Tree, c *candidate, patternval []float64, ncats int, wks int) float64 {
+	x := c.x
+	c.setupRateHet(ncats)
+	lnl := calcLike(t, x, patternval, wks)
+	for i := 0; i < 10; i++ {
+		if c.nuc != nil {
+			gophy.OptimizeNucModel(t, c.nuc, patternval, wks)
+		}
+		if c.inv {
+			gophy.OptimizeGammaInv(t, x, patternval, false, wks)
+			gophy.OptimizeGammaBLS(t, x, patternval, wks)
+		} else if c.gamma {
+			optimizeAlpha(t, x, patternval, wks)
+			gophy.OptimizeGammaBLS(t, x, patternval, wks)
+		} else {
+			gophy.OptimizeBLNR(t, x, patternval, wks)
+		}
+		nlnl := calcLike(t, x, patternval, wks)
+		if nlnl-lnl < 0.01 {
+			lnl = math.Max(lnl, nlnl)
+			break
+		}
+		lnl = nlnl
+	}
+	return lnl
+}
+
+func nucCandidates(bf []float64) (cs []*candidate) {
+	for _, nm := range []string{gophy.JC69, gophy.K80, gophy.F81, gophy.HKY85, gophy.TN93,
+		gophy.TIM, gophy.TVM, gophy.GTR} {
+		d, _ := gophy.NewNucModel(nm, nil, bf)
+		cs = append(cs, &candidate{name: nm, x: &d.M, nuc: d, k: d.NumParams()})
+	}
+	return
+}
+
+// aaCandidates the models are built-in names or PAML model files
+func aaCandidates(models []string, bf []float64) (cs []*candidate, err error) {
+	for _, nm := range models {
+		for _, emp := range []bool{false, true} {
+			y := gophy.NewProteinModel()
+			if err = y.SetRateMatrixByName(nm); err != nil {
+				return nil, err
+			}
+			c := &candidate{name: strings.TrimSuffix(filepath.Base(nm), ".dat"), x: &y.M}
+			if emp {
+				y.M.SetBaseFreqs(bf)
+				c.name += "+F"
+				c.k = 19
+			} else {
+				y.M.SetModelBF()
+			}
+			y.M.SetupQGTR()
+			cs = append(cs, c)
+		}
+	}
+	return cs, nil
+}
+
+// copyAACandidate a new amino acid model with the rates and frequencies of c
+func copyAACandidate(c *candidate) *candidate {
+	y := gophy.NewProteinModel()
+	y.M.R = mat.DenseCopyOf(c.x.R)
+	y.M.Ex = c.x.Ex
+	y.M.MBF = c.x.MBF
+	y.M.SetBaseFreqs(c.x.BF)
+	y.M.SetupQGTR()
+	return &candidate{name: c.name, x: &y.M, k: c.k}
+}
+
+// rateHetCandidates adds the +I, +G and +I+G versions of each candidate
+func rateHetCandidates(cs []*candidate, ncats int, newc func(*candidate) *candidate) (ret []*candidate) {
+	for _, c := range cs {
+		ret = append(ret, c)
+		for _, rh := range [][]bool{{false, true}, {true, false}, {true, true}} {
+			nc := newc(c)
+			nc.gamma, nc.inv = rh[0], rh[1]
+			if nc.inv {
+				nc.name += "+I"
+				nc.k++
+			}
+			if nc.gamma {
+				nc.name += fmt.Sprintf("+G%d", ncats)
+				nc.k++
+			}
+			ret = append(ret, nc)
+		}
+	}
+	return
+}
+
+// calcWeights the Akaike-type weights for the criterion
+func calcWeights(fits []*modelFit, crit string) {
+	best := math.MaxFloat64
+	for _, f := range fits {
+		best = math.Min(best, f.ic[crit])
+	}
+	sum := 0.
+	for _, f := range fits {
+		f.w[crit] = math.Exp(-0.5 * (f.ic[crit] - best))
+		sum += f.w[crit]
+	}
+	for _, f := range fits {
+		f.w[crit] /= sum
+	}
+}
+
+func main() {
+	tfn := flag.String("t", "", "tree filename (if not given a parsimony stepwise addition tree is used)")
+	afn := flag.String("s", "", "seq filename")
+	st := flag.String("st", "", "sequence type [nuc/aa] (detected if not given)")
+	ncats := flag.Int("g", 4, "number of gamma categories")
+	aams := flag.String("m", "JTT,WAG,LG", "comma separated amino acid models (built-in names or PAML model files)")
+	crit := flag.String("c", "BIC", "criterion to sort the models by [AIC/AICc/BIC]")
+	wks := flag.Int("w", 4, "number of threads")
+	flag.Parse()
+	if len(*afn) == 0 {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *crit != "AIC" && *crit != "AICc" && *crit != "BIC" {
+		fmt.Fprintln(os.Stderr, "criterion is not recognised, please use [AIC/AICc/BIC]")
+		os.Exit(1)
+	}
+	if *ncats < 2 {
+		fmt.Fprintln(os.Stderr, "there need to be at least 2 gamma categories")
+		os.Exit(1)
+	}
+	rseqs := gophy.ReadSeqsFromFile(*afn)
+	if len(rseqs) < 3 {
+		fmt.Fprintln(os.Stderr, "there need to be at least 3 sequences")
+		os.Exit(1)
+	}
+	if len(*st) == 0 {
+		if gophy.DetectSeqType(rseqs) == gophy.DNAData {
+			*st = "nuc"
+		} else {
+			*st = "aa"
+		}
+		fmt.Fprintln(os.Stderr, "sequence type:", *st)
+	}
+	if *st != "nuc" && *st != "aa" {
+		fmt.Fprintln(os.Stderr, "sequence type string is not a recognised datatype, please use [nuc/aa]")
+		os.Exit(1)
+	}
+	nuc := *st == "nuc"
+	seqs, patternsint, nsites, bf := gophy.ReadPatternsSeqsFromFile(*afn, nuc)
+	numstates := 4
+	if nuc == false {
+		numstates = 20
+	}
+	prep := func(t *gophy.Tree) []float64 {
+		var patternval []float64
+		if nuc {
+			patternval, _ = gophy.PreparePatternVecs(t, patternsint, seqs)
+		} else {
+			patternval, _ = gophy.PreparePatternVecsProt(t, patternsint, seqs)
+		}
+		return patternval
+	}
+
+	var t *gophy.Tree
+	if len(*tfn) > 0 {
+		t = gophy.ReadTreeFromFile(*tfn)
+		if t == nil {
+			os.Exit(1)
+		}
+		for _, n := range t.Tips {
+			if _, ok := seqs[n.Nam]; !ok {
+				fmt.Fprintln(os.Stderr, n.Nam, "is in the tree but not the alignment")
+				os.Exit(1)
+			}
+		}
+	} else {
+		var names []string
+		for _, s := range rseqs {
+			names = append(names, s.NM)
+		}
+		fmt.Fprintln(os.Stderr, "building a parsimony starting tree")
+		t = gophy.StepwiseAdditionParsTree(names, numstates, nsites, prep, *wks)
+		fmt.Fprintln(os.Stderr, t.Rt.Newick(true)+";")
+	}
+	nbl := len(t.Post) - 1
+
+	var cs []*candidate
+	if nuc {
+		cs = rateHetCandidates(nucCandidates(bf), *ncats, func(c *candidate) *candidate {
+			d, _ := gophy.NewNucModel(c.nuc.Sub, c.nuc.Params, c.nuc.M.BF)
+			return &candidate{name: c.name, x: &d.M, nuc: d, k: c.k}
+		})
+	} else {
+		acs, err := aaCandidates(strings.Split(*aams, ","), bf)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		cs = rateHetCandidates(acs, *ncats, copyAACandidate)
+	}
+
+	var fits []*modelFit
+	var bt *gophy.Tree
+	for _, c := range cs {
+		// the rate heterogeneity versions start from the fit of the plain model
+		if c.gamma == false && c.inv == false {
+			bt = t.DeepCopyTree()
+			prep(bt)
+		}
+		ct := bt.DeepCopyTree()
+		patternval := prep(ct)
+		lnl := fit(ct, c, patternval, *ncats, *wks)
+		if c.gamma == false && c.inv == false {
+			bt = ct
+		}
+		k := c.k + nbl
+		f := &modelFit{name: c.name, lnl: lnl, k: k, ic: map[string]float64{}, w: map[string]float64{}}
+		f.ic["AIC"] = gophy.CalcAIC(lnl, float64(k))
+		f.ic["AICc"] = gophy.CalcAICC(lnl, float64(k), nsites)
+		f.ic["BIC"] = gophy.CalcBIC(lnl, float64(k), nsites)
+		fits = append(fits, f)
+		fmt.Fprintln(os.Stderr, c.name, lnl)
+	}
+	for _, cr := range criteria {
+		calcWeights(fits, cr)
+	}
+	sort.SliceStable(fits, func(i, j int) bool { return fits[i].ic[*crit] < fits[j].ic[*crit] })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "model\tlnL\tk\tAIC\tAICc\tBIC\twAIC\twAICc\twBIC\t")
+	for _, f := range fits {
+		fmt.Fprintf(w, "%s\t%.4f\t%d\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t\n", f.name, f.lnl, f.k,
+			f.ic["AIC"], f.ic["AICc"], f.ic["BIC"], f.w["AIC"], f.w["AICc"], f.w["BIC"])
+	}
+	w.Flush()
+	fmt.Println("best model (" + *crit + "): " + fits[0].name)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func TestAARateHetCandidates(t *testing.T) {
+	tr := gophy.ReadTreeFromFile("../test_files/10tips.pep.fa.treefile")
+	seqs, patternsint, _, bf := gophy.ReadPatternsSeqsFromFile("../test_files/10tips.pep.fa", false)
+	acs, err := aaCandidates([]string{"LG", "mtREV"}, bf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := rateHetCandidates(acs, 4, copyAACandidate)
+	if len(cs) != 16 {
+		t.Fatal("candidates", len(cs))
+	}
+	for _, c := range cs {
+		ct := tr.DeepCopyTree()
+		patternval, _ := gophy.PreparePatternVecsProt(ct, patternsint, seqs)
+		c.setupRateHet(4)
+		lnl := calcLike(ct, c.x, patternval, 2)
+		if math.IsNaN(lnl) || math.IsInf(lnl, 0) || lnl >= 0 {
+			t.Error(c.name, lnl)
+		}
+	}
+}
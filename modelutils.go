@@ -28,6 +28,7 @@ func sumRow(m *mat.Dense, i int) (s float64) {
 //LikeResult likelihood value and site
 type LikeResult struct {
 	value float64
+	scale float64 // the log scaler of value (see CalcLikeNode)
 	site  int
 }
 
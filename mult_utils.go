@@ -3,6 +3,7 @@ package gophy
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 // GetSitePatternsMS return site pattens when the datatype for the alignment is a map[string]string
@@ -21,6 +22,7 @@ func GetSitePatternsMS(seqs []MSeq, charMap map[string][]int, numstates int) (pa
 			stats[i] = 0
 		}
 		gapcount := 0
+		ambcount := 0
 		for _, j := range seqs {
 			tp += string(j.SQs[k])
 			switch c := string(j.SQs[k]); c {
@@ -29,10 +31,14 @@ func GetSitePatternsMS(seqs []MSeq, charMap map[string][]int, numstates int) (pa
 			case "N":
 				gapcount++
 			default:
-				stats[charMap[c][0]]++ //
+				if st := msCharStates(charMap, c); len(st) == 1 {
+					stats[st[0]]++ //
+				} else {
+					ambcount++ // ambiguous like 0/1
+				}
 			}
 		}
-		efc := len(seqs) - gapcount
+		efc := len(seqs) - gapcount - ambcount
 		befc := false
 		for i := range stats {
 			if stats[i] >= efc {
@@ -97,13 +103,13 @@ func PreparePatternVecsMS(t *Tree, patternsint map[int]float64, seqs map[string]
 		if len(n.Chs) == 0 {
 			count := 0
 			for _, i := range patternvec {
-				if _, ok := charMap[string(seqs[n.Nam][i])]; !ok {
+				if msCharStates(charMap, seqs[n.Nam][i]) == nil {
 					if string(seqs[n.Nam][i]) != "-" && string(seqs[n.Nam][i]) != "N" {
 						fmt.Println(n.Nam, string(seqs[n.Nam][i]))
 						os.Exit(0)
 					}
 				}
-				for _, j := range charMap[string(seqs[n.Nam][i])] {
+				for _, j := range msCharStates(charMap, seqs[n.Nam][i]) {
 					n.Data[count][j] = 1.0
 					n.TpConds[count][j] = 1.0
 				}
@@ -113,3 +119,22 @@ func PreparePatternVecsMS(t *Tree, patternsint map[int]float64, seqs map[string]
 	}
 	return
 }
+
+// msCharStates the states for a multistate character. sets of states
+// (from {01} or (01) in nexus) are written like 0/1
+func msCharStates(charMap map[string][]int, c string) (states []int) {
+	if st, ok := charMap[c]; ok {
+		return st
+	}
+	if strings.Contains(c, "/") == false {
+		return nil
+	}
+	for _, s := range strings.Split(c, "/") {
+		st, ok := charMap[s]
+		if !ok {
+			return nil
+		}
+		states = append(states, st...)
+	}
+	return
+}
@@ -23,8 +23,9 @@ func GetEmpiricalBaseFreqsMS(seqs []MSeq, numstates int) (bf []float64) {
 	total := 0
 	for _, j := range seqs {
 		for _, m := range j.SQs {
-			v, _ := strconv.Atoi(m)
-			statecounts[v]++
+			if v, err := strconv.Atoi(m); err == nil {
+				statecounts[v]++
+			}
 		}
 	}
 	for _, i := range statecounts {
@@ -38,7 +39,14 @@ func GetEmpiricalBaseFreqsMS(seqs []MSeq, numstates int) (bf []float64) {
 
 //ReadMSeqsFromFile obvious
 // file should be fasta in format with starts seperated by spaces and starting at 0 going to whatever
+// or a phylip or nexus file
 func ReadMSeqsFromFile(filen string) (seqs []MSeq, numstates int) {
+	switch seqFileFormat(filen) {
+	case "nexus":
+		return ReadNexusMSeqsFromFile(filen)
+	case "phylip":
+		return ReadPhylipMSeqsFromFile(filen)
+	}
 	file, err := os.Open(filen)
 	if err != nil {
 		log.Fatal(err)
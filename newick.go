@@ -0,0 +1,288 @@
+package gophy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// NewickError reports where a newick tree could not be parsed
+type NewickError struct {
+	Tree int // index of the tree in the stream (starting at 0)
+	Line int // line of the error (starting at 1)
+	Col  int // column of the error (starting at 1)
+	Msg  string
+}
+
+func (e *NewickError) Error() string {
+	return fmt.Sprintf("tree %d: line %d, column %d: %s", e.Tree, e.Line, e.Col, e.Msg)
+}
+
+// TreeScanner reads newick trees one at a time from a reader
+// trees end with a ; and can span several lines
+type TreeScanner struct {
+	r      *bufio.Reader
+	line   int
+	col    int
+	ntrees int
+}
+
+// NewTreeScanner get a new TreeScanner for a reader
+func NewTreeScanner(r io.Reader) *TreeScanner {
+	return &TreeScanner{r: bufio.NewReaderSize(r, 1024*1024), line: 1, col: 1}
+}
+
+// Next returns the next tree or io.EOF when there are no more. If a tree cannot
+// be parsed a *NewickError is returned and the next call moves on to the tree after it
+func (s *TreeScanner) Next() (tree *Tree, err error) {
+	var buffer bytes.Buffer
+	sline, scol := 0, 0
+	incomment := false
+	inquote := false
+	for {
+		c, rerr := s.r.ReadByte()
+		if rerr == io.EOF {
+			if buffer.Len() == 0 {
+				return nil, io.EOF
+			}
+			s.ntrees++
+			return nil, &NewickError{Tree: s.ntrees - 1, Line: s.line, Col: s.col, Msg: "missing ; at end of tree"}
+		} else if rerr != nil {
+			return nil, rerr
+		}
+		cline, ccol := s.line, s.col
+		if c == '\n' {
+			s.line++
+			s.col = 1
+		} else {
+			s.col++
+		}
+		if buffer.Len() == 0 {
+			if isNewickSpace(c) {
+				continue
+			}
+			sline, scol = cline, ccol
+		}
+		buffer.WriteByte(c)
+		if inquote {
+			if c == '\'' {
+				inquote = false
+			}
+			continue
+		}
+		if incomment {
+			if c == ']' {
+				incomment = false
+			}
+			continue
+		}
+		if c == '[' {
+			incomment = true
+		} else if c == '\'' {
+			inquote = true
+		} else if c == ';' {
+			break
+		}
+	}
+	ts := buffer.String()
+	index := s.ntrees
+	s.ntrees++
+	rt, perr := parseNewick(ts)
+	if perr != nil {
+		line, col := perr.position(ts, sline, scol)
+		return nil, &NewickError{Tree: index, Line: line, Col: col, Msg: perr.msg}
+	}
+	tree = NewTree()
+	tree.Instantiate(rt)
+	tree.Index = index
+	return tree, nil
+}
+
+// ParseNewick reads the first newick tree from a reader
+// io.EOF is returned if there is no tree
+func ParseNewick(r io.Reader) (*Tree, error) {
+	return NewTreeScanner(r).Next()
+}
+
+// ParseNewickString reads a newick string (ending in ;) and returns the root
+func ParseNewickString(ts string) (*Node, error) {
+	rt, perr := parseNewick(ts)
+	if perr != nil {
+		line, col := perr.position(ts, 1, 1)
+		return nil, &NewickError{Line: line, Col: col, Msg: perr.msg}
+	}
+	return rt, nil
+}
+
+type newickSyntaxError struct {
+	off int
+	msg string
+}
+
+// position the line and column of the error given where ts starts
+func (e *newickSyntaxError) position(ts string, line int, col int) (int, int) {
+	for i := 0; i < e.off && i < len(ts); i++ {
+		if ts[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+type newickParser struct {
+	ts string
+	x  int
+}
+
+func isNewickSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func newNewickNode(par *Node) *Node {
+	return &Node{Par: par, Nam: "", Len: 0.0, IData: map[string]int{},
+		FData: map[string]float64{}, SData: map[string]string{}}
+}
+
+// parseNewick parses a single tree from ts which should end with ;
+func parseNewick(ts string) (rt *Node, err *newickSyntaxError) {
+	p := newickParser{ts: ts}
+	p.skipSpace()
+	// comments before the tree like [&R] are skipped
+	for p.x < len(p.ts) && p.ts[p.x] == '[' {
+		if _, err = p.readComment(); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+	}
+	if rt, err = p.subtree(nil); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.x >= len(p.ts) {
+		return nil, &newickSyntaxError{p.x, "missing ; at end of tree"}
+	}
+	switch p.ts[p.x] {
+	case ';':
+		return rt, nil
+	case ')':
+		return nil, &newickSyntaxError{p.x, "unbalanced parentheses: unexpected )"}
+	default:
+		return nil, &newickSyntaxError{p.x, "expected ; but found " + strconv.Quote(p.ts[p.x:p.x+1])}
+	}
+}
+
+func (p *newickParser) skipSpace() {
+	for p.x < len(p.ts) && isNewickSpace(p.ts[p.x]) {
+		p.x++
+	}
+}
+
+// subtree reads a node, its children, its label, annotations, and branch length
+func (p *newickParser) subtree(par *Node) (nd *Node, err *newickSyntaxError) {
+	nd = newNewickNode(par)
+	p.skipSpace()
+	if p.x < len(p.ts) && p.ts[p.x] == '(' {
+		open := p.x
+		p.x++
+		for {
+			var ch *Node
+			if ch, err = p.subtree(nd); err != nil {
+				return nil, err
+			}
+			nd.addChild(ch)
+			p.skipSpace()
+			if p.x >= len(p.ts) {
+				return nil, &newickSyntaxError{open, "unbalanced parentheses: ( is never closed"}
+			}
+			c := p.ts[p.x]
+			if c == ',' {
+				p.x++
+				continue
+			} else if c == ')' {
+				p.x++
+				break
+			} else if c == ';' {
+				return nil, &newickSyntaxError{open, "unbalanced parentheses: ( is never closed"}
+			}
+			return nil, &newickSyntaxError{p.x, "expected , or ) but found " + strconv.Quote(p.ts[p.x:p.x+1])}
+		}
+	}
+	if nd.Nam, err = p.readLabel(); err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if p.x >= len(p.ts) {
+			return
+		}
+		switch p.ts[p.x] {
+		case '[':
+			var cm string
+			if cm, err = p.readComment(); err != nil {
+				return nil, err
+			}
+			parseNodeAnnotation(nd, cm)
+		case ':':
+			p.x++
+			p.skipSpace()
+			start := p.x
+			for p.x < len(p.ts) && strings.IndexByte("(),:;[", p.ts[p.x]) == -1 && !isNewickSpace(p.ts[p.x]) {
+				p.x++
+			}
+			b, perr := strconv.ParseFloat(p.ts[start:p.x], 64)
+			if perr != nil {
+				return nil, &newickSyntaxError{start, "bad branch length " + strconv.Quote(p.ts[start:p.x])}
+			}
+			nd.Len = b
+		default:
+			return
+		}
+	}
+}
+
+// readLabel reads a label. unquoted labels keep the spaces inside them and
+// quoted labels can have any character (a quote inside is written twice)
+func (p *newickParser) readLabel() (string, *newickSyntaxError) {
+	p.skipSpace()
+	if p.x < len(p.ts) && p.ts[p.x] == '\'' {
+		start := p.x
+		var buffer bytes.Buffer
+		for p.x++; ; p.x++ {
+			if p.x >= len(p.ts) {
+				return "", &newickSyntaxError{start, "quoted label is never closed"}
+			}
+			if p.ts[p.x] == '\'' {
+				if p.x+1 < len(p.ts) && p.ts[p.x+1] == '\'' {
+					buffer.WriteByte('\'')
+					p.x++
+					continue
+				}
+				p.x++
+				return buffer.String(), nil
+			}
+			buffer.WriteByte(p.ts[p.x])
+		}
+	}
+	start := p.x
+	for p.x < len(p.ts) && strings.IndexByte("(),:;[", p.ts[p.x]) == -1 {
+		p.x++
+	}
+	return strings.TrimSpace(p.ts[start:p.x]), nil
+}
+
+// readComment reads [comment] and returns what is inside the brackets
+func (p *newickParser) readComment() (string, *newickSyntaxError) {
+	start := p.x
+	end := strings.IndexByte(p.ts[p.x:], ']')
+	if end == -1 {
+		return "", &newickSyntaxError{start, "[ is never closed"}
+	}
+	p.x += end + 1
+	return p.ts[start+1 : start+end], nil
+}
@@ -0,0 +1,227 @@
+package gophy
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+ NeXML (http://www.nexml.org) to and from the Node data
+   node label (or otu label) -> Nam
+   edge length               -> Len of the target node
+   meta (node or edge)       -> FData, IData, or SData based on the datatype
+*/
+
+type neXML struct {
+	Otus  []neXMLOtus  `xml:"otus"`
+	Trees []neXMLTrees `xml:"trees"`
+}
+
+type neXMLOtus struct {
+	ID   string     `xml:"id,attr"`
+	Otus []neXMLOtu `xml:"otu"`
+}
+
+type neXMLOtu struct {
+	ID    string `xml:"id,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type neXMLTrees struct {
+	Otus  string      `xml:"otus,attr"`
+	Trees []neXMLTree `xml:"tree"`
+}
+
+type neXMLTree struct {
+	ID    string      `xml:"id,attr"`
+	Label string      `xml:"label,attr"`
+	Nodes []neXMLNode `xml:"node"`
+	Edges []neXMLEdge `xml:"edge"`
+}
+
+type neXMLNode struct {
+	ID    string      `xml:"id,attr"`
+	Label string      `xml:"label,attr"`
+	Otu   string      `xml:"otu,attr"`
+	Root  string      `xml:"root,attr"`
+	Metas []neXMLMeta `xml:"meta"`
+}
+
+type neXMLEdge struct {
+	Source string      `xml:"source,attr"`
+	Target string      `xml:"target,attr"`
+	Length string      `xml:"length,attr"`
+	Metas  []neXMLMeta `xml:"meta"`
+}
+
+type neXMLMeta struct {
+	Property string `xml:"property,attr"`
+	Content  string `xml:"content,attr"`
+	Datatype string `xml:"datatype,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// ParseNeXML reads all the trees in a NeXML document
+func ParseNeXML(r io.Reader) (trees []*Tree, err error) {
+	var doc neXML
+	if err = xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	otus := make(map[string]string)
+	for _, ots := range doc.Otus {
+		for _, o := range ots.Otus {
+			otus[o.ID] = o.Label
+		}
+	}
+	trees = make([]*Tree, 0)
+	for _, ts := range doc.Trees {
+		for _, t := range ts.Trees {
+			rt, err := neXMLTreeToNodes(t, otus)
+			if err != nil {
+				return nil, err
+			}
+			tree := NewTree()
+			tree.Instantiate(rt)
+			tree.Nam = t.Label
+			tree.Index = len(trees)
+			trees = append(trees, tree)
+		}
+	}
+	return
+}
+
+func neXMLTreeToNodes(t neXMLTree, otus map[string]string) (rt *Node, err error) {
+	nds := make(map[string]*Node)
+	for _, n := range t.Nodes {
+		nd := newNewickNode(nil)
+		nd.Nam = n.Label
+		if len(nd.Nam) == 0 && len(n.Otu) > 0 {
+			nd.Nam = otus[n.Otu]
+		}
+		for _, m := range n.Metas {
+			setNeXMLMeta(nd, m)
+		}
+		nds[n.ID] = nd
+		if n.Root == "true" || n.Root == "1" {
+			rt = nd
+		}
+	}
+	for _, e := range t.Edges {
+		par, ok1 := nds[e.Source]
+		ch, ok2 := nds[e.Target]
+		if !ok1 || !ok2 {
+			return nil, errors.New("edge between unknown nodes " + e.Source + " and " + e.Target + " in tree " + t.ID)
+		}
+		if len(e.Length) > 0 {
+			if ch.Len, err = strconv.ParseFloat(e.Length, 64); err != nil {
+				return nil, err
+			}
+		}
+		for _, m := range e.Metas {
+			setNeXMLMeta(ch, m)
+		}
+		ch.Par = par
+		par.addChild(ch)
+	}
+	if rt == nil {
+		for _, n := range t.Nodes {
+			if nds[n.ID].Par == nil {
+				rt = nds[n.ID]
+				break
+			}
+		}
+	}
+	if rt == nil {
+		return nil, errors.New("no root in tree " + t.ID)
+	}
+	return
+}
+
+func setNeXMLMeta(nd *Node, m neXMLMeta) {
+	val := m.Content
+	if len(val) == 0 {
+		val = strings.TrimSpace(m.Value)
+	}
+	setNodeDataTyped(nd, strings.TrimPrefix(m.Property, "gophy:"), val, m.Datatype)
+}
+
+// NeXMLString returns the trees as a NeXML document
+func NeXMLString(trees []*Tree) (ret string) {
+	var buffer bytes.Buffer
+	buffer.WriteString(xml.Header)
+	buffer.WriteString("<nex:nexml version=\"0.9\" xmlns=\"http://www.nexml.org/2009\" " +
+		"xmlns:nex=\"http://www.nexml.org/2009\" xmlns:xsi=\"http://www.w3.org/2001/XMLSchema-instance\" " +
+		"xmlns:xsd=\"http://www.w3.org/2001/XMLSchema#\" xmlns:gophy=\"https://github.com/FePhyFoFum/gophy#\">\n")
+	otus := make(map[string]string)
+	buffer.WriteString("  <otus id=\"otus1\">\n")
+	for _, t := range trees {
+		for _, n := range t.Tips {
+			if _, ok := otus[n.Nam]; !ok {
+				otus[n.Nam] = "otu" + strconv.Itoa(len(otus)+1)
+				buffer.WriteString("    <otu id=\"" + otus[n.Nam] + "\" label=\"" + xmlEscape(n.Nam) + "\"/>\n")
+			}
+		}
+	}
+	buffer.WriteString("  </otus>\n")
+	buffer.WriteString("  <trees id=\"trees1\" otus=\"otus1\">\n")
+	for i, t := range trees {
+		tid := "tree" + strconv.Itoa(i+1)
+		buffer.WriteString("    <tree id=\"" + tid + "\" label=\"" + xmlEscape(t.Nam) + "\" xsi:type=\"nex:FloatTree\">\n")
+		ids := make(map[*Node]string)
+		for j, n := range t.Pre {
+			ids[n] = tid + "n" + strconv.Itoa(j+1)
+			buffer.WriteString("      <node id=\"" + ids[n] + "\"")
+			if len(n.Nam) > 0 {
+				buffer.WriteString(" label=\"" + xmlEscape(n.Nam) + "\"")
+			}
+			if len(n.Chs) == 0 {
+				buffer.WriteString(" otu=\"" + otus[n.Nam] + "\"")
+			}
+			if n == t.Rt {
+				buffer.WriteString(" root=\"true\"")
+			}
+			if len(n.FData)+len(n.IData)+len(n.SData) == 0 {
+				buffer.WriteString("/>\n")
+				continue
+			}
+			buffer.WriteString(">\n")
+			for _, k := range sortedFKeys(n.FData) {
+				writeNeXMLMeta(&buffer, k, "xsd:double", strconv.FormatFloat(n.FData[k], 'f', -1, 64))
+			}
+			for _, k := range sortedIKeys(n.IData) {
+				writeNeXMLMeta(&buffer, k, "xsd:integer", strconv.Itoa(n.IData[k]))
+			}
+			for _, k := range sortedSKeys(n.SData) {
+				writeNeXMLMeta(&buffer, k, "xsd:string", n.SData[k])
+			}
+			buffer.WriteString("      </node>\n")
+		}
+		// edges in the order of the children so the tree reads back the same
+		ne := 0
+		for _, n := range t.Pre {
+			for _, c := range n.Chs {
+				ne++
+				buffer.WriteString("      <edge id=\"" + tid + "e" + strconv.Itoa(ne) + "\" source=\"" + ids[n] +
+					"\" target=\"" + ids[c] + "\" length=\"" + strconv.FormatFloat(c.Len, 'f', -1, 64) + "\"/>\n")
+			}
+		}
+		buffer.WriteString("    </tree>\n")
+	}
+	buffer.WriteString("  </trees>\n")
+	buffer.WriteString("</nex:nexml>\n")
+	ret = buffer.String()
+	return
+}
+
+func writeNeXMLMeta(buffer *bytes.Buffer, key string, datatype string, val string) {
+	prop := key
+	if strings.Contains(prop, ":") == false {
+		prop = "gophy:" + prop
+	}
+	buffer.WriteString("        <meta xsi:type=\"nex:LiteralMeta\" property=\"" + xmlEscape(prop) +
+		"\" datatype=\"" + datatype + "\" content=\"" + xmlEscape(val) + "\"/>\n")
+}
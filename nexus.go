@@ -0,0 +1,326 @@
+package gophy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// IsNexusFile checks whether the first non-empty line of a file is #NEXUS
+func IsNexusFile(tfn string) bool {
+	f, err := os.Open(tfn)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	buf := make([]byte, 0, 1024*1024)
+	scanner.Buffer(buf, 1024*1024)
+	for scanner.Scan() {
+		ln := strings.TrimSpace(scanner.Text())
+		if len(ln) == 0 {
+			continue
+		}
+		return strings.HasPrefix(strings.ToUpper(ln), "#NEXUS")
+	}
+	return false
+}
+
+// ReadNexusTreesFromFile read all the trees in the TREES blocks of a NEXUS file
+func ReadNexusTreesFromFile(tfn string) (trees []*Tree) {
+	f, err := os.Open(tfn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer f.Close()
+	return readNexusTrees(NewNexusTreeScanner(f), tfn)
+}
+
+// ReadNexusTreesString read all the trees in the TREES blocks of a NEXUS string
+// tip names are resolved through the TRANSLATE table if there is one
+func ReadNexusTreesString(nex string) (trees []*Tree) {
+	return readNexusTrees(NewNexusTreeScanner(strings.NewReader(nex)), "")
+}
+
+// readNexusTrees reads all the trees from the scanner, reporting and skipping
+// the ones that can't be parsed
+func readNexusTrees(sc *NexusTreeScanner, tfn string) (trees []*Tree) {
+	trees = make([]*Tree, 0)
+	for {
+		tree, err := sc.Next()
+		if err == io.EOF {
+			break
+		}
+		if nerr, ok := err.(*NewickError); ok {
+			if len(tfn) > 0 {
+				fmt.Fprintln(os.Stderr, tfn+":", nerr)
+			} else {
+				fmt.Fprintln(os.Stderr, nerr)
+			}
+			continue
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "Read error:", err)
+			break
+		}
+		tree.Index = len(trees)
+		trees = append(trees, tree)
+	}
+	return
+}
+
+// NexusTreeScanner reads the trees in the TREES blocks of a NEXUS file one at a
+// time so that large sets of trees don't have to be held in memory
+type NexusTreeScanner struct {
+	r         *bufio.Reader
+	started   bool
+	intrees   bool
+	translate map[string]string
+	ntrees    int
+}
+
+// NewNexusTreeScanner get a new NexusTreeScanner for a reader
+func NewNexusTreeScanner(r io.Reader) *NexusTreeScanner {
+	return &NexusTreeScanner{r: bufio.NewReaderSize(r, 1024*1024), translate: map[string]string{}}
+}
+
+// Next returns the next tree or io.EOF when there are no more. tip names are
+// resolved through the TRANSLATE table if there is one. If a tree cannot be
+// parsed a *NewickError is returned and the next call moves on to the tree after it
+func (s *NexusTreeScanner) Next() (tree *Tree, err error) {
+	for {
+		st, err := s.statement()
+		if err != nil {
+			return nil, err
+		}
+		if s.started == false {
+			s.started = true
+			st = strings.TrimSpace(st)
+			if strings.HasPrefix(strings.ToUpper(st), "#NEXUS") {
+				st = st[len("#NEXUS"):]
+			}
+		}
+		st = strings.TrimSpace(stripNexusComments(st, false))
+		if len(st) == 0 {
+			continue
+		}
+		cmd := strings.ToUpper(strings.Fields(st)[0])
+		if cmd != "TREE" && cmd != "UTREE" {
+			st = strings.TrimSpace(stripNexusComments(st, true))
+		}
+		switch {
+		case cmd == "BEGIN":
+			s.intrees = strings.ToUpper(strings.TrimSpace(st[len(cmd):])) == "TREES"
+			s.translate = map[string]string{}
+		case cmd == "END" || cmd == "ENDBLOCK":
+			s.intrees = false
+		case s.intrees && cmd == "TRANSLATE":
+			for _, p := range splitNexusOutside(st[len(cmd):], ',') {
+				flds := strings.Fields(p)
+				if len(flds) < 2 {
+					continue
+				}
+				val := strings.TrimSpace(strings.TrimSpace(p)[len(flds[0]):])
+				s.translate[flds[0]] = unquoteNewickLabel(val)
+			}
+		case s.intrees && (cmd == "TREE" || cmd == "UTREE"):
+			index := s.ntrees
+			s.ntrees++
+			eq := strings.Index(st, "=")
+			if eq == -1 {
+				return nil, &NewickError{Tree: index, Line: 1, Col: 1, Msg: "no = in tree statement: " + st}
+			}
+			nm := strings.TrimSpace(st[len(cmd):eq])
+			if strings.HasPrefix(nm, "*") {
+				nm = strings.TrimSpace(nm[1:])
+			}
+			nm = unquoteNewickLabel(nm)
+			ts := strings.TrimSpace(stripNexusComments(st[eq+1:], false))
+			if len(ts) == 0 {
+				continue
+			}
+			rt, err := ParseNewickString(ts + ";")
+			if err != nil {
+				nerr := err.(*NewickError)
+				nerr.Tree = index
+				nerr.Msg = "tree " + nm + ": " + nerr.Msg
+				return nil, nerr
+			}
+			tree = NewTree()
+			tree.Instantiate(rt)
+			tree.Nam = nm
+			tree.Index = index
+			if len(s.translate) > 0 {
+				for _, n := range tree.Tips {
+					if tn, ok := s.translate[n.Nam]; ok {
+						n.Nam = tn
+					}
+				}
+			}
+			return tree, nil
+		}
+	}
+}
+
+// statement reads up to the next ; that is not in a comment or quote
+func (s *NexusTreeScanner) statement() (string, error) {
+	var buffer bytes.Buffer
+	depth := 0
+	inquote := byte(0)
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF && len(strings.TrimSpace(buffer.String())) > 0 {
+			return buffer.String(), nil
+		} else if err != nil {
+			return "", err
+		}
+		switch {
+		case inquote != 0:
+			if c == inquote {
+				inquote = 0
+			}
+		case c == '[':
+			depth++
+		case c == ']':
+			if depth > 0 {
+				depth--
+			}
+		case depth == 0 && (c == '\'' || c == '"'):
+			inquote = c
+		case depth == 0 && c == ';':
+			return buffer.String(), nil
+		}
+		buffer.WriteByte(c)
+	}
+}
+
+// NexusString returns the trees as a NEXUS TREES block with a TRANSLATE table
+// (node annotations in Annot are written as [&key=val,...])
+func NexusString(trees []*Tree, bl bool) (ret string) {
+	var buffer bytes.Buffer
+	names := make([]string, 0)
+	trmap := make(map[string]string)
+	for _, t := range trees {
+		for _, n := range t.Tips {
+			if _, ok := trmap[n.Nam]; !ok {
+				names = append(names, n.Nam)
+				trmap[n.Nam] = strconv.Itoa(len(names))
+			}
+		}
+	}
+	buffer.WriteString("#NEXUS\nBEGIN TREES;\n\tTRANSLATE\n")
+	for i, nm := range names {
+		buffer.WriteString("\t\t" + trmap[nm] + " " + quoteNewickLabel(nm))
+		if i == len(names)-1 {
+			buffer.WriteString(";\n")
+		} else {
+			buffer.WriteString(",\n")
+		}
+	}
+	for i, t := range trees {
+		nm := t.Nam
+		if len(nm) == 0 {
+			nm = "tree" + strconv.Itoa(i+1)
+		}
+		orig := make([]string, len(t.Tips))
+		for j, n := range t.Tips {
+			orig[j] = n.Nam
+			n.Nam = trmap[n.Nam]
+		}
+		buffer.WriteString("\tTREE " + quoteNewickLabel(nm) + " = " + t.Rt.NewickAnnotated(bl, false) + ";\n")
+		for j, n := range t.Tips {
+			n.Nam = orig[j]
+		}
+	}
+	buffer.WriteString("END;\n")
+	ret = buffer.String()
+	return
+}
+
+// WriteNexusTreesToFile write the trees to a NEXUS file
+func WriteNexusTreesToFile(trees []*Tree, bl bool, tfn string) {
+	f, err := os.Create(tfn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer f.Close()
+	f.WriteString(NexusString(trees, bl))
+}
+
+// splitNexusStatements splits on ; that are not in a comment or quote
+func splitNexusStatements(nex string) []string {
+	return splitNexusOutside(nex, ';')
+}
+
+// splitNexusOutside splits on sep when it is not in a [comment] or 'quote'
+func splitNexusOutside(s string, sep byte) (ret []string) {
+	depth := 0
+	inquote := byte(0)
+	last := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inquote != 0:
+			if c == inquote {
+				inquote = 0
+			}
+		case c == '[':
+			depth++
+		case c == ']':
+			if depth > 0 {
+				depth--
+			}
+		case depth == 0 && (c == '\'' || c == '"'):
+			inquote = c
+		case depth == 0 && c == sep:
+			ret = append(ret, s[last:i])
+			last = i + 1
+		}
+	}
+	if last < len(s) {
+		ret = append(ret, s[last:])
+	}
+	return
+}
+
+// stripNexusComments removes [comments]. if all is false only the comments
+// before the newick string starts are removed so [&...] annotations survive
+func stripNexusComments(s string, all bool) string {
+	var buffer bytes.Buffer
+	depth := 0
+	inquote := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inquote != 0 {
+			if c == inquote {
+				inquote = 0
+			}
+			buffer.WriteByte(c)
+			continue
+		}
+		if depth == 0 && (c == '\'' || c == '"') {
+			inquote = c
+		}
+		if c == '[' {
+			if all == false && strings.TrimSpace(buffer.String()) != "" {
+				buffer.WriteString(s[i:])
+				break
+			}
+			depth++
+			continue
+		}
+		if c == ']' && depth > 0 {
+			depth--
+			continue
+		}
+		if depth == 0 {
+			buffer.WriteByte(c)
+		}
+	}
+	return buffer.String()
+}
@@ -0,0 +1,72 @@
+package gophy_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+var nexTrees = `#NEXUS
+[ written by hand ]
+BEGIN TAXA;
+	DIMENSIONS NTAX=4;
+	TAXLABELS a b c d;
+END;
+BEGIN TREES;
+	TRANSLATE
+		1 a,
+		2 b,
+		3 'c',
+		4 d;
+	TREE tree1 = [&R] ((1:0.1,2:0.2):0.3,3:0.4,4:0.5);
+	tree tree2 = [&U] ((1:0.1,3:0.2):0.3,2:0.4,4:0.5);
+END;
+`
+
+func TestReadNexusTreesString(t *testing.T) {
+	trees := gophy.ReadNexusTreesString(nexTrees)
+	if len(trees) != 2 {
+		t.Fatal("expected 2 trees, got", len(trees))
+	}
+	if trees[0].Rt.Newick(true) != "((a:0.1,b:0.2):0.3,c:0.4,d:0.5)" {
+		t.Error(trees[0].Rt.Newick(true))
+	}
+	if trees[1].Nam != "tree2" || trees[1].Rt.Newick(false) != "((a,c),b,d)" {
+		t.Error(trees[1].Nam, trees[1].Rt.Newick(false))
+	}
+}
+
+func TestNexusStringRoundTrip(t *testing.T) {
+	trees := gophy.ReadNexusTreesString(nexTrees)
+	out := gophy.NexusString(trees, true)
+	trees2 := gophy.ReadNexusTreesString(out)
+	if len(trees2) != len(trees) {
+		t.Fatal("expected", len(trees), "trees, got", len(trees2))
+	}
+	for i := range trees {
+		if trees[i].Rt.Newick(true) != trees2[i].Rt.Newick(true) {
+			t.Error(trees[i].Rt.Newick(true), trees2[i].Rt.Newick(true))
+		}
+	}
+}
+
+func TestNexusTreeStream(t *testing.T) {
+	bad := strings.Replace(nexTrees, "tree tree2 = [&U] ((1:0.1,3:0.2)", "tree tree2 = [&U] ((1:0.1,3:x)", 1)
+	sc := gophy.NewTreeStream(strings.NewReader("\n" + bad + "BEGIN TREES;\n\tTREE t3 = (a,b,(c,d));\nEND;\n"))
+	tr, err := sc.Next()
+	if err != nil || tr.Nam != "tree1" || tr.Rt.Newick(false) != "((a,b),c,d)" {
+		t.Fatal(tr, err)
+	}
+	if _, err = sc.Next(); err == nil {
+		t.Error("expected an error for tree2")
+	}
+	// the TRANSLATE table is reset by the new TREES block
+	if tr, err = sc.Next(); err != nil || tr.Nam != "t3" || tr.Index != 2 || tr.Rt.Newick(false) != "(a,b,(c,d))" {
+		t.Fatal(tr, err)
+	}
+	if _, err = sc.Next(); err != io.EOF {
+		t.Error("expected EOF, got", err)
+	}
+}
@@ -2,9 +2,11 @@ package gophy
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Node minimal node struct
@@ -15,9 +17,11 @@ type Node struct {
 	SData     map[string]string
 	FData     map[string]float64
 	IData     map[string]int
+	Annot     []string //keys of FData and SData read from [&...] annotations
 	Num       int
 	Len       float64     //branch length
 	Data      [][]float64 // [site][states]
+	Scale     []float64   // [site] log scaler of Data (see CalcLikeNode)
 	BData     [][]*SupFlo //[site][states]
 	ContData  []float64   //[site] cont
 	ContData2 []float64   //[site] another cont
@@ -100,7 +104,7 @@ func (n Node) Newick(bl bool) (ret string) {
 			buffer.WriteString(",")
 		}
 	}
-	buffer.WriteString(n.Nam)
+	buffer.WriteString(quoteNewickLabel(n.Nam))
 	ret = buffer.String()
 	return
 }
@@ -124,11 +128,83 @@ func (n Node) NewickFData(bl bool, FD string) (ret string) {
 			buffer.WriteString(",")
 		}
 	}
-	buffer.WriteString(n.Nam + "[&" + FD + "=" + strconv.FormatFloat(n.FData[FD], 'f', -1, 64) + "]")
+	buffer.WriteString(quoteNewickLabel(n.Nam) + "[&" + FD + "=" + strconv.FormatFloat(n.FData[FD], 'f', -1, 64) + "]")
 	ret = buffer.String()
 	return
 }
 
+// NewickAnnotated returns a string newick with the FData and SData listed in Annot
+// written as [&key=val,...] (BEAST, FigTree) or [&&NHX:key=val:...] if nhx
+func (n Node) NewickAnnotated(bl bool, nhx bool) (ret string) {
+	var buffer bytes.Buffer
+	for in, cn := range n.Chs {
+		if in == 0 {
+			buffer.WriteString("(")
+		}
+		buffer.WriteString(cn.NewickAnnotated(bl, nhx))
+		if bl == true {
+			s := strconv.FormatFloat(cn.Len, 'f', -1, 64)
+			buffer.WriteString(":")
+			buffer.WriteString(s)
+		}
+		if nhx {
+			buffer.WriteString(cn.annotationString(true))
+		}
+		if in == len(n.Chs)-1 {
+			buffer.WriteString(")")
+		} else {
+			buffer.WriteString(",")
+		}
+	}
+	buffer.WriteString(quoteNewickLabel(n.Nam))
+	if nhx == false || n.Par == nil {
+		buffer.WriteString(n.annotationString(nhx))
+	}
+	ret = buffer.String()
+	return
+}
+
+// annotationString the [&...] comment for the Annot keys of the node
+func (n Node) annotationString(nhx bool) string {
+	if len(n.Annot) == 0 {
+		return ""
+	}
+	vals := make([]string, 0, len(n.Annot))
+	for _, k := range n.Annot {
+		if f, ok := n.FData[k]; ok {
+			vals = append(vals, k+"="+strconv.FormatFloat(f, 'f', -1, 64))
+		} else if s, ok := n.SData[k]; ok {
+			if nhx == false && strings.HasPrefix(s, "{") == false && strings.ContainsAny(s, ",=:[] ") {
+				s = "\"" + s + "\""
+			}
+			vals = append(vals, k+"="+s)
+		}
+	}
+	if len(vals) == 0 {
+		return ""
+	}
+	if nhx {
+		return "[&&NHX:" + strings.Join(vals, ":") + "]"
+	}
+	return "[&" + strings.Join(vals, ",") + "]"
+}
+
+// GetRange returns the values of a range annotation such as height_95%_HPD={1.0,2.3}
+func (n Node) GetRange(key string) (rng []float64, err error) {
+	s, ok := n.SData[key]
+	if !ok || strings.HasPrefix(s, "{") == false || strings.HasSuffix(s, "}") == false {
+		return nil, errors.New("no range annotation with key: " + key)
+	}
+	for _, v := range strings.Split(s[1:len(s)-1], ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		rng = append(rng, f)
+	}
+	return
+}
+
 // BMPhylogram returns a string newick with brownian motion branch lengths
 func (n Node) BMPhylogram() (ret string) {
 	bl := true
@@ -149,7 +225,7 @@ func (n Node) BMPhylogram() (ret string) {
 			buffer.WriteString(",")
 		}
 	}
-	buffer.WriteString(n.Nam)
+	buffer.WriteString(quoteNewickLabel(n.Nam))
 	ret = buffer.String()
 	return
 }
@@ -173,7 +249,7 @@ func (n Node) NewickFloatBL(fl string) (ret string) {
 			buffer.WriteString(",")
 		}
 	}
-	buffer.WriteString(n.Nam)
+	buffer.WriteString(quoteNewickLabel(n.Nam))
 	ret = buffer.String()
 	return
 }
@@ -204,12 +280,29 @@ func (n Node) NewickPaint(bl bool, rid float64) (ret string) {
 		}
 	}
 	if _, ok := n.MarkedMap[rid]; ok {
-		buffer.WriteString(n.Nam)
+		buffer.WriteString(quoteNewickLabel(n.Nam))
 	}
 	ret = buffer.String()
 	return
 }
 
+// quoteNewickLabel puts single quotes around a name (doubling any quotes in it) if it has
+// spaces or characters that are part of the newick format
+func quoteNewickLabel(nm string) string {
+	if strings.ContainsAny(nm, " ()[]':;,\t\n") {
+		return "'" + strings.Replace(nm, "'", "''", -1) + "'"
+	}
+	return nm
+}
+
+// unquoteNewickLabel removes the single quotes (and '' escaping) from a quoted name
+func unquoteNewickLabel(nm string) string {
+	if len(nm) >= 2 && nm[0] == '\'' && nm[len(nm)-1] == '\'' {
+		return strings.Replace(nm[1:len(nm)-1], "''", "'", -1)
+	}
+	return strings.Trim(nm, "\"")
+}
+
 func (n *Node) addChild(c *Node) {
 	n.Chs = append(n.Chs, c)
 }
@@ -293,6 +386,10 @@ func (n *Node) Reroot(oldroot *Node) *Node {
 		newpar.addChild(curnode)
 		curnode.Len = newpar.Len
 	}
+	// the nodes on the path have other children now
+	for _, p := range pathnodes[:pathlen+1] {
+		p.Marked = true
+	}
 	//curnode = nil
 	//newpar = nil
 	n.Len = 0.0
@@ -0,0 +1,267 @@
+package gophy
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize"
+)
+
+// UNREST the general non-reversible nucleotide model
+const UNREST = "UNREST"
+
+// NonRevModel a non-reversible model, either UNREST (12 rates for nucleotides,
+// scaled to a mean rate of 1 at stationarity) or an all rates different Mk
+// (unscaled like SetupQMk). The root frequencies are in M.RootBF and are the
+// stationary frequencies of Q (M.BF) if Stationary. Q isn't symmetric once it is
+// scaled by the frequencies, so its eigenvalues can be complex and the P matrices
+// are calculated with the matrix exponential (mat.Exp in the P cache) instead of
+// an eigen decomposition. Don't call M.DecomposeQ (or the NR branch length
+// optimizers that use it) with these models as it keeps only the real parts
+type NonRevModel struct {
+	M          DiscreteModel
+	Sub        string    // UNREST or Mk
+	Rates      []float64 // the free off-diagonal rates by row (UNREST fixes T->G to 1)
+	Stationary bool      // root frequencies are the stationary frequencies
+}
+
+// NewUNRESTModel get an UNREST model with all rates 1 (so JC69) and the
+// stationary frequencies at the root
+func NewUNRESTModel() *NonRevModel {
+	m := &NonRevModel{Sub: UNREST, Stationary: true}
+	d := NewDNAModel()
+	m.M.Alph = Nucleotide
+	m.M.NumStates = 4
+	m.M.CharMap = d.M.CharMap
+	rates := make([]float64, 11)
+	for i := range rates {
+		rates[i] = 1.0
+	}
+	m.SetRates(rates)
+	return m
+}
+
+// NewNonRevMkModel get an all rates different Mk model for numstates with all
+// rates at rt and the stationary frequencies at the root
+func NewNonRevMkModel(numstates int, rt float64) *NonRevModel {
+	m := &NonRevModel{Sub: "Mk", Stationary: true}
+	d := NewMultStateModel(numstates)
+	m.M.Alph = MultiState
+	m.M.NumStates = numstates
+	m.M.CharMap = d.M.CharMap
+	rates := make([]float64, numstates*(numstates-1))
+	for i := range rates {
+		rates[i] = rt
+	}
+	m.SetRates(rates)
+	return m
+}
+
+// SetRates set the free rates (see Rates) and setup Q and the stationary
+// frequencies (BF)
+func (m *NonRevModel) SetRates(rates []float64) {
+	m.Rates = append([]float64{}, rates...)
+	if m.Sub == UNREST {
+		m.M.SetScaledRateMatrix(rates, false)
+		m.M.EmptyPDict()
+		m.M.Q = mat.NewDense(4, 4, nil)
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 4; j++ {
+				if i != j {
+					m.M.Q.Set(i, j, m.M.R.At(i, j))
+				}
+			}
+			m.M.Q.Set(i, i, -sumRow(m.M.Q, i))
+		}
+	} else {
+		m.M.SetupQMk(rates, false)
+	}
+	m.M.BF = StationaryFreqs(m.M.Q)
+	if m.Sub == UNREST {
+		mr := 0.
+		for i := 0; i < 4; i++ {
+			mr -= m.M.BF[i] * m.M.Q.At(i, i)
+		}
+		m.M.Q.Scale(1/mr, m.M.Q)
+	}
+	if m.Stationary {
+		m.M.RootBF = m.M.BF
+	}
+}
+
+// SetRootFreqs set the root frequencies (not stationary after)
+func (m *NonRevModel) SetRootFreqs(rf []float64) {
+	m.Stationary = false
+	m.M.RootBF = rf
+}
+
+// NumParams the free rates and the root frequencies if they aren't stationary
+func (m *NonRevModel) NumParams() int {
+	k := len(m.Rates)
+	if m.Stationary == false {
+		k += m.M.NumStates - 1
+	}
+	return k
+}
+
+// StationaryFreqs the stationary distribution of Q (pi Q = 0 with pi summing to 1)
+func StationaryFreqs(Q *mat.Dense) []float64 {
+	n, _ := Q.Dims()
+	A := mat.NewDense(n, n, nil)
+	A.CloneFrom(Q.T())
+	for j := 0; j < n; j++ {
+		A.Set(n-1, j, 1.0)
+	}
+	b := mat.NewVecDense(n, nil)
+	b.SetVec(n-1, 1.0)
+	var pi mat.VecDense
+	if err := pi.SolveVec(A, b); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	ret := make([]float64, n)
+	for i := range ret {
+		ret[i] = math.Max(0, pi.AtVec(i))
+	}
+	normalizeFreqs(ret)
+	return ret
+}
+
+// rootFreqs RootBF or BF if there aren't separate root frequencies
+func (d *DiscreteModel) rootFreqs() []float64 {
+	if d.RootBF != nil {
+		return d.RootBF
+	}
+	return d.BF
+}
+
+// CalcLogLikeOneSiteRooted log likelihood of one site with the root frequencies
+func CalcLogLikeOneSiteRooted(t *Tree, x *DiscreteModel, site int) float64 {
+	rf := x.rootFreqs()
+	for _, n := range t.Post {
+		if len(n.Chs) > 0 {
+			CalcLogLikeNode(n, x, site)
+		}
+	}
+	for i := 0; i < x.NumStates; i++ {
+		t.Rt.Data[site][i] += math.Log(rf[i])
+	}
+	return floats.LogSumExp(t.Rt.Data[site])
+}
+
+// PCalcLogLikePatternsRooted parallel log likelihood using the root of t and the
+// root frequencies (RootBF). With a non-reversible model (or root frequencies
+// that aren't stationary) this changes with where the root is
+func PCalcLogLikePatternsRooted(t *Tree, x *DiscreteModel, patternval []float64, wks int) (fl float64) {
+	fl = 0.0
+	nsites := len(patternval)
+	jobs := make(chan int, nsites)
+	results := make(chan LikeResult, nsites)
+	// populate the P matrix dictionary without problems of race conditions
+	// just the first site
+	x.EmptyPDict()
+	x.EmptyPLDict()
+	x.FillPCache(t, true)
+	fl += CalcLogLikeOneSiteRooted(t, x, 0) * patternval[0]
+	for i := 0; i < wks; i++ {
+		go func() {
+			for j := range jobs {
+				results <- LikeResult{value: CalcLogLikeOneSiteRooted(t, x, j), site: j}
+			}
+		}()
+	}
+	for i := 1; i < nsites; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	rr := LikeResult{}
+	for i := 1; i < nsites; i++ {
+		rr = <-results
+		fl += (rr.value * patternval[rr.site])
+	}
+	return
+}
+
+// OptimizeNonRevModel optimize the rates (and the root frequencies if not
+// Stationary) with the branch lengths fixed. Returns the lnL
+func OptimizeNonRevModel(t *Tree, m *NonRevModel, patternvals []float64, wks int) float64 {
+	nr := len(m.Rates)
+	ns := m.M.NumStates
+	// the root frequencies are logs relative to the last state
+	setp := func(mds []float64) {
+		m.SetRates(mds[:nr])
+		if m.Stationary == false {
+			rf := make([]float64, ns)
+			for i := 0; i < ns-1; i++ {
+				rf[i] = math.Exp(mds[nr+i])
+			}
+			rf[ns-1] = 1.
+			normalizeFreqs(rf)
+			m.M.RootBF = rf
+		}
+	}
+	fcn := func(mds []float64) float64 {
+		for i, v := range mds {
+			if (i < nr && (v <= 0 || v > 1000)) || (i >= nr && math.Abs(v) > 10) {
+				return 1000000000000
+			}
+		}
+		setp(mds)
+		lnl := PCalcLogLikePatternsRooted(t, &m.M, patternvals, wks)
+		return -lnl
+	}
+	p0 := append([]float64{}, m.Rates...)
+	if m.Stationary == false {
+		rf := m.M.rootFreqs()
+		for i := 0; i < ns-1; i++ {
+			p0 = append(p0, math.Log(math.Max(rf[i], 1e-4)/math.Max(rf[ns-1], 1e-4)))
+		}
+	}
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, p0, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	setp(res.X)
+	return -res.F
+}
+
+// OptimizeRootPosition slide the root along the branch it is on (keeping the sum
+// of the two root branch lengths) for the rooted likelihood. Returns the lnL
+func OptimizeRootPosition(t *Tree, x *DiscreteModel, patternvals []float64, wks int) float64 {
+	if len(t.Rt.Chs) != 2 {
+		return PCalcLogLikePatternsRooted(t, x, patternvals, wks)
+	}
+	c1, c2 := t.Rt.Chs[0], t.Rt.Chs[1]
+	tot := c1.Len + c2.Len
+	fcn := func(f []float64) float64 {
+		if f[0] < 0 || f[0] > 1 {
+			return 1000000000000
+		}
+		c1.Len = f[0] * tot
+		c2.Len = tot - c1.Len
+		return -PCalcLogLikePatternsRooted(t, x, patternvals, wks)
+	}
+	f0 := 0.5
+	if tot > 0 {
+		f0 = c1.Len / tot
+	}
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, []float64{f0}, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return -fcn(res.X)
+}
@@ -0,0 +1,75 @@
+package gophy_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+	"gonum.org/v1/gonum/mat"
+)
+
+func rerootTest(fn string, edge int) *gophy.Tree {
+	tr := gophy.ReadTreeFromFile(fn)
+	gophy.Reroot(tr.Pre[edge], tr)
+	tr.Rt.Par = nil
+	nt := gophy.NewTree()
+	nt.Instantiate(tr.Rt)
+	return nt
+}
+
+func TestNonRevModels(t *testing.T) {
+	tfn := "test_files/10tips.nuc.fa.treefile"
+	seqs, patternsint, _, _ := gophy.ReadPatternsSeqsFromFile("test_files/10tips.nuc.fa", true)
+	tr1 := rerootTest(tfn, 3)
+	tr2 := rerootTest(tfn, 7)
+	pv1, _ := gophy.PreparePatternVecs(tr1, patternsint, seqs)
+	pv2, _ := gophy.PreparePatternVecs(tr2, patternsint, seqs)
+
+	// UNREST with equal rates is JC69 and the root doesn't matter
+	u := gophy.NewUNRESTModel()
+	jc := gophy.NewJC69Model()
+	jlnl := gophy.PCalcLogLikePatterns(tr1, &jc.M, pv1, 2)
+	ulnl1 := gophy.PCalcLogLikePatternsRooted(tr1, &u.M, pv1, 2)
+	ulnl2 := gophy.PCalcLogLikePatternsRooted(tr2, &u.M, pv2, 2)
+	if math.Abs(jlnl-ulnl1) > 1e-6 || math.Abs(ulnl1-ulnl2) > 1e-6 || u.NumParams() != 11 {
+		t.Error("UNREST as JC69", jlnl, ulnl1, ulnl2)
+	}
+
+	u.SetRates([]float64{0.5, 2.0, 1.0, 0.3, 1.5, 0.8, 2.5, 0.4, 1.2, 0.7, 3.0})
+	pq := mat.NewVecDense(4, nil)
+	pq.MulVec(u.M.Q.T(), mat.NewVecDense(4, u.M.BF))
+	mr := 0.
+	for i := 0; i < 4; i++ {
+		mr -= u.M.BF[i] * u.M.Q.At(i, i)
+		if math.Abs(pq.AtVec(i)) > 1e-9 {
+			t.Error("not stationary", u.M.BF)
+		}
+	}
+	if math.Abs(mr-1) > 1e-9 {
+		t.Error("mean rate", mr)
+	}
+	ulnl1 = gophy.PCalcLogLikePatternsRooted(tr1, &u.M, pv1, 2)
+	ulnl2 = gophy.PCalcLogLikePatternsRooted(tr2, &u.M, pv2, 2)
+	if math.Abs(ulnl1-ulnl2) < 1e-3 {
+		t.Error("the root position should matter", ulnl1, ulnl2)
+	}
+	olnl := gophy.OptimizeNonRevModel(tr1, u, pv1, 2)
+	if olnl < ulnl1 {
+		t.Error("optimizing UNREST made it worse", ulnl1, olnl)
+	}
+	if rlnl := gophy.OptimizeRootPosition(tr1, &u.M, pv1, 2); rlnl < olnl-1e-6 {
+		t.Error("optimizing the root position made it worse", olnl, rlnl)
+	}
+	u.SetRootFreqs([]float64{0.4, 0.1, 0.1, 0.4})
+	if u.NumParams() != 14 || gophy.PCalcLogLikePatternsRooted(tr1, &u.M, pv1, 2) == olnl {
+		t.Error("root frequencies", u.NumParams())
+	}
+
+	// a reversible model doesn't depend on the root
+	g, _ := gophy.NewNucModel(gophy.GTR, []float64{1.2, 3.0, 0.8, 1.1, 2.9}, []float64{0.3, 0.2, 0.2, 0.3})
+	glnl1 := gophy.PCalcLogLikePatternsRooted(tr1, &g.M, pv1, 2)
+	glnl2 := gophy.PCalcLogLikePatternsRooted(tr2, &g.M, pv2, 2)
+	if math.Abs(glnl1-glnl2) > 1e-6 {
+		t.Error("GTR rooted", glnl1, glnl2)
+	}
+}
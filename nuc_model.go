@@ -1,9 +1,17 @@
 package gophy
 
-import "gonum.org/v1/gonum/mat"
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gonum.org/v1/gonum/mat"
+)
 
 type DNAModel struct {
-	M DiscreteModel
+	M      DiscreteModel
+	Sub    string    // named substitution model (JC69, K80, F81, HKY85, TN93, TIM, TVM, GTR)
+	Params []float64 // free exchangeabilities of Sub
 }
 
 func NewDNAModel() *DNAModel {
@@ -63,5 +71,168 @@ func (d *DNAModel) DeepCopyDNAModel() *DNAModel {
 	outm.M.R = mat.NewDense(4, 4, nil)
 	outm.M.R.Copy(d.M.R)
 	outm.M.Q.Copy(d.M.Q)
+	outm.Sub = d.Sub
+	outm.Params = append([]float64{}, d.Params...)
 	return outm
 }
+
+// nucleotide substitution models. these are all GTR with constraints
+const (
+	JC69  = "JC69"
+	K80   = "K80"
+	F81   = "F81"
+	HKY85 = "HKY85"
+	TN93  = "TN93"
+	TIM   = "TIM"
+	TVM   = "TVM"
+	GTR   = "GTR"
+)
+
+// nucModelFreeRates the number of free exchangeabilities for each model
+// and whether the base frequencies are free (not all equal)
+var nucModelFreeRates = map[string]int{JC69: 0, K80: 1, F81: 0, HKY85: 1, TN93: 2, TIM: 3, TVM: 4, GTR: 5}
+var nucModelFreeBF = map[string]bool{JC69: false, K80: false, F81: true, HKY85: true, TN93: true,
+	TIM: true, TVM: true, GTR: true}
+
+// NewNucModel get a DNAModel for one of the named models (JC69, K80, F81, HKY85,
+// TN93, TIM, TVM, GTR). the params are the free exchangeabilities
+//
+//	K80, HKY85: kappa
+//	TN93: purine (AG) and pyrimidine (CT) transitions
+//	TIM: AG, CT, and the AT=CG transversions (AC=GT=1)
+//	TVM: the AG=CT transitions, AC, AT, and CG (GT=1)
+//	GTR: AC, AG, CG, AT, CT (GT=1) like SetRateMatrix
+//
+// if params is nil they all start at 1. bf is ignored for JC69 and K80 which have
+// equal base frequencies
+func NewNucModel(name string, params []float64, bf []float64) (*DNAModel, error) {
+	nr, ok := nucModelFreeRates[name]
+	if !ok {
+		return nil, errors.New("unknown nucleotide model " + name)
+	}
+	if params == nil {
+		params = make([]float64, nr)
+		for i := range params {
+			params[i] = 1.0
+		}
+	}
+	if len(params) != nr {
+		return nil, fmt.Errorf("%s has %d rate parameters, not %d", name, nr, len(params))
+	}
+	d := NewDNAModel()
+	d.Sub = name
+	if nucModelFreeBF[name] {
+		if len(bf) != 4 {
+			return nil, errors.New(name + " needs 4 base frequencies")
+		}
+		d.M.SetBaseFreqs(bf)
+	} else {
+		d.M.SetBaseFreqs([]float64{0.25, 0.25, 0.25, 0.25})
+	}
+	d.SetNucModelParams(params)
+	return d, nil
+}
+
+// NewJC69Model equal rates and equal base frequencies
+func NewJC69Model() *DNAModel {
+	d, _ := NewNucModel(JC69, nil, nil)
+	return d
+}
+
+// NewK80Model transition/transversion ratio with equal base frequencies
+func NewK80Model(kappa float64) *DNAModel {
+	d, _ := NewNucModel(K80, []float64{kappa}, nil)
+	return d
+}
+
+// NewF81Model equal rates with base frequencies
+func NewF81Model(bf []float64) *DNAModel {
+	d, err := NewNucModel(F81, nil, bf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return d
+}
+
+// NewHKY85Model transition/transversion ratio with base frequencies
+func NewHKY85Model(kappa float64, bf []float64) *DNAModel {
+	d, err := NewNucModel(HKY85, []float64{kappa}, bf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return d
+}
+
+// NewTN93Model separate purine and pyrimidine transition rates with base frequencies
+func NewTN93Model(kappaR float64, kappaY float64, bf []float64) *DNAModel {
+	d, err := NewNucModel(TN93, []float64{kappaR, kappaY}, bf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return d
+}
+
+// NewTIMModel params are AG, CT, and AT=CG
+func NewTIMModel(params []float64, bf []float64) *DNAModel {
+	d, err := NewNucModel(TIM, params, bf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return d
+}
+
+// NewTVMModel params are AG=CT, AC, AT, and CG
+func NewTVMModel(params []float64, bf []float64) *DNAModel {
+	d, err := NewNucModel(TVM, params, bf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return d
+}
+
+// SetNucModelParams set the free exchangeabilities of the model in Sub and setup Q
+func (d *DNAModel) SetNucModelParams(params []float64) {
+	d.Params = append([]float64{}, params...)
+	d.M.SetRateMatrix(nucModelGTRRates(d.Sub, params))
+	d.M.SetupQGTR()
+}
+
+// nucModelGTRRates the five GTR rates (AC, AG, CG, AT, CT with GT=1) for the model
+func nucModelGTRRates(name string, p []float64) []float64 {
+	switch name {
+	case K80, HKY85:
+		return []float64{1.0, p[0], 1.0, 1.0, p[0]}
+	case TN93:
+		return []float64{1.0, p[0], 1.0, 1.0, p[1]}
+	case TIM:
+		return []float64{1.0, p[0], p[2], p[2], p[1]}
+	case TVM:
+		return []float64{p[1], p[0], p[3], p[2], p[0]}
+	case GTR:
+		return []float64{p[0], p[1], p[2], p[3], p[4]}
+	}
+	return []float64{1.0, 1.0, 1.0, 1.0, 1.0}
+}
+
+// NumParams the number of free parameters (exchangeabilities, 3 for the base
+// frequencies if they aren't equal, 1 each for gamma and invariable sites, and 2k-2
+// for FreeRate) for CalcAIC and CalcBIC
+func (d *DNAModel) NumParams() int {
+	name := d.Sub
+	if len(name) == 0 {
+		name = GTR
+	}
+	k := nucModelFreeRates[name]
+	if nucModelFreeBF[name] {
+		k += 3
+	}
+	if d.M.RateWeights != nil {
+		k += 2*d.M.GammaNCats - 2
+	} else if d.M.GammaNCats > 1 {
+		k++
+	}
+	if d.M.PInv > 0 {
+		k++
+	}
+	return k
+}
@@ -0,0 +1,39 @@
+package gophy_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func TestNucModels(t *testing.T) {
+	tr := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	seqs, patternsint, _, bf := gophy.ReadPatternsSeqsFromFile("test_files/10tips.nuc.fa", true)
+	patternval, _ := gophy.PreparePatternVecs(tr, patternsint, seqs)
+	// HKY85 with kappa 1 is F81 which is GTR with all rates 1
+	hky := gophy.NewHKY85Model(1.0, bf)
+	lnl := gophy.PCalcLikePatterns(tr, &hky.M, patternval, 2)
+	if math.Round(lnl*1000)/1000 != -4570.796 {
+		t.Error(lnl)
+	}
+	np := map[*gophy.DNAModel]int{gophy.NewJC69Model(): 0, gophy.NewK80Model(2.0): 1, gophy.NewF81Model(bf): 3,
+		hky: 4, gophy.NewTN93Model(2.0, 3.0, bf): 5, gophy.NewTIMModel([]float64{2, 3, 0.5}, bf): 6,
+		gophy.NewTVMModel([]float64{2, 0.5, 0.5, 0.5}, bf): 7}
+	for m, k := range np {
+		if m.NumParams() != k {
+			t.Error(m.Sub, m.NumParams(), k)
+		}
+	}
+	if _, err := gophy.NewNucModel(gophy.TN93, []float64{1.0}, bf); err == nil {
+		t.Error("expected an error for the wrong number of parameters")
+	}
+	gophy.OptimizeNucModel(tr, hky, patternval, 2)
+	lnl2 := gophy.PCalcLikePatterns(tr, &hky.M, patternval, 2)
+	if lnl2 < lnl || hky.Params[0] == 1.0 {
+		t.Error(lnl, lnl2, hky.Params)
+	}
+	if gophy.CalcAIC(lnl2, float64(hky.NumParams())) != 2*4-2*lnl2 {
+		t.Error("aic")
+	}
+}
@@ -0,0 +1,112 @@
+package gophy
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"gonum.org/v1/gonum/optimize"
+)
+
+/*
+ Pagel's (1994) test of correlated evolution between two binary characters a
+ and b. They are combined into one four state character (2*a+b, so 00, 01, 10,
+ 11) where only one of them can change at a time. The independent model has a
+ rate for each character and direction (4) and the dependent one has a rate for
+ each allowed change (8).
+*/
+
+// PagelCombine the four state character for the two binary characters. -, ?,
+// and N are missing and if just one is missing it is ambiguous (e.g., 0/2)
+func PagelCombine(a string, b string) string {
+	miss := func(s string) bool {
+		return s == "-" || s == "?" || s == "N"
+	}
+	switch {
+	case miss(a) && miss(b):
+		return "-"
+	case miss(a):
+		return b + "/" + fmt.Sprint(2+int(b[0]-'0'))
+	case miss(b):
+		return fmt.Sprint(2*int(a[0]-'0')) + "/" + fmt.Sprint(2*int(a[0]-'0')+1)
+	}
+	return fmt.Sprint(2*int(a[0]-'0') + int(b[0]-'0'))
+}
+
+// PagelMask the allowed changes between the four states (for SetupQMkMask)
+func PagelMask() [][]bool {
+	mask := make([][]bool, 4)
+	for i := range mask {
+		mask[i] = make([]bool, 4)
+		for j := range mask[i] {
+			// one character changes
+			mask[i][j] = i^j == 1 || i^j == 2
+		}
+	}
+	return mask
+}
+
+// PagelIndependentRates the eight rates (in the order of SetupQMkMask with
+// PagelMask) for the independent model with a01, a10, b01, b10
+func PagelIndependentRates(a01, a10, b01, b10 float64) []float64 {
+	return []float64{b01, a01, b10, a01, a10, b01, a10, b10}
+}
+
+// OptimizePagelModel optimize the independent (4 rates, dep false) or dependent
+// (8 rates) model for the four state data in x (see PagelCombine). rates are the
+// starting rates and the result (4 or 8). Returns the lnL
+func OptimizePagelModel(t *Tree, x *DiscreteModel, rates []float64, dep bool,
+	patternvals []float64, wks int) float64 {
+	mask := PagelMask()
+	setp := func(mds []float64) {
+		if dep {
+			x.SetupQMkMask(mds, mask, false)
+		} else {
+			x.SetupQMkMask(PagelIndependentRates(mds[0], mds[1], mds[2], mds[3]), mask, false)
+		}
+	}
+	fcn := func(mds []float64) float64 {
+		for _, v := range mds {
+			if v < 0 || v > 1000 {
+				return 1000000000000
+			}
+		}
+		setp(mds)
+		lnl := PCalcLogLikePatterns(t, x, patternvals, wks)
+		if math.IsNaN(lnl) {
+			return 1000000000000
+		}
+		return -lnl
+	}
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, rates, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	copy(rates, res.X)
+	setp(rates)
+	return -res.F
+}
+
+// AICWeights the Akaike weights for the AIC values
+func AICWeights(aics []float64) []float64 {
+	best := math.Inf(1)
+	for _, a := range aics {
+		best = math.Min(best, a)
+	}
+	w := make([]float64, len(aics))
+	sum := 0.
+	for i, a := range aics {
+		w[i] = math.Exp(-0.5 * (a - best))
+		sum += w[i]
+	}
+	for i := range w {
+		w[i] /= sum
+	}
+	return w
+}
@@ -0,0 +1,128 @@
+// pagel is Pagel's (1994) test of correlated evolution between two binary
+// characters. It fits the independent and dependent models on the tree and
+// reports the likelihoods, the likelihood ratio test, and the AIC weights.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/FePhyFoFum/gophy"
+	"gonum.org/v1/gonum/mat"
+)
+
+var pagelStates = []string{"00", "01", "10", "11"}
+
+// optimize until it stops getting better
+func fit(t *gophy.Tree, x *gophy.DiscreteModel, rates []float64, dep bool, patternval []float64, wks int) float64 {
+	lnl := gophy.OptimizePagelModel(t, x, rates, dep, patternval, wks)
+	for i := 0; i < 20; i++ {
+		nlnl := gophy.OptimizePagelModel(t, x, rates, dep, patternval, wks)
+		if nlnl-lnl < 0.001 {
+			return nlnl
+		}
+		lnl = nlnl
+	}
+	return lnl
+}
+
+func printQ(Q *mat.Dense) {
+	fmt.Println("    " + strings.Join(pagelStates, "         "))
+	for i := 0; i < 4; i++ {
+		fmt.Print(pagelStates[i])
+		for j := 0; j < 4; j++ {
+			fmt.Printf(" %9.5f", Q.At(i, j))
+		}
+		fmt.Print("\n")
+	}
+}
+
+func main() {
+	tfn := flag.String("t", "", "tree filename")
+	afn := flag.String("s", "", "multistate seq filename")
+	chs := flag.String("c", "1,2", "the two binary characters (starting at 1)")
+	wks := flag.Int("w", 4, "number of threads")
+	flag.Parse()
+	if len(*tfn) == 0 || len(*afn) == 0 {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	cs := strings.Split(*chs, ",")
+	if len(cs) != 2 {
+		fmt.Fprintln(os.Stderr, "need two characters (-c 1,2)")
+		os.Exit(1)
+	}
+	c1, err1 := strconv.Atoi(strings.TrimSpace(cs[0]))
+	c2, err2 := strconv.Atoi(strings.TrimSpace(cs[1]))
+	if err1 != nil || err2 != nil || c1 < 1 || c2 < 1 || c1 == c2 {
+		fmt.Fprintln(os.Stderr, "problem with the characters", *chs)
+		os.Exit(1)
+	}
+
+	t := gophy.ReadTreeFromFile(*tfn)
+	if t == nil {
+		os.Exit(1)
+	}
+	mseqs, _ := gophy.ReadMSeqsFromFile(*afn)
+	seqs := map[string][]string{}
+	for _, s := range mseqs {
+		if c1 > len(s.SQs) || c2 > len(s.SQs) {
+			fmt.Fprintln(os.Stderr, "there are only", len(s.SQs), "characters")
+			os.Exit(1)
+		}
+		a, b := strings.ToUpper(s.SQs[c1-1]), strings.ToUpper(s.SQs[c2-1])
+		for _, v := range []string{a, b} {
+			if v != "0" && v != "1" && v != "-" && v != "?" && v != "N" {
+				fmt.Fprintln(os.Stderr, s.NM, "has", v, "but the characters should be binary")
+				os.Exit(1)
+			}
+		}
+		seqs[s.NM] = []string{gophy.PagelCombine(a, b)}
+	}
+	for _, n := range t.Tips {
+		if _, ok := seqs[n.Nam]; !ok {
+			fmt.Fprintln(os.Stderr, "no sequence for", n.Nam)
+			os.Exit(1)
+		}
+	}
+
+	x := gophy.NewMultStateModel(4)
+	x.M.SetBaseFreqs([]float64{0.25, 0.25, 0.25, 0.25})
+	patternval, _ := gophy.PreparePatternVecsMS(t, map[int]float64{0: 1}, seqs, x.M.CharMap, 4)
+	// start with about one change over the tree
+	tl := 0.
+	for _, n := range t.Post {
+		if n != t.Rt {
+			tl += n.Len
+		}
+	}
+	st := 1. / tl
+	irates := []float64{st, st, st, st}
+	ilnl := fit(t, &x.M, irates, false, patternval, *wks)
+	iQ := mat.DenseCopyOf(x.M.Q)
+	drates := gophy.PagelIndependentRates(irates[0], irates[1], irates[2], irates[3])
+	dlnl := fit(t, &x.M, drates, true, patternval, *wks)
+	if dlnl < ilnl {
+		// the dependent model includes the independent one
+		drates = gophy.PagelIndependentRates(irates[0], irates[1], irates[2], irates[3])
+		x.M.SetupQMkMask(drates, gophy.PagelMask(), false)
+		dlnl = ilnl
+	}
+	dQ := mat.DenseCopyOf(x.M.Q)
+
+	stat, p := gophy.LikelihoodRatioTest(ilnl, dlnl, 4)
+	iaic := gophy.CalcAIC(ilnl, 4)
+	daic := gophy.CalcAIC(dlnl, 8)
+	w := gophy.AICWeights([]float64{iaic, daic})
+	fmt.Printf("model        lnL          k   AIC          AICw\n")
+	fmt.Printf("independent  %-12.5f 4   %-12.5f %.4f\n", ilnl, iaic, w[0])
+	fmt.Printf("dependent    %-12.5f 8   %-12.5f %.4f\n", dlnl, daic, w[1])
+	fmt.Printf("LRT: %.5f df: 4 p: %.5g\n", stat, p)
+	fmt.Printf("\nindependent Q (states are character %d then %d)\n", c1, c2)
+	printQ(iQ)
+	fmt.Println("\ndependent Q")
+	printQ(dQ)
+}
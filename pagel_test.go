@@ -0,0 +1,61 @@
+package gophy_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func TestPagelCombine(t *testing.T) {
+	tests := [][3]string{{"0", "0", "0"}, {"0", "1", "1"}, {"1", "0", "2"}, {"1", "1", "3"},
+		{"?", "1", "1/3"}, {"1", "-", "2/3"}, {"N", "?", "-"}}
+	for _, tc := range tests {
+		if c := gophy.PagelCombine(tc[0], tc[1]); c != tc[2] {
+			t.Error(tc, c)
+		}
+	}
+}
+
+func TestPagel(t *testing.T) {
+	tr := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	// neighbouring binary sites as pairs of characters
+	mseqs, bseqs := binaryTestData()
+	seqs := map[string][]string{}
+	var pseqs []gophy.MSeq
+	for _, m := range mseqs {
+		p := gophy.MSeq{NM: m.NM}
+		for i := 0; i+1 < len(m.SQs); i += 2 {
+			p.SQs = append(p.SQs, gophy.PagelCombine(bseqs[m.NM][i], bseqs[m.NM][i+1]))
+		}
+		pseqs = append(pseqs, p)
+		seqs[m.NM] = p.SQs
+	}
+	x := gophy.NewMultStateModel(4)
+	x.M.SetBaseFreqs([]float64{0.25, 0.25, 0.25, 0.25})
+	_, patternsint, _, _, _, _ := gophy.GetSitePatternsMS(pseqs, x.M.CharMap, 4)
+	pv, _ := gophy.PreparePatternVecsMS(tr, patternsint, seqs, x.M.CharMap, 4)
+
+	x.M.SetupQMkMask(gophy.PagelIndependentRates(0.5, 1.5, 2.0, 0.7), gophy.PagelMask(), false)
+	if x.M.Q.At(0, 3) != 0 || x.M.Q.At(1, 2) != 0 || x.M.Q.At(0, 2) != 0.5 || x.M.Q.At(3, 1) != 1.5 ||
+		x.M.Q.At(2, 3) != 2.0 || x.M.Q.At(1, 0) != 0.7 {
+		t.Fatal("independent Q", x.M.Q)
+	}
+	irates := []float64{1, 1, 1, 1}
+	ilnl := gophy.OptimizePagelModel(tr, &x.M, irates, false, pv, 2)
+	if l := gophy.PCalcLogLikePatterns(tr, &x.M, pv, 2); math.Abs(l-ilnl) > 1e-6 {
+		t.Error("the model should be left at the optimum", l, ilnl)
+	}
+	drates := gophy.PagelIndependentRates(irates[0], irates[1], irates[2], irates[3])
+	dlnl := gophy.OptimizePagelModel(tr, &x.M, drates, true, pv, 2)
+	if len(drates) != 8 || dlnl < ilnl-1e-6 {
+		t.Error("the dependent model should be at least as good", ilnl, dlnl)
+	}
+	w := gophy.AICWeights([]float64{gophy.CalcAIC(ilnl, 4), gophy.CalcAIC(dlnl, 8)})
+	if math.Abs(w[0]+w[1]-1) > 1e-12 {
+		t.Error("AIC weights", w)
+	}
+	if w := gophy.AICWeights([]float64{10, 10 + 2*math.Log(3)}); math.Abs(w[0]-0.75) > 1e-12 {
+		t.Error("AIC weights", w)
+	}
+}
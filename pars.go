@@ -3,6 +3,8 @@ package gophy
 import (
 	"math"
 	"strconv"
+
+	"gonum.org/v1/gonum/floats"
 )
 
 // ParsResult gives the parsimony score (value) for a site
@@ -56,60 +58,91 @@ func CalcSankParsWork(t *Tree, numstates int, jobs <-chan int, results chan<- Pa
 
 // CalcSankParsNode ...
 func CalcSankParsNode(nd *Node, numstates int, site int) {
+	CalcSankParsNodeSteps(nd, numstates, site, nil)
+}
+
+// CalcSankParsNodeSteps the Sankoff costs for the node with the step matrix
+// (unordered if nil)
+func CalcSankParsNodeSteps(nd *Node, numstates int, site int, steps StepMatrix) {
 	for i := 0; i < numstates; i++ {
 		nd.Data[site][i] = 0.
 	}
 	for _, c := range nd.Chs {
-		// tip , assume that the data are 1.0 and not data are 0.0
-		if len(c.Chs) == 0 {
-			for i := 0; i < numstates; i++ {
-				minh := math.MaxFloat64
-				for j := 0; j < numstates; j++ {
-					tempv := 0.0
+		for i := 0; i < numstates; i++ {
+			minh := math.MaxFloat64
+			for j := 0; j < numstates; j++ {
+				tempv := steps.cost(i, j)
+				// tip , assume that the data are 1.0 and not data are 0.0
+				if len(c.Chs) == 0 {
 					if c.Data[site][j] == 0.0 {
-						tempv += math.MaxFloat64
-					} else {
-						if i == j {
-							tempv = 0.0
-						} else {
-							tempv = 1.0
-						}
-					}
-					if tempv < minh {
-						minh = tempv
+						continue
 					}
+				} else {
+					tempv += c.Data[site][j]
+				}
+				if tempv < minh {
+					minh = tempv
 				}
-				nd.Data[site][i] += minh
 			}
-		} else {
-			for i := 0; i < numstates; i++ {
-				minh := math.MaxFloat64
-				for j := 0; j < numstates; j++ {
-					tempv := 0.0
-					if i != j {
-						tempv += 1.0
+			nd.Data[site][i] += minh
+		}
+	}
+}
+
+// PCalcSankParsPatternsSteps parallel Sankoff parsimony with a step matrix for
+// each pattern (see PatternStepMatrices), nil ones are unordered
+func PCalcSankParsPatternsSteps(t *Tree, numstates int, patternval []float64, steps []StepMatrix,
+	wks int) (fl float64) {
+	fl = 0.0
+	nsites := len(patternval)
+	jobs := make(chan int, nsites)
+	results := make(chan ParsResult, nsites)
+	for i := 0; i < wks; i++ {
+		go func() {
+			for j := range jobs {
+				for _, n := range t.Post {
+					if len(n.Chs) > 0 {
+						CalcSankParsNodeSteps(n, numstates, j, steps[j])
 					}
-					tempv += c.Data[site][j]
-					if tempv < minh {
-						minh = tempv
+				}
+				sl := math.MaxFloat64
+				for _, v := range t.Rt.Data[j] {
+					if v < sl {
+						sl = v
 					}
 				}
-				nd.Data[site][i] += minh
+				results <- ParsResult{value: sl, site: j}
 			}
-		}
+		}()
+	}
+	for i := 0; i < nsites; i++ {
+		jobs <- i
 	}
+	close(jobs)
+	rr := ParsResult{}
+	for i := 0; i < nsites; i++ {
+		rr = <-results
+		fl += (rr.value * patternval[rr.site])
+	}
+	return
 }
 
 // CalcSankParsAncStateSingleSite ....
 // assumes bifurcating for now
 func CalcSankParsAncStateSingleSite(t *Tree, numstates int, site int) {
+	CalcSankParsAncStateSingleSiteSteps(t, numstates, site, nil)
+}
+
+// CalcSankParsAncStateSingleSiteSteps the ancestral states with the step matrix
+// (unordered if nil) used for the Sankoff costs of the site
+func CalcSankParsAncStateSingleSiteSteps(t *Tree, numstates int, site int, steps StepMatrix) {
 	ancmaps := make(map[*Node][]int) // ancestral states are stored here
 	for _, n := range t.Pre {
 		if len(n.Chs) == 0 {
 			continue
 		}
 		if t.Rt == n {
-			sl := MinF(n.Data[site])
+			sl := floats.Min(n.Data[site]) // MinF sorts in place
 			states := make([]int, 0)
 			//things are stored in n.Data[j][i]
 			for i := 0; i < numstates; i++ {
@@ -122,13 +155,10 @@ func CalcSankParsAncStateSingleSite(t *Tree, numstates int, site int) {
 		//
 		c1v := make(map[int]bool)
 		c2v := make(map[int]bool)
-		for m := range ancmaps[n] {
+		for _, m := range ancmaps[n] {
 			v := n.Data[site][m]
 			for i, j := range n.Chs[0].Data[site] {
-				c1 := 0.
-				if m != i {
-					c1 = 1.
-				}
+				c1 := steps.cost(m, i)
 				if len(n.Chs[0].Chs) == 0 {
 					if j != 1 {
 						c1 += math.MaxFloat64
@@ -137,10 +167,7 @@ func CalcSankParsAncStateSingleSite(t *Tree, numstates int, site int) {
 					c1 += j
 				}
 				for k, l := range n.Chs[1].Data[site] {
-					c2 := 0.
-					if m != k {
-						c2 = 1.
-					}
+					c2 := steps.cost(m, k)
 					if len(n.Chs[1].Chs) == 0 {
 						if l != 1 {
 							c2 += math.MaxFloat64
@@ -181,11 +208,22 @@ func CalcSankParsAncStateSingleSite(t *Tree, numstates int, site int) {
 
 // EstParsBL estimate the parsimony branch lengths
 func EstParsBL(t *Tree, numstates int, patternval []float64, totalsites int) {
+	EstParsBLSteps(t, numstates, patternval, nil, totalsites)
+}
+
+// EstParsBLSteps estimate the parsimony branch lengths with the step matrix for
+// each pattern (after PCalcSankParsPatternsSteps). steps can be nil for unordered
+func EstParsBLSteps(t *Tree, numstates int, patternval []float64, steps []StepMatrix,
+	totalsites int) {
 	nsites := len(patternval)
 	for _, n := range t.Post {
 		n.FData["parsbl"] = 0.0
 	}
 	for i := 0; i < nsites; i++ {
+		var sm StepMatrix
+		if steps != nil {
+			sm = steps[i]
+		}
 		for _, n := range t.Pre {
 			if t.Rt == n {
 				minj := 0
@@ -203,31 +241,24 @@ func EstParsBL(t *Tree, numstates int, patternval []float64, totalsites int) {
 					minj := math.MaxInt64
 					minv := math.MaxFloat64
 					for j := 0; j < numstates; j++ {
-						add := 0.
-						if j != from {
-							add += 1.
-						}
+						add := sm.cost(from, j)
 						if (n.Data[i][j] + add) < minv {
 							minj = j
 							minv = (n.Data[i][j] + add)
 						}
 					}
-					if minj != from {
-						n.FData["parsbl"] += 1. * patternval[i]
-					}
+					n.FData["parsbl"] += sm.cost(from, minj) * patternval[i]
 					n.IData["anc"] = minj
 				} else {
-					minj := 0
+					minv := math.MaxFloat64
 					for j := 0; j < numstates; j++ {
-						if n.Data[i][j] == 1.0 {
-							minj = j
-							break
+						if n.Data[i][j] == 1.0 && sm.cost(from, j) < minv {
+							minv = sm.cost(from, j)
 						}
 					}
-					if minj != from {
-						n.FData["parsbl"] += 1. * patternval[i]
+					if minv < math.MaxFloat64 {
+						n.FData["parsbl"] += minv * patternval[i]
 					}
-
 				}
 			}
 		}
@@ -237,3 +268,48 @@ func EstParsBL(t *Tree, numstates int, patternval []float64, totalsites int) {
 		//n.Len = math.Max(0.0, n.FData["parsbl"])
 	}
 }
+
+// StepwiseAdditionParsTree builds a starting tree by adding the taxa in names, in
+// order, to the branch that gives the lowest Sankoff parsimony score. prep should
+// set up the Data for the nodes of the tree it is given and return the pattern
+// weights (e.g., a closure around PreparePatternVecs). The branch lengths are from
+// EstParsBL and the tree is unrooted (a tritomy at the root)
+func StepwiseAdditionParsTree(names []string, numstates int, totalsites int,
+	prep func(*Tree) []float64, wks int) *Tree {
+	rt := newNewickNode(nil)
+	for i := 0; i < len(names) && i < 3; i++ {
+		c := newNewickNode(rt)
+		c.Nam = names[i]
+		rt.addChild(c)
+	}
+	for i := 3; i < len(names); i++ {
+		nd := newNewickNode(nil)
+		nd.Nam = names[i]
+		var edges []*Node
+		for _, n := range rt.PreorderArray() {
+			if n != rt {
+				edges = append(edges, n)
+			}
+		}
+		var best *Node
+		bestsc := math.MaxFloat64
+		for _, e := range edges {
+			mid := graftAbove(e, nd)
+			t := NewTree()
+			t.Instantiate(rt)
+			sc := PCalcSankParsPatterns(t, numstates, prep(t), wks)
+			if sc < bestsc {
+				bestsc = sc
+				best = e
+			}
+			ungraft(mid)
+		}
+		graftAbove(best, nd)
+	}
+	t := NewTree()
+	t.Instantiate(rt)
+	patternval := prep(t)
+	PCalcSankParsPatterns(t, numstates, patternval, wks)
+	EstParsBL(t, numstates, patternval, totalsites)
+	return t
+}
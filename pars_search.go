@@ -0,0 +1,487 @@
+package gophy
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FitchPatterns the states of each tip for each pattern as bits (so there can
+// be up to 32 states) for Fitch parsimony of unordered characters
+type FitchPatterns struct {
+	Names     []string   // the tips
+	Sets      [][]uint32 // [tip][pattern] the states of the tip
+	Weights   []float64  // [pattern]
+	NumStates int
+}
+
+// NewFitchPatterns the state sets of the tips of t from their Data (after
+// PreparePatternVecs or the like, any tree with the tips will do) with the
+// pattern weights. Missing data are all the states
+func NewFitchPatterns(t *Tree, patternval []float64) (*FitchPatterns, error) {
+	if len(t.Tips) < 3 {
+		return nil, errors.New("there need to be at least 3 tips")
+	}
+	f := &FitchPatterns{Weights: patternval}
+	for _, n := range t.Tips {
+		if len(n.Data) != len(patternval) {
+			return nil, errors.New(n.Nam + " doesn't have the Data of the patterns")
+		}
+		f.NumStates = len(n.Data[0])
+		if f.NumStates > 32 {
+			return nil, errors.New("there can't be more than 32 states for Fitch parsimony")
+		}
+		sets := make([]uint32, len(patternval))
+		for p, st := range n.Data {
+			for j, v := range st {
+				if v > 0 {
+					sets[p] |= 1 << uint(j)
+				}
+			}
+			if sets[p] == 0 {
+				sets[p] = 1<<uint(f.NumStates) - 1
+			}
+		}
+		f.Names = append(f.Names, n.Nam)
+		f.Sets = append(f.Sets, sets)
+	}
+	return f, nil
+}
+
+// fitchJoin the Fitch set of two sets for each pattern in dst and the weighted
+// number of changes
+func fitchJoin(dst, a, b []uint32, w []float64) (cost float64) {
+	for p := range a {
+		if s := a[p] & b[p]; s != 0 {
+			dst[p] = s
+		} else {
+			dst[p] = a[p] | b[p]
+			cost += w[p]
+		}
+	}
+	return
+}
+
+// fitchCost the weighted number of changes to join a and b, stopping once it is
+// over bound
+func fitchCost(a, b []uint32, w []float64, bound float64) (cost float64) {
+	for p := range a {
+		if a[p]&b[p] == 0 {
+			cost += w[p]
+			if cost > bound {
+				return
+			}
+		}
+	}
+	return
+}
+
+// CalcFitchPars the Fitch parsimony score of t (the tips are matched by name to
+// the ones of f). Polytomies are joined in the order of the children
+func CalcFitchPars(t *Tree, f *FitchPatterns) float64 {
+	index := make(map[string]int, len(f.Names))
+	for i, nm := range f.Names {
+		index[nm] = i
+	}
+	np := len(f.Weights)
+	sets := make(map[*Node][]uint32)
+	score := 0.0
+	for _, n := range postorder(t.Rt) {
+		if len(n.Chs) == 0 {
+			sets[n] = f.Sets[index[n.Nam]]
+			continue
+		}
+		s := append(make([]uint32, 0, np), sets[n.Chs[0]]...)
+		for _, c := range n.Chs[1:] {
+			score += fitchJoin(s, s, sets[c], f.Weights)
+		}
+		sets[n] = s
+	}
+	return score
+}
+
+// ParsCIRI the consistency (CI) and retention (RI) indices of a tree with the
+// score. The fewest and most changes of each pattern just count the tips with
+// one state (not ambiguous or missing). RI is NaN if no pattern is informative
+func ParsCIRI(f *FitchPatterns, score float64) (ci float64, ri float64) {
+	mn, mx := 0.0, 0.0
+	for p, w := range f.Weights {
+		counts := map[uint32]int{}
+		n, most := 0, 0
+		for _, s := range f.Sets {
+			if bits.OnesCount32(s[p]) != 1 {
+				continue
+			}
+			counts[s[p]]++
+			n++
+			if counts[s[p]] > most {
+				most = counts[s[p]]
+			}
+		}
+		if n > 0 {
+			mn += float64(len(counts)-1) * w
+			mx += float64(n-most) * w
+		}
+	}
+	ci = 1.0
+	if score > 0 {
+		ci = mn / score
+	}
+	ri = math.NaN()
+	if mx > mn {
+		ri = (mx - score) / (mx - mn)
+	}
+	return
+}
+
+// fitchTree an unrooted tree for the search as the neighbours of each node. The
+// tips are 0 to len(f.Names)-1 and the rest are the internal nodes
+type fitchTree struct {
+	f   *FitchPatterns
+	adj [][]int
+}
+
+func (t *fitchTree) clone() *fitchTree {
+	c := &fitchTree{f: t.f, adj: make([][]int, len(t.adj))}
+	for i, a := range t.adj {
+		c.adj[i] = append(make([]int, 0, 3), a...)
+	}
+	return c
+}
+
+func (t *fitchTree) replace(n, old, new int) {
+	for i, a := range t.adj[n] {
+		if a == old {
+			t.adj[n][i] = new
+			return
+		}
+	}
+}
+
+// split puts n in the middle of the edge e (nothing is done for a lone tip) so
+// it can be joined to something else
+func (t *fitchTree) split(e [2]int, n int) {
+	if e[1] == -1 {
+		return
+	}
+	t.replace(e[0], e[1], n)
+	t.replace(e[1], e[0], n)
+	t.adj[n] = append(t.adj[n][:0], e[0], e[1])
+}
+
+// insert puts the tip n on the edge e with mid as the node between
+func (t *fitchTree) insert(e [2]int, mid int, n int) {
+	t.split(e, mid)
+	t.adj[mid] = append(t.adj[mid], n)
+	t.adj[n] = []int{mid}
+}
+
+// cut takes off the edge between u and v and removes u and v (if they aren't
+// tips) by joining their other neighbours. The edge that was made on each side
+// (or the lone tip u or v as (u, -1)) is returned
+func (t *fitchTree) cut(u, v int) (ea, eb [2]int) {
+	ntips := len(t.f.Names)
+	side := func(u, v int) [2]int {
+		t.replace(u, v, -1)
+		if u < ntips {
+			t.adj[u] = t.adj[u][:0]
+			return [2]int{u, -1}
+		}
+		var x []int
+		for _, n := range t.adj[u] {
+			if n != -1 {
+				x = append(x, n)
+			}
+		}
+		t.replace(x[0], u, x[1])
+		t.replace(x[1], u, x[0])
+		t.adj[u] = t.adj[u][:0]
+		return [2]int{x[0], x[1]}
+	}
+	return side(u, v), side(v, u)
+}
+
+// edgeSets the Fitch score of the part of the tree with r and the Fitch set of
+// each of its edges (as if the tree was rooted there). A lone tip is one edge
+// (r, -1)
+func (t *fitchTree) edgeSets(r int) (score float64, edges [][2]int, sets [][]uint32) {
+	f := t.f
+	ntips := len(f.Names)
+	if len(t.adj[r]) == 0 {
+		return 0, [][2]int{{r, -1}}, [][]uint32{f.Sets[r]}
+	}
+	np := len(f.Weights)
+	// preorder from r with the parent of each node
+	par := make([]int, len(t.adj))
+	par[r] = -1
+	pre := []int{r}
+	for i := 0; i < len(pre); i++ {
+		for _, c := range t.adj[pre[i]] {
+			if c != par[pre[i]] {
+				par[c] = pre[i]
+				pre = append(pre, c)
+			}
+		}
+	}
+	down := make([][]uint32, len(t.adj))
+	up := make([][]uint32, len(t.adj))
+	chs := func(n int) (ret []int) {
+		for _, c := range t.adj[n] {
+			if c != par[n] {
+				ret = append(ret, c)
+			}
+		}
+		return
+	}
+	for i := len(pre) - 1; i > 0; i-- {
+		n := pre[i]
+		if n < ntips {
+			down[n] = f.Sets[n]
+			continue
+		}
+		c := chs(n)
+		down[n] = make([]uint32, np)
+		score += fitchJoin(down[n], down[c[0]], down[c[1]], f.Weights)
+	}
+	rc := chs(r)
+	if r < ntips {
+		score += fitchCost(f.Sets[r], down[rc[0]], f.Weights, math.Inf(1))
+		up[rc[0]] = f.Sets[r]
+	} else {
+		s := make([]uint32, np)
+		score += fitchJoin(s, down[rc[0]], down[rc[1]], f.Weights)
+		score += fitchCost(s, down[rc[2]], f.Weights, math.Inf(1))
+		for k, c := range rc {
+			up[c] = make([]uint32, np)
+			fitchJoin(up[c], down[rc[(k+1)%3]], down[rc[(k+2)%3]], f.Weights)
+		}
+	}
+	for _, n := range pre[1:] {
+		if n >= ntips {
+			c := chs(n)
+			for k := range c {
+				up[c[k]] = make([]uint32, np)
+				fitchJoin(up[c[k]], up[n], down[c[1-k]], f.Weights)
+			}
+		}
+		s := make([]uint32, np)
+		fitchJoin(s, down[n], up[n], f.Weights)
+		edges = append(edges, [2]int{n, par[n]})
+		sets = append(sets, s)
+	}
+	return
+}
+
+// key the bipartitions of the tree (for finding the same topology)
+func (t *fitchTree) key() string {
+	ntips := len(t.f.Names)
+	below := make([][]uint64, len(t.adj))
+	var splits []string
+	var visit func(n, p int)
+	visit = func(n, p int) {
+		below[n] = make([]uint64, ntips/64+1)
+		if n < ntips {
+			below[n][n/64] |= 1 << uint(n%64)
+		}
+		for _, c := range t.adj[n] {
+			if c != p {
+				visit(c, n)
+				for i, v := range below[c] {
+					below[n][i] |= v
+				}
+			}
+		}
+		if n >= ntips && p != 0 {
+			var sb strings.Builder
+			for _, v := range below[n] {
+				sb.WriteString(strconv.FormatUint(v, 16) + ".")
+			}
+			splits = append(splits, sb.String())
+		}
+	}
+	visit(t.adj[0][0], 0)
+	sort.Strings(splits)
+	return strings.Join(splits, " ")
+}
+
+// randomAddition a stepwise addition tree with the tips added in a random order,
+// each to the edge with the lowest score (a random one of them if there are
+// ties)
+func randomAddition(f *FitchPatterns) *fitchTree {
+	ntips := len(f.Names)
+	t := &fitchTree{f: f, adj: make([][]int, 2*ntips-2)}
+	order := rand.Perm(ntips)
+	mid := ntips
+	for _, n := range order[:3] {
+		t.adj[mid] = append(t.adj[mid], n)
+		t.adj[n] = []int{mid}
+	}
+	for _, n := range order[3:] {
+		_, edges, sets := t.edgeSets(order[0])
+		best := math.Inf(1)
+		var be [2]int
+		ties := 0
+		for i, s := range sets {
+			c := fitchCost(s, f.Sets[n], f.Weights, best)
+			if c < best {
+				best, be, ties = c, edges[i], 1
+			} else if c == best {
+				ties++
+				if rand.Intn(ties) == 0 {
+					be = edges[i]
+				}
+			}
+		}
+		mid++
+		t.insert(be, mid, n)
+	}
+	return t
+}
+
+// tbrRound tries the TBR rearrangements of t (with score), cutting the edges of
+// each node from start on, and returns the first better tree found (nil if there
+// isn't one) and the node it was found at. The ones with the same score are sent
+// to same (if it isn't nil)
+func (t *fitchTree) tbrRound(score float64, start int, same func(*fitchTree)) (*fitchTree, float64, int) {
+	eps := 1e-9
+	for k := range t.adj {
+		u := (start + k) % len(t.adj)
+		for _, v := range t.adj[u] {
+			if v < u {
+				continue
+			}
+			c := t.clone()
+			// putting u and v back where they were gives t
+			oa, ob := c.cut(u, v)
+			sa, ea, seta := c.edgeSets(oa[0])
+			sb, eb, setb := c.edgeSets(ob[0])
+			bound := score - sa - sb
+			for i := range ea {
+				for j := range eb {
+					if sameEdge(ea[i], oa) && sameEdge(eb[j], ob) {
+						continue
+					}
+					cost := fitchCost(seta[i], setb[j], t.f.Weights, bound)
+					if cost > bound+eps || (cost > bound-eps && same == nil) {
+						continue
+					}
+					nt := c.clone()
+					nt.split(ea[i], u)
+					nt.split(eb[j], v)
+					nt.adj[u] = append(nt.adj[u], v)
+					nt.adj[v] = append(nt.adj[v], u)
+					if cost < bound-eps {
+						return nt, sa + sb + cost, u
+					}
+					same(nt)
+				}
+			}
+		}
+	}
+	return nil, score, start
+}
+
+// sameEdge e and o are the same edge (either way around)
+func sameEdge(e, o [2]int) bool {
+	return e == o || (e[0] == o[1] && e[1] == o[0])
+}
+
+// tree a Tree with a tritomy at the root (the internal node next to the first
+// tip) without branch lengths
+func (t *fitchTree) tree() *Tree {
+	ntips := len(t.f.Names)
+	var build func(n, p int, par *Node) *Node
+	build = func(n, p int, par *Node) *Node {
+		nd := newNewickNode(par)
+		if n < ntips {
+			nd.Nam = t.f.Names[n]
+		}
+		for _, c := range t.adj[n] {
+			if c != p {
+				nd.addChild(build(c, n, nd))
+			}
+		}
+		return nd
+	}
+	tr := NewTree()
+	tr.Instantiate(build(t.adj[0][0], -1, nil))
+	return tr
+}
+
+// ParsSearch a heuristic search for the most parsimonious (Fitch) trees with
+// nreps random addition sequence replicates each followed by TBR swapping. Up to
+// maxtrees equally parsimonious trees are kept and swapped on for more. The trees
+// (with a tritomy at the root and without branch lengths) and their score are
+// returned
+func ParsSearch(f *FitchPatterns, nreps int, maxtrees int) (trees []*Tree, score float64) {
+	// going on from where the last better tree was found
+	climb := func(t *fitchTree, sc float64) (*fitchTree, float64) {
+		at := 0
+		for {
+			nt, nsc, nat := t.tbrRound(sc, at, nil)
+			if nt == nil {
+				return t, sc
+			}
+			t, sc, at = nt, nsc, nat
+		}
+	}
+	score = math.Inf(1)
+	var pool []*fitchTree
+	keys := map[string]bool{}
+	add := func(t *fitchTree) {
+		if k := t.key(); !keys[k] && len(pool) < maxtrees {
+			keys[k] = true
+			pool = append(pool, t)
+		}
+	}
+	for r := 0; r < nreps; r++ {
+		t := randomAddition(f)
+		sc, _, _ := t.edgeSets(0)
+		t, sc = climb(t, sc)
+		if sc < score-1e-9 {
+			score = sc
+			pool = nil
+			keys = map[string]bool{}
+		}
+		if sc < score+1e-9 {
+			add(t)
+		}
+	}
+	for i := 0; i < len(pool); i++ {
+		nt, nsc, _ := pool[i].tbrRound(score, 0, add)
+		if nt != nil {
+			nt, score = climb(nt, nsc)
+			pool = nil
+			keys = map[string]bool{}
+			add(nt)
+			i = -1
+		}
+	}
+	for _, t := range pool {
+		trees = append(trees, t.tree())
+	}
+	return
+}
+
+// ParsStartTree a most parsimonious tree (from ParsSearch with nreps replicates)
+// with the parsimony branch lengths (EstParsBL) to start the likelihood tools
+// from. prep should set up the Data for the nodes of the tree it is given and
+// return the pattern weights (like for StepwiseAdditionParsTree)
+func ParsStartTree(names []string, numstates int, totalsites int, prep func(*Tree) []float64,
+	nreps int, wks int) (*Tree, error) {
+	st := RandomTree(names, 0.0)
+	f, err := NewFitchPatterns(st, prep(st))
+	if err != nil {
+		return nil, err
+	}
+	trees, _ := ParsSearch(f, nreps, 1)
+	t := trees[0]
+	patternval := prep(t)
+	PCalcSankParsPatterns(t, numstates, patternval, wks)
+	EstParsBL(t, numstates, patternval, totalsites)
+	return t, nil
+}
@@ -0,0 +1,86 @@
+package gophy_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func TestParsSearch(t *testing.T) {
+	rand.Seed(5)
+	var names []string
+	for _, s := range gophy.ReadSeqsFromFile("test_files/10tips.nuc.fa") {
+		names = append(names, s.NM)
+	}
+	seqs, patternsint, nsites, _ := gophy.ReadPatternsSeqsFromFile("test_files/10tips.nuc.fa", true)
+	prep := func(tr *gophy.Tree) []float64 {
+		patternval, _ := gophy.PreparePatternVecs(tr, patternsint, seqs)
+		return patternval
+	}
+	ml := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	f, err := gophy.NewFitchPatterns(ml, prep(ml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same as Sankoff for unordered characters
+	for _, tr := range []*gophy.Tree{ml, gophy.RandomTree(names, 0.1)} {
+		pv := prep(tr)
+		if sk, fp := gophy.PCalcSankParsPatterns(tr, 4, pv, 2), gophy.CalcFitchPars(tr, f); sk != fp {
+			t.Error("Fitch", fp, "Sankoff", sk)
+		}
+	}
+
+	trees, score := gophy.ParsSearch(f, 5, 10)
+	if len(trees) == 0 || len(trees) > 10 {
+		t.Fatal("trees", len(trees))
+	}
+	for _, tr := range trees {
+		if len(tr.Tips) != len(names) || len(tr.Rt.Chs) != 3 || len(tr.Post) != 2*len(names)-2 {
+			t.Fatal(tr.Rt.Newick(false))
+		}
+		if sc := gophy.CalcFitchPars(tr, f); sc != score {
+			t.Error("the score of a tree is", sc, "not", score)
+		}
+	}
+	sw := gophy.StepwiseAdditionParsTree(names, 4, nsites, prep, 2)
+	if sc := gophy.CalcFitchPars(sw, f); score > sc || score > gophy.CalcFitchPars(ml, f) {
+		t.Error("the search is worse than", sc, score)
+	}
+	ci, ri := gophy.ParsCIRI(f, score)
+	if ci <= 0 || ci > 1 || ri <= 0 || ri > 1 {
+		t.Error("CI", ci, "RI", ri)
+	}
+
+	st, err := gophy.ParsStartTree(names, 4, nsites, prep, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range st.Post {
+		if n != st.Rt && (n.Len <= 0 || math.IsNaN(n.Len)) {
+			t.Fatal("no parsimony branch length", st.Rt.Newick(true))
+		}
+	}
+	if sc := gophy.CalcFitchPars(st, f); sc != score {
+		t.Error("the starting tree isn't the most parsimonious", sc, score)
+	}
+}
+
+func TestParsCIRI(t *testing.T) {
+	tr := gophy.NewTree()
+	tr.Instantiate(gophy.ReadNewickString("((a,b),c,(d,e));"))
+	seqs := map[string]string{"a": "AAG", "b": "AAG", "c": "ACG", "d": "CCT", "e": "CAT"}
+	pv, _ := gophy.PreparePatternVecs(tr, map[int]float64{0: 1, 1: 1, 2: 1}, seqs)
+	f, _ := gophy.NewFitchPatterns(tr, pv)
+	sc := gophy.CalcFitchPars(tr, f)
+	// 1 change for the first and last sites and 2 for the second
+	if sc != 4 {
+		t.Fatal("score", sc)
+	}
+	ci, ri := gophy.ParsCIRI(f, sc)
+	// m = 3, g = 2+2+2 = 6
+	if math.Abs(ci-0.75) > 1e-12 || math.Abs(ri-2.0/3.0) > 1e-12 {
+		t.Error("CI", ci, "RI", ri)
+	}
+}
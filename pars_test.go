@@ -0,0 +1,53 @@
+package gophy_test
+
+import (
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func TestStepwiseAdditionParsTree(t *testing.T) {
+	var names []string
+	for _, s := range gophy.ReadSeqsFromFile("test_files/10tips.nuc.fa") {
+		names = append(names, s.NM)
+	}
+	seqs, patternsint, nsites, _ := gophy.ReadPatternsSeqsFromFile("test_files/10tips.nuc.fa", true)
+	prep := func(tr *gophy.Tree) []float64 {
+		patternval, _ := gophy.PreparePatternVecs(tr, patternsint, seqs)
+		return patternval
+	}
+	tr := gophy.StepwiseAdditionParsTree(names, 4, nsites, prep, 2)
+	if len(tr.Tips) != len(names) || len(tr.Rt.Chs) != 3 || len(tr.Post) != 2*len(names)-2 {
+		t.Fatal(tr.Rt.Newick(false))
+	}
+	sc := gophy.PCalcSankParsPatterns(tr, 4, prep(tr), 2)
+	ml := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	if mlsc := gophy.PCalcSankParsPatterns(ml, 4, prep(ml), 2); sc > mlsc {
+		t.Error(sc, mlsc)
+	}
+}
+
+func TestCalcSankParsAncStateSingleSite(t *testing.T) {
+	tr := gophy.NewTree()
+	tr.Instantiate(gophy.ReadNewickString("((a:0.1,b:0.1):0.1,(c:0.1,d:0.1):0.1);"))
+	seqs := map[string][]string{"a": {"2"}, "b": {"2"}, "c": {"2"}, "d": {"0"}}
+	var mseqs []gophy.MSeq
+	for _, nm := range []string{"a", "b", "c", "d"} {
+		mseqs = append(mseqs, gophy.MSeq{NM: nm, SQs: seqs[nm]})
+	}
+	ns := 3
+	charMap := gophy.GetMap(ns)
+	_, patternsint, _, _, _, _ := gophy.GetSitePatternsMS(mseqs, charMap, ns)
+	patternval, _ := gophy.PreparePatternVecsMS(tr, patternsint, seqs, charMap, ns)
+	gophy.PCalcSankParsPatterns(tr, ns, patternval, 1)
+	gophy.CalcSankParsAncStateSingleSite(tr, ns, 0)
+	// the costs at the root are 2, 3, 1 and stay in the order of the states
+	if tr.Rt.Nam != "[&values={2}]" || tr.Rt.Data[0][0] != 2 || tr.Rt.Data[0][2] != 1 {
+		t.Error(tr.Rt.Nam, tr.Rt.Data[0])
+	}
+	for _, c := range tr.Rt.Chs {
+		if c.Nam != "[&values={2}]" {
+			t.Error(c.Nam)
+		}
+	}
+}
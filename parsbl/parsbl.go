@@ -1,6 +1,7 @@
 // parsbl is a tool to apply parsimony branch lengths to a tree. It reads
 // multistate files and so if you have a nucleotide file, you need to give
-// it the -n flag and it will output a multistate file.
+// it the -n flag and it will output a multistate file. Ordered characters can
+// be given with -ord (e.g., -ord 1-5,9) and a step matrix for the others with -sm.
 //
 package main
 
@@ -8,6 +9,7 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"strings"
@@ -46,7 +48,7 @@ func createMultiStateFile(infile string) {
 }
 
 func calcPBL(nsites int, numstates int, mseqs []gophy.MSeq, seqs map[string][]string,
-	trees []*gophy.Tree) {
+	trees []*gophy.Tree, class []int, steps []gophy.StepMatrix) {
 	x := gophy.NewMultStateModel(numstates)
 	bf := gophy.GetEmpiricalBaseFreqsMS(mseqs, numstates)
 	x.M.SetBaseFreqs(bf)
@@ -55,8 +57,11 @@ func calcPBL(nsites int, numstates int, mseqs []gophy.MSeq, seqs map[string][]st
 	// get the site patternas
 	patterns, patternsint, gapsites, constant, uninformative, _ :=
 		gophy.GetSitePatternsMS(mseqs, x.M.GetCharMap(), x.M.GetNumStates())
+	if class != nil {
+		patterns, patternsint = gophy.SplitPatternsByClass(patterns, class)
+	}
 	for _, t := range trees {
-		patternval, _ := gophy.PreparePatternVecsMS(t, patternsint, seqs, x.M.GetCharMap(),
+		patternval, patternvec := gophy.PreparePatternVecsMS(t, patternsint, seqs, x.M.GetCharMap(),
 			x.M.GetNumStates())
 		//this is necessary to get order of the patters in the patternvec since they have no order
 		// this will be used with fullpattern to reconstruct the sequences
@@ -67,14 +72,22 @@ func calcPBL(nsites int, numstates int, mseqs []gophy.MSeq, seqs map[string][]st
 		fmt.Fprintln(os.Stderr, "constant:", len(constant))
 		fmt.Fprintln(os.Stderr, "uninformative:", len(uninformative))
 		// model things
-		gophy.PCalcSankParsPatterns(t, x.M.GetNumStates(), patternval, 1)
-		gophy.EstParsBL(t, x.M.GetNumStates(), patternval, nsites)
+		if class != nil {
+			psteps := gophy.PatternStepMatrices(patternvec, class, steps)
+			fmt.Fprintln(os.Stderr, "pars score:",
+				gophy.PCalcSankParsPatternsSteps(t, x.M.GetNumStates(), patternval, psteps, 1))
+			gophy.EstParsBLSteps(t, x.M.GetNumStates(), patternval, psteps, nsites)
+		} else {
+			fmt.Fprintln(os.Stderr, "pars score:",
+				gophy.PCalcSankParsPatterns(t, x.M.GetNumStates(), patternval, 1))
+			gophy.EstParsBL(t, x.M.GetNumStates(), patternval, nsites)
+		}
 		fmt.Println(t.Rt.Newick(true) + ";")
 	}
 }
 
 func calcPAST(site int, nsites int, numstates int, mseqs []gophy.MSeq, seqs map[string][]string,
-	trees []*gophy.Tree) {
+	trees []*gophy.Tree, class []int, steps []gophy.StepMatrix) {
 	x := gophy.NewMultStateModel(numstates)
 	bf := gophy.GetEmpiricalBaseFreqsMS(mseqs, numstates)
 	x.M.SetBaseFreqs(bf)
@@ -83,8 +96,11 @@ func calcPAST(site int, nsites int, numstates int, mseqs []gophy.MSeq, seqs map[
 	// get the site patternas
 	patterns, patternsint, gapsites, constant, uninformative, _ :=
 		gophy.GetSitePatternsMS(mseqs, x.M.GetCharMap(), x.M.GetNumStates())
+	if class != nil {
+		patterns, patternsint = gophy.SplitPatternsByClass(patterns, class)
+	}
 	for _, t := range trees {
-		patternval, _ := gophy.PreparePatternVecsMS(t, patternsint, seqs, x.M.GetCharMap(),
+		patternval, patternvec := gophy.PreparePatternVecsMS(t, patternsint, seqs, x.M.GetCharMap(),
 			x.M.GetNumStates())
 		//this is necessary to get order of the patters in the patternvec since they have no order
 		// this will be used with fullpattern to reconstruct the sequences
@@ -95,8 +111,14 @@ func calcPAST(site int, nsites int, numstates int, mseqs []gophy.MSeq, seqs map[
 		fmt.Fprintln(os.Stderr, "constant:", len(constant))
 		fmt.Fprintln(os.Stderr, "uninformative:", len(uninformative))
 		// model things
-		gophy.PCalcSankParsPatterns(t, x.M.GetNumStates(), patternval, 1)
-		gophy.CalcSankParsAncStateSingleSite(t, numstates, 0)
+		if class != nil {
+			psteps := gophy.PatternStepMatrices(patternvec, class, steps)
+			gophy.PCalcSankParsPatternsSteps(t, x.M.GetNumStates(), patternval, psteps, 1)
+			gophy.CalcSankParsAncStateSingleSiteSteps(t, numstates, site, psteps[site])
+		} else {
+			gophy.PCalcSankParsPatterns(t, x.M.GetNumStates(), patternval, 1)
+			gophy.CalcSankParsAncStateSingleSite(t, numstates, site)
+		}
 		fmt.Println(t.Rt.Newick(true) + ";")
 	}
 }
@@ -106,6 +128,8 @@ func main() {
 	afn := flag.String("s", "", "seq filename")
 	nuc := flag.Bool("n", false, "nucleotide data?")
 	anc := flag.Bool("a", false, "ancestral states instead")
+	ord := flag.String("ord", "", "ordered characters (e.g., 1-5,9)")
+	smfn := flag.String("sm", "", "step matrix file for the characters that aren't ordered")
 	flag.Parse()
 	if len(*tfn) == 0 {
 		fmt.Fprintln(os.Stderr, "need a tree filename (-t)")
@@ -136,10 +160,43 @@ func main() {
 		nsites = len(i.SQs)
 	}
 
+	// step matrices, 0 is unordered (or -sm) and 1 is ordered
+	var class []int
+	var steps []gophy.StepMatrix
+	if len(*ord) > 0 || len(*smfn) > 0 {
+		class = make([]int, nsites)
+		steps = []gophy.StepMatrix{nil, gophy.OrderedStepMatrix(numstates)}
+		if len(*smfn) > 0 {
+			b, err := ioutil.ReadFile(*smfn)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if steps[0], err = gophy.ParseStepMatrix(string(b)); err != nil {
+				log.Fatal(err)
+			}
+			if len(steps[0]) != numstates {
+				fmt.Fprintln(os.Stderr, "the step matrix should have", numstates, "states")
+				os.Exit(1)
+			}
+		}
+		chars, err := gophy.ParseCharSet(*ord)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, c := range chars {
+			if c >= nsites {
+				fmt.Fprintln(os.Stderr, "ordered character", c+1, "is past the end of the alignment")
+				os.Exit(1)
+			}
+			class[c] = 1
+		}
+		fmt.Fprintln(os.Stderr, len(chars), "ordered characters")
+	}
+
 	//conduct analysis
 	if *anc {
-		calcPAST(0, nsites, numstates, mseqs, seqs, trees)
+		calcPAST(0, nsites, numstates, mseqs, seqs, trees, class, steps)
 	} else {
-		calcPBL(nsites, numstates, mseqs, seqs, trees)
+		calcPBL(nsites, numstates, mseqs, seqs, trees, class, steps)
 	}
 }
@@ -0,0 +1,92 @@
+// parssearch searches for the most parsimonious trees with random addition
+// sequences and TBR swapping (Fitch parsimony for unordered characters)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func main() {
+	afn := flag.String("s", "", "seq filename")
+	st := flag.String("st", "", "sequence type [nuc/aa] (detected if not given)")
+	nreps := flag.Int("r", 10, "number of random addition sequence replicates")
+	maxtrees := flag.Int("m", 100, "most equally parsimonious trees to keep")
+	bl := flag.Bool("bl", false, "estimate the parsimony branch lengths (EstParsBL) for the trees")
+	seed := flag.Int64("seed", 0, "random seed (0 for the time)")
+	wks := flag.Int("w", 4, "number of threads (for the branch lengths)")
+	flag.Parse()
+	if len(*afn) == 0 {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *nreps < 1 || *maxtrees < 1 {
+		fmt.Fprintln(os.Stderr, "there needs to be at least 1 replicate and 1 tree")
+		os.Exit(1)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rand.Seed(*seed)
+	rseqs := gophy.ReadSeqsFromFile(*afn)
+	if len(rseqs) < 4 {
+		fmt.Fprintln(os.Stderr, "there need to be at least 4 sequences")
+		os.Exit(1)
+	}
+	if len(*st) == 0 {
+		if gophy.DetectSeqType(rseqs) == gophy.DNAData {
+			*st = "nuc"
+		} else {
+			*st = "aa"
+		}
+		fmt.Fprintln(os.Stderr, "sequence type:", *st)
+	}
+	if *st != "nuc" && *st != "aa" {
+		fmt.Fprintln(os.Stderr, "sequence type string is not a recognised datatype, please use [nuc/aa]")
+		os.Exit(1)
+	}
+	nuc := *st == "nuc"
+	seqs, patternsint, nsites, _ := gophy.ReadPatternsSeqsFromFile(*afn, nuc)
+	numstates := 4
+	if nuc == false {
+		numstates = 20
+	}
+	prep := func(t *gophy.Tree) []float64 {
+		var patternval []float64
+		if nuc {
+			patternval, _ = gophy.PreparePatternVecs(t, patternsint, seqs)
+		} else {
+			patternval, _ = gophy.PreparePatternVecsProt(t, patternsint, seqs)
+		}
+		return patternval
+	}
+	var names []string
+	for _, s := range rseqs {
+		names = append(names, s.NM)
+	}
+	t := gophy.RandomTree(names, 0.0)
+	f, err := gophy.NewFitchPatterns(t, prep(t))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	trees, score := gophy.ParsSearch(f, *nreps, *maxtrees)
+	ci, ri := gophy.ParsCIRI(f, score)
+	fmt.Fprintln(os.Stderr, "length:", score)
+	fmt.Fprintln(os.Stderr, "trees:", len(trees))
+	fmt.Fprintln(os.Stderr, "CI:", ci)
+	fmt.Fprintln(os.Stderr, "RI:", ri)
+	for _, t := range trees {
+		if *bl {
+			patternval := prep(t)
+			gophy.PCalcSankParsPatterns(t, numstates, patternval, *wks)
+			gophy.EstParsBL(t, numstates, patternval, nsites)
+		}
+		fmt.Println(t.Rt.Newick(*bl) + ";")
+	}
+}
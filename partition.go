@@ -0,0 +1,466 @@
+package gophy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-nlopt/nlopt"
+	"gonum.org/v1/gonum/optimize"
+)
+
+/*
+ Partitioned likelihoods. Each partition has its own model (with its own gamma),
+ its own site patterns, and its own copy of the tree so that the conditionals
+ stored on the nodes don't get mixed up. With linked branch lengths the
+ partition trees get the main tree branch lengths times the partition Rate.
+ With unlinked branch lengths each partition tree has its own branch lengths.
+*/
+
+// Partition a set of alignment columns that share a model
+type Partition struct {
+	Name        string
+	DataType    string  // DNA, BIN, MULTI, ORD, or a protein matrix (a name in ProteinModelNames or a PAML file)
+	Sites       []int   // alignment columns starting at 0
+	Rate        float64 // branch length multiplier when the branch lengths are linked
+	Model       *DiscreteModel
+	Tree        *Tree
+	PatternVals []float64
+}
+
+// PartitionScheme the partitions of an alignment
+type PartitionScheme struct {
+	Parts  []*Partition
+	Linked bool // true if the branch lengths are shared (times the partition Rate)
+}
+
+// ReadPartitionFile reads a RAxML style partition file
+func ReadPartitionFile(fn string) (*PartitionScheme, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParsePartitions(f)
+}
+
+// ParsePartitions reads RAxML style partitions, one per line, like
+// DNA, gene1 = 1-500, 501-900\3 (the \3 is every third site).
+// the sites start at 1 in the file and at 0 in Sites. branch lengths are linked
+func ParsePartitions(r io.Reader) (*PartitionScheme, error) {
+	ps := &PartitionScheme{Linked: true}
+	used := make(map[int]string)
+	scanner := bufio.NewScanner(r)
+	lnum := 0
+	for scanner.Scan() {
+		lnum++
+		ln := strings.TrimSpace(scanner.Text())
+		if len(ln) == 0 || ln[0] == '#' {
+			continue
+		}
+		eq := strings.Index(ln, "=")
+		if eq == -1 {
+			return nil, fmt.Errorf("line %d: no = in partition", lnum)
+		}
+		lhs := strings.Split(ln[:eq], ",")
+		if len(lhs) != 2 {
+			return nil, fmt.Errorf("line %d: partition should start with datatype, name", lnum)
+		}
+		p := &Partition{DataType: strings.TrimSpace(lhs[0]), Name: strings.TrimSpace(lhs[1]), Rate: 1.0}
+		if _, err := os.Stat(p.DataType); err != nil {
+			// not a PAML file
+			p.DataType = strings.ToUpper(p.DataType)
+		}
+		for _, rng := range strings.Split(ln[eq+1:], ",") {
+			sites, err := parseSiteRange(strings.TrimSpace(rng))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lnum, err)
+			}
+			for _, s := range sites {
+				if o, ok := used[s]; ok {
+					return nil, fmt.Errorf("line %d: site %d is in %s and %s", lnum, s+1, o, p.Name)
+				}
+				used[s] = p.Name
+			}
+			p.Sites = append(p.Sites, sites...)
+		}
+		ps.Parts = append(ps.Parts, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ps.Parts) == 0 {
+		return nil, errors.New("no partitions")
+	}
+	return ps, nil
+}
+
+// parseSiteRange reads 5, 1-500, or 1-500\3 and returns the sites starting at 0
+func parseSiteRange(rng string) (sites []int, err error) {
+	step := 1
+	if i := strings.Index(rng, "\\"); i != -1 {
+		if step, err = strconv.Atoi(strings.TrimSpace(rng[i+1:])); err != nil || step < 1 {
+			return nil, errors.New("bad step in " + rng)
+		}
+		rng = rng[:i]
+	}
+	ends := strings.Split(rng, "-")
+	if len(ends) > 2 {
+		return nil, errors.New("bad range " + rng)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(ends[0]))
+	if err != nil {
+		return nil, errors.New("bad range " + rng)
+	}
+	stop := start
+	if len(ends) == 2 {
+		if stop, err = strconv.Atoi(strings.TrimSpace(ends[1])); err != nil {
+			return nil, errors.New("bad range " + rng)
+		}
+	}
+	if start < 1 || stop < start {
+		return nil, errors.New("bad range " + rng)
+	}
+	for i := start; i <= stop; i += step {
+		sites = append(sites, i-1)
+	}
+	return
+}
+
+// NumSites the number of alignment columns in the partition
+func (p *Partition) NumSites() int {
+	return len(p.Sites)
+}
+
+// SetupPartitions splits the alignment, compresses the patterns for each partition,
+// and gives each a copy of t and a starting model. DNA gets GTR with equal rates and
+// empirical frequencies, protein the named matrix with model frequencies, BIN and
+// MULTI (one character per state) get Mk, and ORD (ordered) gets Mk with changes only
+// between adjacent states. Change Model afterwards for something else
+func (ps *PartitionScheme) SetupPartitions(t *Tree, seqs map[string]string) error {
+	nsites := -1
+	for _, s := range seqs {
+		nsites = len(s)
+		break
+	}
+	seqnames := make([]string, len(t.Tips))
+	for i, n := range t.Tips {
+		if _, ok := seqs[n.Nam]; !ok {
+			return errors.New("no sequence for " + n.Nam)
+		}
+		seqnames[i] = n.Nam
+	}
+	for _, p := range ps.Parts {
+		if len(p.Sites) == 0 {
+			return errors.New("no sites in partition " + p.Name)
+		}
+		pseqs := make(map[string]string)
+		for _, nm := range seqnames {
+			b := make([]byte, len(p.Sites))
+			for i, s := range p.Sites {
+				if s >= nsites {
+					return fmt.Errorf("site %d in partition %s is past the end of the alignment", s+1, p.Name)
+				}
+				b[i] = seqs[nm][s]
+			}
+			pseqs[nm] = string(b)
+		}
+		p.Tree = t.DeepCopyTree()
+		if p.Rate == 0 {
+			p.Rate = 1.0
+		}
+		switch p.DataType {
+		case "DNA", "NUC":
+			_, patternsint, _, _, _, _ := GetSitePatterns(pseqs, len(p.Sites), seqnames)
+			p.PatternVals, _ = PreparePatternVecs(p.Tree, patternsint, pseqs)
+			y := NewDNAModel()
+			y.M.SetBaseFreqs(GetEmpiricalBaseFreqs(pseqs))
+			y.M.SetRateMatrix([]float64{1.0, 1.0, 1.0, 1.0, 1.0})
+			y.M.SetupQGTR()
+			p.Model = &y.M
+		case "BIN", "MULTI", "ORD":
+			m := &charMatrix{datatype: StandardData, missing: '?', gap: '-'}
+			for _, nm := range seqnames {
+				m.names = append(m.names, nm)
+				m.cells = append(m.cells, splitCells(pseqs[nm]))
+			}
+			mseqs, numstates := m.mseqs()
+			if p.DataType == "BIN" || numstates < 2 {
+				numstates = 2
+			}
+			charMap := GetMap(numstates)
+			mseqsmap := make(map[string][]string)
+			for _, ms := range mseqs {
+				mseqsmap[ms.NM] = ms.SQs
+			}
+			_, patternsint, _, _, _, _ := GetSitePatternsMS(mseqs, charMap, numstates)
+			p.PatternVals, _ = PreparePatternVecsMS(p.Tree, patternsint, mseqsmap, charMap, numstates)
+			y := NewMultStateModel(numstates)
+			if p.DataType == "ORD" {
+				y.M.SetupQJCMask(OrderedMask(numstates))
+			} else {
+				y.M.SetupQJC()
+			}
+			p.Model = &y.M
+		default:
+			// a protein matrix, built-in or a PAML file
+			y := NewProteinModel()
+			if err := y.SetRateMatrixByName(p.DataType); err != nil {
+				return fmt.Errorf("unknown datatype for partition %s: %v", p.Name, err)
+			}
+			_, patternsint, _, _, _, _ := GetSitePatternsProt(pseqs, len(p.Sites), seqnames)
+			p.PatternVals, _ = PreparePatternVecsProt(p.Tree, patternsint, pseqs)
+			y.M.EBF = GetEmpiricalBaseFreqsProt(pseqs)
+			y.M.SetModelBF()
+			y.M.SetupQGTR()
+			p.Model = &y.M
+		}
+	}
+	return nil
+}
+
+// syncBranchLengths copies the branch lengths of t (times the Rate) to the
+// partition trees when they are linked
+func (ps *PartitionScheme) syncBranchLengths(t *Tree) {
+	if ps.Linked == false {
+		return
+	}
+	for _, p := range ps.Parts {
+		for i, n := range t.Pre {
+			p.Tree.Pre[i].Len = n.Len * p.Rate
+		}
+	}
+}
+
+// NumSites the number of alignment columns in all the partitions
+func (ps *PartitionScheme) NumSites() (n int) {
+	for _, p := range ps.Parts {
+		n += p.NumSites()
+	}
+	return
+}
+
+// PCalcLikePartitions the log likelihood summed over the partitions. t is the tree
+// with the linked branch lengths (it isn't used if the branch lengths are unlinked)
+func PCalcLikePartitions(t *Tree, ps *PartitionScheme, wks int) (fl float64) {
+	ps.syncBranchLengths(t)
+	for _, p := range ps.Parts {
+		fl += PCalcLikePartition(p, wks)
+	}
+	return
+}
+
+// PCalcLikePartition the log likelihood of one partition (with gamma if the model has it)
+func PCalcLikePartition(p *Partition, wks int) float64 {
+	if partitionGamma(p) {
+		return PCalcLikePatternsGamma(p.Tree, p.Model, p.PatternVals, wks)
+	}
+	return PCalcLikePatterns(p.Tree, p.Model, p.PatternVals, wks)
+}
+
+// blDerivatives the first and second derivatives of the log likelihood for the branch
+// length of node (at blen). CalcLikeFrontBack and DecomposeQ have to be done first
+func blDerivatives(node *Node, x *DiscreteModel, patternvals []float64, blen float64) (d1 float64, d2 float64) {
+	numstates := x.NumStates
+	s1probs := node.TpConds
+	s2probs := node.RvConds
+	p := x.GetPCalc(blen)
+	d1p := x.ExpValueFirstD(blen)
+	d2p := x.ExpValueSecondD(blen)
+	for s := range patternvals {
+		templike := 0.
+		tempd1 := 0.
+		tempd2 := 0.
+		for j := 0; j < numstates; j++ {
+			for k := 0; k < numstates; k++ {
+				templike += (s1probs[s][j] * p.At(j, k) * s2probs[s][k] * x.BF[j])
+				tempd1 += (s1probs[s][j] * d1p.At(j, k) * s2probs[s][k] * x.BF[j])
+				tempd2 += (s1probs[s][j] * d2p.At(j, k) * s2probs[s][k] * x.BF[j])
+			}
+		}
+		d1 += ((tempd1 / templike) * patternvals[s])
+		d2 += (((tempd2 / templike) - (math.Pow(tempd1, 2) / math.Pow(templike, 2))) * patternvals[s])
+	}
+	return
+}
+
+// adjustBLNRPartitions NR on the linked branch length of t.Pre[i]. the derivatives
+// are summed over the partitions (with the chain rule for the rates)
+func adjustBLNRPartitions(i int, t *Tree, ps *PartitionScheme, wks int, threshold float64) {
+	node := t.Pre[i]
+	xmin := 10e-8
+	xmax := 2.0
+	if node.Len < xmin || node.Len > xmax {
+		node.Len = 0.1
+	}
+	startLen := node.Len
+	startL := PCalcLikePartitions(t, ps, wks)
+	for _, p := range ps.Parts {
+		CalcLikeFrontBack(p.Model, p.Tree, p.PatternVals)
+		p.Model.DecomposeQ()
+	}
+	for z := 0; z < 10; z++ {
+		bl := node.Len
+		d1 := 0.
+		d2 := 0.
+		for _, p := range ps.Parts {
+			pd1, pd2 := blDerivatives(p.Tree.Pre[i], p.Model, p.PatternVals, bl*p.Rate)
+			d1 += pd1 * p.Rate
+			d2 += pd2 * p.Rate * p.Rate
+		}
+		if (bl - (d1 / d2)) < 0 {
+			node.Len = 10e-12
+			break
+		} else {
+			node.Len = (bl - (d1 / d2))
+		}
+		if math.Abs(d1) < threshold {
+			break
+		}
+	}
+	endL := PCalcLikePartitions(t, ps, wks)
+	if startL > endL || math.IsNaN(endL) {
+		node.Len = startLen
+	}
+}
+
+// partitionGamma whether the likelihood of the partition is calculated with gamma
+func partitionGamma(p *Partition) bool {
+	return p.Model.GammaNCats > 0 && len(p.Model.GammaCats) > 0
+}
+
+// OptimizeBLNRPartitions Newton-Raphson for each branch like OptimizeBLNR. with
+// unlinked branch lengths each partition tree is done on its own. the derivatives
+// don't include gamma so OptimizeGammaBLS (or OptimizeGammaBLSPartitions if the
+// branch lengths are linked) is used instead when a partition has gamma
+func OptimizeBLNRPartitions(t *Tree, ps *PartitionScheme, wks int) {
+	if ps.Linked == false {
+		for _, p := range ps.Parts {
+			if partitionGamma(p) {
+				OptimizeGammaBLS(p.Tree, p.Model, p.PatternVals, wks)
+			} else {
+				OptimizeBLNR(p.Tree, p.Model, p.PatternVals, wks)
+			}
+		}
+		return
+	}
+	for _, p := range ps.Parts {
+		if partitionGamma(p) {
+			OptimizeGammaBLSPartitions(t, ps, wks)
+			return
+		}
+	}
+	for pass := 0; pass < 2; pass++ {
+		for i, c := range t.Pre {
+			if c == t.Rt {
+				continue
+			}
+			adjustBLNRPartitions(i, t, ps, wks, 10e-12)
+		}
+		for j := len(t.Pre) - 1; j >= 0; j-- {
+			if t.Pre[j] == t.Rt {
+				continue
+			}
+			adjustBLNRPartitions(j, t, ps, wks, 10e-12)
+		}
+	}
+	ps.syncBranchLengths(t)
+}
+
+// partitionBLs the branch lengths being optimized. the linked ones are in t
+func (ps *PartitionScheme) partitionBLs(t *Tree) (nds []*Node) {
+	if ps.Linked {
+		return t.Post
+	}
+	for _, p := range ps.Parts {
+		nds = append(nds, p.Tree.Post...)
+	}
+	return
+}
+
+// OptimizeGammaBLSPartitions optimize all branch lengths with the partitioned
+// likelihood (like OptimizeGammaBLS)
+func OptimizeGammaBLSPartitions(t *Tree, ps *PartitionScheme, wks int) {
+	nds := ps.partitionBLs(t)
+	fcn := func(bl []float64) float64 {
+		for _, i := range bl {
+			if i < 0 {
+				return 1000000000000
+			}
+		}
+		for x, n := range nds {
+			n.Len = bl[x]
+		}
+		lnl := PCalcLikePartitions(t, ps, wks)
+		return -lnl
+	}
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	p0 := make([]float64, len(nds))
+	for i, n := range nds {
+		p0[i] = n.Len
+	}
+	res, err := optimize.Minimize(p, p0, nil, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	for x, n := range nds {
+		n.Len = res.X[x]
+	}
+	ps.syncBranchLengths(t)
+}
+
+// OptimizePartitionRates optimize the rate multipliers of linked partitions. the rates
+// are then scaled so their mean (weighted by the number of sites) is 1 and the
+// branch lengths of t take up the difference
+func OptimizePartitionRates(t *Tree, ps *PartitionScheme, wks int) {
+	if ps.Linked == false || len(ps.Parts) < 2 {
+		return
+	}
+	fcn := func(rts, gradient []float64) float64 {
+		for i, p := range ps.Parts {
+			if rts[i] <= 0 {
+				return 1000000000
+			}
+			p.Rate = rts[i]
+		}
+		return -PCalcLikePartitions(t, ps, wks)
+	}
+	p0 := make([]float64, len(ps.Parts))
+	for i, p := range ps.Parts {
+		p0[i] = p.Rate
+	}
+	opt, err := nlopt.NewNLopt(nlopt.LN_BOBYQA, uint(len(p0)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer opt.Destroy()
+	opt.SetMaxEval(1000)
+	opt.SetLowerBounds1(10e-4)
+	opt.SetUpperBounds1(100)
+	opt.SetFtolAbs(10e-5)
+	opt.SetMinObjective(fcn)
+	res, _, err := opt.Optimize(p0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	mean := 0.
+	for i, p := range ps.Parts {
+		p.Rate = res[i]
+		mean += p.Rate * float64(p.NumSites())
+	}
+	mean /= float64(ps.NumSites())
+	for _, p := range ps.Parts {
+		p.Rate /= mean
+	}
+	for _, n := range t.Post {
+		n.Len *= mean
+	}
+	ps.syncBranchLengths(t)
+}
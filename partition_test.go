@@ -0,0 +1,111 @@
+package gophy_test
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestParsePartitions(t *testing.T) {
+	ps, err := gophy.ParsePartitions(strings.NewReader("DNA, gene1 = 1-4, 9-14\\3\n\nWAG, gene2 = 5-8\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.Parts) != 2 || ps.Parts[0].Name != "gene1" || ps.Parts[1].DataType != "WAG" || ps.Linked == false {
+		t.Fatal(ps.Parts)
+	}
+	exp := []int{0, 1, 2, 3, 8, 11}
+	if len(ps.Parts[0].Sites) != len(exp) {
+		t.Fatal(ps.Parts[0].Sites)
+	}
+	for i, s := range exp {
+		if ps.Parts[0].Sites[i] != s {
+			t.Error(ps.Parts[0].Sites)
+		}
+	}
+	if _, err := gophy.ParsePartitions(strings.NewReader("DNA, a = 1-10\nDNA, b = 10-20\n")); err == nil {
+		t.Error("expected an error for overlapping partitions")
+	}
+}
+
+func TestPCalcLikePartitions(t *testing.T) {
+	tr := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	seqs := map[string]string{}
+	nsites := 0
+	for _, s := range gophy.ReadSeqsFromFile("test_files/10tips.nuc.fa") {
+		seqs[s.NM] = s.SQ
+		nsites = len(s.SQ)
+	}
+	half := nsites / 2
+	ps, err := gophy.ParsePartitions(strings.NewReader("DNA, p1 = 1-" + strconv.Itoa(half) + "\nDNA, p2 = " + strconv.Itoa(half+1) + "-" + strconv.Itoa(nsites) + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ps.SetupPartitions(tr, seqs); err != nil {
+		t.Fatal(err)
+	}
+	// with the same model in each partition this is the unpartitioned likelihood
+	_, _, _, bf := gophy.ReadPatternsSeqsFromFile("test_files/10tips.nuc.fa", true)
+	for _, p := range ps.Parts {
+		p.Model.SetBaseFreqs(bf)
+		p.Model.SetupQGTR()
+	}
+	lnl := gophy.PCalcLikePartitions(tr, ps, 2)
+	if math.Round(lnl*1000)/1000 != -4570.796 {
+		t.Error(lnl)
+	}
+	gophy.OptimizeBLNRPartitions(tr, ps, 2)
+	lnl2 := gophy.PCalcLikePartitions(tr, ps, 2)
+	if lnl2 < lnl {
+		t.Error(lnl, lnl2)
+	}
+	ps.Linked = false
+	gophy.OptimizeBLNRPartitions(tr, ps, 2)
+	if lnl3 := gophy.PCalcLikePartitions(tr, ps, 2); lnl3 < lnl2-1e-6 {
+		t.Error(lnl2, lnl3)
+	}
+	// with gamma the branch lengths are fit to the gamma likelihood
+	ps.Linked = true
+	for _, p := range ps.Parts {
+		p.Model.GammaAlpha = 0.5
+		p.Model.GammaNCats = 2
+		p.Model.GammaCats = gophy.GetGammaCats(0.5, 2, false)
+	}
+	lnl4 := gophy.PCalcLikePartitions(tr, ps, 2)
+	gophy.OptimizeBLNRPartitions(tr, ps, 2)
+	if lnl5 := gophy.PCalcLikePartitions(tr, ps, 2); lnl5 < lnl4-1e-6 {
+		t.Error(lnl4, lnl5)
+	}
+}
+
+func TestSetupPartitionsProt(t *testing.T) {
+	tr := gophy.ReadTreeFromFile("test_files/10tips.pep.fa.treefile")
+	seqs := map[string]string{}
+	for _, s := range gophy.ReadSeqsFromFile("test_files/10tips.pep.fa") {
+		seqs[s.NM] = s.SQ
+	}
+	jtt := gophy.NewProteinModel()
+	jtt.SetRateMatrixJTT()
+	fn := writeTestFile(t, "jones.dat", jtt.M.Ex+"\n")
+	ps, err := gophy.ParsePartitions(strings.NewReader("mtREV, p1 = 1-20\n" + fn + ", p2 = 21-40\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Parts[0].DataType != "MTREV" || ps.Parts[1].DataType != fn {
+		t.Fatal(ps.Parts[0].DataType, ps.Parts[1].DataType)
+	}
+	if err = ps.SetupPartitions(tr, seqs); err != nil {
+		t.Fatal(err)
+	}
+	if ps.Parts[0].Model.R.At(1, 0) != 23.18 || !mat.Equal(ps.Parts[1].Model.R, jtt.M.R) {
+		t.Error("the partition models aren't mtREV and the file")
+	}
+	ps, _ = gophy.ParsePartitions(strings.NewReader("notamodel, p1 = 1-20\n"))
+	if err = ps.SetupPartitions(tr, seqs); err == nil {
+		t.Error("expected an error for an unknown datatype")
+	}
+}
@@ -0,0 +1,186 @@
+package gophy
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// DefaultPCacheSize the number of P matrices a PCache keeps by default
+const DefaultPCacheSize = 4096
+
+// pKey a branch length and the rate (of the rate category) it is multiplied by
+type pKey struct {
+	blen float64
+	rate float64
+}
+
+type pEntry struct {
+	P    *mat.Dense
+	used uint64 // the tick when it was last used (atomic)
+}
+
+// PCache a bounded cache of P matrices keyed on the branch length and the rate
+// category that is safe for concurrent use. Reads only take the read lock so
+// the likelihood workers don't wait on each other when the matrices are already
+// there (see FillPCache). The matrices are kept as long as Q is the same (see
+// SetQ) so they carry over between likelihood calls that only change the branch
+// lengths. When it is full the least recently used quarter is evicted
+type PCache struct {
+	mu      sync.RWMutex
+	entries map[pKey]*pEntry
+	max     int
+	tick    uint64    // (atomic)
+	q       []float64 // the Q the matrices are for
+}
+
+// NewPCache get a PCache that holds up to max matrices
+func NewPCache(max int) *PCache {
+	if max < 1 {
+		max = DefaultPCacheSize
+	}
+	return &PCache{entries: make(map[pKey]*pEntry), max: max}
+}
+
+// Get the P for blen and rate if it is there
+func (c *PCache) Get(blen float64, rate float64) (*mat.Dense, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[pKey{blen, rate}]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	atomic.StoreUint64(&e.used, atomic.AddUint64(&c.tick, 1))
+	return e.P, true
+}
+
+// Put the P for blen and rate (evicting if it is full)
+func (c *PCache) Put(blen float64, rate float64, P *mat.Dense) {
+	e := &pEntry{P: P, used: atomic.AddUint64(&c.tick, 1)}
+	c.mu.Lock()
+	c.entries[pKey{blen, rate}] = e
+	if len(c.entries) > c.max {
+		c.evict()
+	}
+	c.mu.Unlock()
+}
+
+// evict the least recently used quarter (needs the write lock)
+func (c *PCache) evict() {
+	type ku struct {
+		k    pKey
+		used uint64
+	}
+	all := make([]ku, 0, len(c.entries))
+	for k, e := range c.entries {
+		all = append(all, ku{k, atomic.LoadUint64(&e.used)})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].used < all[j].used })
+	keep := c.max * 3 / 4
+	if keep < 1 {
+		keep = 1
+	}
+	for _, v := range all[:len(all)-keep] {
+		delete(c.entries, v.k)
+	}
+}
+
+// Len the number of matrices in the cache
+func (c *PCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// Cap the most matrices the cache will hold
+func (c *PCache) Cap() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.max
+}
+
+// Grow the cache to hold at least max matrices
+func (c *PCache) Grow(max int) {
+	c.mu.Lock()
+	if max > c.max {
+		c.max = max
+	}
+	c.mu.Unlock()
+}
+
+// Clear empty the cache
+func (c *PCache) Clear() {
+	c.mu.Lock()
+	c.entries = make(map[pKey]*pEntry)
+	c.q = nil
+	c.mu.Unlock()
+}
+
+// SetQ empty the cache if Q isn't the one the matrices were calculated with.
+// Returns whether they were kept
+func (c *PCache) SetQ(Q *mat.Dense) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var q []float64
+	if Q != nil {
+		r, cl := Q.Dims()
+		q = make([]float64, 0, r*cl)
+		for i := 0; i < r; i++ {
+			q = append(q, Q.RawRowView(i)...)
+		}
+	}
+	if c.q != nil && floats.Equal(q, c.q) {
+		return true
+	}
+	c.entries = make(map[pKey]*pEntry)
+	c.q = q
+	return false
+}
+
+// pRates the rates of the categories used by the likelihood functions (1 without
+// gamma or FreeRate)
+func (d *DiscreteModel) pRates() []float64 {
+	if d.GammaNCats == 0 {
+		return []float64{1.0}
+	}
+	rs := invRateScale(d)
+	rates := make([]float64, len(d.GammaCats))
+	for i, g := range d.GammaCats {
+		rates[i] = g * rs
+	}
+	return rates
+}
+
+// FillPCache calculates the P matrices for every branch of t and every rate
+// category before the workers start so that they only read from the cache. If
+// logged the internal branches get the logged ones (like CalcLogLikeNode). The
+// caches grow if they can't hold them all
+func (d *DiscreteModel) FillPCache(t *Tree, logged bool) {
+	rates := d.pRates()
+	if d.Ps == nil {
+		d.EmptyPDict()
+	}
+	if d.PsL == nil {
+		d.EmptyPLDict()
+	}
+	d.Ps.Grow(len(t.Post) * len(rates))
+	if logged {
+		d.PsL.Grow(len(t.Post) * len(rates))
+	}
+	for _, n := range t.Post {
+		if n == t.Rt || math.IsNaN(n.Len) {
+			continue
+		}
+		for _, r := range rates {
+			if logged && len(n.Chs) > 0 {
+				d.GetPMapLoggedRate(n.Len, r)
+			} else {
+				d.GetPMapRate(n.Len, r)
+			}
+		}
+	}
+}
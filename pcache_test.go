@@ -0,0 +1,152 @@
+package gophy_test
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestPCache(t *testing.T) {
+	c := gophy.NewPCache(8)
+	for i := 0; i < 8; i++ {
+		c.Put(float64(i), 1, mat.NewDense(1, 1, []float64{float64(i)}))
+	}
+	// the rate is part of the key
+	if _, ok := c.Get(0, 2); ok {
+		t.Error("0,2 shouldn't be there")
+	}
+	// use the first ones so the later ones are evicted
+	for i := 0; i < 4; i++ {
+		if P, ok := c.Get(float64(i), 1); !ok || P.At(0, 0) != float64(i) {
+			t.Fatal("missing", i)
+		}
+	}
+	c.Put(8, 1, mat.NewDense(1, 1, nil))
+	if c.Len() > c.Cap() {
+		t.Error("over the cap", c.Len(), c.Cap())
+	}
+	for i := 0; i < 4; i++ {
+		if _, ok := c.Get(float64(i), 1); !ok {
+			t.Error("recently used", i, "was evicted")
+		}
+	}
+	if _, ok := c.Get(4, 1); ok {
+		t.Error("the least recently used should be evicted")
+	}
+	c.Grow(100)
+	if c.Cap() != 100 {
+		t.Error("grow", c.Cap())
+	}
+	c.Clear()
+	if c.Len() != 0 || c.Cap() != 100 {
+		t.Error("clear", c.Len(), c.Cap())
+	}
+
+	// many readers and writers at once (go test -race)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				bl := float64(i % 150)
+				if _, ok := c.Get(bl, float64(w%2)); !ok {
+					c.Put(bl, float64(w%2), mat.NewDense(1, 1, nil))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if c.Len() > c.Cap() {
+		t.Error("over the cap", c.Len(), c.Cap())
+	}
+}
+
+func TestFillPCache(t *testing.T) {
+	tr, x, pv := benchSetup()
+	x.GammaNCats = 4
+	x.GammaCats = gophy.GetGammaCats(0.5, 4, false)
+	x.EmptyPDict()
+	x.FillPCache(tr, false)
+	n := x.Ps.Len()
+	// the likelihood needs the same ones
+	lnl := gophy.PCalcLogLikePatternsGamma(tr, x, pv, 4)
+	if x.Ps.Len() != n {
+		t.Error("the likelihood used other P matrices", n, x.Ps.Len())
+	}
+	// the same as without the cache
+	x.Ps = gophy.NewPCache(1)
+	x.PsL = gophy.NewPCache(1)
+	for _, nd := range tr.Post {
+		if nd == tr.Rt {
+			continue
+		}
+		P := x.GetPMapRate(nd.Len, x.GammaCats[0])
+		var Q mat.Dense
+		Q.Scale(nd.Len*x.GammaCats[0], x.Q)
+		Q.Exp(&Q)
+		if !mat.EqualApprox(P, &Q, 1e-12) {
+			t.Fatal("wrong P for", nd.Len)
+		}
+	}
+	if l := gophy.PCalcLogLikePatternsGamma(tr, x, pv, 4); math.Abs(l-lnl) > 1e-8 {
+		t.Error("the lnL changed", l, lnl)
+	}
+}
+
+func TestPCacheQ(t *testing.T) {
+	tr, x, pv := benchSetup()
+	lnl := gophy.PCalcLikePatterns(tr, x, pv, 2)
+	n := x.Ps.Len()
+	// only a branch length changes so the others are kept
+	tr.Tips[0].Len *= 2
+	gophy.PCalcLikePatterns(tr, x, pv, 2)
+	if x.Ps.Len() != n+1 {
+		t.Error("the P matrices weren't kept", n, x.Ps.Len())
+	}
+	tr.Tips[0].Len /= 2
+	if l := gophy.PCalcLikePatterns(tr, x, pv, 2); l != lnl {
+		t.Error("the lnL changed", lnl, l)
+	}
+	// a new Q empties it
+	x.SetupQJC()
+	jlnl := gophy.PCalcLikePatterns(tr, x, pv, 2)
+	if x.Ps.Len() > n {
+		t.Error("the P matrices of the old Q were kept", n, x.Ps.Len())
+	}
+	x.Ps.Clear()
+	if l := gophy.PCalcLikePatterns(tr, x, pv, 2); l != jlnl {
+		t.Error("the lnL isn't the same as with an empty cache", jlnl, l)
+	}
+	c := gophy.NewPCache(8)
+	if c.SetQ(x.Q) || !c.SetQ(mat.DenseCopyOf(x.Q)) {
+		t.Error("SetQ should keep the matrices for the same Q")
+	}
+}
+
+func benchSetup() (*gophy.Tree, *gophy.DiscreteModel, []float64) {
+	tr := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	seqs, patternsint, _, bf := gophy.ReadPatternsSeqsFromFile("test_files/10tips.nuc.fa", true)
+	patternval, _ := gophy.PreparePatternVecs(tr, patternsint, seqs)
+	x, _ := gophy.NewNucModel(gophy.GTR, []float64{1.2, 3.0, 0.8, 1.1, 2.9}, bf)
+	return tr, &x.M, patternval
+}
+
+func BenchmarkPCalcLikePatterns(b *testing.B) {
+	tr, x, pv := benchSetup()
+	for i := 0; i < b.N; i++ {
+		gophy.PCalcLikePatterns(tr, x, pv, 1)
+	}
+}
+
+func BenchmarkPCalcLogLikePatternsGamma(b *testing.B) {
+	tr, x, pv := benchSetup()
+	x.GammaNCats = 4
+	x.GammaCats = gophy.GetGammaCats(0.5, 4, false)
+	for i := 0; i < b.N; i++ {
+		gophy.PCalcLogLikePatternsGamma(tr, x, pv, 1)
+	}
+}
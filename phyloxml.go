@@ -0,0 +1,266 @@
+package gophy
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ PhyloXML (http://www.phyloxml.org) to and from the Node data
+   name          -> Nam
+   branch_length -> Len
+   confidence    -> FData["confidence"] or FData["confidence_"+type]
+   taxonomy      -> SData["taxonomy_"+field] (id, code, scientific_name, common_name, rank)
+   property      -> FData, IData, or SData based on the datatype
+*/
+
+type phyloXML struct {
+	Phylogenies []phyloXMLPhylogeny `xml:"phylogeny"`
+}
+
+type phyloXMLPhylogeny struct {
+	Name  string         `xml:"name"`
+	Clade *phyloXMLClade `xml:"clade"`
+}
+
+type phyloXMLClade struct {
+	Name             string               `xml:"name"`
+	BranchLength     string               `xml:"branch_length"`
+	BranchLengthAttr string               `xml:"branch_length,attr"`
+	Confidences      []phyloXMLConfidence `xml:"confidence"`
+	Taxonomy         *phyloXMLTaxonomy    `xml:"taxonomy"`
+	Properties       []phyloXMLProperty   `xml:"property"`
+	Clades           []*phyloXMLClade     `xml:"clade"`
+}
+
+type phyloXMLConfidence struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type phyloXMLTaxonomy struct {
+	ID             string `xml:"id"`
+	Code           string `xml:"code"`
+	ScientificName string `xml:"scientific_name"`
+	CommonName     string `xml:"common_name"`
+	Rank           string `xml:"rank"`
+}
+
+type phyloXMLProperty struct {
+	Ref      string `xml:"ref,attr"`
+	Datatype string `xml:"datatype,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// ParsePhyloXML reads all the phylogenies in a PhyloXML document
+func ParsePhyloXML(r io.Reader) (trees []*Tree, err error) {
+	var doc phyloXML
+	if err = xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	trees = make([]*Tree, 0, len(doc.Phylogenies))
+	for _, p := range doc.Phylogenies {
+		if p.Clade == nil {
+			continue
+		}
+		rt, err := phyloXMLCladeToNode(p.Clade, nil)
+		if err != nil {
+			return nil, err
+		}
+		tree := NewTree()
+		tree.Instantiate(rt)
+		tree.Nam = strings.TrimSpace(p.Name)
+		tree.Index = len(trees)
+		trees = append(trees, tree)
+	}
+	return
+}
+
+func phyloXMLCladeToNode(c *phyloXMLClade, par *Node) (nd *Node, err error) {
+	nd = newNewickNode(par)
+	nd.Nam = strings.TrimSpace(c.Name)
+	bl := strings.TrimSpace(c.BranchLength)
+	if len(bl) == 0 {
+		bl = strings.TrimSpace(c.BranchLengthAttr)
+	}
+	if len(bl) > 0 {
+		if nd.Len, err = strconv.ParseFloat(bl, 64); err != nil {
+			return nil, err
+		}
+	}
+	for _, cf := range c.Confidences {
+		key := "confidence"
+		if len(cf.Type) > 0 {
+			key += "_" + cf.Type
+		}
+		setNodeDataTyped(nd, key, strings.TrimSpace(cf.Value), "xsd:double")
+	}
+	if c.Taxonomy != nil {
+		for k, v := range map[string]string{"id": c.Taxonomy.ID, "code": c.Taxonomy.Code,
+			"scientific_name": c.Taxonomy.ScientificName, "common_name": c.Taxonomy.CommonName,
+			"rank": c.Taxonomy.Rank} {
+			if v = strings.TrimSpace(v); len(v) > 0 {
+				nd.SData["taxonomy_"+k] = v
+			}
+		}
+	}
+	for _, p := range c.Properties {
+		setNodeDataTyped(nd, strings.TrimPrefix(p.Ref, "gophy:"), strings.TrimSpace(p.Value), p.Datatype)
+	}
+	for _, cc := range c.Clades {
+		var ch *Node
+		if ch, err = phyloXMLCladeToNode(cc, nd); err != nil {
+			return nil, err
+		}
+		nd.addChild(ch)
+	}
+	return
+}
+
+// setNodeDataTyped puts a value in FData, IData, or SData based on an xsd datatype.
+// If there is no datatype numbers go in FData and everything else in SData.
+// FData and SData keys are added to Annot so NewickAnnotated will write them
+func setNodeDataTyped(nd *Node, key string, val string, datatype string) {
+	dt := datatype
+	if i := strings.Index(dt, ":"); i != -1 {
+		dt = dt[i+1:]
+	}
+	switch dt {
+	case "integer", "int", "long", "short", "byte", "nonNegativeInteger", "positiveInteger":
+		if i, err := strconv.Atoi(val); err == nil {
+			nd.IData[key] = i
+			return
+		}
+		nd.SData[key] = val
+	case "double", "float", "decimal", "":
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			nd.FData[key] = f
+		} else {
+			nd.SData[key] = val
+		}
+	default:
+		nd.SData[key] = val
+	}
+	if StringSliceContains(nd.Annot, key) == false {
+		nd.Annot = append(nd.Annot, key)
+	}
+}
+
+// sortedFKeys, sortedIKeys, and sortedSKeys give the keys of the node data maps
+// in order so the output is stable
+func sortedFKeys(m map[string]float64) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func sortedIKeys(m map[string]int) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func sortedSKeys(m map[string]string) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func xmlEscape(s string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(s))
+	return buffer.String()
+}
+
+// PhyloXMLString returns the trees as a PhyloXML document
+func PhyloXMLString(trees []*Tree) (ret string) {
+	var buffer bytes.Buffer
+	buffer.WriteString(xml.Header)
+	buffer.WriteString("<phyloxml xmlns:xsi=\"http://www.w3.org/2001/XMLSchema-instance\" " +
+		"xsi:schemaLocation=\"http://www.phyloxml.org http://www.phyloxml.org/1.10/phyloxml.xsd\" " +
+		"xmlns=\"http://www.phyloxml.org\">\n")
+	for _, t := range trees {
+		buffer.WriteString("  <phylogeny rooted=\"true\">\n")
+		if len(t.Nam) > 0 {
+			buffer.WriteString("    <name>" + xmlEscape(t.Nam) + "</name>\n")
+		}
+		writePhyloXMLClade(&buffer, t.Rt, "    ")
+		buffer.WriteString("  </phylogeny>\n")
+	}
+	buffer.WriteString("</phyloxml>\n")
+	ret = buffer.String()
+	return
+}
+
+func writePhyloXMLClade(buffer *bytes.Buffer, n *Node, ind string) {
+	buffer.WriteString(ind + "<clade>\n")
+	in := ind + "  "
+	if len(n.Nam) > 0 {
+		buffer.WriteString(in + "<name>" + xmlEscape(n.Nam) + "</name>\n")
+	}
+	if n.Par != nil {
+		buffer.WriteString(in + "<branch_length>" + strconv.FormatFloat(n.Len, 'f', -1, 64) + "</branch_length>\n")
+	}
+	fkeys := sortedFKeys(n.FData)
+	for _, k := range fkeys {
+		if k == "confidence" || strings.HasPrefix(k, "confidence_") {
+			tp := ""
+			if k != "confidence" {
+				tp = " type=\"" + xmlEscape(k[len("confidence_"):]) + "\""
+			}
+			buffer.WriteString(in + "<confidence" + tp + ">" + strconv.FormatFloat(n.FData[k], 'f', -1, 64) + "</confidence>\n")
+		}
+	}
+	skeys := sortedSKeys(n.SData)
+	tax := false
+	for _, k := range []string{"id", "code", "scientific_name", "common_name", "rank"} {
+		if v, ok := n.SData["taxonomy_"+k]; ok {
+			if tax == false {
+				buffer.WriteString(in + "<taxonomy>\n")
+				tax = true
+			}
+			buffer.WriteString(in + "  <" + k + ">" + xmlEscape(v) + "</" + k + ">\n")
+		}
+	}
+	if tax {
+		buffer.WriteString(in + "</taxonomy>\n")
+	}
+	for _, k := range fkeys {
+		if k == "confidence" || strings.HasPrefix(k, "confidence_") {
+			continue
+		}
+		writePhyloXMLProperty(buffer, in, k, "xsd:double", strconv.FormatFloat(n.FData[k], 'f', -1, 64))
+	}
+	for _, k := range sortedIKeys(n.IData) {
+		writePhyloXMLProperty(buffer, in, k, "xsd:integer", strconv.Itoa(n.IData[k]))
+	}
+	for _, k := range skeys {
+		if strings.HasPrefix(k, "taxonomy_") {
+			continue
+		}
+		writePhyloXMLProperty(buffer, in, k, "xsd:string", n.SData[k])
+	}
+	for _, c := range n.Chs {
+		writePhyloXMLClade(buffer, c, in)
+	}
+	buffer.WriteString(ind + "</clade>\n")
+}
+
+func writePhyloXMLProperty(buffer *bytes.Buffer, ind string, key string, datatype string, val string) {
+	ref := key
+	if strings.Contains(ref, ":") == false {
+		ref = "gophy:" + ref
+	}
+	buffer.WriteString(ind + "<property ref=\"" + xmlEscape(ref) + "\" datatype=\"" + datatype +
+		"\" applies_to=\"clade\">" + xmlEscape(val) + "</property>\n")
+}
@@ -121,8 +121,11 @@ func main() {
 	afn := flag.String("s", "", "seq filename")
 	st := flag.String("st", "nuc", "sequence type [nuc/aa/mult]")
 	mdr := flag.String("mdr", "1.0,1.0,1.0,1.0,1.0", "five params for GTR (if sequence type == nuc), or x params (if seq type == mult)")
-	m := flag.String("m", "JTT", "empirical amino acid [JTT/WAG/LG] (if sequence type == aa)")
+	m := flag.String("m", "JTT", "empirical amino acid [JTT/WAG/LG] or a PAML model file (if sequence type == aa)")
 	mbf := flag.String("mbf", "emp", "model base frequencies [mod(el)/emp(irical)] (if sequence type == aa)")
+	asc := flag.String("asc", "", "ascertainment bias correction [lewis/lewisinf/felsenstein/stamatakis] (if sequence type == mult)")
+	ascw := flag.String("ascw", "", "comma separated number of invariant sites left out (one for felsenstein, one per state for stamatakis)")
+	qmask := flag.String("qmask", "", "file with the allowed changes (rows of 0s and 1s) or ordered (if sequence type == mult, -mdr then has a param for each allowed pair but the last)")
 	wks := flag.Int("w", 4, "number of threads")
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
 	flag.Parse()
@@ -159,23 +162,13 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	//read a tree file
-	f, err := os.Open(*tfn)
-	if err != nil {
-		fmt.Println(err)
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	var rt *gophy.Node
-	t := gophy.NewTree()
-	for scanner.Scan() {
-		ln := scanner.Text()
-		if len(ln) < 2 {
-			continue
-		}
-		rt = gophy.ReadNewickString(ln)
-		t.Instantiate(rt)
+	//read a tree file (newick or nexus), the last tree is used
+	trees := gophy.ReadTreesFromFile(*tfn)
+	if len(trees) == 0 {
+		fmt.Fprintln(os.Stderr, "no tree could be read from", *tfn)
+		os.Exit(1)
 	}
+	t := trees[len(trees)-1]
 
 	fmt.Println(t.Rt.Newick(true))
 
@@ -213,14 +206,8 @@ func main() {
 		nsites = ns
 		patternval, _ = gophy.PreparePatternVecsProt(t, patternsint, seqs)
 		y := gophy.NewProteinModel()
-		if *m == "JTT" {
-			y.SetRateMatrixJTT()
-		} else if *m == "WAG" {
-			y.SetRateMatrixWAG()
-		} else if *m == "LG" {
-			y.SetRateMatrixLG()
-		} else {
-			fmt.Fprintln(os.Stderr, "amino acid model string not recognized, please use [JTT/WAG/LG]")
+		if err := y.SetRateMatrixByName(*m); err != nil {
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 		if *mbf == "mod" {
@@ -234,9 +221,22 @@ func main() {
 		seqs, patternsint, ns, bf, numstates := gophy.ReadPatternsMSeqsFromFile(*afn)
 		nsites = ns
 		patternval, _ = gophy.PreparePatternVecsMS(t, patternsint, seqs, gophy.GetMap(numstates), numstates)
+		var mask [][]bool
+		if len(*qmask) > 0 {
+			var err error
+			if mask, err = gophy.ReadQMaskFile(*qmask, numstates); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
 		mds := strings.Split(*mdr, ",")
 		modelparams := make([]float64, len(mds))
-		if len(mds) == (((numstates*numstates)-numstates)/2)-1 {
+		if mask != nil {
+			if len(mds) != gophy.NumMaskRates(mask, true)-1 {
+				fmt.Fprintln(os.Stderr, "your model contains ", len(mds), " params, the mask needs", gophy.NumMaskRates(mask, true)-1)
+				os.Exit(1)
+			}
+		} else if len(mds) == (((numstates*numstates)-numstates)/2)-1 {
 			fmt.Fprintln(os.Stderr, "your model contains ", len(mds), " params, and is symmetric")
 		} else if len(mds) == ((numstates*numstates)-numstates)-1 {
 			fmt.Fprintln(os.Stderr, "your model contains ", len(mds), " params, and is asymmetric")
@@ -253,10 +253,22 @@ func main() {
 			modelparams[i] = f
 		}
 		y := gophy.NewMultStateModel(numstates)
-		y.M.SetScaledRateMatrix(modelparams, true)
+		if mask != nil {
+			y.M.SetScaledRateMatrixMask(modelparams, mask, true)
+		} else {
+			y.M.SetScaledRateMatrix(modelparams, true)
+		}
 		y.M.SetBaseFreqs(bf)
 		y.M.EBF = bf
 		y.M.SetupQGTR()
+		inv, err := gophy.ParseAscInv(*ascw)
+		if err == nil {
+			err = y.M.SetAscBias(*asc, inv, len(t.Tips))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		x = y.M
 	}
 
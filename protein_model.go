@@ -1,11 +1,14 @@
 package gophy
 
 import (
-	"bufio"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"strconv"
 	"strings"
 
+	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -73,33 +76,7 @@ func (d *ProteinModel) SetRateMatrixJTT() {
 	0.076748 0.051691 0.042645 0.051544 0.019803 0.040752 0.061830 0.073152 0.022944 0.053761 0.091904 0.058676 0.023826 0.040126 0.050901 0.068765 0.058565 0.014261 0.032102 0.066005`
 	// from paml jones.dat
 
-	scanner := bufio.NewScanner(strings.NewReader(d.M.Ex))
-	res := [][]float64{}
-	for scanner.Scan() {
-		tmp := []float64{}
-		line := strings.Fields(scanner.Text())
-		for _, j := range line {
-			f, err := strconv.ParseFloat(j, 64)
-			if err != nil {
-				log.Fatal(err)
-			}
-			tmp = append(tmp, f)
-		}
-		res = append(res, tmp)
-	}
-	d.M.R = mat.NewDense(20, 20, nil) // exchangeabilities - let diagonals be 0
-	for i := 0; i < d.M.NumStates; i++ {
-		for j := 0; j < d.M.NumStates; j++ {
-			if i == j {
-				d.M.R.Set(i, j, 0.0)
-			} else if j > i {
-				d.M.R.Set(i, j, res[j-1][i])
-			} else {
-				d.M.R.Set(i, j, res[i-1][j])
-			}
-		}
-	}
-	d.M.MBF = res[20]
+	d.setPAMLRateMatrix()
 }
 
 // SetRateMatrixWAG set up WAG exchangeabilities
@@ -127,33 +104,7 @@ func (d *ProteinModel) SetRateMatrixWAG() {
 	0.0866279 0.043972  0.0390894 0.0570451 0.0193078 0.0367281 0.0580589 0.0832518 0.0244313 0.048466  0.086209  0.0620286 0.0195027 0.0384319 0.0457631 0.0695179 0.0610127 0.0143859 0.0352742 0.0708956`
 	// from PAML wag.dat
 
-	scanner := bufio.NewScanner(strings.NewReader(d.M.Ex))
-	res := [][]float64{}
-	for scanner.Scan() {
-		tmp := []float64{}
-		line := strings.Fields(scanner.Text())
-		for _, j := range line {
-			f, err := strconv.ParseFloat(j, 64)
-			if err != nil {
-				log.Fatal(err)
-			}
-			tmp = append(tmp, f)
-		}
-		res = append(res, tmp)
-	}
-	d.M.R = mat.NewDense(20, 20, nil) // exchangeabilities - let diagonals be 0
-	for i := 0; i < d.M.NumStates; i++ {
-		for j := 0; j < d.M.NumStates; j++ {
-			if i == j {
-				d.M.R.Set(i, j, 0.0)
-			} else if j > i {
-				d.M.R.Set(i, j, res[j-1][i])
-			} else {
-				d.M.R.Set(i, j, res[i-1][j])
-			}
-		}
-	}
-	d.M.MBF = res[20]
+	d.setPAMLRateMatrix()
 }
 
 // SetRateMatrixLG set up LG exchangeabilities
@@ -181,33 +132,343 @@ func (d *ProteinModel) SetRateMatrixLG() {
 	0.079066 0.055941 0.041977 0.053052 0.012937 0.040767 0.071586 0.057337 0.022355 0.062157 0.099081 0.064600 0.022951 0.042302 0.044040 0.061197 0.053287 0.012066 0.034155 0.069147`
 	// lg.dat from paml
 
-	scanner := bufio.NewScanner(strings.NewReader(d.M.Ex))
-	res := [][]float64{}
-	for scanner.Scan() {
-		tmp := []float64{}
-		line := strings.Fields(scanner.Text())
-		for _, j := range line {
-			f, err := strconv.ParseFloat(j, 64)
-			if err != nil {
-				log.Fatal(err)
-			}
-			tmp = append(tmp, f)
+	d.setPAMLRateMatrix()
+}
+
+// SetRateMatrixDayhoff set up Dayhoff exchangeabilities
+func (d *ProteinModel) SetRateMatrixDayhoff() {
+	d.M.Ex = `27
+	98 32
+	120 0 905
+	36 23 0 0
+	89 246 103 134 0
+	198 1 148 1153 0 716
+	240 9 139 125 11 28 81
+	23 240 535 86 28 606 43 10
+	65 64 77 24 44 18 61 0 7
+	41 15 34 0 0 73 11 7 44 257
+	26 464 318 71 0 153 83 27 26 46 18
+	72 90 1 0 0 114 30 17 0 336 527 243
+	18 14 14 0 0 0 0 15 48 196 157 0 92
+	250 103 42 13 19 153 51 34 94 12 32 33 17 11
+	409 154 495 95 161 56 79 234 35 24 17 96 62 46 245
+	371 26 229 66 16 53 34 30 22 192 33 136 104 13 78 550
+	0 201 23 0 0 0 0 0 27 0 46 0 0 76 0 75 0
+	24 8 95 0 96 0 22 0 127 37 28 13 0 698 0 34 42 61
+	208 24 15 18 49 35 37 54 44 889 175 10 258 12 48 30 157 0 28
+
+	0.087127 0.040904 0.040432 0.046872 0.033474 0.038255 0.049530 0.088612 0.033619 0.036886
+	0.085357 0.080481 0.014753 0.039772 0.050680 0.069577 0.058542 0.010494 0.029916 0.064718`
+	// from PAML dayhoff.dat
+
+	d.setPAMLRateMatrix()
+}
+
+// SetRateMatrixMtREV set up mtREV24 exchangeabilities
+func (d *ProteinModel) SetRateMatrixMtREV() {
+	d.M.Ex = `23.18
+	26.95 13.24
+	17.67 1.90 794.38
+	59.93 103.33 58.94 1.90
+	1.90 220.99 173.56 55.28 75.24
+	9.77 1.90 63.05 583.55 1.90 313.56
+	120.71 23.03 53.30 56.77 30.71 6.75 28.28
+	13.90 165.23 496.13 113.99 141.49 582.40 49.12 1.90
+	96.49 1.90 27.10 4.34 62.73 8.34 3.31 5.98 12.26
+	25.46 15.58 15.16 1.90 25.65 39.70 1.90 2.41 11.49 329.09
+	8.36 141.40 608.70 2.31 1.90 465.58 313.86 22.73 127.67 19.57 14.88
+	141.88 1.90 65.41 1.90 6.18 47.37 1.90 1.90 11.97 517.98 537.53 91.37
+	6.37 4.69 15.20 4.98 70.80 19.11 2.67 1.90 48.16 84.67 216.06 6.44 90.82
+	54.31 23.64 73.31 13.43 31.26 137.29 12.83 1.90 60.97 20.63 40.10 50.10 18.84 17.31
+	387.86 6.04 494.39 69.02 277.05 54.11 54.71 125.93 77.46 47.70 73.61 105.79 111.16 64.29 169.90
+	480.72 2.08 238.46 28.01 179.97 94.93 14.82 11.17 44.78 368.43 126.40 136.33 528.17 33.85 128.22 597.21
+	1.90 21.95 10.68 19.86 33.60 1.90 1.90 10.92 7.08 1.90 32.44 24.00 21.71 7.84 4.21 38.58 9.99
+	6.48 1.90 191.36 21.21 254.77 38.82 13.12 3.21 670.14 25.01 44.15 51.17 39.96 465.58 16.21 64.92 38.73 26.25
+	195.06 7.64 1.90 1.90 1.90 19.00 21.14 2.53 1.90 1222.94 91.67 1.90 387.54 6.35 8.23 1.90 204.54 5.37 1.90
+
+	0.072 0.019 0.039 0.019 0.006 0.025 0.024 0.056 0.028 0.088
+	0.169 0.023 0.054 0.061 0.054 0.072 0.086 0.029 0.033 0.043`
+	// from PAML mtREV24.dat
+
+	d.setPAMLRateMatrix()
+}
+
+// SetRateMatrixMtMAM set up mtMAM exchangeabilities
+func (d *ProteinModel) SetRateMatrixMtMAM() {
+	d.M.Ex = `32
+	2 4
+	11 0 864
+	0 186 0 0
+	0 246 8 49 0
+	0 0 0 569 0 274
+	78 18 47 79 0 0 22
+	8 232 458 11 305 550 22 0
+	75 0 19 0 41 0 0 0 0
+	21 6 0 0 27 20 0 0 26 232
+	0 50 408 0 0 242 215 0 0 6 4
+	76 0 21 0 0 22 0 0 0 378 609 59
+	0 0 6 5 7 0 0 0 0 57 246 0 11
+	53 9 33 2 0 51 0 0 53 5 43 18 0 17
+	342 3 446 16 347 30 21 112 20 0 74 65 47 90 202
+	681 0 110 0 114 0 4 0 1 360 34 50 691 8 78 614
+	5 16 6 0 65 0 0 0 0 0 12 0 13 0 7 17 0
+	0 0 156 0 530 54 0 1 1525 16 25 67 0 682 8 107 0 14
+	398 0 0 10 0 33 20 5 0 2220 100 0 832 6 0 0 237 0 0
+
+	0.0692 0.0184 0.0400 0.0186 0.0065 0.0238 0.0236 0.0557 0.0277 0.0905
+	0.1675 0.0221 0.0561 0.0611 0.0536 0.0725 0.0870 0.0293 0.0340 0.0428`
+	// from PAML mtmam.dat
+
+	d.setPAMLRateMatrix()
+}
+
+// SetRateMatrixCpREV set up cpREV exchangeabilities
+func (d *ProteinModel) SetRateMatrixCpREV() {
+	d.M.Ex = `105
+	227 357
+	175 43 4435
+	669 823 538 10
+	157 1745 768 400 10
+	499 152 1055 3691 10 3122
+	665 243 653 431 303 133 379
+	66 715 1405 331 441 1269 162 19
+	145 136 168 10 280 92 148 40 29
+	197 203 113 10 396 286 82 20 66 1745
+	236 4482 2430 412 48 3313 2629 263 305 345 218
+	185 125 61 47 159 202 113 21 10 1772 1351 193
+	68 53 97 22 726 10 145 25 127 454 1268 72 327
+	490 87 173 170 285 323 185 28 152 117 219 302 100 43
+	2440 385 2085 590 2331 396 568 691 303 216 516 868 93 487 1202
+	1340 314 1393 266 576 241 369 92 32 1040 156 918 645 148 260 2151
+	14 230 40 18 435 53 63 82 69 42 159 10 86 468 49 73 29
+	56 323 754 281 1466 391 142 10 1971 89 189 247 215 2370 97 522 71 346
+	968 92 83 75 592 54 200 91 25 4797 865 249 475 317 122 167 760 10 119
+
+	0.0755 0.0621 0.0410 0.0371 0.0091 0.0382 0.0495 0.0838 0.0246 0.0806
+	0.1011 0.0504 0.0220 0.0506 0.0431 0.0622 0.0543 0.0181 0.0307 0.0660`
+	// from PAML cpREV.dat
+
+	d.setPAMLRateMatrix()
+}
+
+// SetRateMatrixRtREV set up rtREV exchangeabilities
+func (d *ProteinModel) SetRateMatrixRtREV() {
+	d.M.Ex = `34
+	51 35
+	10 30 384
+	439 92 128 1
+	32 221 236 78 70
+	81 10 79 542 1 372
+	135 41 94 61 48 18 70
+	30 90 320 91 124 387 34 68
+	1 24 35 1 104 33 1 1 34
+	45 18 15 5 110 54 21 3 51 385
+	38 593 123 20 16 309 141 30 76 34 23
+	235 57 1 1 156 158 1 37 116 375 581 134
+	1 7 49 1 70 1 1 7 141 64 179 14 247
+	97 24 33 55 1 68 52 17 44 10 22 43 1 11
+	460 102 294 136 75 225 95 152 183 4 24 77 1 20 134
+	258 64 148 55 117 146 82 7 49 72 25 110 131 69 62 671
+	5 13 16 1 55 10 17 23 48 39 47 6 111 182 9 14 1
+	55 47 28 1 131 45 1 21 307 26 64 1 74 1017 14 31 34 176
+	197 29 21 6 295 36 35 3 1 1048 112 19 236 92 25 39 196 26 59
+
+	0.0646 0.0453 0.0376 0.0422 0.0114 0.0606 0.0607 0.0639 0.0273 0.0679
+	0.1018 0.0751 0.0150 0.0287 0.0681 0.0488 0.0622 0.0251 0.0318 0.0619`
+	// from PAML rtREV.dat
+
+	d.setPAMLRateMatrix()
+}
+
+// SetRateMatrixVT set up VT exchangeabilities
+func (d *ProteinModel) SetRateMatrixVT() {
+	d.M.Ex = `0.233108
+	0.199097 0.210797
+	0.265145 0.105191 0.883422
+	0.227333 0.031726 0.027495 0.010313
+	0.310084 0.493763 0.275700 0.205842 0.004315
+	0.567957 0.255240 0.270417 1.599461 0.005321 0.960976
+	0.876213 0.156945 0.362028 0.311718 0.050876 0.128660 0.250447
+	0.078692 0.213164 0.290006 0.134252 0.016695 0.315521 0.104458 0.058131
+	0.222972 0.081510 0.087225 0.011720 0.046398 0.054602 0.046589 0.051089 0.020039
+	0.424630 0.192364 0.069245 0.060863 0.091709 0.243530 0.151924 0.087056 0.103552 2.089890
+	0.393245 1.755838 0.503060 0.261101 0.004067 0.738208 0.888630 0.193243 0.153323 0.093181 0.201204
+	0.211550 0.087930 0.057420 0.012182 0.023690 0.120801 0.058643 0.046560 0.021157 0.493186 1.105667 0.096474
+	0.116646 0.042569 0.039769 0.016577 0.051127 0.026235 0.028168 0.050143 0.079807 0.321020 0.946499 0.038261 0.173052
+	0.399143 0.128480 0.083956 0.160063 0.011137 0.156570 0.205134 0.124492 0.078892 0.054797 0.169784 0.212302 0.010363 0.042564
+	1.817198 0.292327 0.847049 0.461519 0.175270 0.358017 0.406035 0.612025 0.167406 0.081567 0.214977 0.400072 0.090515 0.138119 0.430431
+	0.877877 0.204109 0.471268 0.178197 0.079511 0.248992 0.321028 0.136266 0.101117 0.376588 0.243227 0.446646 0.184609 0.085870 0.207143 1.767766
+	0.030309 0.046417 0.010459 0.011393 0.007732 0.021248 0.018844 0.023990 0.020009 0.034954 0.083439 0.023321 0.022019 0.128050 0.014584 0.035933 0.020437
+	0.087061 0.097010 0.093268 0.051664 0.042823 0.062544 0.055200 0.037568 0.286027 0.086237 0.189842 0.068689 0.073223 0.898663 0.032043 0.121979 0.094617 0.124746
+	1.230985 0.113146 0.049824 0.048769 0.163831 0.112027 0.205868 0.082579 0.068575 3.654430 1.337571 0.144587 0.307309 0.247329 0.129315 0.127700 0.740305 0.022134 0.125733
+
+	0.078837 0.051238 0.042313 0.053066 0.015175 0.036713 0.061924 0.070852 0.023082 0.062056
+	0.096371 0.057324 0.023771 0.043296 0.043911 0.063403 0.055897 0.013272 0.034399 0.073101`
+	// from PAML vt.dat
+
+	d.setPAMLRateMatrix()
+}
+
+// SetRateMatrixBlosum62 set up Blosum62 exchangeabilities
+func (d *ProteinModel) SetRateMatrixBlosum62() {
+	d.M.Ex = `0.735790389698
+	0.485391055466 0.738602544161
+	0.543161820899 0.214165846164 1.759346693120
+	1.338188436380 0.270361767418 0.315999047097 0.158934065467
+	1.149904707720 1.992390970970 0.973416059542 0.467195103106 0.150347700652
+	1.234557072090 0.654705306218 0.706213669735 2.947364340130 0.107064219014 3.266513428040
+	2.100109620180 0.354113818451 0.875000024453 0.661237497779 0.395912864740 0.521030504660 0.335581312436
+	0.499353279320 1.383391136520 2.186593150520 0.830998587525 0.294624017734 1.361201119380 0.570744050856 0.301853017716
+	0.621528835460 0.364434434788 0.354133148342 0.097063209062 0.602734809224 0.293012649022 0.146219113808 0.081478751062 0.227164436264
+	0.727215342519 0.431818686522 0.219223917553 0.148659298627 0.761180478466 0.593946097812 0.185006130558 0.212097262669 0.385301591745 2.150042151070
+	1.190357447060 2.530960980050 1.027655498120 0.739418493232 0.180103347096 2.025129190510 1.766336653070 0.499325843106 0.711049773519 0.306710620854 0.398472810768
+	1.233010236560 0.640701617815 0.495193640627 0.236936419614 0.683761823183 1.050121246510 0.299700064408 0.236616602609 0.560542108003 2.441006832190 3.491336405410 0.676152526013
+	0.579286163802 0.245452745012 0.379050738062 0.123009131787 0.526962004009 0.306024812047 0.152981669993 0.201301437828 0.652224745946 1.121470528490 2.212391110020 0.173554236066 1.362187001060
+	1.097271026120 0.398823212101 0.403961127591 0.554701434924 0.267936648815 0.621011566062 0.582826347462 0.351812263751 0.388107802431 0.193564025289 0.353010613695 0.566155998612 0.205543193813 0.134638126649
+	2.404451904970 0.758744434924 2.152010050890 1.106175742120 1.488393050470 1.180090829930 1.021904163300 1.501292130190 0.759736924574 0.163541917306 0.292823659418 0.957007007085 0.322675553585 0.394135004808 1.096407365130
+	1.347388396580 0.732106009811 1.346181534490 0.536113011937 0.882856616102 0.946546143628 0.713416766462 0.391567719233 0.491566669633 1.072199326040 0.475478366718 1.110046067850 1.006186331160 0.318286349478 0.802826153208 2.246598397440
+	0.216798154963 0.526215521958 0.131449316823 0.142006981838 0.339519474862 0.418811307016 0.257322478734 0.341648004849 0.440089264218 0.211848812453 0.508316346224 0.178147043015 0.565924893451 1.174192165780 0.180120826302 0.336099094006 0.308432700373
+	0.474811011498 0.610014911766 0.722148046493 0.303154393632 0.728089493412 0.636694059346 0.426024440908 0.232813109700 2.272542827180 0.391617183035 0.586564138212 0.404226802046 0.632609066618 4.259260499360 0.284349097637 0.582447521493 0.565271123869 2.653497190990
+	1.688480170470 0.383153034099 0.251734860648 0.205054487856 0.840133894320 0.373296609437 0.432838034124 0.283911306466 0.353427350050 4.867271099930 1.172362622250 0.457432651017 1.112330130980 0.631227613413 0.360591567035 0.481113681013 1.431831531160 0.300125215022 0.474149706045
+
+	0.074 0.052 0.045 0.054 0.025 0.034 0.054 0.074 0.026 0.068
+	0.099 0.058 0.025 0.047 0.039 0.057 0.051 0.013 0.032 0.073`
+	// from PAML blosum62.dat
+
+	d.setPAMLRateMatrix()
+}
+
+// SetRateMatrixHIVb set up HIVb exchangeabilities
+func (d *ProteinModel) SetRateMatrixHIVb() {
+	d.M.Ex = `0.30750700
+	0.00500000 0.29554300
+	1.45504000 0.00500000 17.66120000
+	0.12375800 0.35172100 0.08606420 0.00500000
+	0.05511280 3.42150000 0.67205200 0.00500000 0.00500000
+	1.48135000 0.07492180 0.07926330 10.58720000 0.00500000 2.56020000
+	2.13536000 3.65345000 0.32340100 2.83806000 0.89787100 0.06191370 3.92775000
+	0.08476130 9.04044000 7.64585000 1.91690000 0.24007300 7.05545000 0.11974000 0.00500000
+	0.00500000 0.67728900 0.68056500 0.01767920 0.00500000 0.00500000 0.00609079 0.00500000 0.10311100
+	0.21525600 0.70142700 0.00500000 0.00876048 0.12977700 1.49456000 0.00500000 0.00500000 1.74171000 5.95879000
+	0.00500000 20.45000000 7.90443000 0.00500000 0.00500000 6.54737000 4.61482000 0.52170500 0.00500000 0.32231900 0.08149950
+	0.01866430 2.51394000 0.00500000 0.00500000 0.00500000 0.30367600 0.17578900 0.00500000 0.00500000 11.20650000 5.31961000 1.28246000
+	0.01412690 0.00500000 0.00500000 0.00500000 9.29815000 0.00500000 0.00500000 0.29156100 0.14555800 3.39836000 8.52484000 0.03426580 0.18802500
+	2.12217000 1.28355000 0.00739578 0.03426580 0.00500000 4.47211000 0.01202260 0.00500000 2.45318000 0.04105930 2.07757000 0.03138620 0.00500000 0.00500000
+	2.46633000 3.47910000 13.14470000 0.52823000 4.69314000 0.11631100 0.00500000 4.38041000 0.38274700 1.21803000 0.92765600 0.50411100 0.00500000 0.95647200 5.37762000
+	15.91830000 2.86868000 6.88667000 0.27472400 0.73996900 0.24358900 0.28977400 0.36961500 0.71159400 8.61217000 0.04376730 4.67142000 4.94026000 0.01412690 2.01417000 8.93107000
+	0.00500000 0.99133800 0.00500000 0.00500000 2.63277000 0.02665600 0.00500000 1.21674000 0.06951790 0.00500000 0.74884300 0.00500000 0.08907800 0.82934300 0.04445060 0.02487280 0.00500000
+	0.00500000 0.00991826 1.76417000 0.67465300 7.57932000 0.11303300 0.07926330 0.00500000 18.69430000 0.14816800 0.11198600 0.00500000 0.00500000 15.34000000 0.03043810 0.64802400 0.10565200 1.28022000
+	7.61428000 0.08124540 0.02665600 1.04793000 0.42002700 0.02091530 1.02847000 0.95315500 0.00500000 17.73890000 1.41036000 0.26566400 6.85320000 0.72327400 0.00500000 0.07492180 0.70922600 0.00500000 0.04105930
+
+	0.060490222 0.066039665 0.044127815 0.042109048 0.020075899 0.053606488 0.071567447 0.072308239 0.022293943 0.069730629
+	0.098851122 0.056968211 0.019768318 0.028809447 0.046025282 0.050604330 0.053636813 0.033011601 0.028350243 0.061625237`
+	// from PAML hivb.dat
+
+	d.setPAMLRateMatrix()
+}
+
+// ProteinModelNames the built-in empirical amino acid models for SetRateMatrixByName
+var ProteinModelNames = []string{"JTT", "WAG", "LG", "Dayhoff", "mtREV", "mtMAM", "cpREV",
+	"rtREV", "VT", "Blosum62", "HIVb"}
+
+// SetRateMatrixByName set up the exchangeabilities for one of the built-in models
+// (ProteinModelNames, case doesn't matter) or, if it isn't one of those, from a
+// PAML formatted file (see ReadPAMLFile)
+func (d *ProteinModel) SetRateMatrixByName(name string) error {
+	switch strings.ToUpper(name) {
+	case "JTT":
+		d.SetRateMatrixJTT()
+	case "WAG":
+		d.SetRateMatrixWAG()
+	case "LG":
+		d.SetRateMatrixLG()
+	case "DAYHOFF":
+		d.SetRateMatrixDayhoff()
+	case "MTREV", "MTREV24":
+		d.SetRateMatrixMtREV()
+	case "MTMAM":
+		d.SetRateMatrixMtMAM()
+	case "CPREV":
+		d.SetRateMatrixCpREV()
+	case "RTREV":
+		d.SetRateMatrixRtREV()
+	case "VT":
+		d.SetRateMatrixVT()
+	case "BLOSUM62":
+		d.SetRateMatrixBlosum62()
+	case "HIVB":
+		d.SetRateMatrixHIVb()
+	default:
+		if _, err := os.Stat(name); err != nil {
+			return fmt.Errorf("%s is not one of the amino acid models (%s) or a PAML model file",
+				name, strings.Join(ProteinModelNames, ", "))
 		}
-		res = append(res, tmp)
+		return d.ReadPAMLFile(name)
 	}
-	d.M.R = mat.NewDense(20, 20, nil) // exchangeabilities - let diagonals be 0
-	for i := 0; i < d.M.NumStates; i++ {
-		for j := 0; j < d.M.NumStates; j++ {
-			if i == j {
-				d.M.R.Set(i, j, 0.0)
-			} else if j > i {
-				d.M.R.Set(i, j, res[j-1][i])
-			} else {
-				d.M.R.Set(i, j, res[i-1][j])
-			}
+	return nil
+}
+
+// ReadPAMLFile reads the exchangeabilities and model base frequencies from a PAML
+// amino acid model file (like jones.dat or wag.dat) into Ex, R, and MBF. The
+// frequencies are normalised to sum to 1
+func (d *ProteinModel) ReadPAMLFile(fn string) error {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+	R, bf, err := parsePAMLModel(string(b))
+	if err != nil {
+		return fmt.Errorf("%s: %v", fn, err)
+	}
+	sum := floats.Sum(bf)
+	for i := range bf {
+		bf[i] /= sum
+	}
+	d.M.Ex = string(b)
+	d.M.R = R
+	d.M.MBF = bf
+	return nil
+}
+
+// setPAMLRateMatrix sets R and MBF from the PAML formatted Ex
+func (d *ProteinModel) setPAMLRateMatrix() {
+	R, bf, err := parsePAMLModel(d.M.Ex)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d.M.R = R
+	d.M.MBF = bf
+}
+
+// parsePAMLModel reads the lower triangle of the exchangeabilities (190 values)
+// and the 20 frequencies of a PAML amino acid model. The line breaks don't matter
+// and anything after the frequencies (e.g., the notes in the PAML files) is ignored
+func parsePAMLModel(ex string) (R *mat.Dense, bf []float64, err error) {
+	var vals []float64
+	for _, f := range strings.Fields(ex) {
+		if len(vals) == 210 {
+			break
+		}
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%q is not a number (%d of the 190 exchangeabilities and 20 frequencies read)", f, len(vals))
+		}
+		vals = append(vals, v)
+	}
+	if len(vals) < 210 {
+		return nil, nil, fmt.Errorf("only %d of the 190 exchangeabilities and 20 frequencies", len(vals))
+	}
+	R = mat.NewDense(20, 20, nil) // exchangeabilities - let diagonals be 0
+	k := 0
+	for i := 1; i < 20; i++ {
+		for j := 0; j < i; j++ {
+			R.Set(i, j, vals[k])
+			R.Set(j, i, vals[k])
+			k++
 		}
 	}
-	d.M.MBF = res[20]
+	bf = vals[190:]
+	return
 }
 
 // DeepCopyProteinModel ...
@@ -0,0 +1,49 @@
+package gophy_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestReadPAMLFile(t *testing.T) {
+	jtt := gophy.NewProteinModel()
+	jtt.SetRateMatrixJTT()
+	fn := writeTestFile(t, "jones.dat", jtt.M.Ex+"\n\nA R N D C Q E G H I L K M F P S T W Y V\nAla Arg Asn ...\n")
+	x := gophy.NewProteinModel()
+	if err := x.SetRateMatrixByName(fn); err != nil {
+		t.Fatal(err)
+	}
+	if !mat.Equal(x.M.R, jtt.M.R) || x.M.R.At(0, 1) != 58 || x.M.R.At(19, 18) != 16 {
+		t.Error("exchangeabilities differ from JTT")
+	}
+	if len(x.M.MBF) != 20 || math.Abs(x.M.MBF[0]-0.076748) > 1e-5 {
+		t.Error(x.M.MBF)
+	}
+	if err := x.SetRateMatrixByName("lg"); err != nil || x.M.R.At(1, 0) != 0.425093 {
+		t.Error(err)
+	}
+	for _, name := range gophy.ProteinModelNames {
+		if err := x.SetRateMatrixByName(name); err != nil {
+			t.Fatal(err)
+		}
+		if r, c := x.M.R.Dims(); r != 20 || c != 20 || !mat.EqualApprox(x.M.R, x.M.R.T(), 1e-12) {
+			t.Error(name, "exchangeabilities are not a symmetric 20x20 matrix")
+		}
+		if math.Abs(floats.Sum(x.M.MBF)-1) > 1e-3 || x.M.R.At(1, 0) <= 0 {
+			t.Error(name, x.M.MBF, x.M.R.At(1, 0))
+		}
+	}
+	if err := x.SetRateMatrixByName("mtREV24"); err != nil || x.M.R.At(1, 0) != 23.18 {
+		t.Error(err)
+	}
+	if err := x.SetRateMatrixByName("notamodel"); err == nil {
+		t.Error("expected an error for an unknown model")
+	}
+	if err := x.ReadPAMLFile(writeTestFile(t, "short.dat", "1 2 3\n")); err == nil {
+		t.Error("expected an error for a short file")
+	}
+}
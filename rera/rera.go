@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"os"
@@ -21,23 +20,10 @@ func main() {
 		os.Exit(1)
 	}
 
-	//read a tree file
-	f, err := os.Open(*tfn)
-	if err != nil {
-		fmt.Println(err)
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	var rt *gophy.Node
-	t := gophy.NewTree()
-	for scanner.Scan() {
-		ln := scanner.Text()
-		if len(ln) < 2 {
-			continue
-		}
-		rt = gophy.ReadNewickString(ln)
-		t.Instantiate(rt)
-		break // read the first tree
+	//read a tree file (newick or nexus)
+	t := gophy.ReadTreeFromFile(*tfn)
+	if t == nil {
+		os.Exit(1)
 	}
 	fmt.Println(t.Rt.Newick(false) + ";")
 
@@ -0,0 +1,162 @@
+// rootplace scores every root position of a tree with a non-reversible model
+// (UNREST for nucleotides or an all rates different Mk for multistate data) and
+// reports the support for the root on each branch
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+// rootFit the result of the root on one branch
+type rootFit struct {
+	edge int // index in the Pre of the unrooted tree
+	lnl  float64
+	w    float64
+	tree string
+}
+
+// rootOnEdge a copy of the unrooted t rooted in the middle of the branch below
+// t.Pre[edge]
+func rootOnEdge(t *gophy.Tree, edge int) *gophy.Tree {
+	ct := t.DeepCopyTree()
+	gophy.Reroot(ct.Pre[edge], ct)
+	rt := ct.Rt
+	rt.Par = nil
+	nt := gophy.NewTree()
+	nt.Instantiate(rt)
+	return nt
+}
+
+// cladeName the tips on the side of the branch away from the root
+func cladeName(n *gophy.Node) string {
+	nms := n.GetTipNames()
+	sort.Strings(nms)
+	if len(nms) > 4 {
+		return strings.Join(nms[:3], ",") + fmt.Sprintf(",... (%d tips)", len(nms))
+	}
+	return strings.Join(nms, ",")
+}
+
+func main() {
+	tfn := flag.String("t", "", "tree filename (branch lengths from a reversible model)")
+	afn := flag.String("s", "", "seq filename")
+	st := flag.String("st", "nuc", "sequence type [nuc/mult]")
+	rf := flag.String("r", "stat", "root frequencies [stat(ionary)/free]")
+	opt := flag.Bool("o", false, "optimize the model for each root position (slower)")
+	wks := flag.Int("w", 4, "number of threads")
+	flag.Parse()
+	if len(*tfn) == 0 || len(*afn) == 0 {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *st != "nuc" && *st != "mult" {
+		fmt.Fprintln(os.Stderr, "sequence type string is not a recognised datatype, please use [nuc/mult]")
+		os.Exit(1)
+	}
+	if *rf != "stat" && *rf != "free" {
+		fmt.Fprintln(os.Stderr, "root frequencies should be stat or free")
+		os.Exit(1)
+	}
+
+	t := gophy.ReadTreeFromFile(*tfn)
+	if t == nil {
+		os.Exit(1)
+	}
+	if len(t.Rt.Chs) == 2 {
+		gophy.TritomyRoot(t)
+	}
+	t = t.DeepCopyTree()
+
+	var m *gophy.NonRevModel
+	var prep func(*gophy.Tree) []float64
+	if *st == "nuc" {
+		seqs, patternsint, _, bf := gophy.ReadPatternsSeqsFromFile(*afn, true)
+		prep = func(t *gophy.Tree) []float64 {
+			patternval, _ := gophy.PreparePatternVecs(t, patternsint, seqs)
+			return patternval
+		}
+		m = gophy.NewUNRESTModel()
+		if *rf == "free" {
+			m.SetRootFreqs(bf)
+		}
+	} else {
+		seqs, patternsint, _, bf, numstates := gophy.ReadPatternsMSeqsFromFile(*afn)
+		prep = func(t *gophy.Tree) []float64 {
+			patternval, _ := gophy.PreparePatternVecsMS(t, patternsint, seqs, gophy.GetMap(numstates), numstates)
+			return patternval
+		}
+		m = gophy.NewNonRevMkModel(numstates, 1.0)
+		if *rf == "free" {
+			m.SetRootFreqs(bf)
+		}
+	}
+	// fit the model with the root on the first branch
+	rt := rootOnEdge(t, 1)
+	patternval := prep(rt)
+	lnl := gophy.OptimizeRootPosition(rt, &m.M, patternval, *wks)
+	for i := 0; i < 10; i++ {
+		gophy.OptimizeNonRevModel(rt, m, patternval, *wks)
+		nlnl := gophy.OptimizeRootPosition(rt, &m.M, patternval, *wks)
+		fmt.Fprintln(os.Stderr, "model:", nlnl)
+		if nlnl-lnl < 0.01 {
+			break
+		}
+		lnl = nlnl
+	}
+	rates := append([]float64{}, m.Rates...)
+	rootbf := append([]float64{}, m.M.RootBF...)
+
+	var fits []*rootFit
+	for i := 1; i < len(t.Pre); i++ {
+		rt := rootOnEdge(t, i)
+		patternval := prep(rt)
+		if *opt {
+			m.SetRates(rates)
+			if m.Stationary == false {
+				m.SetRootFreqs(rootbf)
+			}
+			gophy.OptimizeNonRevModel(rt, m, patternval, *wks)
+		}
+		lnl := gophy.OptimizeRootPosition(rt, &m.M, patternval, *wks)
+		fits = append(fits, &rootFit{edge: i, lnl: lnl, tree: rt.Rt.Newick(true) + ";"})
+		fmt.Fprintln(os.Stderr, i, lnl)
+	}
+	best := math.Inf(-1)
+	for _, f := range fits {
+		best = math.Max(best, f.lnl)
+	}
+	sum := 0.
+	for _, f := range fits {
+		f.w = math.Exp(f.lnl - best)
+		sum += f.w
+	}
+	for _, f := range fits {
+		f.w /= sum
+		n := t.Pre[f.edge]
+		if n.FData == nil {
+			n.FData = map[string]float64{}
+		}
+		n.FData["root_support"] = f.w
+		n.Annot = append(n.Annot, "root_support")
+	}
+	sort.SliceStable(fits, func(i, j int) bool { return fits[i].lnl > fits[j].lnl })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "branch\tlnL\tdelta\tsupport\tclade")
+	for _, f := range fits {
+		fmt.Fprintf(w, "%d\t%.4f\t%.4f\t%.4f\t%s\n", f.edge, f.lnl, best-f.lnl, f.w, cladeName(t.Pre[f.edge]))
+	}
+	w.Flush()
+	fmt.Println("best rooted tree:")
+	fmt.Println(fits[0].tree)
+	fmt.Println("root support:")
+	fmt.Println(t.Rt.NewickAnnotated(true, false) + ";")
+}
@@ -19,31 +19,16 @@ import (
 //name1,name2 date
 
 func readTreeFile(treefilename string) (gophy.Tree, *gophy.Node) {
-	f, err := os.Open(treefilename)
-	if err != nil {
+	if _, err := os.Stat(treefilename); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	defer f.Close()
-	scanner := bufio.NewReader(f)
 	fmt.Fprintln(os.Stderr, "reading trees")
-	var t gophy.Tree
-	var rt *gophy.Node
-	for {
-		ln, err := scanner.ReadString('\n')
-		if len(ln) > 0 {
-			rt = gophy.ReadNewickString(ln)
-			t.Instantiate(rt)
-			break
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			break
-		}
+	t := gophy.ReadTreeFromFile(treefilename)
+	if t == nil {
+		os.Exit(1)
 	}
-	return t, rt
+	return *t, t.Rt
 }
 
 func extractDates(treefilename string, mrcafilename string) {
@@ -206,7 +206,14 @@ func PNW(seqs []Seq, jobs <-chan []int, results chan<- float32) {
 }
 
 //ReadSeqsFromFile obvious
+// fasta, phylip, and nexus files are all read
 func ReadSeqsFromFile(filen string) (seqs []Seq) {
+	switch seqFileFormat(filen) {
+	case "nexus":
+		return ReadNexusSeqsFromFile(filen)
+	case "phylip":
+		return ReadPhylipSeqsFromFile(filen)
+	}
 	file, err := os.Open(filen)
 	if err != nil {
 		log.Fatal(err)
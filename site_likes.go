@@ -0,0 +1,220 @@
+package gophy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// PCalcLogLikePatternSites the log likelihood of each pattern (not multiplied by
+// the pattern weights), with gamma and +I if x.GammaNCats > 0. The lewis
+// ascertainment bias corrections are included for each site but felsenstein and
+// stamatakis aren't as they aren't for a site
+func PCalcLogLikePatternSites(t *Tree, x *DiscreteModel, patternval []float64, wks int) []float64 {
+	x.EmptyPDict()
+	x.EmptyPLDict()
+	sl := calcLogLikePatternsFlat(t, x, len(patternval), true, false, wks)
+	if x.Asc == AscLewis || x.Asc == AscLewisInf {
+		corr := ascCorrection(t, x, patternval) / floats.Sum(patternval)
+		for i := range sl {
+			sl[i] += corr
+		}
+	}
+	return sl
+}
+
+// ExpandPatternSites the values for each pattern (in the order of patternvec from
+// PreparePatternVecs) for each site of the alignment with the fullpattern and
+// gapsites from GetSitePatterns. The sites with only gaps are 0
+func ExpandPatternSites(patternsites []float64, patternvec []int, fullpattern []int, gapsites []int) []float64 {
+	gaps := make(map[int]bool)
+	for _, g := range gapsites {
+		gaps[g] = true
+	}
+	// the first site of each pattern is in patternvec
+	first := make(map[int]int)
+	for k, p := range fullpattern {
+		if _, ok := first[p]; !ok && gaps[k] == false {
+			first[p] = k
+		}
+	}
+	index := make(map[int]int)
+	for i, s := range patternvec {
+		index[s] = i
+	}
+	ret := make([]float64, len(fullpattern))
+	for k, p := range fullpattern {
+		if gaps[k] {
+			continue
+		}
+		ret[k] = patternsites[index[first[p]]]
+	}
+	return ret
+}
+
+// WriteSiteLH writes the site log likelihoods of each tree in the PUZZLE/RAxML
+// .sitelh format (e.g., for CONSEL)
+func WriteSiteLH(w io.Writer, sitelikes [][]float64) error {
+	bw := bufio.NewWriter(w)
+	nsites := 0
+	if len(sitelikes) > 0 {
+		nsites = len(sitelikes[0])
+	}
+	fmt.Fprintf(bw, "%d %d\n", len(sitelikes), nsites)
+	for i, sl := range sitelikes {
+		bw.WriteString("tr" + strconv.Itoa(i+1) + "\t")
+		for j, v := range sl {
+			if j > 0 {
+				bw.WriteString(" ")
+			}
+			bw.WriteString(strconv.FormatFloat(v, 'f', 6, 64))
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// TopologyTest the results of the tests for one tree (see TopologyTests)
+type TopologyTest struct {
+	LnL    float64
+	DeltaL float64 // to the best tree
+	BP     float64 // RELL bootstrap proportion
+	KH     float64 // Kishino-Hasegawa p (one sided, against the best tree)
+	SH     float64 // Shimodaira-Hasegawa p
+	ELW    float64 // expected likelihood weight
+	AU     float64 // approximately unbiased p
+}
+
+// the scales (bootstrap sample size / number of sites) for the AU test
+var auScales = []float64{0.5, 0.6, 0.7, 0.8, 0.9, 1.0, 1.1, 1.2, 1.3, 1.4}
+
+const auOne = 5 // the index of the scale 1 in auScales
+
+// TopologyTests the KH, SH, ELW, and AU tests for the trees with the site log
+// likelihoods (one slice of sites for each tree) and nboot RELL bootstrap
+// replicates (for each scale of the AU multiscale bootstrap)
+func TopologyTests(sitelikes [][]float64, nboot int) []TopologyTest {
+	ntrees := len(sitelikes)
+	nsites := len(sitelikes[0])
+	res := make([]TopologyTest, ntrees)
+	best := 0
+	for i, sl := range sitelikes {
+		res[i].LnL = floats.Sum(sl)
+		if res[i].LnL > res[best].LnL {
+			best = i
+		}
+	}
+	for i := range res {
+		res[i].DeltaL = res[best].LnL - res[i].LnL
+	}
+	// the bootstrap replicates with the number of sites (the unscaled one)
+	bls := make([][]float64, nboot)
+	// the number of times each tree is the best at each scale
+	bpcounts := make([][]float64, len(auScales))
+	for s, sc := range auScales {
+		bpcounts[s] = make([]float64, ntrees)
+		n := int(math.Round(sc * float64(nsites)))
+		for b := 0; b < nboot; b++ {
+			ls := make([]float64, ntrees)
+			for k := 0; k < n; k++ {
+				site := rand.Intn(nsites)
+				for i := range ls {
+					ls[i] += sitelikes[i][site]
+				}
+			}
+			// ties (e.g., the same tree twice) are split between the trees
+			mx := floats.Max(ls)
+			var tied []int
+			for i, l := range ls {
+				if mx-l < 1e-8 {
+					tied = append(tied, i)
+				}
+			}
+			for _, i := range tied {
+				bpcounts[s][i] += 1 / float64(len(tied))
+			}
+			if s == auOne {
+				bls[b] = ls
+			}
+		}
+	}
+	// centered replicates for KH and SH
+	means := make([]float64, ntrees)
+	for _, ls := range bls {
+		floats.Add(means, ls)
+	}
+	floats.Scale(1/float64(nboot), means)
+	for _, ls := range bls {
+		c := make([]float64, ntrees)
+		floats.SubTo(c, ls, means)
+		mx := floats.Max(c)
+		w := make([]float64, ntrees)
+		lmx := floats.Max(ls)
+		for i := range ls {
+			w[i] = math.Exp(ls[i] - lmx)
+		}
+		floats.Scale(1/floats.Sum(w), w)
+		for i := range res {
+			if c[best]-c[i] >= res[i].DeltaL {
+				res[i].KH++
+			}
+			if mx-c[i] >= res[i].DeltaL {
+				res[i].SH++
+			}
+			res[i].ELW += w[i]
+		}
+	}
+	for i := range res {
+		res[i].KH /= float64(nboot)
+		res[i].SH /= float64(nboot)
+		res[i].ELW /= float64(nboot)
+		res[i].BP = bpcounts[auOne][i] / float64(nboot)
+		bps := make([]float64, len(auScales))
+		for s := range auScales {
+			bps[s] = bpcounts[s][i] / float64(nboot)
+		}
+		res[i].AU = auPValue(bps, nboot)
+	}
+	return res
+}
+
+// auPValue fits z = v*sqrt(r) + c/sqrt(r) to the z values of the bootstrap
+// proportions at the scales r (weighted least squares) and the AU p is
+// 1-Phi(v-c) (Shimodaira 2002)
+func auPValue(bps []float64, nboot int) float64 {
+	norm := distuv.UnitNormal
+	var sxx, sxy, syy, sxz, syz float64
+	used := 0
+	for s, bp := range bps {
+		if bp <= 0 || bp >= 1 {
+			continue
+		}
+		z := norm.Quantile(1 - bp)
+		// the variance of z from the binomial variance of bp
+		w := norm.Prob(z) * norm.Prob(z) * float64(nboot) / (bp * (1 - bp))
+		x, y := math.Sqrt(auScales[s]), 1/math.Sqrt(auScales[s])
+		sxx += w * x * x
+		sxy += w * x * y
+		syy += w * y * y
+		sxz += w * x * z
+		syz += w * y * z
+		used++
+	}
+	if used < 2 {
+		// always or never the best
+		return bps[auOne]
+	}
+	det := sxx*syy - sxy*sxy
+	if det == 0 {
+		return bps[auOne]
+	}
+	v := (sxz*syy - syz*sxy) / det
+	c := (sxx*syz - sxy*sxz) / det
+	return 1 - norm.CDF(v-c)
+}
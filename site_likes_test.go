@@ -0,0 +1,83 @@
+package gophy_test
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+	"gonum.org/v1/gonum/floats"
+)
+
+func TestSiteLikes(t *testing.T) {
+	tr := gophy.ReadTreeFromFile("test_files/10tips.nuc.fa.treefile")
+	rseqs := gophy.ReadSeqsFromFile("test_files/10tips.nuc.fa")
+	seqs := map[string]string{}
+	var names []string
+	for _, s := range rseqs {
+		seqs[s.NM] = s.SQ
+		names = append(names, s.NM)
+	}
+	nsites := len(rseqs[0].SQ)
+	_, patternsint, gapsites, _, _, fullpattern := gophy.GetSitePatterns(seqs, nsites, names)
+	patternval, patternvec := gophy.PreparePatternVecs(tr, patternsint, seqs)
+	x, _ := gophy.NewNucModel(gophy.GTR, []float64{1.2, 3.0, 0.8, 1.1, 2.9}, gophy.GetEmpiricalBaseFreqs(seqs))
+	for _, ncats := range []int{0, 4} {
+		x.M.GammaNCats = ncats
+		x.M.GammaCats = gophy.GetGammaCats(0.5, 4, false)
+		var lnl float64
+		if ncats == 0 {
+			lnl = gophy.PCalcLogLikePatterns(tr, &x.M, patternval, 2)
+		} else {
+			lnl = gophy.PCalcLogLikePatternsGamma(tr, &x.M, patternval, 2)
+		}
+		psl := gophy.PCalcLogLikePatternSites(tr, &x.M, patternval, 2)
+		sl := gophy.ExpandPatternSites(psl, patternvec, fullpattern, gapsites)
+		if len(sl) != nsites || math.Abs(floats.Sum(sl)-lnl) > 1e-6 {
+			t.Error("the sites should sum to the lnL", ncats, floats.Sum(sl), lnl)
+		}
+		// a site is the same as its pattern
+		for i, s := range patternvec {
+			if sl[s] != psl[i] {
+				t.Fatal("site", s, sl[s], psl[i])
+			}
+		}
+	}
+	var b bytes.Buffer
+	gophy.WriteSiteLH(&b, [][]float64{{-1.5, -2.25}, {-1, -3}})
+	if b.String() != "2 2\ntr1\t-1.500000 -2.250000\ntr2\t-1.000000 -3.000000\n" {
+		t.Error(b.String())
+	}
+}
+
+func TestTopologyTests(t *testing.T) {
+	rand.Seed(1)
+	nsites := 200
+	sl := make([][]float64, 3)
+	for i := range sl {
+		sl[i] = make([]float64, nsites)
+	}
+	for s := 0; s < nsites; s++ {
+		v := -5 - rand.Float64()
+		sl[0][s] = v
+		// the same tree
+		sl[1][s] = v
+		// a much worse one
+		sl[2][s] = v - 0.5 - 0.2*rand.Float64()
+	}
+	res := gophy.TopologyTests(sl, 1000)
+	if res[0].DeltaL != 0 || res[1].DeltaL != 0 || math.Abs(res[2].DeltaL-(floats.Sum(sl[0])-floats.Sum(sl[2]))) > 1e-9 {
+		t.Error("deltaL", res)
+	}
+	if math.Abs(res[0].ELW-0.5) > 1e-6 || res[0].SH != 1 || res[0].KH != 1 || res[0].AU < 0.3 {
+		t.Error("the best trees", res[0], res[1])
+	}
+	// the RELL ties are split between the two
+	if math.Abs(res[0].BP-0.5) > 1e-9 || res[1].BP != res[0].BP || res[1].AU != res[0].AU || res[1].SH != 1 {
+		t.Error("the tied trees", res[0], res[1])
+	}
+	if res[2].KH > 0.001 || res[2].SH > 0.001 || res[2].ELW > 0.001 || res[2].AU > 0.001 || res[2].BP != 0 {
+		t.Error("the worse tree", res[2])
+	}
+}
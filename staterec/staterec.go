@@ -35,6 +35,9 @@ func main() {
 	//ebf := flag.Bool("b", true, "use empirical base freqs (alt is estimate)")
 	ma := flag.Int("p", 0, "map the most likely result for the p site to the internal node label")
 	wks := flag.Int("w", 4, "number of threads")
+	asc := flag.String("asc", "", "ascertainment bias correction [lewis/lewisinf/felsenstein/stamatakis] for data without the constant characters")
+	ascw := flag.String("ascw", "", "comma separated number of invariant sites left out (one for felsenstein, one per state for stamatakis)")
+	qmask := flag.String("qmask", "", "file with the allowed changes (rows of 0s and 1s, row is from and column to) or ordered")
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
 	flag.Parse()
 	if len(*tfn) == 0 {
@@ -99,10 +102,25 @@ func main() {
 		seqnames = append(seqnames, i.NM)
 		nsites = len(i.SQ)
 	}
+	var mask [][]bool
+	if len(*qmask) > 0 {
+		if mask, err = gophy.ReadQMaskFile(*qmask, numstates); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 	x := gophy.NewMultStateModel(numstates)
 	bf := gophy.GetEmpiricalBaseFreqsMS(mseqs, numstates)
 	x.M.SetBaseFreqs(bf)
 	x.M.EBF = x.M.BF
+	inv, err := gophy.ParseAscInv(*ascw)
+	if err == nil {
+		err = x.M.SetAscBias(*asc, inv, len(seqnames))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	//fmt.Fprint(lg, x.M.BF)
 	// get the site patternas
 	patterns, patternsint, gapsites, constant, uninformative, fullpattern := gophy.GetSitePatternsMS(mseqs, x.M.GetCharMap(), x.M.GetNumStates())
@@ -120,9 +138,12 @@ func main() {
 		fmt.Fprintln(os.Stderr, "onlygaps:", len(gapsites))
 		fmt.Fprintln(os.Stderr, "constant:", len(constant))
 		fmt.Fprintln(os.Stderr, "uninformative:", len(uninformative))
+		if len(*asc) > 0 && len(constant) > 0 {
+			fmt.Fprintln(os.Stderr, "warning: there are constant characters and an ascertainment bias correction")
+		}
 		// model things
 
-		optimizeThings(t, x, patternval, *wks)
+		optimizeThings(t, x, mask, patternval, *wks)
 
 		//ancestral states
 		if *anc || *stt || *stn {
@@ -161,7 +182,9 @@ func main() {
 	}
 }
 
-func optimizeThings(t *gophy.Tree, x *gophy.MultStateModel, patternval []float64, wks int) {
+// optimizeThings the rates of Mk (only the changes allowed by the mask if there
+// is one)
+func optimizeThings(t *gophy.Tree, x *gophy.MultStateModel, mask [][]bool, patternval []float64, wks int) {
 	x.M.SetupQJC()
 	l := gophy.PCalcLogLikePatterns(t, &x.M, patternval, wks)
 	fmt.Fprintln(os.Stderr, "starting lnL:", l)
@@ -169,7 +192,15 @@ func optimizeThings(t *gophy.Tree, x *gophy.MultStateModel, patternval []float64
 		os.Exit(0)
 	}
 	gophy.OptimizeMS1R(t, &x.M, patternval, wks)
-	gophy.OptimizeMKMS(t, &x.M, x.M.Q.At(0, 1), patternval, false, wks)
+	if mask != nil {
+		rates := make([]float64, gophy.NumMaskRates(mask, false))
+		for i := range rates {
+			rates[i] = x.M.Q.At(0, 1)
+		}
+		gophy.OptimizeMKMSMask(t, &x.M, rates, mask, false, patternval, wks)
+	} else {
+		gophy.OptimizeMKMS(t, &x.M, x.M.Q.At(0, 1), patternval, false, wks)
+	}
 	//fmt.Println(mat.Formatted(x.M.Q))
 	l = gophy.PCalcLogLikePatterns(t, &x.M, patternval, wks)
 	fmt.Fprintln(os.Stderr, "optimized lnL:", l)
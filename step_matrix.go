@@ -0,0 +1,300 @@
+package gophy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize"
+)
+
+// StepMatrix the cost of a change from state i (row) to state j (column) for
+// Sankoff parsimony. nil is unordered (every change costs 1)
+type StepMatrix [][]float64
+
+// cost of i to j
+func (s StepMatrix) cost(i, j int) float64 {
+	if s == nil {
+		if i == j {
+			return 0.
+		}
+		return 1.
+	}
+	return s[i][j]
+}
+
+// UnorderedStepMatrix every change costs 1
+func UnorderedStepMatrix(numstates int) StepMatrix {
+	s := make(StepMatrix, numstates)
+	for i := range s {
+		s[i] = make([]float64, numstates)
+		for j := range s[i] {
+			if i != j {
+				s[i][j] = 1.
+			}
+		}
+	}
+	return s
+}
+
+// OrderedStepMatrix a change from i to j costs |i-j| (e.g., meristic characters)
+func OrderedStepMatrix(numstates int) StepMatrix {
+	s := make(StepMatrix, numstates)
+	for i := range s {
+		s[i] = make([]float64, numstates)
+		for j := range s[i] {
+			s[i][j] = math.Abs(float64(i - j))
+		}
+	}
+	return s
+}
+
+// ParseStepMatrix reads a square step matrix with a row on each line (like a PAUP
+// usertype). i or inf is a change that isn't allowed
+func ParseStepMatrix(s string) (StepMatrix, error) {
+	var sm StepMatrix
+	for _, ln := range strings.Split(strings.TrimSpace(s), "\n") {
+		if len(strings.TrimSpace(ln)) == 0 {
+			continue
+		}
+		var row []float64
+		for _, v := range strings.Fields(ln) {
+			switch strings.ToLower(v) {
+			case "i", "inf":
+				row = append(row, math.Inf(1))
+			default:
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return nil, errors.New("problem parsing " + v + " in the step matrix")
+				}
+				row = append(row, f)
+			}
+		}
+		sm = append(sm, row)
+	}
+	for i, row := range sm {
+		if len(row) != len(sm) {
+			return nil, fmt.Errorf("the step matrix should be square (row %d has %d values not %d)", i+1, len(row), len(sm))
+		}
+		if row[i] != 0 {
+			return nil, fmt.Errorf("the step matrix diagonal should be 0 (row %d)", i+1)
+		}
+	}
+	return sm, nil
+}
+
+// ParseCharSet the characters in a set like 1-5,8,10-20\2 (starting at 1 like
+// the partition files). They start at 0 in the result
+func ParseCharSet(s string) (chars []int, err error) {
+	for _, rng := range strings.Split(s, ",") {
+		if len(strings.TrimSpace(rng)) == 0 {
+			continue
+		}
+		sites, err := parseSiteRange(strings.TrimSpace(rng))
+		if err != nil {
+			return nil, err
+		}
+		chars = append(chars, sites...)
+	}
+	return
+}
+
+// SplitPatternsByClass splits the site patterns (from GetSitePatternsMS) so that
+// all of the sites of a pattern are in the same class (e.g., the index of their
+// step matrix, see PatternStepMatrices). Otherwise an ordered and an unordered
+// character that look the same would be one pattern. Returns the patterns and the
+// patternsint for PreparePatternVecsMS
+func SplitPatternsByClass(patterns map[string][]int, class []int) (cpatterns map[string][]int,
+	patternsint map[int]float64) {
+	cpatterns = make(map[string][]int)
+	for tp, sites := range patterns {
+		for _, s := range sites {
+			k := tp + "|" + strconv.Itoa(class[s])
+			cpatterns[k] = append(cpatterns[k], s)
+		}
+	}
+	patternsint = make(map[int]float64)
+	for _, j := range cpatterns {
+		patternsint[j[0]] = float64(len(j))
+	}
+	return
+}
+
+// PatternStepMatrices the step matrix for each pattern (patternvec from
+// PreparePatternVecsMS) where class is the index in steps for each site
+func PatternStepMatrices(patternvec []int, class []int, steps []StepMatrix) []StepMatrix {
+	ps := make([]StepMatrix, len(patternvec))
+	for i, s := range patternvec {
+		ps[i] = steps[class[s]]
+	}
+	return ps
+}
+
+// OrderedMask the allowed changes for an ordered character, only between
+// adjacent states (for SetupQMkMask)
+func OrderedMask(numstates int) [][]bool {
+	mask := make([][]bool, numstates)
+	for i := range mask {
+		mask[i] = make([]bool, numstates)
+		for j := range mask[i] {
+			mask[i][j] = i-j == 1 || j-i == 1
+		}
+	}
+	return mask
+}
+
+// ParseQMask reads the allowed changes for Q with a row on each line with 1 for
+// allowed and 0 for not (the diagonal is ignored). "ordered" gives OrderedMask
+func ParseQMask(s string, numstates int) ([][]bool, error) {
+	if strings.TrimSpace(strings.ToLower(s)) == "ordered" {
+		return OrderedMask(numstates), nil
+	}
+	var mask [][]bool
+	for _, ln := range strings.Split(strings.TrimSpace(s), "\n") {
+		if len(strings.TrimSpace(ln)) == 0 {
+			continue
+		}
+		var row []bool
+		for _, v := range strings.Fields(ln) {
+			if v != "0" && v != "1" {
+				return nil, errors.New("the Q mask should be 0s and 1s not " + v)
+			}
+			row = append(row, v == "1")
+		}
+		if len(row) != numstates {
+			return nil, fmt.Errorf("each row of the Q mask should have %d values", numstates)
+		}
+		mask = append(mask, row)
+	}
+	if len(mask) != numstates {
+		return nil, fmt.Errorf("the Q mask should have %d rows", numstates)
+	}
+	return mask, nil
+}
+
+// NumMaskRates the number of rates for SetupQMkMask (for sym only the allowed
+// changes with j > i, and either direction allows it)
+func NumMaskRates(mask [][]bool, sym bool) (n int) {
+	for i := range mask {
+		for j := range mask[i] {
+			if i == j {
+				continue
+			}
+			if sym && j > i && (mask[i][j] || mask[j][i]) {
+				n++
+			} else if sym == false && mask[i][j] {
+				n++
+			}
+		}
+	}
+	return
+}
+
+// SetupQMkMask like SetupQMk but only for the changes allowed by the mask (see
+// OrderedMask) and the rest are 0. The rates are in row order for the allowed
+// changes (see NumMaskRates). This is unscaled
+func (d *DiscreteModel) SetupQMkMask(rt []float64, mask [][]bool, sym bool) {
+	full := make([]float64, 0, d.NumStates*d.NumStates)
+	cc := 0
+	for i := 0; i < d.NumStates; i++ {
+		for j := 0; j < d.NumStates; j++ {
+			if i == j || (sym && j < i) {
+				continue
+			}
+			if mask[i][j] || (sym && mask[j][i]) {
+				full = append(full, rt[cc])
+				cc++
+			} else {
+				full = append(full, 0.)
+			}
+		}
+	}
+	d.SetupQMk(full, sym)
+}
+
+// SetScaledRateMatrixMask like SetScaledRateMatrix (for SetupQGTR) but only for
+// the changes allowed by the mask with the last allowed one set to 1. There is one
+// fewer param than NumMaskRates
+func (d *DiscreteModel) SetScaledRateMatrixMask(params []float64, mask [][]bool, sym bool) {
+	n := NumMaskRates(mask, sym)
+	rt := make([]float64, n)
+	copy(rt, params)
+	rt[n-1] = 1.0
+	d.SetupQMkMask(rt, mask, sym)
+	d.R = mat.NewDense(d.NumStates, d.NumStates, nil)
+	for i := 0; i < d.NumStates; i++ {
+		for j := 0; j < d.NumStates; j++ {
+			if i != j {
+				d.R.Set(i, j, d.Q.At(i, j))
+			}
+		}
+	}
+}
+
+// ReadQMaskFile reads a Q mask (see ParseQMask) from a file. "ordered" (instead
+// of a file name) gives OrderedMask
+func ReadQMaskFile(fn string, numstates int) ([][]bool, error) {
+	if strings.ToLower(fn) == "ordered" {
+		return OrderedMask(numstates), nil
+	}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return ParseQMask(string(b), numstates)
+}
+
+// SetupQJCMask like SetupQJC (equal frequencies and rates) but only for the
+// changes allowed by the mask (in either direction). The rates are scaled so that
+// there is one change per unit
+func (d *DiscreteModel) SetupQJCMask(mask [][]bool) {
+	bf := make([]float64, d.NumStates)
+	for i := range bf {
+		bf[i] = 1. / float64(d.NumStates)
+	}
+	d.BF = bf
+	n := NumMaskRates(mask, true)
+	rt := make([]float64, n)
+	for i := range rt {
+		rt[i] = float64(d.NumStates) / float64(2*n)
+	}
+	d.SetupQMkMask(rt, mask, true)
+}
+
+// OptimizeMKMSMask optimize the rates of the changes allowed by the mask (see
+// SetupQMkMask). rates are the starting rates and the result (NumMaskRates).
+// Returns the lnL
+func OptimizeMKMSMask(t *Tree, x *DiscreteModel, rates []float64, mask [][]bool, sym bool,
+	patternvals []float64, wks int) float64 {
+	fcn := func(mds []float64) float64 {
+		for _, v := range mds {
+			if v < 0 || v > 1000 {
+				return 1000000000000
+			}
+		}
+		x.SetupQMkMask(mds, mask, sym)
+		lnl := PCalcLogLikePatterns(t, x, patternvals, wks)
+		if math.IsNaN(lnl) {
+			return 1000000000000
+		}
+		return -lnl
+	}
+	settings := optimize.Settings{}
+	FC := optimize.FunctionConverge{}
+	FC.Absolute = 10e-5
+	FC.Iterations = 20
+	settings.Converger = &FC
+	p := optimize.Problem{Func: fcn, Grad: nil, Hess: nil}
+	res, err := optimize.Minimize(p, rates, &settings, &optimize.NelderMead{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	copy(rates, res.X)
+	x.SetupQMkMask(rates, mask, sym)
+	return -res.F
+}
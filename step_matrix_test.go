@@ -0,0 +1,189 @@
+package gophy_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func TestStepMatrixPars(t *testing.T) {
+	tr := gophy.NewTree()
+	tr.Instantiate(gophy.ReadNewickString("((a:0.1,b:0.1):0.1,c:0.1,(d:0.1,e:0.1):0.1);"))
+	// site 0 is 0 0 2 2 2 and site 1 is the same but will be ordered, site 2 is 0 1 2 1 1
+	mseqs := []gophy.MSeq{{NM: "a", SQs: []string{"0", "0", "0"}}, {NM: "b", SQs: []string{"0", "0", "1"}},
+		{NM: "c", SQs: []string{"2", "2", "2"}}, {NM: "d", SQs: []string{"2", "2", "1"}},
+		{NM: "e", SQs: []string{"2", "2", "1"}}}
+	seqs := map[string][]string{}
+	for _, m := range mseqs {
+		seqs[m.NM] = m.SQs
+	}
+	ns := 3
+	charMap := gophy.GetMap(ns)
+	patterns, patternsint, _, _, _, _ := gophy.GetSitePatternsMS(mseqs, charMap, ns)
+	patternval, _ := gophy.PreparePatternVecsMS(tr, patternsint, seqs, charMap, ns)
+	unord := gophy.PCalcSankParsPatterns(tr, ns, patternval, 1)
+	if unord != 4 {
+		t.Error("unordered", unord)
+	}
+	// nil step matrices are the same as unordered
+	if s := gophy.PCalcSankParsPatternsSteps(tr, ns, patternval, make([]gophy.StepMatrix, len(patternval)), 2); s != unord {
+		t.Error("nil steps", s, unord)
+	}
+	class := []int{0, 1, 0}
+	cpatterns, cpatternsint := gophy.SplitPatternsByClass(patterns, class)
+	if len(cpatterns) != 3 || len(patterns) != 2 {
+		t.Fatal("split patterns", len(cpatterns), len(patterns))
+	}
+	patternval, patternvec := gophy.PreparePatternVecsMS(tr, cpatternsint, seqs, charMap, ns)
+	steps := gophy.PatternStepMatrices(patternvec, class, []gophy.StepMatrix{nil, gophy.OrderedStepMatrix(ns)})
+	if s := gophy.PCalcSankParsPatternsSteps(tr, ns, patternval, steps, 2); s != 5 {
+		t.Error("ordered", s)
+	}
+	gophy.EstParsBLSteps(tr, ns, patternval, steps, 1)
+	tot := 0.
+	for _, n := range tr.Post {
+		tot += n.Len
+	}
+	if math.Abs(tot-5) > 1e-6 {
+		t.Error("ordered branch lengths", tot)
+	}
+	// 2->0 isn't allowed so the 0 0 2 2 2 sites need two 0->2 changes
+	sm, err := gophy.ParseStepMatrix("0 1 1\n1 0 1\ni 1 0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps = gophy.PatternStepMatrices(patternvec, []int{0, 0, 0}, []gophy.StepMatrix{sm})
+	if s := gophy.PCalcSankParsPatternsSteps(tr, ns, patternval, steps, 1); s != 6 {
+		t.Error("step matrix", s)
+	}
+	for _, bad := range []string{"0 1\n1 0 1\n", "0 1\n1 1\n", "0 x\n1 0\n"} {
+		if _, err := gophy.ParseStepMatrix(bad); err == nil {
+			t.Error("should be an error", bad)
+		}
+	}
+	if cs, err := gophy.ParseCharSet("1-3, 7,10-14\\2"); err != nil || len(cs) != 7 || cs[3] != 6 || cs[6] != 13 {
+		t.Error("charset", cs, err)
+	}
+}
+
+// 0 2 1 1 has the root in any state unordered and only in 1 if ordered
+func TestStepMatrixAncStates(t *testing.T) {
+	tr := gophy.NewTree()
+	tr.Instantiate(gophy.ReadNewickString("((a:0.1,b:0.1):0.1,(c:0.1,d:0.1):0.1);"))
+	seqs := map[string][]string{"a": {"0"}, "b": {"2"}, "c": {"1"}, "d": {"1"}}
+	var mseqs []gophy.MSeq
+	for _, nm := range []string{"a", "b", "c", "d"} {
+		mseqs = append(mseqs, gophy.MSeq{NM: nm, SQs: seqs[nm]})
+	}
+	ns := 3
+	charMap := gophy.GetMap(ns)
+	_, patternsint, _, _, _, _ := gophy.GetSitePatternsMS(mseqs, charMap, ns)
+	patternval, _ := gophy.PreparePatternVecsMS(tr, patternsint, seqs, charMap, ns)
+	gophy.PCalcSankParsPatterns(tr, ns, patternval, 1)
+	gophy.CalcSankParsAncStateSingleSite(tr, ns, 0)
+	if tr.Rt.Nam != "[&values={0,1,2}]" {
+		t.Error("unordered", tr.Rt.Nam)
+	}
+	steps := []gophy.StepMatrix{gophy.OrderedStepMatrix(ns)}
+	if s := gophy.PCalcSankParsPatternsSteps(tr, ns, patternval, steps, 1); s != 2 {
+		t.Error("ordered", s)
+	}
+	gophy.CalcSankParsAncStateSingleSiteSteps(tr, ns, 0, steps[0])
+	if tr.Rt.Nam != "[&values={1}]" || tr.Rt.Chs[1].Nam != "[&values={1}]" {
+		t.Error("ordered", tr.Rt.Nam, tr.Rt.Chs[1].Nam)
+	}
+}
+
+func TestQMask(t *testing.T) {
+	x := gophy.NewMultStateModel(4)
+	mask := gophy.OrderedMask(4)
+	if gophy.NumMaskRates(mask, true) != 3 || gophy.NumMaskRates(mask, false) != 6 {
+		t.Error("number of rates")
+	}
+	x.M.SetupQMkMask([]float64{1, 2, 3}, mask, true)
+	for i := 0; i < 4; i++ {
+		sum := 0.
+		for j := 0; j < 4; j++ {
+			sum += x.M.Q.At(i, j)
+			if (i-j > 1 || j-i > 1) && x.M.Q.At(i, j) != 0 {
+				t.Error("ordered Q", i, j, x.M.Q.At(i, j))
+			}
+		}
+		if math.Abs(sum) > 1e-12 {
+			t.Error("Q rows should sum to 0", i, sum)
+		}
+	}
+	if x.M.Q.At(1, 2) != 2 || x.M.Q.At(2, 1) != 2 || x.M.Q.At(3, 2) != 3 {
+		t.Error("rates", x.M.Q)
+	}
+	// 0 can't go to 3 directly but can over a branch
+	P := x.M.GetPCalc(0.5)
+	if P.At(0, 3) <= 0 || P.At(0, 3) >= P.At(0, 1) {
+		t.Error("P", P.At(0, 1), P.At(0, 3))
+	}
+	// one way changes from a user mask
+	umask, err := gophy.ParseQMask("0 1 0\n0 0 1\n0 0 0\n", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	y := gophy.NewMultStateModel(3)
+	y.M.SetBaseFreqs([]float64{0.2, 0.3, 0.5})
+	y.M.SetScaledRateMatrixMask([]float64{0.5}, umask, false)
+	if y.M.R.At(0, 1) != 0.5 || y.M.R.At(1, 2) != 1 || y.M.R.At(1, 0) != 0 || y.M.R.At(0, 2) != 0 {
+		t.Error("R", y.M.R)
+	}
+	if _, err := gophy.ParseQMask("0 1\n1 0\n", 3); err == nil {
+		t.Error("the mask should be 3x3")
+	}
+	if m, _ := gophy.ParseQMask("ordered", 5); gophy.NumMaskRates(m, true) != 4 {
+		t.Error("ordered keyword")
+	}
+}
+
+func TestQMaskModels(t *testing.T) {
+	mask, err := gophy.ReadQMaskFile(writeTestFile(t, "mask.txt", "0 1 0 0\n1 0 1 0\n0 1 0 1\n0 0 1 0\n"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if om, _ := gophy.ReadQMaskFile("ordered", 4); !reflect.DeepEqual(om, mask) {
+		t.Error("ordered", om)
+	}
+	if _, err := gophy.ReadQMaskFile("notafile", 4); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	// one change per unit like SetupQJC
+	x := gophy.NewMultStateModel(4)
+	x.M.SetupQJCMask(mask)
+	mr := 0.
+	for i := 0; i < 4; i++ {
+		mr -= x.M.BF[i] * x.M.Q.At(i, i)
+	}
+	if math.Abs(mr-1) > 1e-12 || x.M.Q.At(0, 2) != 0 || x.M.Q.At(0, 1) != x.M.Q.At(2, 3) {
+		t.Error("SetupQJCMask", mr, x.M.Q)
+	}
+
+	tr := gophy.NewTree()
+	tr.Instantiate(gophy.ReadNewickString("((a:0.3,b:0.3):0.3,c:0.3,(d:0.3,e:0.3):0.3);"))
+	seqs := map[string][]string{"a": {"0", "1", "0", "3", "2"}, "b": {"1", "1", "0", "3", "2"},
+		"c": {"1", "2", "1", "2", "2"}, "d": {"2", "2", "1", "2", "3"}, "e": {"2", "3", "1", "1", "3"}}
+	var mseqs []gophy.MSeq
+	for _, nm := range []string{"a", "b", "c", "d", "e"} {
+		mseqs = append(mseqs, gophy.MSeq{NM: nm, SQs: seqs[nm]})
+	}
+	_, patternsint, _, _, _, _ := gophy.GetSitePatternsMS(mseqs, x.M.CharMap, 4)
+	patternval, _ := gophy.PreparePatternVecsMS(tr, patternsint, seqs, x.M.CharMap, 4)
+	l0 := gophy.PCalcLogLikePatterns(tr, &x.M, patternval, 1)
+	rates := make([]float64, gophy.NumMaskRates(mask, false))
+	for i := range rates {
+		rates[i] = x.M.Q.At(0, 1)
+	}
+	l1 := gophy.OptimizeMKMSMask(tr, &x.M, rates, mask, false, patternval, 1)
+	if l1 < l0-1e-8 || math.Abs(l1-gophy.PCalcLogLikePatterns(tr, &x.M, patternval, 1)) > 1e-8 {
+		t.Error("OptimizeMKMSMask", l0, l1)
+	}
+	if x.M.Q.At(0, 2) != 0 || x.M.Q.At(3, 1) != 0 || x.M.Q.At(1, 0) != rates[1] {
+		t.Error("the masked rates should stay 0", x.M.Q)
+	}
+}
@@ -0,0 +1,173 @@
+// topotest compares tree topologies with the KH, SH, ELW, and AU tests (RELL
+// bootstrap) and can write the site log likelihoods in the .sitelh format
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func calcLike(t *gophy.Tree, x *gophy.DiscreteModel, patternval []float64, wks int) float64 {
+	if x.GammaNCats != 0 {
+		return gophy.PCalcLikePatternsGamma(t, x, patternval, wks)
+	}
+	return gophy.PCalcLikePatterns(t, x, patternval, wks)
+}
+
+// optimize the branch lengths (and the nucleotide model if nuc isn't nil) until
+// the lnL stops improving
+func fitTree(t *gophy.Tree, x *gophy.DiscreteModel, nuc *gophy.DNAModel, patternval []float64, wks int) float64 {
+	lnl := calcLike(t, x, patternval, wks)
+	for i := 0; i < 10; i++ {
+		if nuc != nil {
+			gophy.OptimizeNucModel(t, nuc, patternval, wks)
+		}
+		if x.GammaNCats != 0 {
+			if nuc != nil {
+				gophy.OptimizeGamma(t, x, patternval, false, wks)
+			}
+			gophy.OptimizeGammaBLS(t, x, patternval, wks)
+		} else {
+			gophy.OptimizeBLNR(t, x, patternval, wks)
+		}
+		nlnl := calcLike(t, x, patternval, wks)
+		if nlnl-lnl < 0.01 {
+			return math.Max(lnl, nlnl)
+		}
+		lnl = nlnl
+	}
+	return lnl
+}
+
+func main() {
+	tfn := flag.String("t", "", "trees filename (the model is fit on the first)")
+	afn := flag.String("s", "", "seq filename")
+	st := flag.String("st", "nuc", "sequence type [nuc/aa/mult]")
+	m := flag.String("m", "", "model (nucleotide: JC69, K80, F81, HKY85, TN93, TIM, TVM, GTR; amino acid: a built-in name or PAML model file), GTR or JTT if not given")
+	ncats := flag.Int("g", 0, "number of gamma categories (0 for none)")
+	nboot := flag.Int("b", 10000, "number of RELL bootstrap replicates")
+	slfn := flag.String("sitelh", "", "write the site log likelihoods to this file (.sitelh format)")
+	wks := flag.Int("w", 4, "number of threads")
+	flag.Parse()
+	if len(*tfn) == 0 || len(*afn) == 0 {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *st != "nuc" && *st != "aa" && *st != "mult" {
+		fmt.Fprintln(os.Stderr, "sequence type string is not a recognised datatype, please use [nuc/aa/mult]")
+		os.Exit(1)
+	}
+	trees := gophy.ReadTreesFromFile(*tfn)
+	if len(trees) < 2 {
+		fmt.Fprintln(os.Stderr, "need at least two trees to compare")
+		os.Exit(1)
+	}
+
+	var x *gophy.DiscreteModel
+	var nuc *gophy.DNAModel
+	var fullpattern, gapsites []int
+	var prep func(t *gophy.Tree) ([]float64, []int)
+	switch *st {
+	case "nuc", "aa":
+		rseqs := gophy.ReadSeqsFromFile(*afn)
+		seqs := map[string]string{}
+		var names []string
+		for _, s := range rseqs {
+			seqs[s.NM] = s.SQ
+			names = append(names, s.NM)
+		}
+		nsites := len(rseqs[0].SQ)
+		var patternsint map[int]float64
+		if *st == "nuc" {
+			_, patternsint, gapsites, _, _, fullpattern = gophy.GetSitePatterns(seqs, nsites, names)
+			prep = func(t *gophy.Tree) ([]float64, []int) {
+				return gophy.PreparePatternVecs(t, patternsint, seqs)
+			}
+			if len(*m) == 0 {
+				*m = "GTR"
+			}
+			var err error
+			if nuc, err = gophy.NewNucModel(*m, nil, gophy.GetEmpiricalBaseFreqs(seqs)); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			x = &nuc.M
+		} else {
+			_, patternsint, gapsites, _, _, fullpattern = gophy.GetSitePatternsProt(seqs, nsites, names)
+			prep = func(t *gophy.Tree) ([]float64, []int) {
+				return gophy.PreparePatternVecsProt(t, patternsint, seqs)
+			}
+			if len(*m) == 0 {
+				*m = "JTT"
+			}
+			y := gophy.NewProteinModel()
+			if err := y.SetRateMatrixByName(*m); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			y.M.SetBaseFreqs(gophy.GetEmpiricalBaseFreqsProt(seqs))
+			y.M.SetupQGTR()
+			x = &y.M
+		}
+	case "mult":
+		mseqs, numstates := gophy.ReadMSeqsFromFile(*afn)
+		seqs := map[string][]string{}
+		for _, s := range mseqs {
+			seqs[s.NM] = s.SQs
+		}
+		charMap := gophy.GetMap(numstates)
+		var patternsint map[int]float64
+		_, patternsint, gapsites, _, _, fullpattern = gophy.GetSitePatternsMS(mseqs, charMap, numstates)
+		prep = func(t *gophy.Tree) ([]float64, []int) {
+			return gophy.PreparePatternVecsMS(t, patternsint, seqs, charMap, numstates)
+		}
+		y := gophy.NewMultStateModel(numstates)
+		y.M.SetupQJC()
+		x = &y.M
+	}
+	if *ncats > 0 {
+		x.GammaNCats = *ncats
+		x.GammaAlpha = 1.0
+		x.GammaCats = gophy.GetGammaCats(x.GammaAlpha, x.GammaNCats, false)
+	}
+
+	// fit the model on the first tree and then just the branch lengths
+	sitelikes := make([][]float64, len(trees))
+	for i, t := range trees {
+		patternval, patternvec := prep(t)
+		var lnl float64
+		if i == 0 {
+			lnl = fitTree(t, x, nuc, patternval, *wks)
+		} else {
+			lnl = fitTree(t, x, nil, patternval, *wks)
+		}
+		fmt.Fprintln(os.Stderr, "tree", i+1, "lnL:", lnl)
+		psl := gophy.PCalcLogLikePatternSites(t, x, patternval, *wks)
+		sitelikes[i] = gophy.ExpandPatternSites(psl, patternvec, fullpattern, gapsites)
+	}
+	if len(*slfn) > 0 {
+		f, err := os.Create(*slfn)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := gophy.WriteSiteLH(f, sitelikes); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		f.Close()
+	}
+
+	res := gophy.TopologyTests(sitelikes, *nboot)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "tree\tlnL\tdeltaL\tbp-RELL\tp-KH\tp-SH\tc-ELW\tp-AU")
+	for i, r := range res {
+		fmt.Fprintf(w, "%d\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\n", i+1, r.LnL, r.DeltaL, r.BP, r.KH, r.SH, r.ELW, r.AU)
+	}
+	w.Flush()
+}
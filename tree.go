@@ -9,7 +9,10 @@ type Tree struct {
 	Post  []*Node
 	Pre   []*Node
 	Tips  []*Node
-	Index int // if there is an identifying index
+	Index int         // if there is an identifying index
+	Nam   string      // name of the tree if there is one (e.g., from NEXUS)
+	LB    *LikeBuffer // the conditional likelihoods (see GetLikeBuffer)
+	cb    *condBuffer // the front and back conditionals (see CalcLikeFrontBack)
 }
 
 // NewTree return a tree
@@ -54,14 +57,34 @@ func (t *Tree) populatePreorder(rt *Node) {
 	}
 }
 
-// Instantiate will preorder and postorder
+// Instantiate will preorder and postorder (again if the tree was edited)
 func (t *Tree) Instantiate(rt *Node) {
 	t.Rt = rt
+	t.Pre, t.Post, t.Tips = nil, nil, nil
 	t.populatePrePostIt(t.Rt)
 	//t.populatePreorder(t.Rt)
 	//t.populatePostorder(t.Rt)
 }
 
+// DeepCopyTree a new tree with the same topology, names, and branch lengths
+func (t *Tree) DeepCopyTree() *Tree {
+	var cp func(n *Node, par *Node) *Node
+	cp = func(n *Node, par *Node) *Node {
+		nn := newNewickNode(par)
+		nn.Nam = n.Nam
+		nn.Len = n.Len
+		for _, c := range n.Chs {
+			nn.addChild(cp(c, nn))
+		}
+		return nn
+	}
+	nt := NewTree()
+	nt.Instantiate(cp(t.Rt, nil))
+	nt.Index = t.Index
+	nt.Nam = t.Nam
+	return nt
+}
+
 // GetTipByName get
 func (t *Tree) GetTipByName(name string) (*Node, error) {
 	for _, n := range t.Tips {
@@ -2,7 +2,6 @@ package gophy
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -10,172 +9,147 @@ import (
 	"strings"
 )
 
+// TreeStream reads trees one at a time (a TreeScanner or a NexusTreeScanner)
+type TreeStream interface {
+	Next() (*Tree, error)
+}
+
+// NewTreeStream returns a NexusTreeScanner if the reader starts with #NEXUS and
+// a TreeScanner otherwise
+func NewTreeStream(r io.Reader) TreeStream {
+	br := bufio.NewReaderSize(r, 1024*1024)
+	for {
+		c, err := br.Peek(1)
+		if err != nil || isNewickSpace(c[0]) == false {
+			break
+		}
+		br.ReadByte()
+	}
+	if b, _ := br.Peek(len("#NEXUS")); strings.ToUpper(string(b)) == "#NEXUS" {
+		return NewNexusTreeScanner(br)
+	}
+	return NewTreeScanner(br)
+}
+
 // ReadTreeFromFile read a single tree from a file
+// the file can be newick or NEXUS. nil is returned (and the error printed) if
+// there is no tree or the first one can't be parsed
 func ReadTreeFromFile(tfn string) (tree *Tree) {
 	f, err := os.Open(tfn)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		return nil
 	}
 	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	buf := make([]byte, 0, 1024*1024)
-	scanner.Buffer(buf, 1024*1024)
-	var rt *Node
-	tree = NewTree()
-	for scanner.Scan() {
-		ln := scanner.Text()
-		if len(ln) < 2 {
-			continue
+	tree, err = NewTreeStream(f).Next()
+	if err != nil {
+		if err == io.EOF {
+			fmt.Fprintln(os.Stderr, tfn+": no tree found")
+		} else {
+			fmt.Fprintln(os.Stderr, tfn+":", err)
 		}
-		rt = ReadNewickString(ln)
-		tree.Instantiate(rt)
-		break
+		return nil
 	}
-	return
+	return tree
 }
 
 // ReadTreesFromFile read multi single tree from a file
+// the file can be newick or NEXUS. trees that can't be parsed are
+// reported and skipped. Use NewTreeStream to read them one at a time
 func ReadTreesFromFile(tfn string) (trees []*Tree) {
 	f, err := os.Open(tfn)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		return
 	}
 	defer f.Close()
 	trees = make([]*Tree, 0)
-	reader := bufio.NewReader(f)
+	sc := NewTreeStream(f)
 	for {
-		line, err := reader.ReadString('\n')
+		tree, err := sc.Next()
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
-			fmt.Println("Read error:", err)
-			break
-		}
-		ln := strings.TrimSpace(line)
-		if len(ln) < 2 {
+		if nerr, ok := err.(*NewickError); ok {
+			fmt.Fprintln(os.Stderr, tfn+":", nerr)
 			continue
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "Read error:", err)
+			break
 		}
-		tree := NewTree()
-		rt := ReadNewickString(ln)
-		tree.Instantiate(rt)
+		tree.Index = len(trees)
 		trees = append(trees, tree)
 	}
-	/*
-		scanner := bufio.NewScanner(f)
-		buf := make([]byte, 0, 1024*1024)
-		scanner.Buffer(buf, 1024*1024)
-		for scanner.Scan() {
-			var rt *Node
-			tree := NewTree()
-			ln := scanner.Text()
-			if len(ln) < 2 {
-				continue
-			}
-			rt = ReadNewickString(ln)
-			tree.Instantiate(rt)
-			trees = append(trees, tree)
-		}
-		if err := scanner.Err(); err != nil {
-			fmt.Println("Scanner error:", err)
-		}*/
 	return
 }
 
 // ReadNewickString given a string it will return a pointer to the root node
+// errors are printed and nil is returned, use ParseNewickString to get the error
 func ReadNewickString(ts string) (root *Node) {
-	rt := Node{Nam: "", Len: 0.0, Par: nil, IData: map[string]int{},
-		FData: map[string]float64{}, SData: map[string]string{}}
-	x := 0
-	nc := string(ts[x : x+1])
-	start := true
-	cn := new(Node)
-	for {
-		if nc == "(" {
-			if start == true {
-				cn = &rt
-				start = false
-			} else {
-				nn := Node{Par: cn, Nam: "", Len: 0.0, IData: map[string]int{},
-					FData: map[string]float64{}, SData: map[string]string{}}
-				cn.addChild(&nn)
-				cn = &nn
-			}
-		} else if nc == "," {
-			cn = cn.Par
-		} else if nc == ")" {
-			cn = cn.Par
-			x++
-			nc = ts[x : x+1]
-			if nc == "," || nc == ")" || nc == ":" || nc == "[" || nc == ";" {
-				continue
-			}
-			var nm bytes.Buffer
-			for {
-				nm.WriteString(nc)
-				x++
-				nc = ts[x : x+1]
-				if nc == "," || nc == ")" || nc == ":" || nc == "[" || nc == ";" {
-					break
-				}
-			}
-			cn.Nam = nm.String()
-			x--
-		} else if nc == ";" {
-			break
-		} else if nc == ":" {
-			x++
-			nc = ts[x : x+1]
-			var bl bytes.Buffer
-			for {
-				bl.WriteString(nc)
-				x++
-				nc = ts[x : x+1]
-				if nc == "," || nc == ")" || nc == ":" || nc == "[" || nc == ";" {
-					break
-				}
-			}
-			b, err := strconv.ParseFloat(bl.String(), 64)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "There is an error in branch length processing\n")
-			}
-			cn.Len = b
-			x--
-		} else if nc == "[" {
-			x++
-			nc = ts[x : x+1]
-			var nm bytes.Buffer
-			for {
-				nm.WriteString(nc)
-				x++
-				nc = ts[x : x+1]
-				if nc == "]" {
-					break
-				}
-			}
-			cn.SData["comment"] = nm.String()
-		} else if nc == ";" {
-			break
+	rt, err := ParseNewickString(ts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
+	return rt
+}
+
+// parseNodeAnnotation puts the key/values of a BEAST style [&key=val,...] or
+// NHX style [&&NHX:key=val:...] comment into FData (numeric) or SData (everything
+// else, including ranges like {1.0,2.3}). Keys are kept in order in Annot.
+// Other comments go in SData["comment"]
+func parseNodeAnnotation(n *Node, cm string) {
+	var parts []string
+	if strings.HasPrefix(cm, "&&NHX") {
+		parts = strings.Split(cm[len("&&NHX"):], ":")
+	} else if strings.HasPrefix(cm, "&") {
+		parts = splitOutsideBraces(cm[1:], ',')
+	} else {
+		n.SData["comment"] = cm
+		return
+	}
+	for _, p := range parts {
+		kv := strings.SplitN(p, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(key) == 0 {
+			continue
+		}
+		val := ""
+		if len(kv) == 2 {
+			val = strings.TrimSpace(kv[1])
+		}
+		if StringSliceContains(n.Annot, key) == false {
+			n.Annot = append(n.Annot, key)
+		}
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			n.FData[key] = f
+			delete(n.SData, key)
 		} else {
-			nn := Node{Par: cn, Nam: "", Len: 0.0, IData: map[string]int{},
-				FData: map[string]float64{}, SData: map[string]string{}}
-			cn.addChild(&nn)
-			cn = &nn
-			var nm bytes.Buffer
-			for {
-				nm.WriteString(nc)
-				x++
-				nc = ts[x : x+1]
-				if nc == "," || nc == ")" || nc == ":" || nc == "[" {
-					break
-				}
-			}
-			x--
-			nn.Nam = nm.String()
+			n.SData[key] = strings.Trim(val, "\"")
+			delete(n.FData, key)
+		}
+	}
+}
+
+// splitOutsideBraces splits on sep when it is not inside {} or quotes
+func splitOutsideBraces(s string, sep byte) (ret []string) {
+	depth := 0
+	inquote := false
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			inquote = !inquote
+		case inquote:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		case c == sep && depth == 0:
+			ret = append(ret, s[last:i])
+			last = i + 1
 		}
-		x++
-		nc = ts[x : x+1]
 	}
-	root = &rt
+	ret = append(ret, s[last:])
 	return
 }
@@ -0,0 +1,100 @@
+package gophy_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+func TestReadNewickStringBEASTAnnotations(t *testing.T) {
+	ts := "((a[&rate=0.3]:1,b[&rate=0.5,state=\"x y\"]:1)[&height=1.2,height_95%_HPD={1.0,2.3},posterior=0.98]:0.5,c:1.5);"
+	rt := gophy.ReadNewickString(ts)
+	tr := gophy.NewTree()
+	tr.Instantiate(rt)
+	a, _ := tr.GetTipByName("a")
+	if a.FData["rate"] != 0.3 {
+		t.Error("rate", a.FData["rate"])
+	}
+	b, _ := tr.GetTipByName("b")
+	if b.SData["state"] != "x y" {
+		t.Error("state", b.SData["state"])
+	}
+	in := a.Par
+	if in.FData["height"] != 1.2 || in.FData["posterior"] != 0.98 {
+		t.Error(in.FData)
+	}
+	rng, err := in.GetRange("height_95%_HPD")
+	if err != nil || len(rng) != 2 || rng[0] != 1.0 || rng[1] != 2.3 {
+		t.Error(rng, err)
+	}
+	if out := rt.NewickAnnotated(true, false) + ";"; out != ts {
+		t.Error(out)
+	}
+}
+
+func TestReadNewickStringNHXAnnotations(t *testing.T) {
+	ts := "((a:1[&&NHX:S=human],b:1[&&NHX:S=chimp]):0.5[&&NHX:D=Y:B=100],c:1.5);"
+	rt := gophy.ReadNewickString(ts)
+	if rt.Chs[0].SData["D"] != "Y" || rt.Chs[0].FData["B"] != 100 {
+		t.Error(rt.Chs[0].SData, rt.Chs[0].FData)
+	}
+	if rt.Chs[0].Chs[0].SData["S"] != "human" {
+		t.Error(rt.Chs[0].Chs[0].SData)
+	}
+	if out := rt.NewickAnnotated(true, true) + ";"; out != ts {
+		t.Error(out)
+	}
+}
+
+func TestTreeScannerErrors(t *testing.T) {
+	in := "((a:1,b:1):1,c:1);\n((a:1,b:1):1,c:x);\n((a:1,b:1:1,c:1);\n(a:1,b:1)),c:1);\n((a,b),c)\n"
+	sc := gophy.NewTreeScanner(strings.NewReader(in))
+	exp := []string{"",
+		"tree 1: line 2, column 16: bad branch length \"x\"",
+		"tree 2: line 3, column 1: unbalanced parentheses: ( is never closed",
+		"tree 3: line 4, column 10: unbalanced parentheses: unexpected )",
+		"tree 4: line 6, column 1: missing ; at end of tree"}
+	for i, e := range exp {
+		tr, err := sc.Next()
+		if e == "" {
+			if err != nil || len(tr.Tips) != 3 {
+				t.Error(i, err)
+			}
+		} else if err == nil || err.Error() != e {
+			t.Error(i, err)
+		}
+	}
+	if _, err := sc.Next(); err != io.EOF {
+		t.Error("expected EOF, got", err)
+	}
+}
+
+func TestParseNewick(t *testing.T) {
+	tr, err := gophy.ParseNewick(strings.NewReader("  [&R] ((a:1,b:2)90:1,\n c:3);"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Rt.Newick(true) != "((a:1,b:2)90:1,c:3)" {
+		t.Error(tr.Rt.Newick(true))
+	}
+}
+
+func TestQuotedLabels(t *testing.T) {
+	ts := "(('Carex sp. ''A''':1,'a,b (c)':1):1,'x;y':1,plain_name:1);"
+	rt, err := gophy.ParseNewickString(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.Chs[0].Chs[0].Nam != "Carex sp. 'A'" || rt.Chs[0].Chs[1].Nam != "a,b (c)" || rt.Chs[1].Nam != "x;y" {
+		t.Error(rt.GetTipNames())
+	}
+	if out := rt.Newick(true) + ";"; out != ts {
+		t.Error(out)
+	}
+	tr, err := gophy.ParseNewick(strings.NewReader(ts))
+	if err != nil || len(tr.Tips) != 4 {
+		t.Error(err)
+	}
+}
@@ -3,6 +3,7 @@ package gophy
 import (
 	"fmt"
 	"math"
+	"math/rand"
 )
 
 // GetMrca get the mrca
@@ -101,9 +102,19 @@ func SwapBranch(nd1 *Node, nd2 *Node) bool {
 	par2.removeChild(nd2)
 	par1.addChild(nd2)
 	par2.addChild(nd1)
+	MarkToRoot(par1)
+	MarkToRoot(par2)
 	return true
 }
 
+// MarkToRoot marks n and the nodes above it so the Marked likelihoods (e.g.,
+// PCalcLikePatternsMarked) calculate them again
+func MarkToRoot(n *Node) {
+	for ; n != nil; n = n.Par {
+		n.Marked = true
+	}
+}
+
 // TritomyRoot makes the root a tritomy
 func TritomyRoot(tr *Tree) {
 	curroot := tr.Rt
@@ -129,11 +140,17 @@ func TritomyRoot(tr *Tree) {
 			i.Par = curroot
 		}
 	}
+	curroot.Marked = true
 }
 
 // Reroot basic reroot function
 func Reroot(inroot *Node, tr *Tree) {
 	tempParent := inroot.Par
+	// the nodes on the path to the old root get other children
+	var path []*Node
+	for n := tempParent; n != nil; n = n.Par {
+		path = append(path, n)
+	}
 	newRoot := new(Node)
 	newRoot.addChild(inroot)
 	inroot.Par = newRoot
@@ -144,6 +161,10 @@ func Reroot(inroot *Node, tr *Tree) {
 	inroot.Len = inroot.Len / 2.
 	processReRoot(newRoot)
 	tr.Rt = newRoot
+	for _, n := range path {
+		n.Marked = true
+	}
+	newRoot.Marked = true
 }
 
 func processReRoot(node *Node) {
@@ -241,3 +262,112 @@ func GetSubtreeRoot(n *Node, higherNode *Node) (subtreeRoot *Node) {
 	}
 	return
 }
+
+// graftAbove puts nd on a new node in the middle of the branch subtending n and
+// returns that new node. n must not be the root
+func graftAbove(n *Node, nd *Node) (mid *Node) {
+	par := n.Par
+	mid = newNewickNode(par)
+	for i, c := range par.Chs {
+		if c == n {
+			par.Chs[i] = mid
+		}
+	}
+	n.Par = mid
+	nd.Par = mid
+	mid.Chs = []*Node{n, nd}
+	return
+}
+
+// PruneSubtree takes the subtree of n off the tree with the parent of n, which
+// is returned (with n as the only child) to use with RegraftSubtree. The branch
+// of the sibling of n is joined with the one of the parent. The parent of n
+// can't be the root and has to be bifurcating (nil is returned otherwise)
+func PruneSubtree(n *Node) (mid *Node) {
+	mid = n.Par
+	if mid == nil || mid.Par == nil || len(mid.Chs) != 2 {
+		return nil
+	}
+	sib := mid.Chs[0]
+	if sib == n {
+		sib = mid.Chs[1]
+	}
+	gp := mid.Par
+	for i, c := range gp.Chs {
+		if c == mid {
+			gp.Chs[i] = sib
+		}
+	}
+	sib.Par = gp
+	sib.Len += mid.Len
+	mid.Chs = []*Node{n}
+	mid.Par = nil
+	mid.Len = 0.0
+	MarkToRoot(gp)
+	return
+}
+
+// RegraftSubtree puts mid from PruneSubtree back on the tree in the middle of
+// the branch subtending target. target can't be the root or in the pruned
+// subtree (false is returned)
+func RegraftSubtree(mid *Node, target *Node) bool {
+	par := target.Par
+	if par == nil || len(mid.Chs) != 1 {
+		return false
+	}
+	for n := par; n != nil; n = n.Par {
+		if n == mid {
+			return false
+		}
+	}
+	for i, c := range par.Chs {
+		if c == target {
+			par.Chs[i] = mid
+		}
+	}
+	mid.Par = par
+	mid.Chs = append(mid.Chs, target)
+	target.Par = mid
+	mid.Len = target.Len / 2.
+	target.Len = target.Len / 2.
+	MarkToRoot(mid)
+	return true
+}
+
+// RandomTree a random unrooted tree (a tritomy at the root) of the names, each
+// added to a random branch, with all the branch lengths blen
+func RandomTree(names []string, blen float64) *Tree {
+	rt := newNewickNode(nil)
+	var nodes []*Node
+	for i, nm := range names {
+		nd := newNewickNode(nil)
+		nd.Nam = nm
+		nd.Len = blen
+		if i < 3 {
+			nd.Par = rt
+			rt.addChild(nd)
+		} else {
+			mid := graftAbove(nodes[rand.Intn(len(nodes))], nd)
+			mid.Len = blen
+			nodes = append(nodes, mid)
+		}
+		nodes = append(nodes, nd)
+	}
+	t := NewTree()
+	t.Instantiate(rt)
+	return t
+}
+
+// ungraft removes a node made with graftAbove (and the node grafted on it),
+// putting back the original branch
+func ungraft(mid *Node) {
+	par := mid.Par
+	n := mid.Chs[0]
+	for i, c := range par.Chs {
+		if c == mid {
+			par.Chs[i] = n
+		}
+	}
+	n.Par = par
+	mid.Chs[1].Par = nil
+}
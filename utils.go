@@ -342,6 +342,13 @@ func CalcBIC(ln float64, k float64, n int) (x float64) {
 	return
 }
 
+//LikelihoodRatioTest lnl0 is the null (nested) model, df the difference in params
+func LikelihoodRatioTest(lnl0 float64, lnl1 float64, df float64) (stat float64, p float64) {
+	stat = math.Max(0., 2.*(lnl1-lnl0))
+	p = distuv.ChiSquared{K: df}.Survival(stat)
+	return
+}
+
 func CalcNormPDF(val, mn, std float64) float64 {
 	//x = 1/(st*math.sqrt(6.283185307179586))
 	x := 1 / (std * 2.5066282746310002)
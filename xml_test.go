@@ -0,0 +1,93 @@
+package gophy_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/FePhyFoFum/gophy"
+)
+
+var phyloXMLTree = `<?xml version="1.0" encoding="UTF-8"?>
+<phyloxml xmlns="http://www.phyloxml.org">
+  <phylogeny rooted="true">
+    <name>example</name>
+    <clade>
+      <clade branch_length="0.06">
+        <confidence type="bootstrap">89</confidence>
+        <clade>
+          <name>A</name>
+          <branch_length>0.102</branch_length>
+          <taxonomy>
+            <scientific_name>Carex sp. &apos;A&apos;</scientific_name>
+          </taxonomy>
+          <property ref="gophy:rate" datatype="xsd:double" applies_to="clade">0.3</property>
+          <property ref="gophy:count" datatype="xsd:integer" applies_to="clade">4</property>
+        </clade>
+        <clade>
+          <name>B</name>
+          <branch_length>0.23</branch_length>
+        </clade>
+      </clade>
+      <clade>
+        <name>C</name>
+        <branch_length>0.4</branch_length>
+      </clade>
+    </clade>
+  </phylogeny>
+</phyloxml>
+`
+
+func TestParsePhyloXML(t *testing.T) {
+	trees, err := gophy.ParsePhyloXML(strings.NewReader(phyloXMLTree))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trees) != 1 || trees[0].Nam != "example" {
+		t.Fatal(trees)
+	}
+	tr := trees[0]
+	if tr.Rt.Newick(true) != "((A:0.102,B:0.23):0.06,C:0.4)" {
+		t.Error(tr.Rt.Newick(true))
+	}
+	a, _ := tr.GetTipByName("A")
+	if a.FData["rate"] != 0.3 || a.IData["count"] != 4 || a.SData["taxonomy_scientific_name"] != "Carex sp. 'A'" {
+		t.Error(a.FData, a.IData, a.SData)
+	}
+	if a.Par.FData["confidence_bootstrap"] != 89 {
+		t.Error(a.Par.FData)
+	}
+	trees2, err := gophy.ParsePhyloXML(strings.NewReader(gophy.PhyloXMLString(trees)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, _ := trees2[0].GetTipByName("A")
+	if trees2[0].Rt.Newick(true) != tr.Rt.Newick(true) || a2.FData["rate"] != 0.3 || a2.IData["count"] != 4 ||
+		a2.SData["taxonomy_scientific_name"] != "Carex sp. 'A'" || a2.Par.FData["confidence_bootstrap"] != 89 {
+		t.Error(gophy.PhyloXMLString(trees))
+	}
+}
+
+func TestNeXMLRoundTrip(t *testing.T) {
+	rt := gophy.ReadNewickString("((a[&rate=0.3]:1,b:2)[&posterior=0.9]:0.5,'c d':1.5);")
+	tr := gophy.NewTree()
+	tr.Instantiate(rt)
+	tr.Nam = "t1"
+	tr.Tips[0].IData["count"] = 2
+	tr.Tips[0].SData["state"] = "x<y"
+	nex := gophy.NeXMLString([]*gophy.Tree{tr})
+	trees, err := gophy.ParseNeXML(strings.NewReader(nex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trees) != 1 || trees[0].Nam != "t1" || trees[0].Rt.Newick(true) != tr.Rt.Newick(true) {
+		t.Fatal(nex)
+	}
+	a, _ := trees[0].GetTipByName("a")
+	if a.FData["rate"] != 0.3 || a.Par.FData["posterior"] != 0.9 {
+		t.Error(a.FData, a.Par.FData)
+	}
+	n := trees[0].Tips[0]
+	if n.IData["count"] != 2 || n.SData["state"] != "x<y" {
+		t.Error(n.IData, n.SData)
+	}
+}